@@ -0,0 +1,152 @@
+package circuit
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// appendedRunMetrics holds RunMetrics added with AppendRunMetrics.  Reads are a single atomic load, so the hot path
+// never takes a lock.
+type appendedRunMetrics struct {
+	collectors atomic.Value // RunMetricsCollection
+	mu         sync.Mutex
+}
+
+var _ RunMetrics = &appendedRunMetrics{}
+var _ ExecuteDurationMetrics = &appendedRunMetrics{}
+var _ AttemptMetrics = &appendedRunMetrics{}
+var _ OutcomeLabelMetrics = &appendedRunMetrics{}
+var _ FailureSeverityMetrics = &appendedRunMetrics{}
+
+func (a *appendedRunMetrics) load() RunMetricsCollection {
+	ret, _ := a.collectors.Load().(RunMetricsCollection)
+	return ret
+}
+
+func (a *appendedRunMetrics) append(m RunMetrics) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current := a.load()
+	// Copy, so callers that already loaded the old collection are not affected
+	next := make(RunMetricsCollection, 0, len(current)+1)
+	next = append(next, current...)
+	a.collectors.Store(append(next, m))
+}
+
+// Var exposes appended run collectors as expvar
+func (a *appendedRunMetrics) Var() expvar.Var {
+	return a.load().Var()
+}
+
+func (a *appendedRunMetrics) Success(now time.Time, duration time.Duration) {
+	a.load().Success(now, duration)
+}
+
+func (a *appendedRunMetrics) ErrFailure(now time.Time, duration time.Duration) {
+	a.load().ErrFailure(now, duration)
+}
+
+func (a *appendedRunMetrics) ErrTimeout(now time.Time, duration time.Duration) {
+	a.load().ErrTimeout(now, duration)
+}
+
+func (a *appendedRunMetrics) ErrBadRequest(now time.Time, duration time.Duration) {
+	a.load().ErrBadRequest(now, duration)
+}
+
+func (a *appendedRunMetrics) ErrInterrupt(now time.Time, duration time.Duration) {
+	a.load().ErrInterrupt(now, duration)
+}
+
+func (a *appendedRunMetrics) ErrConcurrencyLimitReject(now time.Time) {
+	a.load().ErrConcurrencyLimitReject(now)
+}
+
+func (a *appendedRunMetrics) ErrShortCircuit(now time.Time) {
+	a.load().ErrShortCircuit(now)
+}
+
+func (a *appendedRunMetrics) Attempt(now time.Time) {
+	a.load().Attempt(now)
+}
+
+func (a *appendedRunMetrics) ExecuteDuration(now time.Time, runDuration time.Duration, totalDuration time.Duration) {
+	a.load().ExecuteDuration(now, runDuration, totalDuration)
+}
+
+func (a *appendedRunMetrics) LabeledOutcome(now time.Time, label string, outcome Outcome, duration time.Duration) {
+	a.load().LabeledOutcome(now, label, outcome, duration)
+}
+
+func (a *appendedRunMetrics) ErrFailureSeverity(now time.Time, severity string, duration time.Duration) {
+	a.load().ErrFailureSeverity(now, severity, duration)
+}
+
+// appendedFallbackMetrics holds FallbackMetrics added with AppendFallbackMetrics
+type appendedFallbackMetrics struct {
+	collectors atomic.Value // FallbackMetricsCollection
+	mu         sync.Mutex
+}
+
+var _ FallbackMetrics = &appendedFallbackMetrics{}
+var _ FallbackSkippedMetrics = &appendedFallbackMetrics{}
+var _ ShortCircuitFallbackMetrics = &appendedFallbackMetrics{}
+var _ NestedCircuitFallbackMetrics = &appendedFallbackMetrics{}
+var _ HedgeFallbackMetrics = &appendedFallbackMetrics{}
+
+func (a *appendedFallbackMetrics) load() FallbackMetricsCollection {
+	ret, _ := a.collectors.Load().(FallbackMetricsCollection)
+	return ret
+}
+
+func (a *appendedFallbackMetrics) append(m FallbackMetrics) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current := a.load()
+	next := make(FallbackMetricsCollection, 0, len(current)+1)
+	next = append(next, current...)
+	a.collectors.Store(append(next, m))
+}
+
+// Var exposes appended fallback collectors as expvar
+func (a *appendedFallbackMetrics) Var() expvar.Var {
+	return a.load().Var()
+}
+
+func (a *appendedFallbackMetrics) Success(now time.Time, duration time.Duration) {
+	a.load().Success(now, duration)
+}
+
+func (a *appendedFallbackMetrics) ErrFailure(now time.Time, duration time.Duration) {
+	a.load().ErrFailure(now, duration)
+}
+
+func (a *appendedFallbackMetrics) ErrConcurrencyLimitReject(now time.Time) {
+	a.load().ErrConcurrencyLimitReject(now)
+}
+
+func (a *appendedFallbackMetrics) Skipped(now time.Time) {
+	a.load().Skipped(now)
+}
+
+func (a *appendedFallbackMetrics) ShortCircuitSuccess(now time.Time, duration time.Duration) {
+	a.load().ShortCircuitSuccess(now, duration)
+}
+
+func (a *appendedFallbackMetrics) ShortCircuitFailure(now time.Time, duration time.Duration) {
+	a.load().ShortCircuitFailure(now, duration)
+}
+
+func (a *appendedFallbackMetrics) ErrNestedShortCircuit(now time.Time, duration time.Duration) {
+	a.load().ErrNestedShortCircuit(now, duration)
+}
+
+func (a *appendedFallbackMetrics) PrimaryWon(now time.Time, duration time.Duration) {
+	a.load().PrimaryWon(now, duration)
+}
+
+func (a *appendedFallbackMetrics) FallbackWon(now time.Time, duration time.Duration) {
+	a.load().FallbackWon(now, duration)
+}
@@ -0,0 +1,12 @@
+//go:build go1.13
+// +build go1.13
+
+package circuit
+
+import "errors"
+
+// wrapsBadRequest is true if any error wrapped by err is a BadRequest
+func wrapsBadRequest(err error) bool {
+	var br BadRequest
+	return errors.As(err, &br) && br.BadRequest()
+}
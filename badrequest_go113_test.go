@@ -0,0 +1,33 @@
+//go:build go1.13
+// +build go1.13
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewBadRequest(t *testing.T) {
+	c := NewCircuitFromConfig("TestNewBadRequest", Config{})
+	original := errors.New("this request is bad")
+	err := c.Execute(context.Background(), func(_ context.Context) error {
+		return fmt.Errorf("wrapped: %w", NewBadRequest(original))
+	}, func(_ context.Context, _ error) error {
+		panic("fallbacks don't get called on wrapped bad requests")
+	})
+	if !errors.Is(err, original) {
+		t.Error("expected to see the original error", err)
+	}
+	err = c.Execute(context.Background(), func(_ context.Context) error {
+		return NewBadRequest(original)
+	}, nil)
+	if err != original {
+		t.Error("expected Execute to unwrap the bad request", err)
+	}
+	if NewBadRequest(nil) != nil {
+		t.Error("expected nil errors to stay nil")
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !go1.13
+// +build !go1.13
+
+package circuit
+
+// wrapsBadRequest needs errors.As, so only top level BadRequest errors are found before Go 1.13
+func wrapsBadRequest(err error) bool {
+	return false
+}
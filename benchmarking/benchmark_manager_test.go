@@ -0,0 +1,34 @@
+package benchmarking
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/cep21/circuit"
+)
+
+// BenchmarkManagerCreateCircuit creates many circuits at once, like a service configuring all its circuits at startup
+func BenchmarkManagerCreateCircuit(b *testing.B) {
+	const circuits = 5000
+	const concurrent = 16
+	for _, expected := range []int{0, circuits} {
+		b.Run("expected="+strconv.Itoa(expected), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h := circuit.Manager{ExpectedCircuits: expected}
+				wg := sync.WaitGroup{}
+				for j := 0; j < concurrent; j++ {
+					wg.Add(1)
+					go func(j int) {
+						defer wg.Done()
+						for k := j; k < circuits; k += concurrent {
+							h.MustCreateCircuit(strconv.Itoa(k))
+						}
+					}(j)
+				}
+				wg.Wait()
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package benchmarking
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cep21/circuit"
+)
+
+// BenchmarkCircuitOverhead compares calling a function directly to calling it through circuits that do little or no
+// bookkeeping
+func BenchmarkCircuitOverhead(b *testing.B) {
+	ctx := context.Background()
+	b.Run("bare", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := passesCtx(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	circuits := []struct {
+		name string
+		c    *circuit.Circuit
+	}{
+		{
+			name: "disabled",
+			c:    circuit.NewDisabledCircuit("disabled"),
+		}, {
+			// No metrics, timeout, or concurrency limit: Execute takes its fast path
+			name: "minimal",
+			c: circuit.NewCircuitFromConfig("minimal", circuit.Config{
+				Execution: circuit.ExecutionConfig{
+					MaxConcurrentRequests: -1,
+					Timeout:               -1,
+				},
+			}),
+		}, {
+			name: "default",
+			c:    circuit.NewCircuitFromConfig("default", circuit.Config{}),
+		}, {
+			// Pools the contexts for the circuit name and timeout, so the success path does not allocate
+			name: "reuse-contexts",
+			c: circuit.NewCircuitFromConfig("reuse-contexts", circuit.Config{
+				Execution: circuit.ExecutionConfig{
+					ReuseContexts: true,
+				},
+			}),
+		},
+	}
+	for _, bc := range circuits {
+		c := bc.c
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := c.Execute(ctx, passesCtx, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
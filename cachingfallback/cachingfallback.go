@@ -0,0 +1,83 @@
+//go:build go1.18
+// +build go1.18
+
+package cachingfallback
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+)
+
+// CachingFallback remembers the last successful result of a call and serves it as the fallback.  Wrap runFunc with
+// Run and pass Fallback as the fallback, or use Execute to do both.  The zero value is ready to use and serves values
+// of any age.  It is safe to use concurrently.
+type CachingFallback[T any] struct {
+	// MaxAge, if set, is how long after a value is stored that it may be served.  Older values are not served.
+	MaxAge time.Duration
+	// Now returns the current time.  It defaults to time.Now.  You only want to modify this for testing.
+	Now func() time.Time
+
+	mu       sync.RWMutex
+	value    T
+	storedAt time.Time
+	stored   bool
+}
+
+func (c *CachingFallback[T]) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
+// Store saves value as the last known good value
+func (c *CachingFallback[T]) Store(value T) {
+	now := c.now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.value = value
+	c.storedAt = now
+	c.stored = true
+}
+
+// Load returns the last stored value.  It returns false if nothing was stored yet, or the value is older than MaxAge.
+func (c *CachingFallback[T]) Load() (T, bool) {
+	now := c.now()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.stored || (c.MaxAge > 0 && now.Sub(c.storedAt) > c.MaxAge) {
+		var zero T
+		return zero, false
+	}
+	return c.value, true
+}
+
+// Run wraps runFunc so each result it returns without an error is stored
+func (c *CachingFallback[T]) Run(runFunc func(context.Context) (T, error)) func(context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		ret, err := runFunc(ctx)
+		if err == nil {
+			c.Store(ret)
+		}
+		return ret, err
+	}
+}
+
+// Fallback serves the value from Load.  If there is none, it returns err unchanged, so the circuit returns the
+// original error instead of a *circuit.FallbackError.
+func (c *CachingFallback[T]) Fallback(_ context.Context, err error) (T, error) {
+	if ret, ok := c.Load(); ok {
+		return ret, nil
+	}
+	var zero T
+	return zero, err
+}
+
+// Execute calls runFunc inside cb with circuit.RunWithResult, storing its successful results and serving the last one
+// when it fails or cb is open
+func (c *CachingFallback[T]) Execute(ctx context.Context, cb *circuit.Circuit, runFunc func(context.Context) (T, error)) (T, error) {
+	return circuit.RunWithResult(ctx, cb, c.Run(runFunc), c.Fallback)
+}
@@ -0,0 +1,71 @@
+//go:build go1.18
+// +build go1.18
+
+package cachingfallback
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/clock"
+)
+
+func TestCachingFallback(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	cache := &CachingFallback[string]{
+		MaxAge: time.Minute,
+		Now:    clk.Now,
+	}
+	c := circuit.NewCircuitFromConfig("TestCachingFallback", circuit.Config{})
+	ctx := context.Background()
+	errDown := errors.New("down")
+	fails := func(_ context.Context) (string, error) {
+		return "", errDown
+	}
+
+	if _, err := cache.Execute(ctx, c, fails); err != errDown {
+		t.Error("expected the original error before anything was cached", err)
+	}
+
+	ret, err := cache.Execute(ctx, c, func(_ context.Context) (string, error) {
+		return "good", nil
+	})
+	if err != nil || ret != "good" {
+		t.Fatalf("expected the run result, saw %q %v", ret, err)
+	}
+	clk.Add(time.Second * 30)
+	if ret, err := cache.Execute(ctx, c, fails); err != nil || ret != "good" {
+		t.Errorf("expected the fresh cached value, saw %q %v", ret, err)
+	}
+	c.OpenCircuit()
+	if ret, err := cache.Execute(ctx, c, fails); err != nil || ret != "good" {
+		t.Errorf("expected the cached value while the circuit is open, saw %q %v", ret, err)
+	}
+
+	clk.Add(time.Minute)
+	c.CloseCircuit()
+	if ret, err := cache.Execute(ctx, c, fails); err != errDown || ret != "" {
+		t.Errorf("expected a stale value to not be served, saw %q %v", ret, err)
+	}
+}
+
+func TestCachingFallback_Concurrent(t *testing.T) {
+	cache := &CachingFallback[int]{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			cache.Store(i)
+			if _, ok := cache.Load(); !ok {
+				t.Error("expected a value once one is stored")
+			}
+		}(i)
+	}
+	wg.Wait()
+}
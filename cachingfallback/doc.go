@@ -0,0 +1,5 @@
+/*
+Package cachingfallback serves the last known good value of a call when the call fails or its circuit is open.  It
+needs go 1.18 or later.
+*/
+package cachingfallback
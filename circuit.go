@@ -3,6 +3,7 @@ package circuit
 import (
 	"context"
 	"expvar"
+	"runtime/debug"
 	"sync"
 	"time"
 
@@ -15,9 +16,13 @@ type Circuit struct {
 	CmdMetricCollector      RunMetricsCollection
 	FallbackMetricCollector FallbackMetricsCollection
 	CircuitMetricsCollector MetricsCollection
+	// Collectors added after the circuit was created.  They are the last entries of the collections above.
+	appendedRunMetrics      appendedRunMetrics
+	appendedFallbackMetrics appendedFallbackMetrics
 	// This is used to help run `Go` calls in the background
 	goroutineWrapper goroutineWrapper
 	name             string
+	labels           map[string]string
 	// The passed in config is not atomic and thread safe.  We reference thread safe values during circuit operations
 	// with atomicCircuitConfig.  Those are, also, the only values that can actually be changed while a circuit is
 	// running.
@@ -29,18 +34,66 @@ type Circuit struct {
 
 	// Tracks if the circuit has been shut open or closed
 	isOpen faststats.AtomicBoolean
+	// UnixNano of the last time the circuit opened or closed.  Zero if it never has
+	lastTransitionTime faststats.AtomicInt64
+	// UnixNano of the last half open attempt OpenToClose allowed, and of the last one that succeeded.  Zero if none
+	lastProbeTime           faststats.AtomicInt64
+	lastSuccessfulProbeTime faststats.AtomicInt64
+	// When the circuit was created.  WarmupDuration is measured from here
+	createdAt time.Time
+	// Errors seen during CloseGracePeriod since the circuit last closed
+	closeGraceErrors faststats.AtomicInt64
+
+	// Tracks if the circuit is rejecting new calls for Drain
+	draining faststats.AtomicBoolean
+	// Set by SetExternalHealth.  While true, an open circuit does not probe or close on its own
+	externallyUnhealthy faststats.AtomicBoolean
+	// Set if any run collector implements OutcomeLabelMetrics, so runFunc's context can carry a SetOutcomeLabel label
+	labelsOutcomes faststats.AtomicBoolean
 
 	// Tracks how many commands are currently running
 	concurrentCommands faststats.AtomicInt64
+	// Commands waiting for a slot under Execution.MaxConcurrencyWait.  slotFreed is closed, under slotMu, when a
+	// command ends while any are waiting
+	slotWaiters faststats.AtomicInt64
+	slotFreed   chan struct{}
+	slotMu      sync.Mutex
 	// Tracks how many fallbacks are currently running
 	concurrentFallbacks faststats.AtomicInt64
+	// How many commands were rejected over the concurrency limit since one last got a slot.  Only tracked with a logger
+	concurrencyRejectBurst faststats.AtomicInt64
 
 	// ClosedToOpen controls when to open a closed circuit
 	ClosedToOpen ClosedToOpen
 	// openToClosed controls when to close an open circuit
 	OpenToClose OpenToClosed
 
-	timeNow func() time.Time
+	timeNow           func() time.Time
+	timeAfterFunc     func(time.Duration, func()) *time.Timer
+	runTracer         RunTracer
+	badRequestChecker BadRequestChecker
+	severityChecker   SeverityChecker
+	onStateChange     func(c *Circuit, isOpen bool)
+	onAdmission       func(c *Circuit, decision Admission)
+	budgetFunc        func(ctx context.Context) bool
+	shortCircuitError func(ctx context.Context) error
+	logger            Logger
+	concurrencyPool   *ConcurrencyPool
+	// concurrencyLimiter replaces the counting of MaxConcurrentRequests, if set
+	concurrencyLimiter ConcurrencyLimiter
+	defaultFallback    func(context.Context, error) error
+	// reusableAfterFunc is timeAfterFunc, or nil if that is the default TimeKeeper's time.AfterFunc, so a reusableTimer
+	// can Reset its timer and withTimer can use a plain deadline
+	reusableAfterFunc func(time.Duration, func()) *time.Timer
+	// recentOutcomes is nil unless GeneralConfig.RecentOutcomeCount is set
+	recentOutcomes *outcomeRing
+	// openOnSuccess is true if ClosedToOpen wants ShouldOpen called after successes too
+	openOnSuccess bool
+	// noRunMetrics is true if nothing configured can use run metrics: see canRunFast
+	noRunMetrics bool
+	// nameValue is name, boxed once so adding it to each call's context does not allocate
+	nameValue  interface{}
+	rejections rejectionErrors
 }
 
 // NewCircuitFromConfig creates an inline circuit.  If you want to group all your circuits together, you should probably
@@ -50,16 +103,124 @@ func NewCircuitFromConfig(name string, config Config) *Circuit {
 	ret := &Circuit{
 		name:                name,
 		notThreadSafeConfig: config,
+		rejections:          newRejectionErrors(name),
+		nameValue:           name,
 	}
 	ret.SetConfigNotThreadSafe(config)
+	ret.createdAt = ret.now()
 	return ret
 }
 
-// ConcurrentCommands returns how many commands are currently running
+// NewDisabledCircuit creates a circuit with General.Disabled set.  Execute and Run just call runFunc: the circuit never
+// opens, short circuits, limits concurrency, or reports metrics.  Use it to turn circuit breaking off for a code path
+// without changing its callers.  SetConfigThreadSafe can enable the circuit later.
+func NewDisabledCircuit(name string) *Circuit {
+	return NewCircuitFromConfig(name, Config{
+		General: GeneralConfig{
+			Disabled: true,
+		},
+	})
+}
+
+// Clone creates a new, closed circuit named newName with the same config as c, but none of its state or stats.  Use
+// it to try a config change on a copy of a circuit.  Open and close logic is made again by the config's factories.
+// Metric collectors that implement MetricsCloner, such as rolling stats, are replaced by their clones.  Any others are
+// shared, so they receive metrics from both circuits.  Collectors added with AppendRunMetrics or
+// AppendFallbackMetrics are not copied.  The clone is not tracked by any Manager.
+func (c *Circuit) Clone(newName string) *Circuit {
+	config := c.Config()
+	// Copy the maps, so changing one circuit's labels or custom config does not change the other's
+	general := config.General
+	config.General.Labels = nil
+	config.General.CustomConfig = nil
+	config.General.mergeLabels(general)
+	config.General.mergeCustomConfig(general)
+
+	metrics := config.Metrics
+	config.Metrics = MetricsCollectors{}
+	for _, m := range metrics.Run {
+		if r, ok := cloneMetrics(m, newName).(RunMetrics); ok {
+			m = r
+		}
+		config.Metrics.Run = append(config.Metrics.Run, m)
+	}
+	for _, m := range metrics.Fallback {
+		if f, ok := cloneMetrics(m, newName).(FallbackMetrics); ok {
+			m = f
+		}
+		config.Metrics.Fallback = append(config.Metrics.Fallback, m)
+	}
+	for _, m := range metrics.Circuit {
+		if cm, ok := cloneMetrics(m, newName).(Metrics); ok {
+			m = cm
+		}
+		config.Metrics.Circuit = append(config.Metrics.Circuit, m)
+	}
+	return NewCircuitFromConfig(newName, config)
+}
+
+// AppendRunMetrics adds a run collector to a circuit that is already in use.  It starts receiving events from the
+// next Execute call onward.  It is safe to call while the circuit is running.
+func (c *Circuit) AppendRunMetrics(m RunMetrics) {
+	setLabels(m, c.labels)
+	c.appendedRunMetrics.append(m)
+	if wantsOutcomeLabels([]RunMetrics{m}) {
+		c.labelsOutcomes.Set(true)
+	}
+}
+
+// AppendFallbackMetrics adds a fallback collector to a circuit that is already in use.  It starts receiving events
+// from the next Execute call onward.  It is safe to call while the circuit is running.
+func (c *Circuit) AppendFallbackMetrics(m FallbackMetrics) {
+	setLabels(m, c.labels)
+	c.appendedFallbackMetrics.append(m)
+}
+
+// ConcurrentCommands returns how many commands are currently running.  It is a lock free atomic read, so it is cheap
+// enough to poll as a gauge next to Execution.MaxConcurrentRequests.
 func (c *Circuit) ConcurrentCommands() int64 {
 	return c.concurrentCommands.Get()
 }
 
+// AbandonedGoroutines returns how many goroutines started by Go are still running after Go returned without them,
+// because the context ended first.  A count that keeps growing means a runFunc or fallback ignores its context.  The
+// circuit cannot stop those goroutines: it can only count them, and log each one if General.Logger is set.
+func (c *Circuit) AbandonedGoroutines() int64 {
+	return c.goroutineWrapper.abandoned.Get()
+}
+
+func (c *Circuit) logAbandonedGoroutine(stillRunning int64) {
+	c.logger.Printf("circuit %s: Go returned before its goroutine ended: %d still running", c.name, stillRunning)
+}
+
+// SetConcurrencyLimit changes Execution.MaxConcurrentRequests while the circuit is running, keeping its stats.  Commands
+// already running above a lower limit finish normally, but new ones are rejected until enough of them end.
+func (c *Circuit) SetConcurrencyLimit(maxConcurrentRequests int64) {
+	c.notThreadSafeConfigMu.Lock()
+	defer c.notThreadSafeConfigMu.Unlock()
+	c.notThreadSafeConfig.Execution.MaxConcurrentRequests = maxConcurrentRequests
+	c.threadSafeConfig.Execution.MaxConcurrentRequests.Set(maxConcurrentRequests)
+}
+
+// ConcurrencyLimit returns the current Execution.MaxConcurrentRequests
+func (c *Circuit) ConcurrencyLimit() int64 {
+	return c.threadSafeConfig.Execution.MaxConcurrentRequests.Get()
+}
+
+// SetDryRun changes General.DryRun while the circuit is running.  With it on, runFunc is always called, even when the
+// circuit is open, while metrics and open/closed state are still tracked.
+func (c *Circuit) SetDryRun(dryRun bool) {
+	c.notThreadSafeConfigMu.Lock()
+	defer c.notThreadSafeConfigMu.Unlock()
+	c.notThreadSafeConfig.General.DryRun = dryRun
+	c.threadSafeConfig.CircuitBreaker.DryRun.Set(dryRun)
+}
+
+// IsDryRun returns the current General.DryRun
+func (c *Circuit) IsDryRun() bool {
+	return c.threadSafeConfig.CircuitBreaker.DryRun.Get()
+}
+
 // ConcurrentFallbacks returns how many fallbacks are currently running
 func (c *Circuit) ConcurrentFallbacks() int64 {
 	return c.concurrentFallbacks.Get()
@@ -72,7 +233,7 @@ func (c *Circuit) SetConfigThreadSafe(config Config) {
 	c.notThreadSafeConfigMu.Lock()
 	defer c.notThreadSafeConfigMu.Unlock()
 	//c.circuitStats.SetConfigThreadSafe(config)
-	c.threadSafeConfig.reset(c.notThreadSafeConfig)
+	c.threadSafeConfig.reset(config)
 	c.notThreadSafeConfig = config
 	if cfg, ok := c.OpenToClose.(Configurable); ok {
 		cfg.SetConfigThreadSafe(config)
@@ -100,10 +261,48 @@ func (c *Circuit) SetConfigNotThreadSafe(config Config) {
 	c.notThreadSafeConfigMu.Unlock()
 
 	c.goroutineWrapper.lostErrors = config.General.GoLostErrors
+	c.goroutineWrapper.recoverPanics = &c.threadSafeConfig.Execution.RecoverPanics
+	c.goroutineWrapper.onAbandoned = nil
+	if config.General.Logger != nil {
+		c.goroutineWrapper.onAbandoned = c.logAbandonedGoroutine
+	}
 	c.timeNow = config.General.TimeKeeper.Now
+	c.timeAfterFunc = config.General.TimeKeeper.AfterFunc
+	c.reusableAfterFunc = c.timeAfterFunc
+	if c.timeAfterFunc == nil || config.General.TimeKeeper.realAfterFunc {
+		c.timeAfterFunc = time.AfterFunc
+		c.reusableAfterFunc = nil
+	}
+	c.runTracer = config.General.RunTracer
+	c.badRequestChecker = config.General.BadRequestChecker
+	c.severityChecker = config.General.SeverityChecker
+	c.onStateChange = config.General.OnStateChange
+	c.onAdmission = config.General.OnAdmission
+	c.budgetFunc = config.General.BudgetFunc
+	c.shortCircuitError = config.General.ShortCircuitError
+	c.logger = config.General.Logger
+	c.defaultFallback = config.Fallback.Default
+	c.concurrencyPool = config.Execution.ConcurrencyPool
+	c.concurrencyLimiter = config.Execution.ConcurrencyLimiter
 
 	c.OpenToClose = config.General.OpenToClosedFactory()
 	c.ClosedToOpen = config.General.ClosedToOpenFactory()
+	if tk, ok := c.OpenToClose.(TimeKeeperSetter); ok {
+		tk.SetTimeKeeper(config.General.TimeKeeper)
+	}
+	if tk, ok := c.ClosedToOpen.(TimeKeeperSetter); ok {
+		tk.SetTimeKeeper(config.General.TimeKeeper)
+	}
+	if rs, ok := c.OpenToClose.(RandSetter); ok && config.General.RandInt63n != nil {
+		rs.SetRand(config.General.RandInt63n)
+	}
+	if rs, ok := c.ClosedToOpen.(RandSetter); ok && config.General.RandInt63n != nil {
+		rs.SetRand(config.General.RandInt63n)
+	}
+	c.openOnSuccess = false
+	if o, ok := c.ClosedToOpen.(OpensOnSuccess); ok {
+		c.openOnSuccess = o.OpensOnSuccess()
+	}
 	if cfg, ok := c.OpenToClose.(Configurable); ok {
 		cfg.SetConfigNotThreadSafe(config)
 	}
@@ -111,14 +310,25 @@ func (c *Circuit) SetConfigNotThreadSafe(config Config) {
 		cfg.SetConfigNotThreadSafe(config)
 	}
 	c.CmdMetricCollector = append(
-		make([]RunMetrics, 0, len(config.Metrics.Run)+2),
+		make([]RunMetrics, 0, len(config.Metrics.Run)+3),
 		c.OpenToClose,
 		c.ClosedToOpen)
 	c.CmdMetricCollector = append(c.CmdMetricCollector, config.Metrics.Run...)
+	c.recentOutcomes = nil
+	if config.General.RecentOutcomeCount > 0 {
+		c.recentOutcomes = newOutcomeRing(c.name, config.General.RecentOutcomeCount)
+		c.CmdMetricCollector = append(c.CmdMetricCollector, c.recentOutcomes)
+	}
+	_, neverOpen := c.ClosedToOpen.(neverOpens)
+	_, neverClose := c.OpenToClose.(neverCloses)
+	c.noRunMetrics = neverOpen && neverClose && len(config.Metrics.Run) == 0 && c.recentOutcomes == nil
+	c.labelsOutcomes.Set(wantsOutcomeLabels(c.CmdMetricCollector) || wantsOutcomeLabels(c.appendedRunMetrics.load()))
+	c.CmdMetricCollector = append(c.CmdMetricCollector, &c.appendedRunMetrics)
 
 	c.FallbackMetricCollector = append(
-		make([]FallbackMetrics, 0, len(config.Metrics.Fallback)+2),
+		make([]FallbackMetrics, 0, len(config.Metrics.Fallback)+1),
 		config.Metrics.Fallback...)
+	c.FallbackMetricCollector = append(c.FallbackMetricCollector, &c.appendedFallbackMetrics)
 
 	c.CircuitMetricsCollector = append(
 		make([]Metrics, 0, len(config.Metrics.Circuit)+2),
@@ -126,6 +336,17 @@ func (c *Circuit) SetConfigNotThreadSafe(config Config) {
 		c.ClosedToOpen)
 	c.CircuitMetricsCollector = append(c.CircuitMetricsCollector, config.Metrics.Circuit...)
 
+	c.labels = config.General.Labels
+	for _, m := range config.Metrics.Run {
+		setLabels(m, c.labels)
+	}
+	for _, m := range config.Metrics.Fallback {
+		setLabels(m, c.labels)
+	}
+	for _, m := range config.Metrics.Circuit {
+		setLabels(m, c.labels)
+	}
+
 	c.SetConfigThreadSafe(config)
 }
 
@@ -143,6 +364,7 @@ func (c *Circuit) Var() expvar.Var {
 			"run_metrics":          expvarToVal(c.CmdMetricCollector.Var()),
 			"concurrent_commands":  c.ConcurrentCommands(),
 			"concurrent_fallbacks": c.ConcurrentFallbacks(),
+			"abandoned_goroutines": c.AbandonedGoroutines(),
 			"closer":               c.OpenToClose,
 			"opener":               c.ClosedToOpen,
 			"fallback_metrics":     expvarToVal(c.FallbackMetricCollector.Var()),
@@ -151,11 +373,16 @@ func (c *Circuit) Var() expvar.Var {
 	})
 }
 
-// Name of this circuit
+// Name of this circuit, as given when it was created.  It never changes, so reading it needs no locking.
 func (c *Circuit) Name() string {
 	return c.name
 }
 
+// Labels returns the General.Labels the circuit gives its metric collectors.  Do not modify the returned map.
+func (c *Circuit) Labels() map[string]string {
+	return c.labels
+}
+
 // IsOpen returns true if the circuit should be considered 'open' (ie not allowing runFunc calls)
 func (c *Circuit) IsOpen() bool {
 	if c.threadSafeConfig.CircuitBreaker.ForceOpen.Get() {
@@ -167,15 +394,26 @@ func (c *Circuit) IsOpen() bool {
 	return c.isOpen.Get()
 }
 
+// LastTransitionTime returns when the circuit last opened or closed, using the circuit's TimeKeeper.  It returns the
+// zero time if the circuit has never changed state.
+func (c *Circuit) LastTransitionTime() time.Time {
+	nanos := c.lastTransitionTime.Get()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
 // CloseCircuit closes an open circuit.  Usually because we think it's healthy again.  Be aware, if the circuit isn't actually
-// healthy, it will just open back up again.
+// healthy, it will just open back up again.  Closing an already closed circuit does nothing.
 func (c *Circuit) CloseCircuit() {
 	c.close(c.now(), true)
 }
 
-// OpenCircuit will open a closed circuit.  The circuit will then try to repair itself
+// OpenCircuit will open a closed circuit.  The circuit will then try to repair itself.  Opening an already open circuit
+// does nothing.
 func (c *Circuit) OpenCircuit() {
-	c.openCircuit(time.Now())
+	c.openCircuit(c.now())
 }
 
 // OpenCircuit opens a circuit, without checking error thresholds or request volume thresholds.  The circuit will, after
@@ -189,89 +427,513 @@ func (c *Circuit) openCircuit(now time.Time) {
 		// Don't bother opening a circuit that is already open
 		return
 	}
-	c.CircuitMetricsCollector.Opened(now)
-	c.isOpen.Set(true)
+	// Only the caller that actually changes the state reports the transition
+	if c.isOpen.CompareAndSwap(false, true) {
+		c.lastTransitionTime.Set(now.UnixNano())
+		c.CircuitMetricsCollector.Opened(now)
+		c.notifyStateChange(true)
+	}
+}
+
+// SetExternalHealth lets an out of band health check, like a dependency's own health endpoint, drive the circuit.
+// Marking it unhealthy opens the circuit right away, the same way OpenCircuit does, and keeps it open without any half
+// open attempts.  Marking it healthy again lets OpenToClose probe and close the circuit as usual.  Error based opening
+// keeps working either way, and CloseCircuit still closes the circuit.
+func (c *Circuit) SetExternalHealth(healthy bool) {
+	c.externallyUnhealthy.Set(!healthy)
+	if !healthy {
+		c.openCircuit(c.now())
+	}
+}
+
+// IsExternallyUnhealthy is true if SetExternalHealth last marked the circuit unhealthy
+func (c *Circuit) IsExternallyUnhealthy() bool {
+	return c.externallyUnhealthy.Get()
+}
+
+// Drain makes the circuit reject every new call with a *DrainingError, without calling runFunc or fallbackFunc, while
+// calls already running finish normally.  Use it during graceful shutdown.  Unlike ForceOpen, rejected calls are not
+// reported to any metrics, so draining does not look like the dependency failing.
+func (c *Circuit) Drain() {
+	c.draining.Set(true)
+}
+
+// UndoDrain lets the circuit accept new calls again after Drain
+func (c *Circuit) UndoDrain() {
+	c.draining.Set(false)
+}
+
+// IsDraining returns true if Drain was called without a following UndoDrain
+func (c *Circuit) IsDraining() bool {
+	return c.draining.Get()
 }
 
 // Go executes `Execute`, but uses spawned goroutines to end early if the context is canceled.  Use this if you don't trust
 // the runFunc to end correctly if context fails.  This is a design mirroed in the go-hystrix library, but be warned it
 // is very dangerous and could leave orphaned goroutines hanging around forever doing who knows what.
 func (c *Circuit) Go(ctx context.Context, runFunc func(context.Context) error, fallbackFunc func(context.Context, error) error) error {
-	return c.Execute(ctx, c.goroutineWrapper.run(runFunc), c.goroutineWrapper.fallback(fallbackFunc))
+	// The goroutines may outlive the call, so their contexts are never reused
+	_, err := c.execute(ctx, c.goroutineWrapper.run(runFunc), c.goroutineWrapper.fallback(fallbackFunc), false)
+	return err
 }
 
-// Run will execute the circuit without a fallback.  It is the equivalent of calling Execute with a nil fallback function
+// Run will execute the circuit without a fallback.  It is the equivalent of calling Execute with a nil fallback function,
+// so Fallback.Default still applies
 func (c *Circuit) Run(ctx context.Context, runFunc func(context.Context) error) error {
 	return c.Execute(ctx, runFunc, nil)
 }
 
+// WrapFallback returns a fallback function, for another circuit, that calls fallbackFunc inside this circuit.  Use it
+// when the fallback calls a service that can fail too:
+//
+//	primary.Execute(ctx, callPrimary, secondary.WrapFallback(callSecondary))
+//
+// Each circuit reports only its own outcomes: a rejection by the primary is a short circuit for the primary and the
+// start of a fallback, not a failure for the secondary.  If the secondary rejects the fallback, Execute returns a
+// *FallbackError whose FallbackErr is the secondary's RejectedError, so CircuitName tells callers which circuit
+// rejected them.  The secondary has no fallback
+// of its own, other than its Fallback.Default.  Context overrides, like WithTimeout, only apply to the primary: the
+// secondary is called from the primary's fallback, so it uses its own config.
+func (c *Circuit) WrapFallback(fallbackFunc func(context.Context, error) error) func(context.Context, error) error {
+	return func(ctx context.Context, err error) error {
+		return c.Execute(ctx, func(ctx context.Context) error {
+			return fallbackFunc(ctx, err)
+		}, nil)
+	}
+}
+
 // Execute the circuit.  Prefer this over Go.  Similar to http://netflix.github.io/Hystrix/javadoc/com/netflix/hystrix/HystrixCommand.html#execute--
+// If the fallback fails too, the error is a *FallbackError holding both errors.
 func (c *Circuit) Execute(ctx context.Context, runFunc func(context.Context) error, fallbackFunc func(context.Context, error) error) error {
+	_, err := c.execute(ctx, runFunc, fallbackFunc, c.threadSafeConfig.Execution.ReuseContexts.Get())
+	return err
+}
+
+// ExecuteWithInfo is Execute, but also returns how the circuit handled the call.  info.Outcome is the same
+// classification the circuit reported to its RunMetrics, so callers do not need to inspect the error to tell a
+// timeout from a short circuit.  A disabled circuit does no classification: its Outcome is OutcomeSuccess or
+// OutcomeFailure, depending only on runFunc's error.  info is filled in on every path, including success.
+func (c *Circuit) ExecuteWithInfo(ctx context.Context, runFunc func(context.Context) error, fallbackFunc func(context.Context, error) error) (ExecutionInfo, error) {
+	startTime := c.now()
+	info, err := c.execute(ctx, runFunc, fallbackFunc, c.threadSafeConfig.Execution.ReuseContexts.Get())
+	info.Duration = c.now().Sub(startTime)
+	return info, err
+}
+
+// execute is ExecuteWithInfo without measuring Duration, so Execute does not pay for reading the clock.  If
+// reuseContexts, the contexts it makes for the call are pooled: see Execution.ReuseContexts.
+func (c *Circuit) execute(ctx context.Context, runFunc func(context.Context) error, fallbackFunc func(context.Context, error) error, reuseContexts bool) (ExecutionInfo, error) {
+	if c.draining.Get() {
+		return ExecutionInfo{Outcome: OutcomeDraining}, c.rejections.draining
+	}
 	if c.threadSafeConfig.CircuitBreaker.Disabled.Get() {
-		return runFunc(ctx)
+		if err := runFunc(withoutOverrides(ctx)); err != nil {
+			return ExecutionInfo{Outcome: OutcomeFailure}, err
+		}
+		return ExecutionInfo{Outcome: OutcomeSuccess}, nil
+	}
+
+	// A hedged call can return while the losing side still uses its contexts
+	if reuseContexts && c.threadSafeConfig.Fallback.Hedge.Get() {
+		reuseContexts = false
+	}
+	caller := ctx
+	if reuseContexts {
+		nameCtx := reuseNameContext(ctx, c.nameValue)
+		defer nameCtx.release()
+		ctx = nameCtx
+	} else {
+		ctx = withCircuitName(ctx, c.nameValue)
+	}
+	if fallbackFunc == nil {
+		fallbackFunc = c.defaultFallback
+	}
+	var info ExecutionInfo
+	var err error
+	if c.runTracer == nil {
+		info, err = c.runAndFallback(ctx, runFunc, fallbackFunc, reuseContexts)
+	} else {
+		var span RunSpan
+		ctx, span = c.runTracer.StartRun(ctx, c.name)
+		info, err = c.runAndFallback(ctx, runFunc, fallbackFunc, reuseContexts)
+		span.End(info, err)
 	}
+	// The caller sees the original error, so a circuit this one is nested in is told it was a bad request
+	if info.Outcome == OutcomeBadRequest {
+		markNestedBadRequest(caller, err)
+	}
+	return info, err
+}
 
+func (c *Circuit) runAndFallback(ctx context.Context, runFunc func(context.Context) error, fallbackFunc func(context.Context, error) error, reuseContexts bool) (ExecutionInfo, error) {
+	var runDuration time.Duration
+	if c.hasRunMetrics() && !withoutMetricsFromContext(ctx) {
+		startTime := c.now()
+		defer func() {
+			c.CmdMetricCollector.ExecuteDuration(startTime, runDuration, c.reportedDuration(c.now().Sub(startTime)))
+		}()
+	}
+	// budgetEnd is when runFunc and the fallback together must be done, or zero for no budget
+	var budgetEnd time.Time
+	if budget := c.threadSafeConfig.Execution.TotalBudget.Duration(); budget > 0 {
+		budgetEnd = c.now().Add(budget)
+	}
+	if c.threadSafeConfig.Fallback.Hedge.Get() && c.fallbackWillRun(ctx, fallbackFunc) {
+		var info ExecutionInfo
+		var err error
+		info, runDuration, err = c.hedge(ctx, runFunc, fallbackFunc, budgetEnd)
+		return info, err
+	}
 	// Try to run the command in the context of the circuit
-	err := c.run(ctx, runFunc)
+	outcome, runDuration, err := c.run(ctx, runFunc, budgetEnd, reuseContexts)
+	info := ExecutionInfo{Outcome: outcome}
 	if err == nil {
-		return nil
+		return info, nil
 	}
 	// A bad request should not trigger fallback logic.  The user just gave bad input.
 	// The list of conditions that trigger fallbacks is documented at
 	// https://github.com/Netflix/Hystrix/wiki/Metrics-and-Monitoring#command-execution-event-types-comnetflixhystrixhystrixeventtype
-	if IsBadRequest(err) {
-		return err
+	if outcome == OutcomeBadRequest {
+		return info, unwrapSimpleBadRequest(err)
 	}
-	return c.fallback(ctx, err, fallbackFunc)
+	if outcome == OutcomeShortCircuit && c.shortCircuitError != nil && !c.fallbackWillRun(ctx, fallbackFunc) {
+		if custom := c.shortCircuitError(ctx); custom != nil {
+			err = custom
+		}
+	}
+	if fallbackFunc != nil && withoutFallbackFromContext(ctx) {
+		info.FallbackSkipped = true
+		c.FallbackMetricCollector.Skipped(c.now())
+		return info, err
+	}
+	if fallbackFunc != nil && !c.threadSafeConfig.Fallback.Disabled.Get() {
+		info.FallbackCalled = true
+	}
+	shortCircuited := outcome == OutcomeShortCircuit || outcome == OutcomeConcurrencyLimitReject || outcome == OutcomeBudgetExhausted
+	return info, c.fallback(ctx, err, fallbackFunc, shortCircuited, budgetEnd)
 }
 
 // --------- only private functions below here
 
-func (c *Circuit) throttleConcurrentCommands(currentCommandCount int64) error {
-	if c.threadSafeConfig.Execution.MaxConcurrentRequests.Get() >= 0 && currentCommandCount > c.threadSafeConfig.Execution.MaxConcurrentRequests.Get() {
-		return errThrottledConcucrrentCommands
+// fallbackWillRun is true if fallbackFunc would be called for a call that did not succeed
+func (c *Circuit) fallbackWillRun(ctx context.Context, fallbackFunc func(context.Context, error) error) bool {
+	return fallbackFunc != nil && !c.threadSafeConfig.Fallback.Disabled.Get() && !withoutFallbackFromContext(ctx)
+}
+
+// maxConcurrentRequests is the WithMaxConcurrentRequests override, or Execution.MaxConcurrentRequests
+func (c *Circuit) maxConcurrentRequests(ctx context.Context) int64 {
+	if override, ok := maxConcurrentRequestsFromContext(ctx); ok {
+		return override
+	}
+	return c.threadSafeConfig.Execution.MaxConcurrentRequests.Get()
+}
+
+func (c *Circuit) throttleConcurrentCommands(ctx context.Context, currentCommandCount int64) error {
+	maxConcurrentRequests := c.maxConcurrentRequests(ctx)
+	if maxConcurrentRequests >= 0 && currentCommandCount > maxConcurrentRequests {
+		return c.rejections.concurrencyLimit
 	}
 	return nil
 }
 
+// acquireCommandSlot counts a new running command, waiting up to Execution.MaxConcurrencyWait for a slot if the
+// circuit is at its limit.  waited is true if it had to wait.  If ctx ends while waiting, err is from callerCanceled.
+// With a ConcurrencyLimiter, the limiter decides instead, and limiterRelease must be passed to releaseCommandSlot.
+func (c *Circuit) acquireCommandSlot(ctx context.Context) (waited bool, limiterRelease func(), err error) {
+	if c.concurrencyLimiter != nil {
+		return c.acquireLimiterSlot(ctx)
+	}
+	waited, err = c.acquireCircuitSlot(ctx)
+	return waited, nil, err
+}
+
+// acquireLimiterSlot is acquireCommandSlot for a circuit with a ConcurrencyLimiter.  The limiter may have blocked, so
+// waited is always true.
+func (c *Circuit) acquireLimiterSlot(ctx context.Context) (waited bool, limiterRelease func(), err error) {
+	limiterRelease, ok := c.concurrencyLimiter.Acquire(ctx, weightFromContext(ctx))
+	if !ok {
+		if canceled := c.callerCanceled(ctx); canceled != nil {
+			return true, nil, canceled
+		}
+		return true, nil, c.rejections.concurrencyLimit
+	}
+	c.concurrentCommands.Add(1)
+	if err := c.acquirePoolSlot(); err != nil {
+		limiterRelease()
+		return true, nil, err
+	}
+	return true, limiterRelease, nil
+}
+
+// acquireCircuitSlot is acquireCommandSlot for the circuit's own MaxConcurrentRequests
+func (c *Circuit) acquireCircuitSlot(ctx context.Context) (waited bool, err error) {
+	err = c.throttleConcurrentCommands(ctx, c.concurrentCommands.Add(1))
+	if err == nil {
+		return false, c.acquirePoolSlot()
+	}
+	c.concurrentCommands.Add(-1)
+	wait := c.threadSafeConfig.Execution.MaxConcurrencyWait.Duration()
+	if wait <= 0 {
+		return false, err
+	}
+	c.slotWaiters.Add(1)
+	defer c.slotWaiters.Add(-1)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		// Get the channel before trying, so a slot freed right after the try still wakes us up
+		freed := c.slotFreedChan()
+		if c.throttleConcurrentCommands(ctx, c.concurrentCommands.Add(1)) == nil {
+			return true, c.acquirePoolSlot()
+		}
+		c.concurrentCommands.Add(-1)
+		select {
+		case <-freed:
+		case <-timer.C:
+			return true, err
+		case <-ctx.Done():
+			if canceled := c.callerCanceled(ctx); canceled != nil {
+				return true, canceled
+			}
+			return true, err
+		}
+	}
+}
+
+// acquirePoolSlot takes a slot in Execution.ConcurrencyPool, if there is one, for a command that already has a circuit
+// slot.  If the pool is full, the circuit slot is given back.
+func (c *Circuit) acquirePoolSlot() error {
+	if c.concurrencyPool == nil || c.concurrencyPool.tryAcquire() {
+		return nil
+	}
+	c.releaseCircuitSlot()
+	return c.rejections.concurrencyLimit
+}
+
+// callerCanceled is ctx's error if the caller canceled it or its deadline passed, unless Execution.IgnoreInterrputs is
+// set.  An ignored interrupt runs like any other call.
+func (c *Circuit) callerCanceled(ctx context.Context) error {
+	if c.threadSafeConfig.GoSpecific.IgnoreInterrputs.Get() {
+		return nil
+	}
+	return ctx.Err()
+}
+
+// releaseCommandSlot stops counting a running command, waking anything waiting for a slot.  limiterRelease is from
+// acquireCommandSlot, and is nil without a ConcurrencyLimiter.
+func (c *Circuit) releaseCommandSlot(limiterRelease func()) {
+	if limiterRelease != nil {
+		limiterRelease()
+	}
+	if c.concurrencyPool != nil {
+		c.concurrencyPool.release()
+	}
+	c.releaseCircuitSlot()
+}
+
+// releaseCircuitSlot is releaseCommandSlot for a command that does not hold a pool slot
+func (c *Circuit) releaseCircuitSlot() {
+	c.concurrentCommands.Add(-1)
+	if c.slotWaiters.Get() == 0 {
+		return
+	}
+	c.slotMu.Lock()
+	if c.slotFreed != nil {
+		close(c.slotFreed)
+		c.slotFreed = nil
+	}
+	c.slotMu.Unlock()
+}
+
+func (c *Circuit) slotFreedChan() chan struct{} {
+	c.slotMu.Lock()
+	defer c.slotMu.Unlock()
+	if c.slotFreed == nil {
+		c.slotFreed = make(chan struct{})
+	}
+	return c.slotFreed
+}
+
+// logConcurrencyReject logs the first concurrency limit rejection of a burst
+func (c *Circuit) logConcurrencyReject() {
+	if c.logger != nil && c.concurrencyRejectBurst.Add(1) == 1 {
+		c.logger.Printf("circuit %s: rejecting commands over the concurrency limit", c.name)
+	}
+}
+
+// logConcurrencyRejectsEnded logs the end of a burst of concurrency limit rejections, once a command gets a slot
+func (c *Circuit) logConcurrencyRejectsEnded() {
+	if c.logger == nil || c.concurrencyRejectBurst.Get() == 0 {
+		return
+	}
+	if rejected := c.concurrencyRejectBurst.Swap(0); rejected > 0 {
+		c.logger.Printf("circuit %s: accepting commands again after %d concurrency limit rejections", c.name, rejected)
+	}
+}
+
+// executionTimeout is Execution.Timeout, unless ctx overrides it with WithTimeout
+func (c *Circuit) executionTimeout(ctx context.Context) time.Duration {
+	timeout, ok := timeoutFromContext(ctx)
+	if !ok {
+		return c.threadSafeConfig.Execution.ExecutionTimeout.Duration()
+	}
+	if maxTimeout := c.threadSafeConfig.Execution.MaxTimeoutOverride.Duration(); maxTimeout > 0 && (timeout <= 0 || timeout > maxTimeout) {
+		return maxTimeout
+	}
+	return timeout
+}
+
+// canRunFast is true if run's full bookkeeping would make no difference for this call: nothing receives run metrics,
+// the circuit is closed, and there is no timeout, concurrency limit, or ConcurrencyLimiter.  Without metrics, runFunc's timing is never
+// needed, so the fast path skips reading the clock.
+func (c *Circuit) canRunFast(ctx context.Context) bool {
+	if c.hasRunMetrics() || c.IsOpen() {
+		return false
+	}
+	if c.executionTimeout(ctx) > 0 || c.threadSafeConfig.Execution.TotalBudget.Get() > 0 || c.concurrencyPool != nil || c.concurrencyLimiter != nil {
+		return false
+	}
+	return c.maxConcurrentRequests(ctx) < 0
+}
+
+// hasRunMetrics is true if anything, configured or appended, receives run metrics
+func (c *Circuit) hasRunMetrics() bool {
+	return !c.noRunMetrics || len(c.appendedRunMetrics.load()) != 0
+}
+
+// admit tells OnAdmission, if set, what the circuit decided for a call
+func (c *Circuit) admit(decision Admission) {
+	if c.onAdmission != nil {
+		c.onAdmission(c, decision)
+	}
+}
+
+// runFast is run for calls where canRunFast is true.  It classifies the result the same way run does.
+func (c *Circuit) runFast(ctx context.Context, runFunc func(context.Context) error) (Outcome, error) {
+	c.admit(AdmissionAllowed)
+	c.concurrentCommands.Add(1)
+	defer c.releaseCommandSlot(nil)
+	ret := c.callRunFunc(ctx, runFunc)
+	if ret == nil {
+		return OutcomeSuccess, nil
+	}
+	if c.isBadRequest(ret) {
+		return OutcomeBadRequest, ret
+	}
+	if !c.threadSafeConfig.GoSpecific.IgnoreInterrputs.Get() && ctx.Err() != nil {
+		return OutcomeInterrupt, ret
+	}
+	return OutcomeFailure, ret
+}
+
 // run is the equivalent of Java Manager's http://netflix.github.io/Hystrix/javadoc/com/netflix/hystrix/HystrixCommand.html#run()
-func (c *Circuit) run(ctx context.Context, runFunc func(context.Context) error) (retErr error) {
+// runDuration is how long runFunc ran, or zero if it was never called.  If budgetEnd is set, runFunc times out by then.
+// If reuseContexts, a timeout uses a pooled reusableTimer when ctx can never be canceled.
+func (c *Circuit) run(ctx context.Context, runFunc func(context.Context) error, budgetEnd time.Time, reuseContexts bool) (outcome Outcome, runDuration time.Duration, err error) {
 	if runFunc == nil {
-		return nil
+		return OutcomeSuccess, 0, nil
+	}
+	// Calls made WithoutMetrics report nothing and never probe, so they cannot change the circuit
+	counted := !withoutMetricsFromContext(ctx)
+	metrics := c.CmdMetricCollector
+	if !counted {
+		metrics = nil
+	}
+	// A caller that already gave up should not take a slot or a half open attempt
+	if canceled := c.callerCanceled(ctx); canceled != nil {
+		metrics.ErrInterrupt(c.now(), 0)
+		return OutcomeInterrupt, 0, canceled
+	}
+	if c.budgetFunc != nil && !c.budgetFunc(ctx) {
+		c.admit(AdmissionBudgetExhausted)
+		return OutcomeBudgetExhausted, 0, c.rejections.budgetExhausted
+	}
+	if c.canRunFast(ctx) {
+		outcome, err = c.runFast(ctx, runFunc)
+		return outcome, 0, err
 	}
 	var expectedDoneBy time.Time
 	startTime := c.now()
 	originalContext := ctx
 
-	if !c.allowNewRun(startTime) {
-		// Rather than make this inline, return a global reference (for memory optimization sake).
-		c.CmdMetricCollector.ErrShortCircuit(startTime)
-		return errCircuitOpen
+	// In a dry run, we still ask the open/close logic what it would do, but run anyway
+	dryRun := c.threadSafeConfig.CircuitBreaker.DryRun.Get()
+	if !c.allowNewRun(startTime, counted && !withoutProbeFromContext(ctx)) && !dryRun {
+		// Rather than make this inline, return a per circuit reference (for memory optimization sake).
+		metrics.ErrShortCircuit(startTime)
+		c.admit(AdmissionShortCircuit)
+		return OutcomeShortCircuit, 0, c.rejections.open
 	}
 
-	if c.ClosedToOpen.Prevent(startTime) {
-		return errCircuitOpen
+	if c.ClosedToOpen.Prevent(startTime) && !dryRun {
+		c.admit(AdmissionShortCircuit)
+		return OutcomeShortCircuit, 0, c.rejections.prevented
 	}
 
-	currentCommandCount := c.concurrentCommands.Add(1)
-	defer c.concurrentCommands.Add(-1)
-	if err := c.throttleConcurrentCommands(currentCommandCount); err != nil {
-		c.CmdMetricCollector.ErrConcurrencyLimitReject(startTime)
-		return err
+	waited, limiterRelease, err := c.acquireCommandSlot(ctx)
+	if err != nil && err != c.rejections.concurrencyLimit {
+		// The caller gave up while waiting for a slot
+		metrics.ErrInterrupt(c.now(), 0)
+		return OutcomeInterrupt, 0, err
+	}
+	if err != nil {
+		metrics.ErrConcurrencyLimitReject(startTime)
+		c.logConcurrencyReject()
+		c.admit(AdmissionConcurrencyLimitReject)
+		return OutcomeConcurrencyLimitReject, 0, err
+	}
+	defer c.releaseCommandSlot(limiterRelease)
+	c.admit(AdmissionAllowed)
+	c.logConcurrencyRejectsEnded()
+	if waited {
+		// Time spent waiting for a slot is not part of the command's time or timeout
+		startTime = c.now()
 	}
 
 	// Set timeout on the command if we have one
-	if c.threadSafeConfig.Execution.ExecutionTimeout.Get() > 0 {
-		var timeoutCancel func()
-		expectedDoneBy = startTime.Add(c.threadSafeConfig.Execution.ExecutionTimeout.Duration())
-		ctx, timeoutCancel = context.WithDeadline(ctx, expectedDoneBy)
-		defer timeoutCancel()
+	var timer timeoutTimer
+	timeout := c.executionTimeout(ctx)
+	hasTimeout := timeout > 0
+	if !budgetEnd.IsZero() {
+		if remaining := budgetEnd.Sub(startTime); !hasTimeout || remaining < timeout {
+			timeout, hasTimeout = remaining, true
+		}
+	}
+	if hasTimeout {
+		expectedDoneBy = startTime.Add(timeout)
+		if reuseContexts && ctx.Done() == nil {
+			reused := reuseTimer(ctx, expectedDoneBy, timeout, c.reusableAfterFunc)
+			defer reused.release()
+			ctx, timer = reused, reused
+		} else {
+			timeoutCtx, t, timeoutCancel := withTimer(ctx, expectedDoneBy, timeout, c.reusableAfterFunc)
+			defer timeoutCancel()
+			ctx, timer = timeoutCtx, t
+		}
+	}
+
+	var label *outcomeLabel
+	if c.labelsOutcomes.Get() {
+		ctx, label = withOutcomeLabel(ctx)
 	}
 
-	ret := runFunc(ctx)
+	metrics.Attempt(startTime)
+	ret := c.callRunFunc(ctx, runFunc)
 	endTime := c.now()
-	totalCmdTime := endTime.Sub(startTime)
+	// Timeouts are judged on runFuncDoneTime, so capping only changes what metrics see
+	totalCmdTime := c.reportedDuration(endTime.Sub(startTime))
 	runFuncDoneTime := c.now()
+	if !counted {
+		timedOut := (timer != nil && timer.timedOut()) || (!expectedDoneBy.IsZero() && expectedDoneBy.Before(runFuncDoneTime))
+		return c.uncountedOutcome(ret, originalContext, timedOut), totalCmdTime, ret
+	}
+	if label != nil {
+		defer func() {
+			if l := label.get(); l != "" {
+				metrics.LabeledOutcome(runFuncDoneTime, l, outcome, totalCmdTime)
+			}
+		}()
+	}
 	// See bad request documentation at https://github.com/Netflix/Hystrix/wiki/How-To-Use#error-propagation
 	// This request had invalid input, but shouldn't be marked as an 'error' for the circuit
 	// From documentation
@@ -279,37 +941,85 @@ func (c *Circuit) run(ctx context.Context, runFunc func(context.Context) error)
 	// The HystrixBadRequestException is intended for use cases such as reporting illegal arguments or non-system
 	// failures that should not count against the failure metrics and should not trigger fallback logic.
 	if c.checkErrBadRequest(ret, runFuncDoneTime, totalCmdTime) {
-		return ret
+		return OutcomeBadRequest, totalCmdTime, ret
 	}
 
 	// Even if there is no error (or if there is an error), if the request took too long it is always an error for the
 	// socket.  Note that ret *MAY* actually be nil.  In that case, we still want to return nil.
-	if c.checkErrTimeout(expectedDoneBy, runFuncDoneTime, totalCmdTime) {
+	if c.checkErrTimeout(expectedDoneBy, timer != nil && timer.timedOut(), runFuncDoneTime, totalCmdTime) {
 		// Note: ret could possibly be nil.  We will still return nil, but the circuit will consider it a failure.
-		return ret
+		return OutcomeTimeout, totalCmdTime, ret
 	}
 
 	// The runFunc failed, but someone asked the original context to end.  This probably isn't a failure of the
-	// circuit: someone just wanted `Execute` to end early, so don't track it as a failure.
+	// circuit: someone just wanted `Execute` to end early, so don't track it as a failure.  The circuit's own
+	// Execution.Timeout was already caught above, so only the caller's cancellation or deadline gets here.
 	if c.checkErrInterrupt(originalContext, ret, runFuncDoneTime, totalCmdTime) {
-		return ret
+		return OutcomeInterrupt, totalCmdTime, ret
 	}
 
 	if c.checkErrFailure(ret, runFuncDoneTime, totalCmdTime) {
-		return ret
+		return OutcomeFailure, totalCmdTime, ret
 	}
 
 	// The circuit works.  Close it!
 	// Note: Execute this *after* you check for timeouts so we can still track circuit time outs that happen to also return a
 	//       valid value later.
 	c.checkSuccess(runFuncDoneTime, totalCmdTime)
-	return nil
+	return OutcomeSuccess, totalCmdTime, nil
+}
+
+// callRunFunc calls runFunc.  Bad requests that circuits nested in runFunc unwrapped are wrapped again, so this circuit
+// does not count them as failures either.
+func (c *Circuit) callRunFunc(ctx context.Context, runFunc func(context.Context) error) error {
+	ctx = withoutOverrides(ctx)
+	err := c.recoverRunFunc(ctx, runFunc)
+	if isNestedBadRequest(ctx, err) {
+		return SimpleBadRequest{Err: err}
+	}
+	return err
+}
+
+// recoverRunFunc calls runFunc, turning any panic into a *PanicError if Execution.RecoverPanics is set
+func (c *Circuit) recoverRunFunc(ctx context.Context, runFunc func(context.Context) error) (err error) {
+	if !c.threadSafeConfig.Execution.RecoverPanics.Get() {
+		return runFunc(ctx)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if panicErr, ok := r.(*PanicError); ok {
+				// Go already captured the stack inside its goroutine
+				err = panicErr
+				return
+			}
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return runFunc(ctx)
+}
+
+// uncountedOutcome classifies a call made WithoutMetrics the way the check functions would, without reporting it
+func (c *Circuit) uncountedOutcome(ret error, originalContext context.Context, timedOut bool) Outcome {
+	switch {
+	case c.isBadRequest(ret):
+		return OutcomeBadRequest
+	case timedOut:
+		return OutcomeTimeout
+	case !c.threadSafeConfig.GoSpecific.IgnoreInterrputs.Get() && ret != nil && originalContext.Err() != nil:
+		return OutcomeInterrupt
+	case ret != nil:
+		return OutcomeFailure
+	}
+	return OutcomeSuccess
 }
 
 func (c *Circuit) checkSuccess(runFuncDoneTime time.Time, totalCmdTime time.Duration) {
 	c.CmdMetricCollector.Success(runFuncDoneTime, totalCmdTime)
 	if c.IsOpen() {
+		c.lastSuccessfulProbeTime.Set(runFuncDoneTime.UnixNano())
 		c.close(runFuncDoneTime, false)
+	} else if c.openOnSuccess {
+		c.attemptToOpen(runFuncDoneTime)
 	}
 }
 
@@ -321,8 +1031,26 @@ func (c *Circuit) checkErrInterrupt(originalContext context.Context, ret error,
 	return false
 }
 
+func (c *Circuit) isBadRequest(err error) bool {
+	if IsBadRequest(err) {
+		return true
+	}
+	return err != nil && c.badRequestChecker != nil && c.badRequestChecker.CheckBadRequest(err)
+}
+
+// severity is the severity of a failure's error, from the error itself or else the SeverityChecker
+func (c *Circuit) severity(err error) string {
+	if s := SeverityOf(err); s != "" {
+		return s
+	}
+	if c.severityChecker != nil {
+		return c.severityChecker.CheckSeverity(err)
+	}
+	return ""
+}
+
 func (c *Circuit) checkErrBadRequest(ret error, runFuncDoneTime time.Time, totalCmdTime time.Duration) bool {
-	if IsBadRequest(ret) {
+	if c.isBadRequest(ret) {
 		c.CmdMetricCollector.ErrBadRequest(runFuncDoneTime, totalCmdTime)
 		return true
 	}
@@ -332,7 +1060,10 @@ func (c *Circuit) checkErrBadRequest(ret error, runFuncDoneTime time.Time, total
 func (c *Circuit) checkErrFailure(ret error, runFuncDoneTime time.Time, totalCmdTime time.Duration) bool {
 	if ret != nil {
 		c.CmdMetricCollector.ErrFailure(runFuncDoneTime, totalCmdTime)
-		if !c.IsOpen() {
+		if severity := c.severity(ret); severity != "" {
+			c.CmdMetricCollector.ErrFailureSeverity(runFuncDoneTime, severity, totalCmdTime)
+		}
+		if !c.IsOpen() && !c.inCloseGrace(runFuncDoneTime) {
 			c.attemptToOpen(runFuncDoneTime)
 		}
 		return true
@@ -340,11 +1071,13 @@ func (c *Circuit) checkErrFailure(ret error, runFuncDoneTime time.Time, totalCmd
 	return false
 }
 
-func (c *Circuit) checkErrTimeout(expectedDoneBy time.Time, runFuncDoneTime time.Time, totalCmdTime time.Duration) bool {
+// timedOut is true if the timeout timer fired, even if Now has not reached expectedDoneBy, so a mock AfterFunc can
+// force a timeout
+func (c *Circuit) checkErrTimeout(expectedDoneBy time.Time, timedOut bool, runFuncDoneTime time.Time, totalCmdTime time.Duration) bool {
 	// I don't use the deadline from the context because it could be a smaller timeout from the parent context
-	if !expectedDoneBy.IsZero() && expectedDoneBy.Before(runFuncDoneTime) {
+	if timedOut || (!expectedDoneBy.IsZero() && expectedDoneBy.Before(runFuncDoneTime)) {
 		c.CmdMetricCollector.ErrTimeout(runFuncDoneTime, totalCmdTime)
-		if !c.IsOpen() {
+		if !c.IsOpen() && !c.inCloseGrace(runFuncDoneTime) {
 			c.attemptToOpen(runFuncDoneTime)
 		}
 		return true
@@ -354,7 +1087,8 @@ func (c *Circuit) checkErrTimeout(expectedDoneBy time.Time, runFuncDoneTime time
 
 // Does fallback logic.  Equivalent of
 // http://netflix.github.io/Hystrix/javadoc/com/netflix/hystrix/HystrixCommand.html#getFallback
-func (c *Circuit) fallback(ctx context.Context, err error, fallbackFunc func(context.Context, error) error) error {
+// shortCircuited is true if runFunc was never called.  If budgetEnd is set, the fallback's context ends by then.
+func (c *Circuit) fallback(ctx context.Context, err error, fallbackFunc func(context.Context, error) error, shortCircuited bool, budgetEnd time.Time) error {
 	// Use the fallback command if available
 	if fallbackFunc == nil || c.threadSafeConfig.Fallback.Disabled.Get() {
 		return err
@@ -365,27 +1099,73 @@ func (c *Circuit) fallback(ctx context.Context, err error, fallbackFunc func(con
 	defer c.concurrentFallbacks.Add(-1)
 	if c.threadSafeConfig.Fallback.MaxConcurrentRequests.Get() >= 0 && currentFallbackCount > c.threadSafeConfig.Fallback.MaxConcurrentRequests.Get() {
 		c.FallbackMetricCollector.ErrConcurrencyLimitReject(c.now())
-		return &circuitError{concurrencyLimitReached: true, msg: "throttling concurrency to fallbacks"}
+		return c.rejections.fallbackConcurrency
+	}
+	return c.callFallback(ctx, err, fallbackFunc, shortCircuited, budgetEnd)
+}
+
+// callFallback calls fallbackFunc and reports how it went, without checking Fallback.MaxConcurrentRequests
+func (c *Circuit) callFallback(ctx context.Context, err error, fallbackFunc func(context.Context, error) error, shortCircuited bool, budgetEnd time.Time) error {
+	// Give the fallback its own deadline if we have one
+	if c.threadSafeConfig.Fallback.Timeout.Get() > 0 {
+		var timeoutCancel func()
+		ctx, timeoutCancel = context.WithTimeout(detachedContext{parent: ctx}, c.threadSafeConfig.Fallback.Timeout.Duration())
+		defer timeoutCancel()
+	}
+	// Only what is left of the budget
+	if !budgetEnd.IsZero() {
+		budgetCtx, _, budgetCancel := withTimer(ctx, budgetEnd, budgetEnd.Sub(c.now()), c.reusableAfterFunc)
+		ctx = budgetCtx
+		defer budgetCancel()
 	}
 
 	startTime := c.now()
-	retErr := fallbackFunc(ctx, err)
-	totalCmdTime := c.now().Sub(startTime)
+	retErr := fallbackFunc(withoutOverrides(ctx), err)
+	totalCmdTime := c.reportedDuration(c.now().Sub(startTime))
 	if retErr != nil {
 		c.FallbackMetricCollector.ErrFailure(startTime, totalCmdTime)
-		return retErr
+		if shortCircuited {
+			c.FallbackMetricCollector.ShortCircuitFailure(startTime, totalCmdTime)
+		}
+		if c.isNestedShortCircuit(retErr) {
+			c.FallbackMetricCollector.ErrNestedShortCircuit(startTime, totalCmdTime)
+		}
+		if retErr == err {
+			return retErr
+		}
+		return &FallbackError{Err: err, FallbackErr: retErr}
 	}
 	c.FallbackMetricCollector.Success(startTime, totalCmdTime)
+	if shortCircuited {
+		c.FallbackMetricCollector.ShortCircuitSuccess(startTime, totalCmdTime)
+	}
 	return nil
 }
 
+// isNestedShortCircuit is true if a fallback's error is another circuit refusing to run because it is open
+func (c *Circuit) isNestedShortCircuit(err error) bool {
+	rejected := rejectionOf(err)
+	if rejected == nil || rejected.CircuitName() == c.name {
+		return false
+	}
+	reason := rejected.Reason()
+	return reason == ReasonCircuitOpen || reason == ReasonPrevented
+}
+
 // allowNewRun checks if the circuit is allowing new run commands. This happens if the circuit is closed, or
 // if it is open, but we want to explore to see if we should close it again.
-func (c *Circuit) allowNewRun(now time.Time) bool {
+func (c *Circuit) allowNewRun(now time.Time, canProbe bool) bool {
 	if !c.IsOpen() {
 		return true
 	}
+	if !canProbe || c.threadSafeConfig.CircuitBreaker.ManualClose.Get() || c.externallyUnhealthy.Get() {
+		return false
+	}
 	if c.OpenToClose.Allow(now) {
+		c.lastProbeTime.Set(now.UnixNano())
+		if c.logger != nil {
+			c.logger.Printf("circuit %s: allowed a half open attempt", c.name)
+		}
 		return true
 	}
 	return false
@@ -400,9 +1180,33 @@ func (c *Circuit) close(now time.Time, forceClosed bool) {
 	if c.threadSafeConfig.CircuitBreaker.ForceOpen.Get() {
 		return
 	}
+	if !forceClosed && (c.threadSafeConfig.CircuitBreaker.ManualClose.Get() || c.externallyUnhealthy.Get()) {
+		return
+	}
+	if !forceClosed && now.Sub(c.LastTransitionTime()) < c.threadSafeConfig.CircuitBreaker.MinimumOpenDuration.Duration() {
+		return
+	}
 	if forceClosed || c.OpenToClose.ShouldClose(now) {
-		c.CircuitMetricsCollector.Closed(now)
-		c.isOpen.Set(false)
+		// Only the caller that actually changes the state reports the transition
+		if c.isOpen.CompareAndSwap(true, false) {
+			c.closeGraceErrors.Set(0)
+			c.lastTransitionTime.Set(now.UnixNano())
+			c.CircuitMetricsCollector.Closed(now)
+			c.notifyStateChange(false)
+		}
+	}
+}
+
+func (c *Circuit) notifyStateChange(isOpen bool) {
+	if c.logger != nil {
+		if isOpen {
+			c.logger.Printf("circuit %s: opened", c.name)
+		} else {
+			c.logger.Printf("circuit %s: closed", c.name)
+		}
+	}
+	if c.onStateChange != nil {
+		c.onStateChange(c, isOpen)
 	}
 }
 
@@ -415,6 +1219,10 @@ func (c *Circuit) attemptToOpen(now time.Time) {
 		// Don't open circuits that are forced closed
 		return
 	}
+	if c.warmingUp(now) {
+		// Cold caches and connection pools fail a lot right after start: keep collecting stats, but don't judge them
+		return
+	}
 	if c.IsOpen() {
 		// Don't bother opening a circuit that is already open
 		// This check isn't needed (it is also checked inside OpenCircuit below), but is an optimization to avoid
@@ -426,3 +1234,55 @@ func (c *Circuit) attemptToOpen(now time.Time) {
 		c.openCircuit(now)
 	}
 }
+
+// reportedDuration is d capped at General.MaxReportedDuration, for durations given to metrics
+func (c *Circuit) reportedDuration(d time.Duration) time.Duration {
+	if max := c.threadSafeConfig.CircuitBreaker.MaxReportedDuration.Duration(); max > 0 && d > max {
+		return max
+	}
+	return d
+}
+
+// inCloseGrace counts an error of a closed circuit during CloseGracePeriod, and is true if the error should not be
+// allowed to open it
+func (c *Circuit) inCloseGrace(now time.Time) bool {
+	grace := c.threadSafeConfig.CircuitBreaker.CloseGracePeriod.Duration()
+	if grace <= 0 {
+		return false
+	}
+	// A closed circuit's last transition is its last close.  Circuits that never opened have nothing to recover from.
+	closedAt := c.lastTransitionTime.Get()
+	if closedAt == 0 || now.Sub(time.Unix(0, closedAt)) >= grace {
+		return false
+	}
+	errorsNeeded := c.threadSafeConfig.CircuitBreaker.CloseGraceErrors.Get()
+	return c.closeGraceErrors.Add(1) < errorsNeeded || errorsNeeded <= 0
+}
+
+func (c *Circuit) warmingUp(now time.Time) bool {
+	warmup := c.threadSafeConfig.CircuitBreaker.WarmupDuration.Duration()
+	return warmup > 0 && now.Before(c.createdAt.Add(warmup))
+}
+
+// detachedContext keeps the values of a parent context, but not its deadline or cancellation
+type detachedContext struct {
+	parent context.Context
+}
+
+var _ context.Context = detachedContext{}
+
+func (d detachedContext) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (d detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (d detachedContext) Err() error {
+	return nil
+}
+
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
@@ -3,12 +3,15 @@ package circuit
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/cep21/circuit/faststats"
+	"github.com/cep21/circuit/internal/clock"
 	"github.com/cep21/circuit/internal/testhelp"
 )
 
@@ -48,6 +51,53 @@ func TestBadRequest(t *testing.T) {
 	}
 }
 
+func TestBadRequest_Nested(t *testing.T) {
+	outer := NewCircuitFromConfig("outer", Config{})
+	inner := NewCircuitFromConfig("inner", Config{})
+	original := errors.New("this request is bad")
+	info, err := outer.ExecuteWithInfo(context.Background(), func(ctx context.Context) error {
+		return inner.Execute(ctx, func(_ context.Context) error {
+			return NewBadRequest(original)
+		}, nil)
+	}, nil)
+	if err != original {
+		t.Error("expected callers to see the original error", err)
+	}
+	if info.Outcome != OutcomeBadRequest {
+		t.Errorf("expected the outer circuit to see a bad request, saw %s", info.Outcome)
+	}
+}
+
+func TestAnyAllBadRequest(t *testing.T) {
+	err := errors.New("some error")
+	for _, first := range []bool{false, true} {
+		for _, second := range []bool{false, true} {
+			var askedFirst, askedSecond int
+			checkers := []BadRequestChecker{
+				BadRequestCheckerFunc(func(error) bool { askedFirst++; return first }),
+				nil,
+				BadRequestCheckerFunc(func(error) bool { askedSecond++; return second }),
+			}
+			if got := AnyBadRequest(checkers...).CheckBadRequest(err); got != (first || second) {
+				t.Errorf("AnyBadRequest(%v, %v) = %v", first, second, got)
+			}
+			if askedFirst != 1 || (askedSecond == 1) == first {
+				t.Errorf("AnyBadRequest(%v, %v) should stop at the first yes: asked %d %d", first, second, askedFirst, askedSecond)
+			}
+			askedFirst, askedSecond = 0, 0
+			if got := AllBadRequest(checkers...).CheckBadRequest(err); got != (first && second) {
+				t.Errorf("AllBadRequest(%v, %v) = %v", first, second, got)
+			}
+			if askedFirst != 1 || (askedSecond == 1) != first {
+				t.Errorf("AllBadRequest(%v, %v) should stop at the first no: asked %d %d", first, second, askedFirst, askedSecond)
+			}
+		}
+	}
+	if AnyBadRequest().CheckBadRequest(err) || AllBadRequest(nil).CheckBadRequest(err) {
+		t.Error("no checkers should mean no bad requests")
+	}
+}
+
 func TestManyConcurrent(t *testing.T) {
 	concurrency := 20
 	c := NewCircuitFromConfig("TestManyConcurrent", Config{
@@ -182,6 +232,494 @@ func TestThrottled(t *testing.T) {
 	}
 }
 
+func TestFromContext(t *testing.T) {
+	if name := FromContext(context.Background()); name != "" {
+		t.Error("expected no circuit name outside of a circuit", name)
+	}
+	outer := NewCircuitFromConfig("outer", Config{})
+	inner := NewCircuitFromConfig("inner", Config{})
+	var seen []string
+	err := outer.Execute(context.Background(), func(ctx context.Context) error {
+		seen = append(seen, FromContext(ctx))
+		return inner.Run(ctx, func(ctx context.Context) error {
+			seen = append(seen, FromContext(ctx))
+			return errors.New("inner failure")
+		})
+	}, func(ctx context.Context, err error) error {
+		seen = append(seen, FromContext(ctx))
+		return nil
+	})
+	testhelp.MustTesting(t, err)
+	if len(seen) != 3 || seen[0] != "outer" || seen[1] != "inner" || seen[2] != "outer" {
+		t.Error("unexpected circuit names", seen)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	c := NewCircuitFromConfig("TestWithTimeout", Config{
+		Execution: ExecutionConfig{
+			Timeout:            time.Second,
+			MaxTimeoutOverride: time.Minute,
+		},
+	})
+	budget := func(ctx context.Context) time.Duration {
+		var ret time.Duration
+		testhelp.MustTesting(t, c.Execute(ctx, func(ctx context.Context) error {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				t.Fatal("expected runFunc to have a deadline")
+			}
+			ret = time.Until(deadline)
+			return nil
+		}, nil))
+		return ret
+	}
+	if d := budget(context.Background()); d > time.Second {
+		t.Error("expected the configured timeout without an override", d)
+	}
+	if d := budget(WithTimeout(context.Background(), 30*time.Second)); d <= time.Second || d > 30*time.Second {
+		t.Error("expected the override to apply", d)
+	}
+	if d := budget(WithTimeout(context.Background(), time.Hour)); d <= 30*time.Second || d > time.Minute {
+		t.Error("expected the override to be clamped to MaxTimeoutOverride", d)
+	}
+	if d := budget(WithTimeout(context.Background(), -1)); d <= 30*time.Second || d > time.Minute {
+		t.Error("expected a disabled timeout to be clamped to MaxTimeoutOverride", d)
+	}
+}
+
+func TestWithTimeout_Unclamped(t *testing.T) {
+	c := NewCircuitFromConfig("TestWithTimeout_Unclamped", Config{
+		Execution: ExecutionConfig{
+			Timeout: time.Millisecond,
+		},
+	})
+	err := c.Execute(WithTimeout(context.Background(), time.Minute), func(ctx context.Context) error {
+		time.Sleep(5 * time.Millisecond)
+		return ctx.Err()
+	}, nil)
+	if err != nil {
+		t.Error("expected the longer budget to let the call finish", err)
+	}
+}
+
+func TestWrapFallback(t *testing.T) {
+	primaryRun := &countingRunMetrics{}
+	primaryFallback := &countingRunMetrics{}
+	primary := NewCircuitFromConfig("primary", Config{
+		Metrics: MetricsCollectors{
+			Run:      []RunMetrics{primaryRun},
+			Fallback: []FallbackMetrics{primaryFallback},
+		},
+	})
+	secondaryRun := &countingRunMetrics{}
+	secondary := NewCircuitFromConfig("secondary", Config{
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{secondaryRun},
+		},
+	})
+	var fallbackErr error
+	fallback := secondary.WrapFallback(func(_ context.Context, err error) error {
+		fallbackErr = err
+		return nil
+	})
+
+	primary.OpenCircuit()
+	testhelp.MustTesting(t, primary.Execute(context.Background(), testhelp.AlwaysPasses, fallback))
+	if fallbackErr != primary.rejections.open {
+		t.Error("expected the fallback to see the primary's rejection", fallbackErr)
+	}
+	if secondaryRun.calls.Get() != 1 {
+		t.Error("expected the secondary to count the fallback once", secondaryRun.calls.Get())
+	}
+
+	secondary.OpenCircuit()
+	err := primary.Execute(context.Background(), testhelp.AlwaysPasses, fallback)
+	fallbackFailed, ok := err.(*FallbackError)
+	if !ok {
+		t.Fatal("expected a FallbackError", err)
+	}
+	if rejected, ok := fallbackFailed.FallbackErr.(RejectedError); !ok || rejected.CircuitName() != "secondary" {
+		t.Error("expected the secondary's rejection to reach the caller", err)
+	}
+	if primaryRun.calls.Get() != 2 || primaryFallback.calls.Get() != 2 || secondaryRun.calls.Get() != 2 {
+		t.Error("expected each circuit to count each call once", primaryRun.calls.Get(), primaryFallback.calls.Get(), secondaryRun.calls.Get())
+	}
+}
+
+func TestMaxConcurrencyWait(t *testing.T) {
+	c := NewCircuitFromConfig("TestMaxConcurrencyWait", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+			MaxConcurrencyWait:    time.Second * 5,
+		},
+	})
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(context.Background(), func(_ context.Context) error {
+			close(running)
+			<-release
+			return nil
+		}, nil)
+	}()
+	<-running
+	waiting := make(chan error)
+	go func() {
+		waiting <- c.Execute(context.Background(), testhelp.AlwaysPasses, nil)
+	}()
+	// Let the second call start waiting, then free the slot
+	for c.slotWaiters.Get() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	testhelp.MustTesting(t, <-done)
+	if err := <-waiting; err != nil {
+		t.Error("expected the waiting call to pick up the freed slot", err)
+	}
+	if c.ConcurrentCommands() != 0 {
+		t.Error("expected every slot to be released", c.ConcurrentCommands())
+	}
+}
+
+func TestMaxConcurrencyWait_Elapses(t *testing.T) {
+	c := NewCircuitFromConfig("TestMaxConcurrencyWait_Elapses", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+			MaxConcurrencyWait:    time.Millisecond * 20,
+		},
+	})
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(context.Background(), func(_ context.Context) error {
+			close(running)
+			<-release
+			return nil
+		}, nil)
+	}()
+	<-running
+	start := time.Now()
+	err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil)
+	if err != c.rejections.concurrencyLimit {
+		t.Error("expected a rejection once the wait elapses", err)
+	}
+	if time.Since(start) < time.Millisecond*20 {
+		t.Error("expected the call to wait before being rejected")
+	}
+	close(release)
+	testhelp.MustTesting(t, <-done)
+}
+
+func TestCanceledBeforeRun(t *testing.T) {
+	collector := &orderedRunMetrics{}
+	c := NewCircuitFromConfig("TestCanceledBeforeRun", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collector},
+		},
+	})
+	fast := NewCircuitFromConfig("TestCanceledBeforeRun_Fast", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: -1,
+			Timeout:               -1,
+		},
+	})
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, circ := range []*Circuit{c, fast} {
+		info, err := circ.ExecuteWithInfo(canceled, func(_ context.Context) error {
+			t.Error("runFunc should not be called with a canceled context")
+			return nil
+		}, nil)
+		if err != context.Canceled || info.Outcome != OutcomeInterrupt {
+			t.Errorf("%s: expected the context's error as an interrupt: %v %s", circ.Name(), err, info.Outcome)
+		}
+		if circ.ConcurrentCommands() != 0 {
+			t.Errorf("%s: expected no slot to be taken", circ.Name())
+		}
+	}
+	if strings.Join(collector.events, ",") != "interrupt" {
+		t.Error("expected only an interrupt to be recorded", collector.events)
+	}
+}
+
+func TestMaxConcurrencyWait_Canceled(t *testing.T) {
+	collector := &orderedRunMetrics{}
+	c := NewCircuitFromConfig("TestMaxConcurrencyWait_Canceled", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+			MaxConcurrencyWait:    time.Second * 5,
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collector},
+		},
+	})
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(context.Background(), func(_ context.Context) error {
+			close(running)
+			<-release
+			return nil
+		}, nil)
+	}()
+	<-running
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error)
+	go func() {
+		waiting <- c.Execute(ctx, testhelp.AlwaysPasses, nil)
+	}()
+	for c.slotWaiters.Get() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-waiting; err != context.Canceled {
+		t.Error("expected canceling the context to end the wait", err)
+	}
+	close(release)
+	testhelp.MustTesting(t, <-done)
+	if strings.Join(collector.events, ",") != "attempt,interrupt,success" {
+		t.Error("expected the canceled wait to be an interrupt, not a rejection", collector.events)
+	}
+}
+
+func TestWithMaxConcurrentRequests(t *testing.T) {
+	c := NewCircuitFromConfig("TestWithMaxConcurrentRequests", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+		},
+	})
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(context.Background(), func(_ context.Context) error {
+			close(running)
+			<-release
+			return nil
+		}, nil)
+	}()
+	<-running
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err == nil {
+		t.Error("expected the normal call to be throttled")
+	}
+	if err := c.Execute(WithMaxConcurrentRequests(context.Background(), 2), testhelp.AlwaysPasses, nil); err != nil {
+		t.Errorf("expected the override to allow the call: %s", err)
+	}
+	close(release)
+	testhelp.MustTesting(t, <-done)
+	if c.ConcurrentCommands() != 0 {
+		t.Error("expected all concurrent commands to be released")
+	}
+}
+
+func TestConcurrentCommands(t *testing.T) {
+	c := NewCircuitFromConfig("TestConcurrentCommands", Config{})
+	concurrency := 5
+	release := make(chan struct{})
+	var running sync.WaitGroup
+	var done sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		running.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			testhelp.MustTesting(t, c.Execute(context.Background(), func(_ context.Context) error {
+				running.Done()
+				<-release
+				return nil
+			}, nil))
+		}()
+	}
+	running.Wait()
+	if c.ConcurrentCommands() != int64(concurrency) {
+		t.Errorf("expected %d concurrent commands, saw %d", concurrency, c.ConcurrentCommands())
+	}
+	close(release)
+	done.Wait()
+	if c.ConcurrentCommands() != 0 {
+		t.Errorf("expected no concurrent commands, saw %d", c.ConcurrentCommands())
+	}
+}
+
+func TestSetConcurrencyLimit(t *testing.T) {
+	c := NewCircuitFromConfig("TestSetConcurrencyLimit", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 10,
+		},
+	})
+	if c.ConcurrencyLimit() != 10 {
+		t.Fatal("expected the configured limit", c.ConcurrencyLimit())
+	}
+	release := make(chan struct{})
+	var running sync.WaitGroup
+	var done sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		running.Add(1)
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			testhelp.MustTesting(t, c.Execute(context.Background(), func(_ context.Context) error {
+				running.Done()
+				<-release
+				return nil
+			}, nil))
+		}()
+	}
+	running.Wait()
+	c.SetConcurrencyLimit(2)
+	if c.ConcurrencyLimit() != 2 || c.Config().Execution.MaxConcurrentRequests != 2 {
+		t.Fatal("expected the new limit", c.ConcurrencyLimit())
+	}
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err == nil {
+		t.Error("expected new commands to be rejected while old ones drain")
+	}
+	close(release)
+	done.Wait()
+
+	var inFlight, maxInFlight int64
+	var mu sync.Mutex
+	for i := 0; i < 20; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			_ = c.Execute(context.Background(), func(_ context.Context) error {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return nil
+			}, nil)
+		}()
+	}
+	done.Wait()
+	if maxInFlight > 2 {
+		t.Error("expected no more than 2 commands at once", maxInFlight)
+	}
+}
+
+func TestSetConfigThreadSafe(t *testing.T) {
+	c := NewCircuitFromConfig("TestSetConfigThreadSafe", Config{})
+	cfg := c.Config()
+	cfg.Execution.MaxConcurrentRequests = 3
+	c.SetConfigThreadSafe(cfg)
+	if c.ConcurrencyLimit() != 3 {
+		t.Error("expected the new config to apply right away", c.ConcurrencyLimit())
+	}
+}
+
+func TestWithMaxConcurrentRequests_Nested(t *testing.T) {
+	outer := NewCircuitFromConfig("outer", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+		},
+	})
+	inner := NewCircuitFromConfig("inner", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+		},
+	})
+	running := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- inner.Execute(context.Background(), func(_ context.Context) error {
+			close(running)
+			<-release
+			return nil
+		}, nil)
+	}()
+	<-running
+	var innerErr error
+	testhelp.MustTesting(t, outer.Execute(WithMaxConcurrentRequests(context.Background(), 2), func(ctx context.Context) error {
+		innerErr = inner.Execute(ctx, testhelp.AlwaysPasses, nil)
+		return nil
+	}, nil))
+	if _, ok := innerErr.(*ConcurrencyLimitError); !ok {
+		t.Errorf("expected the inner circuit to keep its own limit, saw %v", innerErr)
+	}
+	close(release)
+	testhelp.MustTesting(t, <-done)
+}
+
+func TestContextOverrides_Nested(t *testing.T) {
+	overrides := []struct {
+		name string
+		with func(context.Context) context.Context
+		seen func(context.Context) bool
+	}{
+		{"WithMaxConcurrentRequests", func(ctx context.Context) context.Context { return WithMaxConcurrentRequests(ctx, 5) }, func(ctx context.Context) bool {
+			_, ok := maxConcurrentRequestsFromContext(ctx)
+			return ok
+		}},
+		{"WithWeight", func(ctx context.Context) context.Context { return WithWeight(ctx, 5) }, func(ctx context.Context) bool { return weightFromContext(ctx) != 1 }},
+		{"WithoutFallback", WithoutFallback, withoutFallbackFromContext},
+		{"WithTimeout", func(ctx context.Context) context.Context { return WithTimeout(ctx, time.Minute) }, func(ctx context.Context) bool {
+			_, ok := timeoutFromContext(ctx)
+			return ok
+		}},
+		{"WithoutProbe", WithoutProbe, withoutProbeFromContext},
+		{"WithoutMetrics", WithoutMetrics, withoutMetricsFromContext},
+	}
+	for _, override := range overrides {
+		t.Run(override.name, func(t *testing.T) {
+			check := func(where string, ctx context.Context) {
+				if override.seen(ctx) {
+					t.Errorf("expected the %s context not to carry the override", where)
+				}
+			}
+			ctx := override.with(context.Background())
+			if !override.seen(ctx) {
+				t.Fatal("expected the override to be set")
+			}
+			c := NewCircuitFromConfig("TestContextOverrides_Nested", Config{})
+			_ = c.Execute(ctx, func(ctx context.Context) error {
+				check("runFunc", ctx)
+				return errors.New("failure")
+			}, func(ctx context.Context, err error) error {
+				check("fallback", ctx)
+				return nil
+			})
+			// Nested circuits see the overrides they are given themselves
+			_ = c.Execute(ctx, func(ctx context.Context) error {
+				if !override.seen(override.with(ctx)) {
+					t.Error("expected an override set inside runFunc to be seen")
+				}
+				return nil
+			}, nil)
+			hedged := NewCircuitFromConfig("TestContextOverrides_Nested_hedge", Config{
+				Fallback: FallbackConfig{Hedge: true},
+			})
+			_ = hedged.Execute(ctx, func(ctx context.Context) error {
+				check("hedged runFunc", ctx)
+				return errors.New("failure")
+			}, func(ctx context.Context, err error) error {
+				check("hedged fallback", ctx)
+				return nil
+			})
+			disabled := NewCircuitFromConfig("TestContextOverrides_Nested_disabled", Config{
+				General: GeneralConfig{Disabled: true},
+			})
+			_ = disabled.Execute(ctx, func(ctx context.Context) error {
+				check("disabled runFunc", ctx)
+				return nil
+			}, nil)
+		})
+	}
+}
+
 func TestCircuitCloses(t *testing.T) {
 	c := NewCircuitFromConfig("TestCircuitCloses", Config{})
 	c.OpenCircuit()
@@ -262,120 +800,846 @@ func TestCircuitIgnoreContextFailures(t *testing.T) {
 	}
 }
 
-func TestFallbackCircuitConcurrency(t *testing.T) {
-	c := NewCircuitFromConfig("TestFallbackCircuitConcurrency", Config{
-		Fallback: FallbackConfig{
-			MaxConcurrentRequests: 2,
+func TestCallerCancelIsInterrupt(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewCircuitFromConfig("TestCallerCancelIsInterrupt", Config{
+		General: GeneralConfig{
+			RunTracer: tracer,
+		},
+		Execution: ExecutionConfig{
+			Timeout: time.Millisecond * 5,
 		},
 	})
-	wg := sync.WaitGroup{}
-	workingCircuitCount := int64(0)
-	var fallbackExecuted faststats.AtomicInt64
-	var totalExecuted faststats.AtomicInt64
-	for i := 0; i < 3; i++ {
-		wg.Add(1)
-		go func() {
-			totalExecuted.Add(1)
-			defer wg.Done()
-			err := c.Execute(context.Background(), testhelp.AlwaysFails, func(ctx context.Context, err error) error {
-				fallbackExecuted.Add(1)
-				return testhelp.SleepsForX(time.Millisecond * 500)(ctx)
-			})
-			if err == nil {
-				atomic.AddInt64(&workingCircuitCount, 1)
-			}
-		}()
+	waitsForContext := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
 	}
-	wg.Wait()
-	if totalExecuted.Get() == fallbackExecuted.Get() {
-		t.Error("At least one fallback call should never happen due to concurrency")
+
+	// The caller cancels before the circuit's timeout: the dependency was fine
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := c.Execute(ctx, waitsForContext, nil)
+	if err != context.Canceled {
+		t.Fatal("expected the caller's cancellation", err)
 	}
-	if workingCircuitCount != 2 {
-		t.Error("Should see 2 working examples")
+	if tracer.infos[0].Outcome != OutcomeInterrupt {
+		t.Error("expected caller cancellation to be an interrupt", tracer.infos[0].Outcome)
 	}
-}
 
-func TestFailingFallbackCircuit(t *testing.T) {
-	c := NewCircuitFromConfig("TestFailingCircuit", Config{})
-	err := c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysFailsFallback)
-	if err == nil {
-		t.Error("expected error back")
-		t.FailNow()
+	// The circuit's own timeout expires: the dependency was too slow
+	err = c.Execute(context.Background(), waitsForContext, nil)
+	if err != context.DeadlineExceeded {
+		t.Fatal("expected the circuit's deadline", err)
 	}
-	if err.Error() != "failed: alwaysFails failure" {
-		t.Error("unexpected error string", err)
+	if tracer.infos[1].Outcome != OutcomeTimeout {
+		t.Error("expected the circuit's own deadline to be a timeout", tracer.infos[1].Outcome)
 	}
 }
 
-//func TestSLO(t *testing.T) {
-//	c := NewCircuitFromConfig("TestFailingCircuit", Config{
-//		GoSpecific: GoSpecificConfig{
-//			ResponseTimeSLO: time.Millisecond,
-//		},
-//	})
-//	err := c.Execute(context.Background(), sleepsForX(time.Millisecond*5), nil)
-//	if err != nil {
-//		t.Error("This request should not fail")
-//	}
-//	if c.errorsCount.TotalSum() != 0 {
-//		t.Error("the request should not be an error")
-//	}
-//	if c.responseTimeSLO.MeetsSLOCount.Get() != 0 {
-//		t.Error("the request should not be healthy")
-//	}
-//	if c.responseTimeSLO.FailsSLOCount.Get() != 1 {
-//		t.Error("the request should be failed")
-//	}
-//}
-
-func TestFallbackAfterTimeout(t *testing.T) {
-	c := NewCircuitFromConfig("TestThrottled", Config{
+func TestExecuteWithInfo(t *testing.T) {
+	c := NewCircuitFromConfig("TestExecuteWithInfo", Config{
 		Execution: ExecutionConfig{
-			Timeout: time.Millisecond,
+			Timeout: time.Millisecond * 5,
 		},
 	})
-	bc := testhelp.BehaviorCheck{
-		RunFunc: testhelp.SleepsForX(time.Millisecond * 35),
+	waitsForContext := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
 	}
-	err := c.Execute(context.Background(), bc.Run, func(ctx context.Context, err error) error {
-		if ctx.Err() != nil {
-			return errors.New("the passed in context should not be finished")
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	badRequest := func(_ context.Context) error {
+		return SimpleBadRequest{Err: errors.New("bad request")}
+	}
+	expectOutcome := func(name string, expected Outcome, ctx context.Context, runFunc func(context.Context) error) {
+		info, err := c.ExecuteWithInfo(ctx, runFunc, nil)
+		if info.Outcome != expected {
+			t.Errorf("%s: expected %s, saw %s", name, expected, info.Outcome)
+		}
+		if (err == nil) != (expected == OutcomeSuccess) {
+			t.Errorf("%s: unexpected error %v", name, err)
 		}
-		return nil
-	})
-	if err != nil {
-		t.Log("Should be no error since the fallback didn't error")
 	}
-	if bc.LongestRunDuration >= time.Millisecond*20 {
-		t.Log("A cancel didn't happen fast enough")
+	expectOutcome("success", OutcomeSuccess, context.Background(), testhelp.AlwaysPasses)
+	expectOutcome("failure", OutcomeFailure, context.Background(), testhelp.AlwaysFails)
+	expectOutcome("timeout", OutcomeTimeout, context.Background(), waitsForContext)
+	expectOutcome("bad request", OutcomeBadRequest, context.Background(), badRequest)
+	expectOutcome("interrupt", OutcomeInterrupt, canceled, waitsForContext)
+	expectOutcome("concurrency limit", OutcomeConcurrencyLimitReject, WithMaxConcurrentRequests(context.Background(), 0), testhelp.AlwaysPasses)
+	c.OpenCircuit()
+	expectOutcome("short circuit", OutcomeShortCircuit, context.Background(), testhelp.AlwaysPasses)
+
+	info, err := c.ExecuteWithInfo(context.Background(), testhelp.AlwaysPasses, testhelp.AlwaysPassesFallback)
+	if err != nil || info.Outcome != OutcomeShortCircuit || !info.FallbackCalled {
+		t.Error("expected a short circuit handled by the fallback", info, err)
 	}
+
+	c.SetConfigThreadSafe(Config{General: GeneralConfig{Disabled: true}})
+	expectOutcome("disabled failure", OutcomeFailure, context.Background(), testhelp.AlwaysFails)
 }
 
-// Just test to make sure the -race detector doesn't find anything with a public function
-func TestVariousRaceConditions(t *testing.T) {
-	concurrentThreads := 5
-	c := NewCircuitFromConfig("TestVariousRaceConditions", Config{
-		Execution: ExecutionConfig{
-			MaxConcurrentRequests: int64(-1),
-		},
-		Fallback: FallbackConfig{
-			MaxConcurrentRequests: int64(-1),
+func TestExecuteWithInfo_Fields(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := NewCircuitFromConfig("TestExecuteWithInfo_Fields", Config{
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
 		},
 	})
-
-	doNotPassTime := time.Now().Add(time.Millisecond * 20)
-
-	wg := sync.WaitGroup{}
-	for i := 0; i < concurrentThreads; i++ {
-		testhelp.DoTillTime(doNotPassTime, &wg, func() {
-			c.Var()
-		})
-		testhelp.DoTillTime(doNotPassTime, &wg, func() {
-			c.IsOpen()
-		})
-		testhelp.DoTillTime(doNotPassTime, &wg, func() {
-			c.CloseCircuit()
-		})
+	takes := func(d time.Duration, err error) func(context.Context) error {
+		return func(_ context.Context) error {
+			clk.Add(d)
+			return err
+		}
+	}
+	fallbackTakes := func(d time.Duration) func(context.Context, error) error {
+		return func(_ context.Context, _ error) error {
+			clk.Add(d)
+			return nil
+		}
+	}
+	for _, tc := range []struct {
+		name           string
+		open           bool
+		runFunc        func(context.Context) error
+		fallbackFunc   func(context.Context, error) error
+		expected       ExecutionInfo
+		shortCircuited bool
+	}{
+		{
+			name:     "success",
+			runFunc:  takes(time.Millisecond*5, nil),
+			expected: ExecutionInfo{Outcome: OutcomeSuccess, Duration: time.Millisecond * 5},
+		},
+		{
+			name:         "failure with fallback",
+			runFunc:      takes(time.Millisecond*5, errors.New("failed")),
+			fallbackFunc: fallbackTakes(time.Millisecond * 2),
+			expected:     ExecutionInfo{Outcome: OutcomeFailure, FallbackCalled: true, Duration: time.Millisecond * 7},
+		},
+		{
+			name:     "failure without fallback",
+			runFunc:  takes(time.Millisecond, errors.New("failed")),
+			expected: ExecutionInfo{Outcome: OutcomeFailure, Duration: time.Millisecond},
+		},
+		{
+			name:           "short circuit with fallback",
+			open:           true,
+			runFunc:        takes(time.Millisecond*5, nil),
+			fallbackFunc:   fallbackTakes(time.Millisecond * 2),
+			expected:       ExecutionInfo{Outcome: OutcomeShortCircuit, FallbackCalled: true, Duration: time.Millisecond * 2},
+			shortCircuited: true,
+		},
+	} {
+		if tc.open {
+			c.OpenCircuit()
+		} else {
+			c.CloseCircuit()
+		}
+		info, _ := c.ExecuteWithInfo(context.Background(), tc.runFunc, tc.fallbackFunc)
+		if info != tc.expected {
+			t.Errorf("%s: expected %+v, saw %+v", tc.name, tc.expected, info)
+		}
+		if info.ShortCircuited() != tc.shortCircuited {
+			t.Errorf("%s: expected ShortCircuited=%t", tc.name, tc.shortCircuited)
+		}
+	}
+}
+
+// capturingLogger keeps every line logged to it
+type capturingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *capturingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestCircuit_Logger(t *testing.T) {
+	logger := &capturingLogger{}
+	c := NewCircuitFromConfig("TestCircuit_Logger", Config{
+		General: GeneralConfig{
+			Logger:              logger,
+			OpenToClosedFactory: func() OpenToClosed { return probingCloser{} },
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	c.OpenCircuit()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	c.CloseCircuit()
+	for i := 0; i < 2; i++ {
+		testhelp.MustNotTesting(t, c.Execute(WithMaxConcurrentRequests(ctx, 0), testhelp.AlwaysPasses, nil))
+	}
+	for i := 0; i < 2; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	expected := []string{
+		"circuit TestCircuit_Logger: opened",
+		"circuit TestCircuit_Logger: allowed a half open attempt",
+		"circuit TestCircuit_Logger: closed",
+		"circuit TestCircuit_Logger: rejecting commands over the concurrency limit",
+		"circuit TestCircuit_Logger: accepting commands again after 2 concurrency limit rejections",
+	}
+	if strings.Join(logger.lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected log lines:\n%s", strings.Join(logger.lines, "\n"))
+	}
+}
+
+func TestCircuit_AbandonedGoroutines(t *testing.T) {
+	logger := &capturingLogger{}
+	c := NewCircuitFromConfig("TestCircuit_AbandonedGoroutines", Config{
+		General: GeneralConfig{
+			Logger: logger,
+		},
+	})
+	release := make(chan struct{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	// Ignores its context, so Go returns without it
+	err := c.Go(ctx, func(_ context.Context) error {
+		<-release
+		return nil
+	}, nil)
+	testhelp.MustNotTesting(t, err)
+	if n := c.AbandonedGoroutines(); n != 1 {
+		t.Fatalf("expected one abandoned goroutine, saw %d", n)
+	}
+	expected := "circuit TestCircuit_AbandonedGoroutines: Go returned before its goroutine ended: 1 still running"
+	if strings.Join(logger.lines, "\n") != expected {
+		t.Errorf("unexpected log lines:\n%s", strings.Join(logger.lines, "\n"))
+	}
+	close(release)
+	for c.AbandonedGoroutines() != 0 {
+		time.Sleep(time.Millisecond)
+	}
+	testhelp.MustTesting(t, c.Go(context.Background(), testhelp.AlwaysPasses, nil))
+	if n := c.AbandonedGoroutines(); n != 0 {
+		t.Fatalf("goroutines that finish in time are not abandoned, saw %d", n)
+	}
+}
+
+func TestConcurrencyPool(t *testing.T) {
+	pool := NewConcurrencyPool(3)
+	cfg := Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 10,
+			ConcurrencyPool:       pool,
+		},
+	}
+	circuits := []*Circuit{
+		NewCircuitFromConfig("TestConcurrencyPool_a", cfg),
+		NewCircuitFromConfig("TestConcurrencyPool_b", cfg),
+	}
+	var running, maxRunning, rejected int64
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		c := circuits[i%len(circuits)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.Execute(context.Background(), func(_ context.Context) error {
+				now := atomic.AddInt64(&running, 1)
+				defer atomic.AddInt64(&running, -1)
+				for {
+					seen := atomic.LoadInt64(&maxRunning)
+					if now <= seen || atomic.CompareAndSwapInt64(&maxRunning, seen, now) {
+						break
+					}
+				}
+				<-release
+				return nil
+			}, nil)
+			if err != nil {
+				atomic.AddInt64(&rejected, 1)
+			}
+		}()
+	}
+	// Only 3 calls can ever get a slot, so the rest are rejected while those 3 block
+	for atomic.LoadInt64(&rejected) < 17 {
+		time.Sleep(time.Millisecond)
+	}
+	if n := pool.Running(); n != 3 {
+		t.Errorf("expected the pool to be full, saw %d running", n)
+	}
+	close(release)
+	wg.Wait()
+	if maxRunning > 3 {
+		t.Errorf("the circuits together ran %d calls at once, over the pool limit", maxRunning)
+	}
+	if rejected != 17 {
+		t.Errorf("expected every call over the pool limit to be rejected, saw %d rejections", rejected)
+	}
+	if n := pool.Running(); n != 0 {
+		t.Errorf("every slot should be released, saw %d running", n)
+	}
+	for _, c := range circuits {
+		if c.ConcurrentCommands() != 0 {
+			t.Errorf("circuit %s should release its own slots too", c.Name())
+		}
+		testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	}
+}
+
+// weightLimiter admits calls while their total weight stays under capacity, recording each weight it is asked for
+type weightLimiter struct {
+	mu       sync.Mutex
+	capacity int64
+	inUse    int64
+	asked    []int64
+}
+
+func (w *weightLimiter) Acquire(_ context.Context, weight int64) (func(), bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.asked = append(w.asked, weight)
+	if w.inUse+weight > w.capacity {
+		return nil, false
+	}
+	w.inUse += weight
+	return func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.inUse -= weight
+	}, true
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter := &weightLimiter{capacity: 5}
+	c := NewCircuitFromConfig("TestConcurrencyLimiter", Config{
+		Execution: ExecutionConfig{
+			// Ignored in favor of the limiter
+			MaxConcurrentRequests: 1,
+			ConcurrencyLimiter:    limiter,
+		},
+	})
+	ctx := context.Background()
+	var inside bool
+	err := c.Execute(WithWeight(ctx, 4), func(_ context.Context) error {
+		inside = true
+		if c.ConcurrentCommands() != 1 {
+			t.Error("calls admitted by the limiter should still be counted")
+		}
+		// Only 1 unit of weight is left
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		err := c.Execute(WithWeight(ctx, 2), testhelp.AlwaysPasses, nil)
+		if _, ok := err.(*ConcurrencyLimitError); !ok {
+			t.Errorf("expected the limiter to reject a call over its capacity, saw %v", err)
+		}
+		return nil
+	}, nil)
+	testhelp.MustTesting(t, err)
+	if !inside {
+		t.Fatal("expected the limiter to admit the first call")
+	}
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if len(limiter.asked) != 3 || limiter.asked[0] != 4 || limiter.asked[1] != 1 || limiter.asked[2] != 2 {
+		t.Errorf("expected the limiter to be asked for each call's weight, defaulting to 1: %v", limiter.asked)
+	}
+	if limiter.inUse != 0 {
+		t.Errorf("expected every admitted call to be released, saw %d in use", limiter.inUse)
+	}
+	if c.ConcurrentCommands() != 0 {
+		t.Error("expected the circuit to stop counting finished calls")
+	}
+}
+
+func TestReuseContexts(t *testing.T) {
+	c := NewCircuitFromConfig("TestReuseContexts", Config{
+		Execution: ExecutionConfig{
+			Timeout:               10 * time.Millisecond,
+			MaxConcurrentRequests: -1,
+			ReuseContexts:         true,
+		},
+	})
+	waitsForTimeout := func(ctx context.Context) error {
+		<-ctx.Done()
+		if ctx.Err() != context.DeadlineExceeded {
+			return fmt.Errorf("expected the deadline to pass, saw %v", ctx.Err())
+		}
+		return nil
+	}
+	passes := func(ctx context.Context) error {
+		if FromContext(ctx) != "TestReuseContexts" {
+			return fmt.Errorf("unexpected circuit name %q", FromContext(ctx))
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("a reused context should start fresh, saw %v", ctx.Err())
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("a reused context should not start done")
+		default:
+			return nil
+		}
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				runFunc := passes
+				if (i+j)%5 == 0 {
+					runFunc = waitsForTimeout
+				}
+				if err := c.Execute(context.Background(), runFunc, nil); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	// Contexts that can be canceled are not reused, but still work
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	testhelp.MustNotTesting(t, c.Execute(ctx, passes, nil))
+	testhelp.MustTesting(t, c.Execute(context.Background(), passes, nil))
+}
+
+func TestFallbackCircuitConcurrency(t *testing.T) {
+	c := NewCircuitFromConfig("TestFallbackCircuitConcurrency", Config{
+		Fallback: FallbackConfig{
+			MaxConcurrentRequests: 2,
+		},
+	})
+	wg := sync.WaitGroup{}
+	workingCircuitCount := int64(0)
+	var fallbackExecuted faststats.AtomicInt64
+	var totalExecuted faststats.AtomicInt64
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			totalExecuted.Add(1)
+			defer wg.Done()
+			err := c.Execute(context.Background(), testhelp.AlwaysFails, func(ctx context.Context, err error) error {
+				fallbackExecuted.Add(1)
+				return testhelp.SleepsForX(time.Millisecond * 500)(ctx)
+			})
+			if err == nil {
+				atomic.AddInt64(&workingCircuitCount, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if totalExecuted.Get() == fallbackExecuted.Get() {
+		t.Error("At least one fallback call should never happen due to concurrency")
+	}
+	if workingCircuitCount != 2 {
+		t.Error("Should see 2 working examples")
+	}
+}
+
+func TestFailingFallbackCircuit(t *testing.T) {
+	c := NewCircuitFromConfig("TestFailingCircuit", Config{})
+	err := c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysFailsFallback)
+	if err == nil {
+		t.Error("expected error back")
+		t.FailNow()
+	}
+	fallbackFailed, ok := err.(*FallbackError)
+	if !ok {
+		t.Fatal("expected a FallbackError", err)
+	}
+	if fallbackFailed.FallbackErr.Error() != "failed: alwaysFails failure" || fallbackFailed.Err.Error() != "alwaysFails failure" {
+		t.Error("unexpected errors", fallbackFailed.FallbackErr, fallbackFailed.Err)
+	}
+}
+
+//func TestSLO(t *testing.T) {
+//	c := NewCircuitFromConfig("TestFailingCircuit", Config{
+//		GoSpecific: GoSpecificConfig{
+//			ResponseTimeSLO: time.Millisecond,
+//		},
+//	})
+//	err := c.Execute(context.Background(), sleepsForX(time.Millisecond*5), nil)
+//	if err != nil {
+//		t.Error("This request should not fail")
+//	}
+//	if c.errorsCount.TotalSum() != 0 {
+//		t.Error("the request should not be an error")
+//	}
+//	if c.responseTimeSLO.MeetsSLOCount.Get() != 0 {
+//		t.Error("the request should not be healthy")
+//	}
+//	if c.responseTimeSLO.FailsSLOCount.Get() != 1 {
+//		t.Error("the request should be failed")
+//	}
+//}
+
+func TestFallbackAfterTimeout(t *testing.T) {
+	c := NewCircuitFromConfig("TestThrottled", Config{
+		Execution: ExecutionConfig{
+			Timeout: time.Millisecond,
+		},
+	})
+	bc := testhelp.BehaviorCheck{
+		RunFunc: testhelp.SleepsForX(time.Millisecond * 35),
+	}
+	err := c.Execute(context.Background(), bc.Run, func(ctx context.Context, err error) error {
+		if ctx.Err() != nil {
+			return errors.New("the passed in context should not be finished")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Log("Should be no error since the fallback didn't error")
+	}
+	if bc.LongestRunDuration >= time.Millisecond*20 {
+		t.Log("A cancel didn't happen fast enough")
+	}
+}
+
+type countingRunMetrics struct {
+	calls faststats.AtomicInt64
+}
+
+func (c *countingRunMetrics) Success(now time.Time, duration time.Duration)       { c.calls.Add(1) }
+func (c *countingRunMetrics) ErrFailure(now time.Time, duration time.Duration)    { c.calls.Add(1) }
+func (c *countingRunMetrics) ErrTimeout(now time.Time, duration time.Duration)    { c.calls.Add(1) }
+func (c *countingRunMetrics) ErrBadRequest(now time.Time, duration time.Duration) { c.calls.Add(1) }
+func (c *countingRunMetrics) ErrInterrupt(now time.Time, duration time.Duration)  { c.calls.Add(1) }
+func (c *countingRunMetrics) ErrConcurrencyLimitReject(now time.Time)             { c.calls.Add(1) }
+func (c *countingRunMetrics) ErrShortCircuit(now time.Time)                       { c.calls.Add(1) }
+
+type countingCircuitMetrics struct {
+	opened faststats.AtomicInt64
+	closed faststats.AtomicInt64
+}
+
+func (c *countingCircuitMetrics) Opened(now time.Time) { c.opened.Add(1) }
+func (c *countingCircuitMetrics) Closed(now time.Time) { c.closed.Add(1) }
+
+func TestOpenCloseCircuitIdempotent(t *testing.T) {
+	metrics := &countingCircuitMetrics{}
+	c := NewCircuitFromConfig("TestOpenCloseCircuitIdempotent", Config{
+		Metrics: MetricsCollectors{
+			Circuit: []Metrics{metrics},
+		},
+	})
+	c.CloseCircuit()
+	if metrics.closed.Get() != 0 {
+		t.Error("closing a closed circuit should not report a transition")
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.OpenCircuit()
+		}()
+	}
+	wg.Wait()
+	if metrics.opened.Get() != 1 {
+		t.Errorf("expected exactly one open transition, saw %d", metrics.opened.Get())
+	}
+	c.CloseCircuit()
+	c.CloseCircuit()
+	if metrics.closed.Get() != 1 {
+		t.Errorf("expected exactly one close transition, saw %d", metrics.closed.Get())
+	}
+}
+
+func TestOnStateChange(t *testing.T) {
+	var transitions []bool
+	c := NewCircuitFromConfig("TestOnStateChange", Config{
+		General: GeneralConfig{
+			OnStateChange: func(c *Circuit, isOpen bool) {
+				// Calling back into the circuit must not deadlock
+				if c.IsOpen() != isOpen {
+					t.Error("listener should see the new state")
+				}
+				_ = c.Config()
+				transitions = append(transitions, isOpen)
+			},
+		},
+	})
+	c.CloseCircuit()
+	c.OpenCircuit()
+	c.OpenCircuit()
+	c.CloseCircuit()
+	c.OpenCircuit()
+	expected := []bool{true, false, true}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, saw %v", expected, transitions)
+	}
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Fatalf("expected transitions %v, saw %v", expected, transitions)
+		}
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	metrics := &countingRunMetrics{}
+	c := NewCircuitFromConfig("TestDryRun", Config{
+		General: GeneralConfig{
+			DryRun: true,
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{metrics},
+		},
+	})
+	c.OpenCircuit()
+	if !c.IsOpen() {
+		t.Fatal("dry run circuits should still track open state")
+	}
+	ran := false
+	err := c.Execute(context.Background(), func(_ context.Context) error {
+		ran = true
+		return nil
+	}, nil)
+	if err != nil {
+		t.Error("expected dry run to run the primary", err)
+	}
+	if !ran {
+		t.Error("expected primary to be called on an open dry run circuit")
+	}
+	if metrics.calls.Get() != 1 {
+		t.Error("dry run should record only the successful run", metrics.calls.Get())
+	}
+}
+
+func TestWithoutFallback(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewCircuitFromConfig("TestWithoutFallback", Config{
+		General: GeneralConfig{
+			RunTracer: tracer,
+		},
+	})
+	fallbackCalled := false
+	fallback := func(_ context.Context, err error) error {
+		fallbackCalled = true
+		return nil
+	}
+	err := c.Execute(WithoutFallback(context.Background()), testhelp.AlwaysFails, fallback)
+	if err == nil {
+		t.Error("expected the original error")
+	}
+	if fallbackCalled {
+		t.Error("fallback should be skipped")
+	}
+	if info := tracer.infos[0]; !info.FallbackSkipped || info.FallbackCalled {
+		t.Error("expected the fallback to be reported as skipped", info)
+	}
+
+	c.OpenCircuit()
+	err = c.Execute(WithoutFallback(context.Background()), testhelp.AlwaysPasses, fallback)
+	if err != c.rejections.open {
+		t.Error("expected the circuit open error", err)
+	}
+	if fallbackCalled {
+		t.Error("fallback should be skipped for open circuits")
+	}
+}
+
+func TestLastTransitionTime(t *testing.T) {
+	now := time.Now()
+	c := NewCircuitFromConfig("TestLastTransitionTime", Config{
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now: func() time.Time {
+					return now
+				},
+			},
+		},
+	})
+	if !c.LastTransitionTime().IsZero() {
+		t.Error("expected no transition time before the circuit changes state")
+	}
+	c.OpenCircuit()
+	if !c.LastTransitionTime().Equal(now) {
+		t.Error("expected open to record the transition time", c.LastTransitionTime())
+	}
+	openedAt := now
+	now = now.Add(time.Minute)
+	c.OpenCircuit()
+	if !c.LastTransitionTime().Equal(openedAt) {
+		t.Error("opening an open circuit is not a transition", c.LastTransitionTime())
+	}
+	c.CloseCircuit()
+	if !c.LastTransitionTime().Equal(now) {
+		t.Error("expected close to record the transition time", c.LastTransitionTime())
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	metrics := &countingRunMetrics{}
+	c := NewCircuitFromConfig("TestRecoverPanics", Config{
+		Execution: ExecutionConfig{
+			RecoverPanics: true,
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{metrics},
+		},
+	})
+	panics := func(_ context.Context) error {
+		panic("bad things")
+	}
+	for _, execute := range []func(context.Context, func(context.Context) error, func(context.Context, error) error) error{c.Execute, c.Go} {
+		var fallbackErr error
+		err := execute(context.Background(), panics, func(_ context.Context, err error) error {
+			fallbackErr = err
+			return err
+		})
+		panicErr, ok := err.(*PanicError)
+		if !ok {
+			t.Fatal("expected a panic error", err)
+		}
+		if panicErr.Value != "bad things" {
+			t.Error("expected the panic value", panicErr.Value)
+		}
+		if !strings.Contains(string(panicErr.Stack), "TestRecoverPanics") {
+			t.Error("expected the stack of the panicking function", string(panicErr.Stack))
+		}
+		if fallbackErr != err {
+			t.Error("expected the fallback to see the panic error", fallbackErr)
+		}
+	}
+	if metrics.calls.Get() != 2 {
+		t.Error("expected each panic to count as a failure", metrics.calls.Get())
+	}
+}
+
+func TestIsOpenDoesNotRecordMetrics(t *testing.T) {
+	metrics := &countingRunMetrics{}
+	c := NewCircuitFromConfig("TestIsOpenDoesNotRecordMetrics", Config{
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{metrics},
+		},
+	})
+	if c.IsOpen() {
+		t.Error("circuit should start closed")
+	}
+	c.OpenCircuit()
+	for i := 0; i < 10; i++ {
+		if !c.IsOpen() {
+			t.Error("circuit should be open after opening it")
+		}
+	}
+	if metrics.calls.Get() != 0 {
+		t.Error("IsOpen should not record any metrics")
+	}
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err == nil {
+		t.Error("Execute should short circuit when IsOpen is true")
+	}
+	if metrics.calls.Get() != 1 {
+		t.Error("Execute should record the short circuit")
+	}
+}
+
+type recordingTracer struct {
+	names []string
+	infos []ExecutionInfo
+	errs  []error
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+}
+
+func (r *recordingTracer) StartRun(ctx context.Context, circuitName string) (context.Context, RunSpan) {
+	r.names = append(r.names, circuitName)
+	return ctx, recordingSpan{tracer: r}
+}
+
+func (r recordingSpan) End(info ExecutionInfo, err error) {
+	r.tracer.infos = append(r.tracer.infos, info)
+	r.tracer.errs = append(r.tracer.errs, err)
+}
+
+func TestRunTracer(t *testing.T) {
+	tracer := &recordingTracer{}
+	c := NewCircuitFromConfig("TestRunTracer", Config{
+		General: GeneralConfig{
+			RunTracer: tracer,
+		},
+	})
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+
+	expected := []ExecutionInfo{
+		{Outcome: OutcomeSuccess},
+		{Outcome: OutcomeFailure, FallbackCalled: true},
+		{Outcome: OutcomeShortCircuit},
+	}
+	if len(tracer.infos) != len(expected) {
+		t.Fatalf("expected %d spans, saw %d", len(expected), len(tracer.infos))
+	}
+	for i := range expected {
+		if tracer.names[i] != "TestRunTracer" {
+			t.Error("unexpected circuit name", tracer.names[i])
+		}
+		if tracer.infos[i] != expected[i] {
+			t.Errorf("span %d: expected %+v, saw %+v", i, expected[i], tracer.infos[i])
+		}
+	}
+	if tracer.errs[2] == nil {
+		t.Error("expected the short circuit error on the span")
+	}
+}
+
+func TestFallbackTimeout(t *testing.T) {
+	c := NewCircuitFromConfig("TestFallbackTimeout", Config{
+		Execution: ExecutionConfig{
+			Timeout: time.Millisecond * 10,
+		},
+		Fallback: FallbackConfig{
+			Timeout: time.Millisecond * 50,
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	err := c.Execute(ctx, testhelp.SleepsForX(time.Second), func(ctx context.Context, err error) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			return errors.New("expected the fallback to have a deadline")
+		}
+		if time.Until(deadline) < time.Millisecond*20 {
+			return errors.New("expected the fallback to have its own deadline")
+		}
+		return testhelp.SleepsForX(time.Millisecond * 20)(ctx)
+	})
+	if err != nil {
+		t.Errorf("expected the fallback to have time to run: %s", err)
+	}
+}
+
+// Just test to make sure the -race detector doesn't find anything with a public function
+func TestVariousRaceConditions(t *testing.T) {
+	concurrentThreads := 5
+	c := NewCircuitFromConfig("TestVariousRaceConditions", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: int64(-1),
+		},
+		Fallback: FallbackConfig{
+			MaxConcurrentRequests: int64(-1),
+		},
+	})
+
+	doNotPassTime := time.Now().Add(time.Millisecond * 20)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrentThreads; i++ {
+		testhelp.DoTillTime(doNotPassTime, &wg, func() {
+			c.Var()
+		})
+		testhelp.DoTillTime(doNotPassTime, &wg, func() {
+			c.IsOpen()
+		})
+		testhelp.DoTillTime(doNotPassTime, &wg, func() {
+			c.CloseCircuit()
+		})
 		testhelp.DoTillTime(doNotPassTime, &wg, func() {
 			c.OpenCircuit()
 		})
@@ -398,3 +1662,610 @@ func TestVariousRaceConditions(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCircuit_AppendMetrics(t *testing.T) {
+	c := NewCircuitFromConfig("TestCircuit_AppendMetrics", Config{})
+	if err := c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback); err != nil {
+		t.Fatal("expected fallback to pass", err)
+	}
+	runMetrics := &countingRunMetrics{}
+	fallbackMetrics := &countingRunMetrics{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = c.Execute(context.Background(), testhelp.AlwaysPasses, nil)
+		}()
+	}
+	c.AppendRunMetrics(runMetrics)
+	c.AppendFallbackMetrics(fallbackMetrics)
+	wg.Wait()
+
+	before := runMetrics.calls.Get()
+	if err := c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback); err != nil {
+		t.Fatal("expected fallback to pass", err)
+	}
+	if runMetrics.calls.Get() != before+1 {
+		t.Error("expected the appended run collector to see the next call", runMetrics.calls.Get())
+	}
+	if fallbackMetrics.calls.Get() != 1 {
+		t.Error("expected the appended fallback collector to only see the fallback after it was added", fallbackMetrics.calls.Get())
+	}
+}
+
+func TestNewDisabledCircuit(t *testing.T) {
+	metrics := &countingRunMetrics{}
+	c := NewDisabledCircuit("TestNewDisabledCircuit")
+	c.AppendRunMetrics(metrics)
+	c.OpenCircuit()
+	ctx := WithMaxConcurrentRequests(context.Background(), 0)
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if err := c.Execute(ctx, testhelp.AlwaysFails, testhelp.AlwaysPassesFallback); err == nil {
+		t.Error("expected runFunc's error without a fallback")
+	}
+	if metrics.calls.Get() != 0 {
+		t.Error("expected no metrics from a disabled circuit", metrics.calls.Get())
+	}
+}
+
+func TestDefaultFallback(t *testing.T) {
+	var defaultCalls int
+	c := NewCircuitFromConfig("TestDefaultFallback", Config{
+		Fallback: FallbackConfig{
+			Default: func(_ context.Context, err error) error {
+				defaultCalls++
+				return nil
+			},
+		},
+	})
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	c.OpenCircuit()
+	testhelp.MustTesting(t, c.Run(context.Background(), testhelp.AlwaysPasses))
+	if defaultCalls != 2 {
+		t.Error("expected the default fallback for failures and short circuits", defaultCalls)
+	}
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, testhelp.AlwaysFailsFallback))
+	testhelp.MustNotTesting(t, c.Execute(WithoutFallback(context.Background()), testhelp.AlwaysPasses, nil))
+	if defaultCalls != 2 {
+		t.Error("expected a per call fallback and WithoutFallback to replace the default", defaultCalls)
+	}
+}
+
+func TestExecuteWithInfo_FastPath(t *testing.T) {
+	c := NewCircuitFromConfig("TestExecuteWithInfo_FastPath", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: -1,
+			Timeout:               -1,
+		},
+	})
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	countsItself := func(ctx context.Context) error {
+		if c.ConcurrentCommands() != 1 {
+			t.Error("expected the call to count as running", c.ConcurrentCommands())
+		}
+		if FromContext(ctx) != "TestExecuteWithInfo_FastPath" {
+			t.Error("expected the circuit name in the context", FromContext(ctx))
+		}
+		return nil
+	}
+	badRequest := func(_ context.Context) error {
+		return SimpleBadRequest{Err: errors.New("bad request")}
+	}
+	for _, tc := range []struct {
+		name     string
+		ctx      context.Context
+		runFunc  func(context.Context) error
+		expected Outcome
+	}{
+		{"success", context.Background(), countsItself, OutcomeSuccess},
+		{"failure", context.Background(), testhelp.AlwaysFails, OutcomeFailure},
+		{"bad request", context.Background(), badRequest, OutcomeBadRequest},
+		{"interrupt", canceled, testhelp.AlwaysFails, OutcomeInterrupt},
+		{"concurrency limit", WithMaxConcurrentRequests(context.Background(), 0), testhelp.AlwaysPasses, OutcomeConcurrencyLimitReject},
+	} {
+		info, _ := c.ExecuteWithInfo(tc.ctx, tc.runFunc, nil)
+		if info.Outcome != tc.expected {
+			t.Errorf("%s: expected %s, saw %s", tc.name, tc.expected, info.Outcome)
+		}
+	}
+	if c.ConcurrentCommands() != 0 {
+		t.Error("expected every call to be released", c.ConcurrentCommands())
+	}
+
+	// Appended metrics must still see every call
+	metrics := &countingRunMetrics{}
+	c.AppendRunMetrics(metrics)
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	if metrics.calls.Get() != 1 {
+		t.Error("expected appended metrics to disable the fast path", metrics.calls.Get())
+	}
+}
+
+func TestDrain(t *testing.T) {
+	metrics := &countingRunMetrics{}
+	c := NewCircuitFromConfig("TestDrain", Config{
+		Metrics: MetricsCollectors{
+			Run:      []RunMetrics{metrics},
+			Fallback: []FallbackMetrics{metrics},
+		},
+	})
+	const running = 5
+	started := make(chan struct{}, running)
+	release := make(chan struct{})
+	results := make(chan error, running)
+	for i := 0; i < running; i++ {
+		go func() {
+			results <- c.Execute(context.Background(), func(_ context.Context) error {
+				started <- struct{}{}
+				<-release
+				return nil
+			}, nil)
+		}()
+	}
+	for i := 0; i < running; i++ {
+		<-started
+	}
+	c.Drain()
+	if !c.IsDraining() {
+		t.Fatal("expected the circuit to be draining")
+	}
+	fallbackCalled := false
+	info, err := c.ExecuteWithInfo(context.Background(), testhelp.AlwaysPasses, func(_ context.Context, _ error) error {
+		fallbackCalled = true
+		return nil
+	})
+	if _, ok := err.(*DrainingError); !ok {
+		t.Fatal("expected a draining error", err)
+	}
+	if info.Outcome != OutcomeDraining || fallbackCalled {
+		t.Error("expected a draining outcome without a fallback", info, fallbackCalled)
+	}
+	if metrics.calls.Get() != 0 {
+		t.Error("draining should not be reported to metrics", metrics.calls.Get())
+	}
+
+	close(release)
+	for i := 0; i < running; i++ {
+		testhelp.MustTesting(t, <-results)
+	}
+	if metrics.calls.Get() != running {
+		t.Error("expected every running call to finish normally", metrics.calls.Get())
+	}
+	if c.IsOpen() {
+		t.Error("draining should not open the circuit")
+	}
+
+	c.UndoDrain()
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+}
+
+// cloningRunMetrics is a countingRunMetrics that Clone gives each circuit its own copy of
+type cloningRunMetrics struct {
+	countingRunMetrics
+}
+
+func (c *cloningRunMetrics) CloneMetrics(_ string) interface{} {
+	return &cloningRunMetrics{}
+}
+
+func TestCircuit_Clone(t *testing.T) {
+	shared := &countingRunMetrics{}
+	cloned := &cloningRunMetrics{}
+	original := NewCircuitFromConfig("TestCircuit_Clone", Config{
+		Execution: ExecutionConfig{
+			Timeout:               time.Second,
+			MaxConcurrentRequests: 7,
+		},
+		General: GeneralConfig{
+			Labels: map[string]string{"team": "a"},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{shared, cloned},
+		},
+	})
+	testhelp.MustNotTesting(t, original.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	original.OpenCircuit()
+
+	clone := original.Clone("TestCircuit_Clone_2")
+	if clone.Name() != "TestCircuit_Clone_2" {
+		t.Error("expected the new name", clone.Name())
+	}
+	if clone.IsOpen() {
+		t.Error("expected the clone to start closed")
+	}
+	cfg, cloneCfg := original.Config(), clone.Config()
+	if cloneCfg.Execution != cfg.Execution || cloneCfg.General.Labels["team"] != "a" {
+		t.Error("expected the same config", cloneCfg)
+	}
+	cloneCfg.General.Labels["team"] = "b"
+	if original.Config().General.Labels["team"] != "a" {
+		t.Error("expected the clone to have its own labels")
+	}
+
+	testhelp.MustTesting(t, clone.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	if n := cloned.calls.Get(); n != 1 {
+		t.Error("expected the original's cloneable collector to only see its own call", n)
+	}
+	if n := clone.Config().Metrics.Run[1].(*cloningRunMetrics).calls.Get(); n != 1 {
+		t.Error("expected the clone's collector to only see its own call", n)
+	}
+	if n := shared.calls.Get(); n != 2 {
+		t.Error("expected collectors that cannot be cloned to be shared", n)
+	}
+}
+
+func TestTimeoutUsesAfterFunc(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := NewCircuitFromConfig("TestTimeoutUsesAfterFunc", Config{
+		Execution: ExecutionConfig{
+			Timeout: time.Second,
+		},
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	info, err := c.ExecuteWithInfo(context.Background(), func(ctx context.Context) error {
+		if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(clk.Now().Add(time.Second)) {
+			t.Error("expected the deadline of the mocked clock", deadline)
+		}
+		clk.Add(time.Second)
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+	if info.Outcome != OutcomeTimeout || err != context.DeadlineExceeded {
+		t.Error("expected the mocked clock to time out the call", info.Outcome, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	info, err = c.ExecuteWithInfo(ctx, func(ctx context.Context) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+	if info.Outcome != OutcomeInterrupt || err != context.Canceled {
+		t.Error("expected canceling the caller's context to not be a timeout", info.Outcome, err)
+	}
+}
+
+func TestTimeout_DerivedContext(t *testing.T) {
+	for _, reuse := range []bool{false, true} {
+		c := NewCircuitFromConfig("TestTimeout_DerivedContext", Config{
+			Execution: ExecutionConfig{
+				Timeout:       10 * time.Millisecond,
+				ReuseContexts: reuse,
+			},
+		})
+		err := c.Execute(context.Background(), func(ctx context.Context) error {
+			child, cancel := context.WithCancel(ctx)
+			defer cancel()
+			<-child.Done()
+			return child.Err()
+		}, nil)
+		if err != context.DeadlineExceeded {
+			t.Error("expected a context derived from the circuit's to see the deadline", reuse, err)
+		}
+	}
+}
+
+func TestReusableAfterFunc(t *testing.T) {
+	if c := NewCircuitFromConfig("TestReusableAfterFunc", Config{}); c.reusableAfterFunc != nil {
+		t.Error("expected the default clock to use plain deadlines")
+	}
+	afterFunc := func(d time.Duration, f func()) *time.Timer {
+		return time.AfterFunc(d, f)
+	}
+	c := NewCircuitFromConfig("TestReusableAfterFunc", Config{
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{AfterFunc: afterFunc},
+		},
+	})
+	if c.reusableAfterFunc == nil {
+		t.Error("expected a configured AfterFunc to be used")
+	}
+}
+
+func TestCircuit_OnAdmission(t *testing.T) {
+	var counts [3]faststats.AtomicInt64
+	c := NewCircuitFromConfig("TestCircuit_OnAdmission", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+		},
+		General: GeneralConfig{
+			OnAdmission: func(_ *Circuit, decision Admission) {
+				counts[decision].Add(1)
+			},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustTesting(t, c.Execute(ctx, func(ctx context.Context) error {
+		// The only slot is taken
+		testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		return nil
+	}, nil))
+	c.OpenCircuit()
+	for i := 0; i < 2; i++ {
+		testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	expected := map[Admission]int64{
+		AdmissionAllowed:                3,
+		AdmissionShortCircuit:           2,
+		AdmissionConcurrencyLimitReject: 1,
+	}
+	for decision, n := range expected {
+		if got := counts[decision].Get(); got != n {
+			t.Errorf("expected %d %s decisions, saw %d", n, decision, got)
+		}
+	}
+}
+
+func TestTotalBudget(t *testing.T) {
+	clk := &clock.MockClock{}
+	start := clk.Set(time.Now())
+	c := NewCircuitFromConfig("TestTotalBudget", Config{
+		Execution: ExecutionConfig{
+			TotalBudget: time.Millisecond * 50,
+		},
+		Fallback: FallbackConfig{
+			Timeout: time.Second,
+		},
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	info, err := c.ExecuteWithInfo(context.Background(), func(ctx context.Context) error {
+		if deadline, _ := ctx.Deadline(); !deadline.Equal(start.Add(time.Millisecond * 50)) {
+			t.Error("expected runFunc to get the whole budget", deadline.Sub(start))
+		}
+		clk.Add(time.Millisecond * 40)
+		return errors.New("failed")
+	}, func(ctx context.Context, _ error) error {
+		if deadline, _ := ctx.Deadline(); deadline.Sub(clk.Now()) != time.Millisecond*10 {
+			t.Error("expected the fallback to only get what is left of the budget", deadline.Sub(clk.Now()))
+		}
+		return nil
+	})
+	if err != nil || info.Outcome != OutcomeFailure {
+		t.Error("expected a failure handled by the fallback", info, err)
+	}
+
+	info, err = c.ExecuteWithInfo(context.Background(), func(ctx context.Context) error {
+		clk.Add(time.Millisecond * 50)
+		<-ctx.Done()
+		return ctx.Err()
+	}, func(ctx context.Context, err error) error {
+		if ctx.Err() != context.DeadlineExceeded {
+			t.Error("expected the fallback to have no budget left", ctx.Err())
+		}
+		return err
+	})
+	if err != context.DeadlineExceeded || info.Outcome != OutcomeTimeout {
+		t.Error("expected using up the budget to time out runFunc", info, err)
+	}
+}
+
+func TestBudgetFunc(t *testing.T) {
+	type budgetKey struct{}
+	var admissions [4]faststats.AtomicInt64
+	metrics := &countingRunMetrics{}
+	c := NewCircuitFromConfig("TestBudgetFunc", Config{
+		General: GeneralConfig{
+			BudgetFunc: func(ctx context.Context) bool {
+				remaining, ok := ctx.Value(budgetKey{}).(int)
+				return !ok || remaining > 0
+			},
+			OnAdmission: func(_ *Circuit, decision Admission) {
+				admissions[decision].Add(1)
+			},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{metrics},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(context.WithValue(ctx, budgetKey{}, 1), testhelp.AlwaysPasses, nil))
+	exhausted := context.WithValue(ctx, budgetKey{}, 0)
+	info, err := c.ExecuteWithInfo(exhausted, func(_ context.Context) error {
+		t.Error("runFunc should not run without budget")
+		return nil
+	}, nil)
+	if info.Outcome != OutcomeBudgetExhausted || info.Outcome.String() != "budget_exhausted" {
+		t.Error("expected a distinct outcome", info.Outcome)
+	}
+	if rejected, ok := err.(*BudgetExhaustedError); !ok || rejected.Reason() != ReasonBudgetExhausted {
+		t.Error("expected a budget exhausted error", err)
+	}
+	if metrics.calls.Get() != 1 {
+		t.Error("calls without budget should not reach RunMetrics", metrics.calls.Get())
+	}
+	if admissions[AdmissionBudgetExhausted].Get() != 1 || admissions[AdmissionAllowed].Get() != 1 {
+		t.Error("expected OnAdmission to see the budget decision")
+	}
+	// The fallback still runs, as it does for short circuits
+	testhelp.MustTesting(t, c.Execute(exhausted, testhelp.AlwaysPasses, func(_ context.Context, err error) error {
+		return nil
+	}))
+}
+
+func TestShortCircuitError(t *testing.T) {
+	unavailable := errors.New("service unavailable")
+	var names []string
+	c := NewCircuitFromConfig("TestShortCircuitError", Config{
+		General: GeneralConfig{
+			ShortCircuitError: func(ctx context.Context) error {
+				names = append(names, FromContext(ctx))
+				return unavailable
+			},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if len(names) != 0 {
+		t.Fatal("failures of a closed circuit should keep their own error")
+	}
+	c.OpenCircuit()
+	if err := c.Execute(ctx, testhelp.AlwaysPasses, nil); err != unavailable {
+		t.Errorf("expected the custom error while open, saw %v", err)
+	}
+	if err := c.Execute(WithoutFallback(ctx), testhelp.AlwaysPasses, testhelp.AlwaysPassesFallback); err != unavailable {
+		t.Errorf("expected the custom error when the fallback is skipped, saw %v", err)
+	}
+	if len(names) != 2 || names[0] != "TestShortCircuitError" {
+		t.Errorf("expected the context to name the circuit: %v", names)
+	}
+	err := c.Execute(ctx, testhelp.AlwaysPasses, func(_ context.Context, err error) error {
+		if _, ok := err.(*CircuitOpenError); !ok {
+			t.Errorf("expected the fallback to get the default error, saw %v", err)
+		}
+		return nil
+	})
+	testhelp.MustTesting(t, err)
+	if len(names) != 2 {
+		t.Error("the custom error should not be made when a fallback runs")
+	}
+}
+
+type hedgeWinsMetrics struct {
+	primaryWon  faststats.AtomicInt64
+	fallbackWon faststats.AtomicInt64
+}
+
+func (h *hedgeWinsMetrics) Success(now time.Time, duration time.Duration)     {}
+func (h *hedgeWinsMetrics) ErrFailure(now time.Time, duration time.Duration)  {}
+func (h *hedgeWinsMetrics) ErrConcurrencyLimitReject(now time.Time)           {}
+func (h *hedgeWinsMetrics) PrimaryWon(now time.Time, duration time.Duration)  { h.primaryWon.Add(1) }
+func (h *hedgeWinsMetrics) FallbackWon(now time.Time, duration time.Duration) { h.fallbackWon.Add(1) }
+
+func TestHedge(t *testing.T) {
+	wins := &hedgeWinsMetrics{}
+	c := NewCircuitFromConfig("TestHedge", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 1,
+		},
+		Fallback: FallbackConfig{
+			Hedge: true,
+		},
+		Metrics: MetricsCollectors{
+			Fallback: []FallbackMetrics{wins},
+		},
+	})
+	primaryStarted := make(chan struct{})
+	primaryCanceled := make(chan error, 1)
+	result := ""
+	info, err := c.ExecuteWithInfo(context.Background(), func(ctx context.Context) error {
+		close(primaryStarted)
+		<-ctx.Done()
+		primaryCanceled <- ctx.Err()
+		return ctx.Err()
+	}, func(ctx context.Context, err error) error {
+		if err != nil {
+			t.Error("a hedged fallback should not be given an error", err)
+		}
+		// Return while the primary is still running
+		<-primaryStarted
+		result = "from fallback"
+		return nil
+	})
+	testhelp.MustTesting(t, err)
+	if result != "from fallback" {
+		t.Error("expected the fallback's result")
+	}
+	if !info.FallbackCalled || !info.FallbackWon {
+		t.Errorf("expected info to show the fallback won: %+v", info)
+	}
+	select {
+	case canceledErr := <-primaryCanceled:
+		if canceledErr != context.Canceled {
+			t.Error("expected the primary to be canceled, saw", canceledErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the primary to be canceled once the fallback won")
+	}
+	if wins.fallbackWon.Get() != 1 || wins.primaryWon.Get() != 0 {
+		t.Errorf("expected one fallback win: %d fallback, %d primary", wins.fallbackWon.Get(), wins.primaryWon.Get())
+	}
+
+	// The primary wins when it succeeds first, and the fallback is canceled
+	fallbackCanceled := make(chan struct{})
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, func(ctx context.Context, err error) error {
+		<-ctx.Done()
+		close(fallbackCanceled)
+		return ctx.Err()
+	}))
+	select {
+	case <-fallbackCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected the fallback to be canceled once the primary won")
+	}
+	if wins.primaryWon.Get() != 1 {
+		t.Error("expected one primary win", wins.primaryWon.Get())
+	}
+
+	// Both failing returns both errors
+	primaryErr := errors.New("primary failed")
+	err = c.Execute(context.Background(), func(_ context.Context) error {
+		return primaryErr
+	}, func(ctx context.Context, err error) error {
+		return errors.New("fallback failed")
+	})
+	if fallbackErr, ok := err.(*FallbackError); !ok || fallbackErr.Err != primaryErr {
+		t.Errorf("expected a *FallbackError holding the primary's error, saw %v", err)
+	}
+}
+
+func TestHedge_IgnoresFallbackConcurrency(t *testing.T) {
+	c := NewCircuitFromConfig("TestHedge_IgnoresFallbackConcurrency", Config{
+		Fallback: FallbackConfig{
+			MaxConcurrentRequests: 1,
+			Hedge:                 true,
+		},
+	})
+	fallbackRunning := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- c.Execute(context.Background(), func(_ context.Context) error {
+			<-release
+			return errors.New("primary failed")
+		}, func(_ context.Context, _ error) error {
+			close(fallbackRunning)
+			<-release
+			return nil
+		})
+	}()
+	<-fallbackRunning
+	// A second hedged fallback runs even though the first is still running
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback))
+	if c.ConcurrentFallbacks() != 0 {
+		t.Error("expected hedged fallbacks not to be counted", c.ConcurrentFallbacks())
+	}
+	close(release)
+	testhelp.MustTesting(t, <-done)
+}
+
+func TestHedge_ShortCircuitError(t *testing.T) {
+	unavailable := errors.New("unavailable")
+	c := NewCircuitFromConfig("TestHedge_ShortCircuitError", Config{
+		General: GeneralConfig{
+			ShortCircuitError: func(_ context.Context) error { return unavailable },
+		},
+		Fallback: FallbackConfig{
+			Hedge: true,
+		},
+	})
+	c.OpenCircuit()
+	err := c.Execute(context.Background(), testhelp.AlwaysPasses, testhelp.AlwaysFailsFallback)
+	if fallbackErr, ok := err.(*FallbackError); !ok || fallbackErr.Err != unavailable {
+		t.Errorf("expected a *FallbackError holding the custom short circuit error, saw %v", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+Package circuitgrpc adds circuit breaking to gRPC clients with a unary client interceptor.  Each full method name gets
+its own circuit, created lazily from a circuit.Manager.  It needs Go 1.21 or newer, like current releases of gRPC.
+*/
+package circuitgrpc
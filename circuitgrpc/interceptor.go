@@ -0,0 +1,80 @@
+//go:build go1.21
+// +build go1.21
+
+package circuitgrpc
+
+import (
+	"context"
+
+	"github.com/cep21/circuit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Interceptor sends each unary gRPC call through a circuit named after the call's full method name.  Install it with
+// grpc.WithUnaryInterceptor(i.Unary).
+type Interceptor struct {
+	// Manager creates and tracks the circuit for each method.  It is required.
+	Manager *circuit.Manager
+	// IsBadRequest decides which error codes are the caller's fault.  Bad requests neither count against the circuit
+	// nor trigger fallbacks; every other error is a failure.  It defaults to DefaultIsBadRequest
+	IsBadRequest func(code codes.Code) bool
+}
+
+// DefaultIsBadRequest treats the codes for mistakes by the caller as bad requests: InvalidArgument, NotFound,
+// AlreadyExists, PermissionDenied, Unauthenticated, FailedPrecondition, OutOfRange, and Unimplemented.  Every other
+// code, including server faults like Internal and Unknown, is a failure.
+func DefaultIsBadRequest(code codes.Code) bool {
+	switch code {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied, codes.Unauthenticated,
+		codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented:
+		return true
+	}
+	return false
+}
+
+var _ grpc.UnaryClientInterceptor = (&Interceptor{}).Unary
+
+// Unary is a grpc.UnaryClientInterceptor.  If the circuit rejects the call, it returns codes.Unavailable without
+// calling the remote.
+func (i *Interceptor) Unary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	c, err := i.circuitFor(method)
+	if err != nil {
+		return err
+	}
+	err = c.Execute(ctx, func(ctx context.Context) error {
+		invokeErr := invoker(ctx, method, req, reply, cc, opts...)
+		if invokeErr != nil && i.isBadRequest(status.Code(invokeErr)) {
+			return circuit.SimpleBadRequest{Err: invokeErr}
+		}
+		return invokeErr
+	}, nil)
+	if rejected, ok := err.(circuit.RejectedError); ok {
+		return status.Errorf(codes.Unavailable, "circuit %s rejected the call: %s", rejected.CircuitName(), rejected.Reason())
+	}
+	return err
+}
+
+func (i *Interceptor) isBadRequest(code codes.Code) bool {
+	if i.IsBadRequest == nil {
+		return DefaultIsBadRequest(code)
+	}
+	return i.IsBadRequest(code)
+}
+
+// circuitFor returns the circuit for method, creating it if this is the first call
+func (i *Interceptor) circuitFor(method string) (*circuit.Circuit, error) {
+	if c := i.Manager.GetCircuit(method); c != nil {
+		return c, nil
+	}
+	c, err := i.Manager.CreateCircuit(method)
+	if err != nil {
+		// Another call may have created it first
+		if existing := i.Manager.GetCircuit(method); existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,192 @@
+//go:build go1.21
+// +build go1.21
+
+package circuitgrpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const checkMethod = "/grpc.health.v1.Health/Check"
+
+// testServer is a health server whose calls fail with code, unless code is OK
+type testServer struct {
+	code  int32
+	calls int32
+	lis   *bufconn.Listener
+	srv   *grpc.Server
+}
+
+func newTestServer() *testServer {
+	ts := &testServer{
+		lis: bufconn.Listen(1 << 20),
+	}
+	ts.srv = grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		atomic.AddInt32(&ts.calls, 1)
+		if code := codes.Code(atomic.LoadInt32(&ts.code)); code != codes.OK {
+			return nil, status.Error(code, "injected")
+		}
+		return handler(ctx, req)
+	}))
+	grpc_health_v1.RegisterHealthServer(ts.srv, health.NewServer())
+	go func() {
+		_ = ts.srv.Serve(ts.lis)
+	}()
+	return ts
+}
+
+func (ts *testServer) setCode(code codes.Code) {
+	atomic.StoreInt32(&ts.code, int32(code))
+}
+
+func (ts *testServer) client(t *testing.T, i *Interceptor) (grpc_health_v1.HealthClient, func()) {
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return ts.lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(i.Unary),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return grpc_health_v1.NewHealthClient(conn), func() {
+		_ = conn.Close()
+		ts.srv.Stop()
+	}
+}
+
+type countingMetrics struct {
+	failures    int32
+	badRequests int32
+}
+
+func (c *countingMetrics) Success(now time.Time, duration time.Duration) {}
+func (c *countingMetrics) ErrFailure(now time.Time, duration time.Duration) {
+	atomic.AddInt32(&c.failures, 1)
+}
+func (c *countingMetrics) ErrTimeout(now time.Time, duration time.Duration) {}
+func (c *countingMetrics) ErrBadRequest(now time.Time, duration time.Duration) {
+	atomic.AddInt32(&c.badRequests, 1)
+}
+func (c *countingMetrics) ErrInterrupt(now time.Time, duration time.Duration) {}
+func (c *countingMetrics) ErrShortCircuit(now time.Time)                      {}
+func (c *countingMetrics) ErrConcurrencyLimitReject(now time.Time)            {}
+
+func countingManager(m *countingMetrics) *circuit.Manager {
+	return &circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					Metrics: circuit.MetricsCollectors{
+						Run: []circuit.RunMetrics{m},
+					},
+				}
+			},
+		},
+	}
+}
+
+func TestInterceptor_CircuitPerMethod(t *testing.T) {
+	ts := newTestServer()
+	h := &circuit.Manager{}
+	client, cleanup := ts.client(t, &Interceptor{Manager: h})
+	defer cleanup()
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err != nil {
+		t.Fatal("expected a healthy response", err)
+	}
+	if h.GetCircuit(checkMethod) == nil {
+		t.Fatal("expected a circuit named after the full method", h.AllCircuits())
+	}
+}
+
+func TestInterceptor_Codes(t *testing.T) {
+	ts := newTestServer()
+	m := &countingMetrics{}
+	client, cleanup := ts.client(t, &Interceptor{Manager: countingManager(m)})
+	defer cleanup()
+	ctx := context.Background()
+
+	ts.setCode(codes.InvalidArgument)
+	if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatal("expected the server's error", err)
+	}
+	for _, code := range []codes.Code{codes.Unavailable, codes.Internal, codes.Unknown} {
+		ts.setCode(code)
+		if _, err := client.Check(ctx, &grpc_health_v1.HealthCheckRequest{}); status.Code(err) != code {
+			t.Fatal("expected the server's error", err)
+		}
+	}
+	if atomic.LoadInt32(&m.badRequests) != 1 || atomic.LoadInt32(&m.failures) != 3 {
+		t.Fatalf("expected one bad request and three failures, saw %d and %d", m.badRequests, m.failures)
+	}
+}
+
+func TestInterceptor_CustomIsBadRequest(t *testing.T) {
+	ts := newTestServer()
+	m := &countingMetrics{}
+	client, cleanup := ts.client(t, &Interceptor{
+		Manager: countingManager(m),
+		IsBadRequest: func(code codes.Code) bool {
+			return false
+		},
+	})
+	defer cleanup()
+	ts.setCode(codes.NotFound)
+	if _, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if atomic.LoadInt32(&m.failures) != 1 {
+		t.Fatal("expected the custom mapping to count NotFound as a failure")
+	}
+}
+
+func TestInterceptor_OpenCircuit(t *testing.T) {
+	ts := newTestServer()
+	h := &circuit.Manager{}
+	client, cleanup := ts.client(t, &Interceptor{Manager: h})
+	defer cleanup()
+	h.MustCreateCircuit(checkMethod).OpenCircuit()
+	_, err := client.Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	if status.Code(err) != codes.Unavailable || !strings.Contains(err.Error(), checkMethod) {
+		t.Fatal("expected an Unavailable error naming the circuit", err)
+	}
+	if atomic.LoadInt32(&ts.calls) != 0 {
+		t.Fatal("open circuits should not call the server")
+	}
+}
+
+func TestDefaultIsBadRequest(t *testing.T) {
+	for code, expected := range map[codes.Code]bool{
+		codes.InvalidArgument:    true,
+		codes.NotFound:           true,
+		codes.PermissionDenied:   true,
+		codes.Unimplemented:      true,
+		codes.FailedPrecondition: true,
+		codes.Internal:           false,
+		codes.Unknown:            false,
+		codes.DataLoss:           false,
+		codes.Aborted:            false,
+		codes.Unavailable:        false,
+		codes.DeadlineExceeded:   false,
+		codes.ResourceExhausted:  false,
+	} {
+		if DefaultIsBadRequest(code) != expected {
+			t.Errorf("expected DefaultIsBadRequest(%s) to be %t", code, expected)
+		}
+	}
+}
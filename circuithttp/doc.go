@@ -0,0 +1,5 @@
+/*
+Package circuithttp adds circuit breaking to any http.Client by wrapping its Transport.  Each request host gets its own
+circuit, created lazily from a circuit.Manager.
+*/
+package circuithttp
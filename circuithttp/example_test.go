@@ -0,0 +1,24 @@
+package circuithttp_test
+
+import (
+	"net/http"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/circuithttp"
+)
+
+// This example adds circuit breaking to an http.Client by swapping its Transport
+func ExampleTransport() {
+	h := circuit.Manager{}
+	client := &http.Client{
+		Transport: &circuithttp.Transport{
+			Manager: &h,
+		},
+	}
+	resp, err := client.Get("http://example.com/")
+	if err != nil {
+		// Circuits for example.com may be open
+		return
+	}
+	_ = resp.Body.Close()
+}
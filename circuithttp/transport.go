@@ -0,0 +1,100 @@
+package circuithttp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/cep21/circuit"
+)
+
+// Transport is an http.RoundTripper that sends each request through a circuit named after the request's host.
+// Connection errors, timeouts, and 5xx responses count as failures.  4xx responses are bad requests: they neither
+// count against the circuit nor trigger fallbacks.  Responses are always returned to the caller, whatever the status.
+//
+// The request is sent with its own context, not the circuit's, so the response body stays readable after RoundTrip
+// returns.  A response slower than the circuit's Execution.Timeout still counts as a timeout, but to cancel slow
+// requests use http.Client.Timeout or a request context deadline.
+type Transport struct {
+	// Manager creates and tracks the circuit for each host.  It is required.
+	Manager *circuit.Manager
+	// Base sends requests that the circuit allows.  It defaults to http.DefaultTransport
+	Base http.RoundTripper
+	// Fallback, if set, sends requests the circuit rejects.  Without it, RoundTrip returns the circuit's
+	// circuit.RejectedError
+	Fallback http.RoundTripper
+	// CircuitName names the circuit for a request.  It defaults to the request's URL host
+	CircuitName func(req *http.Request) string
+}
+
+var _ http.RoundTripper = &Transport{}
+
+// StatusError is the error a circuit sees for a 4xx or 5xx response.  RoundTrip returns the response instead of it.
+type StatusError struct {
+	StatusCode int
+}
+
+func (s *StatusError) Error() string {
+	return fmt.Sprintf("http status %d", s.StatusCode)
+}
+
+// RoundTrip sends req through the circuit for its host
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	c, err := t.circuitFor(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp *http.Response
+	err = c.Execute(req.Context(), func(_ context.Context) error {
+		var runErr error
+		resp, runErr = t.base().RoundTrip(req)
+		if runErr != nil {
+			return runErr
+		}
+		switch {
+		case resp.StatusCode >= 500:
+			return &StatusError{StatusCode: resp.StatusCode}
+		case resp.StatusCode >= 400:
+			return circuit.SimpleBadRequest{Err: &StatusError{StatusCode: resp.StatusCode}}
+		}
+		return nil
+	}, nil)
+	if resp != nil {
+		return resp, nil
+	}
+	if _, isRejected := err.(circuit.RejectedError); isRejected && t.Fallback != nil {
+		return t.Fallback.RoundTrip(req)
+	}
+	return nil, err
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+func (t *Transport) circuitName(req *http.Request) string {
+	if t.CircuitName == nil {
+		return req.URL.Host
+	}
+	return t.CircuitName(req)
+}
+
+// circuitFor returns the circuit for req, creating it if this is the first request for its name
+func (t *Transport) circuitFor(req *http.Request) (*circuit.Circuit, error) {
+	name := t.circuitName(req)
+	if c := t.Manager.GetCircuit(name); c != nil {
+		return c, nil
+	}
+	c, err := t.Manager.CreateCircuit(name)
+	if err != nil {
+		// Another request may have created it first
+		if existing := t.Manager.GetCircuit(name); existing != nil {
+			return existing, nil
+		}
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,168 @@
+package circuithttp
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+)
+
+func statusServer(status *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(*status)
+		_, _ = rw.Write([]byte("hello"))
+	}))
+}
+
+func get(t *testing.T, client *http.Client, url string) (*http.Response, error) {
+	resp, err := client.Get(url)
+	if err == nil {
+		body, readErr := ioutil.ReadAll(resp.Body)
+		if readErr != nil {
+			t.Fatal("expected a readable body", readErr)
+		}
+		if string(body) != "hello" {
+			t.Fatal("unexpected body", string(body))
+		}
+		_ = resp.Body.Close()
+	}
+	return resp, err
+}
+
+func TestTransport_CircuitPerHost(t *testing.T) {
+	status := http.StatusOK
+	server := statusServer(&status)
+	defer server.Close()
+	h := &circuit.Manager{}
+	client := &http.Client{Transport: &Transport{Manager: h}}
+
+	resp, err := get(t, client, server.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("expected a successful request", err)
+	}
+	host := strings.TrimPrefix(server.URL, "http://")
+	c := h.GetCircuit(host)
+	if c == nil {
+		t.Fatal("expected a circuit named after the host", h.AllCircuits())
+	}
+	if _, err := get(t, client, server.URL); err != nil {
+		t.Fatal("expected a second successful request", err)
+	}
+	if len(h.AllCircuits()) != 1 {
+		t.Fatal("expected requests to one host to share a circuit")
+	}
+}
+
+func TestTransport_StatusCodes(t *testing.T) {
+	status := http.StatusNotFound
+	server := statusServer(&status)
+	defer server.Close()
+	var failures, badRequests int
+	h := &circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					Metrics: circuit.MetricsCollectors{
+						Run: []circuit.RunMetrics{&countingMetrics{failures: &failures, badRequests: &badRequests}},
+					},
+				}
+			},
+		},
+	}
+	client := &http.Client{Transport: &Transport{Manager: h}}
+
+	resp, err := get(t, client, server.URL)
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatal("expected the 404 response to be returned", err)
+	}
+	status = http.StatusServiceUnavailable
+	resp, err = get(t, client, server.URL)
+	if err != nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("expected the 503 response to be returned", err)
+	}
+	if badRequests != 1 || failures != 1 {
+		t.Fatalf("expected 4xx to be a bad request and 5xx a failure, saw %d bad requests and %d failures", badRequests, failures)
+	}
+}
+
+func TestTransport_ConnectionErrorsFail(t *testing.T) {
+	status := http.StatusOK
+	server := statusServer(&status)
+	url := server.URL
+	server.Close()
+	var failures, badRequests int
+	h := &circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(_ string) circuit.Config {
+				return circuit.Config{
+					Metrics: circuit.MetricsCollectors{
+						Run: []circuit.RunMetrics{&countingMetrics{failures: &failures, badRequests: &badRequests}},
+					},
+				}
+			},
+		},
+	}
+	client := &http.Client{Transport: &Transport{Manager: h}}
+	if _, err := client.Get(url); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	if failures != 1 {
+		t.Fatal("expected connection errors to count as failures", failures)
+	}
+}
+
+func TestTransport_ShortCircuit(t *testing.T) {
+	status := http.StatusOK
+	server := statusServer(&status)
+	defer server.Close()
+	h := &circuit.Manager{}
+	transport := &Transport{Manager: h}
+	client := &http.Client{Transport: transport}
+	if _, err := get(t, client, server.URL); err != nil {
+		t.Fatal("expected a successful request", err)
+	}
+	h.GetCircuit(strings.TrimPrefix(server.URL, "http://")).OpenCircuit()
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = transport.RoundTrip(req)
+	if rejected, ok := err.(circuit.RejectedError); !ok || rejected.Reason() != circuit.ReasonCircuitOpen {
+		t.Fatal("expected the circuit open error", err)
+	}
+
+	fallbackStatus := http.StatusOK
+	fallbackServer := statusServer(&fallbackStatus)
+	defer fallbackServer.Close()
+	transport.Fallback = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return http.Get(fallbackServer.URL)
+	})
+	resp, err := get(t, client, server.URL)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatal("expected the fallback response", err)
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (r roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return r(req)
+}
+
+type countingMetrics struct {
+	failures    *int
+	badRequests *int
+}
+
+func (c *countingMetrics) Success(now time.Time, duration time.Duration)       {}
+func (c *countingMetrics) ErrFailure(now time.Time, duration time.Duration)    { *c.failures++ }
+func (c *countingMetrics) ErrTimeout(now time.Time, duration time.Duration)    {}
+func (c *countingMetrics) ErrBadRequest(now time.Time, duration time.Duration) { *c.badRequests++ }
+func (c *countingMetrics) ErrInterrupt(now time.Time, duration time.Duration)  {}
+func (c *countingMetrics) ErrShortCircuit(now time.Time)                       {}
+func (c *countingMetrics) ErrConcurrencyLimitReject(now time.Time)             {}
@@ -0,0 +1,49 @@
+package circuittest_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/circuittest"
+	"github.com/cep21/circuit/closers/hystrix"
+)
+
+// Script the hystrix logic through open, half open, and closed again
+func ExampleHarness() {
+	h := circuittest.NewHarness("scripted", time.Unix(0, 0), circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: hystrix.OpenerFactory(hystrix.ConfigureOpener{
+				RequestVolumeThreshold: 2,
+			}),
+			OpenToClosedFactory: hystrix.CloserFactory(hystrix.ConfigureCloser{
+				SleepWindow: time.Second,
+			}),
+		},
+	})
+	results := h.Run(
+		circuittest.Step{Outcome: circuit.OutcomeFailure},
+		circuittest.Step{Outcome: circuit.OutcomeTimeout},
+		// Open: short circuited until the sleep window ends
+		circuittest.Step{Outcome: circuit.OutcomeSuccess, Advance: time.Millisecond},
+		// Half open: the probe fails, so the circuit sleeps again
+		circuittest.Step{Outcome: circuit.OutcomeFailure, Advance: time.Second},
+		// Probes succeed again, and close the circuit once there are more than RequiredConcurrentSuccessful
+		circuittest.Step{Outcome: circuit.OutcomeSuccess, Advance: time.Second},
+		circuittest.Step{Outcome: circuit.OutcomeSuccess, Advance: time.Second},
+	)
+	for i, r := range results {
+		fmt.Println(i, r.Outcome, r.IsOpen)
+	}
+	for _, t := range h.Transitions() {
+		fmt.Println("step", t.Step, "open:", t.IsOpen)
+	}
+	// Output: 0 failure false
+	// 1 timeout true
+	// 2 short_circuit true
+	// 3 failure true
+	// 4 success true
+	// 5 success false
+	// step 1 open: true
+	// step 5 open: false
+}
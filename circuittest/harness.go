@@ -0,0 +1,137 @@
+// Package circuittest contains helpers for testing circuits and custom open and close logic deterministically.
+package circuittest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/faststats/faststatstest"
+)
+
+// errScripted is what runFunc returns for scripted failures
+var errScripted = errors.New("scripted failure")
+
+// Step is one scripted call to the circuit.  The clock moves forward by Advance, then the circuit is asked to run a
+// runFunc that produces Outcome.
+type Step struct {
+	// Outcome is what runFunc does: OutcomeSuccess, OutcomeFailure, OutcomeTimeout, or OutcomeBadRequest.  A timeout
+	// moves the clock past the circuit's Execution.Timeout while runFunc runs, so the circuit must have one.
+	Outcome circuit.Outcome
+	// Advance is how far to move the clock before the call
+	Advance time.Duration
+}
+
+// StepResult is what happened to one Step
+type StepResult struct {
+	// Outcome is how the circuit handled the call.  It differs from the scripted Outcome when the circuit did not
+	// call runFunc, such as OutcomeShortCircuit while open.
+	Outcome circuit.Outcome
+	// IsOpen is the circuit's state after the call
+	IsOpen bool
+	// Time is the clock when the call was made
+	Time time.Time
+}
+
+// Transition is a change of circuit state seen while running a script
+type Transition struct {
+	// Step is the index, into every step run so far, that caused the transition
+	Step   int
+	IsOpen bool
+	Time   time.Time
+}
+
+// Harness drives a circuit through scripted outcomes with a virtual clock and records its state transitions
+type Harness struct {
+	// Circuit is the circuit being driven.  Its TimeKeeper is Clock.
+	Circuit *circuit.Circuit
+	// Clock is the circuit's virtual clock.  Scripts move it forward with Step.Advance.
+	Clock *faststatstest.ManualTimer
+
+	mu          sync.Mutex
+	steps       int
+	transitions []Transition
+}
+
+// NewHarness creates a circuit from config, with a virtual clock starting at start.  Any TimeKeeper in config is
+// replaced, and any OnStateChange is still called after the harness records the transition.
+func NewHarness(name string, start time.Time, config circuit.Config) *Harness {
+	h := &Harness{
+		Clock: faststatstest.NewManualTimer(start),
+	}
+	config.General.TimeKeeper = circuit.TimeKeeper{
+		Now:       h.Clock.Now,
+		AfterFunc: h.Clock.AfterFunc,
+	}
+	onStateChange := config.General.OnStateChange
+	config.General.OnStateChange = func(c *circuit.Circuit, isOpen bool) {
+		h.mu.Lock()
+		h.transitions = append(h.transitions, Transition{Step: h.steps, IsOpen: isOpen, Time: h.Clock.Now()})
+		h.mu.Unlock()
+		if onStateChange != nil {
+			onStateChange(c, isOpen)
+		}
+	}
+	h.Circuit = circuit.NewCircuitFromConfig(name, config)
+	return h
+}
+
+// Run applies each step in order and returns what happened to each.  It panics on an Outcome it cannot script.
+func (h *Harness) Run(steps ...Step) []StepResult {
+	ret := make([]StepResult, 0, len(steps))
+	for _, step := range steps {
+		ret = append(ret, h.run(step))
+		h.mu.Lock()
+		h.steps++
+		h.mu.Unlock()
+	}
+	return ret
+}
+
+func (h *Harness) run(step Step) StepResult {
+	now := h.Clock.Advance(step.Advance)
+	runFunc := h.runFunc(step.Outcome)
+	info, _ := h.Circuit.ExecuteWithInfo(context.Background(), runFunc, nil)
+	return StepResult{
+		Outcome: info.Outcome,
+		IsOpen:  h.Circuit.IsOpen(),
+		Time:    now,
+	}
+}
+
+func (h *Harness) runFunc(outcome circuit.Outcome) func(context.Context) error {
+	switch outcome {
+	case circuit.OutcomeSuccess:
+		return func(_ context.Context) error {
+			return nil
+		}
+	case circuit.OutcomeFailure:
+		return func(_ context.Context) error {
+			return errScripted
+		}
+	case circuit.OutcomeBadRequest:
+		return func(_ context.Context) error {
+			return circuit.NewBadRequest(errScripted)
+		}
+	case circuit.OutcomeTimeout:
+		timeout := h.Circuit.Config().Execution.Timeout
+		if timeout <= 0 {
+			panic("circuittest: a timeout step needs a circuit with an Execution.Timeout")
+		}
+		return func(_ context.Context) error {
+			h.Clock.Advance(timeout)
+			return nil
+		}
+	}
+	panic(fmt.Sprintf("circuittest: cannot script outcome %s", outcome))
+}
+
+// Transitions returns every state transition seen so far, in order
+func (h *Harness) Transitions() []Transition {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Transition(nil), h.transitions...)
+}
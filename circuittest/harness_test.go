@@ -0,0 +1,51 @@
+package circuittest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/closers/hystrix"
+)
+
+func TestHarness(t *testing.T) {
+	start := time.Unix(100, 0)
+	var changes []bool
+	h := NewHarness("TestHarness", start, circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: hystrix.OpenerFactory(hystrix.ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			OnStateChange: func(_ *circuit.Circuit, isOpen bool) {
+				changes = append(changes, isOpen)
+			},
+		},
+	})
+	results := h.Run(
+		Step{Outcome: circuit.OutcomeBadRequest},
+		Step{Outcome: circuit.OutcomeFailure, Advance: time.Second},
+	)
+	if results[0].Outcome != circuit.OutcomeBadRequest || results[0].IsOpen {
+		t.Error("bad requests should not open the circuit", results[0])
+	}
+	if results[1].Outcome != circuit.OutcomeFailure || !results[1].IsOpen || !results[1].Time.Equal(start.Add(time.Second)) {
+		t.Error("expected the failure to open the circuit a second in", results[1])
+	}
+	transitions := h.Transitions()
+	if len(transitions) != 1 || transitions[0].Step != 1 || !transitions[0].IsOpen {
+		t.Error("expected one transition to open on the second step", transitions)
+	}
+	if len(changes) != 1 || !changes[0] {
+		t.Error("expected the configured OnStateChange to still be called", changes)
+	}
+}
+
+func TestHarness_UnscriptableOutcome(t *testing.T) {
+	h := NewHarness("TestHarness_UnscriptableOutcome", time.Now(), circuit.Config{})
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for an outcome runFunc cannot produce")
+		}
+	}()
+	h.Run(Step{Outcome: circuit.OutcomeShortCircuit})
+}
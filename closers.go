@@ -1,6 +1,7 @@
 package circuit
 
 import (
+	"errors"
 	"time"
 )
 
@@ -10,7 +11,7 @@ type ClosedToOpen interface {
 	RunMetrics
 	Metrics
 	// AttemptToOpen a circuit that is currently closed, after a bad request comes in.  Only called after bad requests,
-	// never called after a successful request
+	// never called after a successful request, unless the ClosedToOpen implements OpensOnSuccess
 	ShouldOpen(now time.Time) bool
 	// Even though the circuit is closed, and we want to allow the circuit to remain closed, we still prevent this
 	// command from happening.  The error will return as a short circuit to the caller, as well as trigger fallback
@@ -18,6 +19,31 @@ type ClosedToOpen interface {
 	Prevent(now time.Time) bool
 }
 
+// OpensOnSuccess is implemented by ClosedToOpen logic that may want to open a circuit after a successful request, for
+// example because requests are succeeding too slowly.  If OpensOnSuccess returns true, circuits also call ShouldOpen
+// after successful requests.
+type OpensOnSuccess interface {
+	OpensOnSuccess() bool
+}
+
+// ErrorThresholdSetter is implemented by ClosedToOpen logic, like the hystrix Opener, that opens on an error percentage
+// which can be changed while the circuit is running
+type ErrorThresholdSetter interface {
+	// SetErrorThresholdPercentage changes the threshold, between 1 and 100, used by the next ShouldOpen
+	SetErrorThresholdPercentage(percentage int64) error
+}
+
+// SetErrorThresholdPercentage changes the error percentage, between 1 and 100, that opens the circuit, for example to
+// loosen it during a maintenance window.  It returns an error if ClosedToOpen is not an ErrorThresholdSetter or the
+// percentage is out of range.
+func (c *Circuit) SetErrorThresholdPercentage(percentage int64) error {
+	setter, ok := c.ClosedToOpen.(ErrorThresholdSetter)
+	if !ok {
+		return errors.New("circuit's ClosedToOpen does not support changing the error threshold")
+	}
+	return setter.SetErrorThresholdPercentage(percentage)
+}
+
 // OpenToClosed controls logic that tries to close an open circuit
 type OpenToClosed interface {
 	RunMetrics
@@ -3,6 +3,7 @@ package hystrix
 import (
 	"context"
 	"errors"
+	"math/rand"
 	"sync"
 	"testing"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"sync/atomic"
 
 	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/clock"
 	"github.com/cep21/circuit/internal/testhelp"
 )
 
@@ -304,3 +306,951 @@ func TestCircuitRecovers(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCloser_SleepWindowJitter(t *testing.T) {
+	now := time.Now()
+	sleepWindow := time.Second
+	jitter := time.Second
+	closerFactory := CloserFactory(ConfigureCloser{
+		SleepWindow:       sleepWindow,
+		SleepWindowJitter: jitter,
+		JitterSource:      rand.New(rand.NewSource(1)).Int63n,
+	})
+	seen := make(map[time.Duration]struct{})
+	for i := 0; i < 20; i++ {
+		closer := closerFactory().(*Closer)
+		closer.Opened(now)
+		remaining := closer.reopenCircuitCheck.Remaining(now)
+		if remaining < sleepWindow || remaining > sleepWindow+jitter {
+			t.Fatalf("expected next probe between %s and %s, saw %s", sleepWindow, sleepWindow+jitter, remaining)
+		}
+		seen[remaining] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Error("expected jitter to vary the next probe time across circuits")
+	}
+}
+
+func TestCloser_NoJitterByDefault(t *testing.T) {
+	now := time.Now()
+	closer := CloserFactory(ConfigureCloser{
+		SleepWindow: time.Second,
+	})().(*Closer)
+	closer.Opened(now)
+	if remaining := closer.reopenCircuitCheck.Remaining(now); remaining != time.Second {
+		t.Errorf("expected next probe exactly one sleep window away, saw %s", remaining)
+	}
+}
+
+func TestCloser_ProbeBackoff(t *testing.T) {
+	now := time.Now()
+	closer := CloserFactory(ConfigureCloser{
+		SleepWindow:         time.Second,
+		ProbeBackoffFactor:  2,
+		ProbeMaxSleepWindow: time.Second * 3,
+	})().(*Closer)
+	closer.Opened(now)
+	expectWait := func(expected time.Duration) {
+		t.Helper()
+		if remaining := closer.reopenCircuitCheck.Remaining(now); remaining != expected {
+			t.Errorf("expected the next probe in %s, saw %s", expected, remaining)
+		}
+	}
+	expectWait(time.Second)
+	closer.ErrFailure(now, time.Millisecond)
+	expectWait(time.Second * 2)
+	closer.ErrTimeout(now, time.Millisecond)
+	// 4 seconds, capped
+	expectWait(time.Second * 3)
+
+	closer.Success(now, time.Millisecond)
+	closer.Success(now, time.Millisecond)
+	if !closer.ShouldClose(now) {
+		t.Fatal("expected successful probes to close the circuit")
+	}
+	closer.Closed(now)
+	closer.Opened(now)
+	expectWait(time.Second)
+}
+
+func TestCloser_HalfOpenSuccessPercentage(t *testing.T) {
+	now := time.Now()
+	closer := CloserFactory(ConfigureCloser{
+		SleepWindow:               time.Second,
+		HalfOpenAttempts:          4,
+		HalfOpenSuccessPercentage: 75,
+	})().(*Closer)
+	closer.Opened(now)
+
+	// Half the probes fail: stay open
+	closer.Success(now, time.Millisecond)
+	closer.ErrFailure(now, time.Millisecond)
+	closer.Success(now, time.Millisecond)
+	if closer.ShouldClose(now) {
+		t.Error("should wait for every probe before closing")
+	}
+	closer.ErrTimeout(now, time.Millisecond)
+	if closer.ShouldClose(now) {
+		t.Error("should not close when only half the probes pass")
+	}
+
+	// Three of four pass: close, even though they were not consecutive
+	closer.Success(now, time.Millisecond)
+	closer.ErrFailure(now, time.Millisecond)
+	closer.Success(now, time.Millisecond)
+	closer.Success(now, time.Millisecond)
+	if !closer.ShouldClose(now) {
+		t.Error("should close when enough probes pass")
+	}
+
+	closer.Opened(now)
+	if closer.ShouldClose(now) {
+		t.Error("opening again should forget old probes")
+	}
+}
+
+func TestCloser_RequiredConsecutiveSuccessesToClose(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestCloser_RequiredConsecutiveSuccessesToClose", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow:                         time.Second,
+				RequiredConsecutiveSuccessesToClose: 3,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the circuit to open")
+	}
+	clk.Add(time.Second)
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	// A successful probe lets the next one through right away, but it fails
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if err := c.Execute(ctx, testhelp.AlwaysPasses, nil); err == nil {
+		t.Fatal("expected a failed probe to start a new sleep window")
+	}
+	clk.Add(time.Second)
+	for i := 0; i < 2; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		if !c.IsOpen() {
+			t.Fatalf("expected the circuit to stay half open after %d consecutive successes", i+1)
+		}
+	}
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if c.IsOpen() {
+		t.Fatal("expected the circuit to close after 3 consecutive successes")
+	}
+}
+
+func TestCloser_MinProbesToClose(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestCloser_MinProbesToClose", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow:      time.Second,
+				MinProbesToClose: 3,
+				ProbeWindow:      time.Minute,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the circuit to open")
+	}
+	for i := 0; i < 2; i++ {
+		clk.Add(time.Second)
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		if !c.IsOpen() {
+			t.Fatalf("expected the circuit to stay half open after %d probes", i+1)
+		}
+	}
+	// Probes that fall out of the window no longer count
+	clk.Add(time.Minute)
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected old probes to leave the probe window")
+	}
+	for i := 0; i < 2; i++ {
+		clk.Add(time.Second)
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	if c.IsOpen() {
+		t.Fatal("expected the circuit to close once enough probes finished")
+	}
+}
+
+func TestSleepWindowUsesTimeKeeper(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestSleepWindowUsesTimeKeeper", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow: time.Minute,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	if err := c.Execute(context.Background(), testhelp.AlwaysFails, nil); err == nil {
+		t.Fatal("expected a failure")
+	}
+	if !c.IsOpen() {
+		t.Fatal("circuit should open after failing")
+	}
+	clk.Add(time.Second * 30)
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err == nil {
+		t.Fatal("circuit should still be sleeping")
+	}
+	clk.Add(time.Second * 31)
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+		t.Fatal("circuit should allow a probe once the mock clock passes the sleep window", err)
+	}
+	clk.Add(time.Minute)
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+		t.Fatal("circuit should allow another probe after the next sleep window", err)
+	}
+	if c.IsOpen() {
+		t.Fatal("circuit should close after passing probes")
+	}
+}
+
+func TestCloser_ShorterSleepWindowAppliesToCurrentSleep(t *testing.T) {
+	clk := &clock.MockClock{}
+	now := clk.Set(time.Now())
+	closer := CloserFactory(ConfigureCloser{
+		SleepWindow: time.Minute,
+	})().(*Closer)
+	closer.SetTimeKeeper(circuit.TimeKeeper{
+		Now:       clk.Now,
+		AfterFunc: clk.AfterFunc,
+	})
+	closer.Opened(now)
+	cfg := closer.Config()
+	cfg.SleepWindow = time.Second * 5
+	closer.SetConfigThreadSafe(cfg)
+	if closer.Allow(clk.Add(time.Second * 4)) {
+		t.Fatal("should still sleep before the new window ends")
+	}
+	if !closer.Allow(clk.Add(time.Second)) {
+		t.Fatal("should probe once the shortened window ends")
+	}
+}
+
+func TestWarmupDuration(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestWarmupDuration", circuit.Config{
+		General: circuit.GeneralConfig{
+			WarmupDuration: time.Second * 10,
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 3,
+				RollingDuration:        time.Minute,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	for i := 0; i < 5; i++ {
+		if err := c.Execute(context.Background(), testhelp.AlwaysFails, nil); err == nil {
+			t.Fatal("expected a failure")
+		}
+	}
+	if c.IsOpen() {
+		t.Fatal("circuit should not open during warmup")
+	}
+	clk.Add(time.Second * 10)
+	if err := c.Execute(context.Background(), testhelp.AlwaysFails, nil); err == nil {
+		t.Fatal("expected a failure")
+	}
+	if !c.IsOpen() {
+		t.Fatal("circuit should open on the failures seen during warmup once warmup ends")
+	}
+}
+
+func TestCloser_JitterUsesCircuitRand(t *testing.T) {
+	sleepWindows := func(seed int64) []time.Duration {
+		ret := make([]time.Duration, 0, 5)
+		rnd := circuit.NewSeededRand(seed)
+		for i := 0; i < 5; i++ {
+			c := circuit.NewCircuitFromConfig("TestCloser_JitterUsesCircuitRand", circuit.Config{
+				General: circuit.GeneralConfig{
+					RandInt63n: rnd,
+					OpenToClosedFactory: CloserFactory(ConfigureCloser{
+						SleepWindow:       time.Second,
+						SleepWindowJitter: time.Second,
+					}),
+				},
+			})
+			closer := c.OpenToClose.(*Closer)
+			now := time.Now()
+			closer.Opened(now)
+			ret = append(ret, closer.reopenCircuitCheck.Remaining(now))
+		}
+		return ret
+	}
+	first := sleepWindows(7)
+	second := sleepWindows(7)
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("expected the same seed to give the same jitter: %v != %v", first, second)
+		}
+	}
+}
+
+func TestCloseCircuitClearsErrorHistory(t *testing.T) {
+	c := circuit.NewCircuitFromConfig("TestCloseCircuitClearsErrorHistory", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 3,
+			}),
+		},
+	})
+	for i := 0; i < 3; i++ {
+		_ = c.Execute(context.Background(), testhelp.AlwaysFails, nil)
+	}
+	if !c.IsOpen() {
+		t.Fatal("circuit should open after failing")
+	}
+	c.CloseCircuit()
+	// One failure after a manual close should not reopen on the old errors
+	_ = c.Execute(context.Background(), testhelp.AlwaysFails, nil)
+	if c.IsOpen() {
+		t.Fatal("closing a circuit should clear the errors that opened it")
+	}
+}
+
+func TestCircuitErrorPercentage(t *testing.T) {
+	c := circuit.NewCircuitFromConfig("TestCircuitErrorPercentage", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RollingDuration: time.Minute,
+			}),
+		},
+	})
+	if c.ErrorPercentage() != 0 {
+		t.Error("expected no error percentage without traffic", c.ErrorPercentage())
+	}
+	for i := 0; i < 4; i++ {
+		testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	}
+	for i := 0; i < 2; i++ {
+		testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	}
+	testhelp.MustNotTesting(t, c.Execute(circuit.WithTimeout(context.Background(), time.Millisecond), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil))
+	for i := 0; i < 3; i++ {
+		testhelp.MustNotTesting(t, c.Execute(context.Background(), func(_ context.Context) error {
+			return circuit.SimpleBadRequest{Err: errors.New("bad request")}
+		}, nil))
+	}
+	// 2 failures and a timeout out of 7 legitimate attempts.  Bad requests do not count
+	if pct := c.ErrorPercentage(); pct != 3.0/7 {
+		t.Error("unexpected error percentage", pct)
+	}
+	if c.HealthSnapshot().RequestVolume != 7 {
+		t.Error("expected the opener's request volume", c.HealthSnapshot().RequestVolume)
+	}
+}
+
+func TestManualClose(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestManualClose", circuit.Config{
+		General: circuit.GeneralConfig{
+			ManualClose: true,
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow: time.Second,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the circuit to open")
+	}
+	for i := 0; i < 10; i++ {
+		clk.Add(time.Second * 5)
+		if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err == nil {
+			t.Fatal("expected no half open attempts while waiting for a manual close")
+		}
+	}
+	if !c.IsOpen() {
+		t.Fatal("expected the circuit to stay open well past the sleep window")
+	}
+	c.CloseCircuit()
+	if c.IsOpen() {
+		t.Fatal("expected CloseCircuit to close the circuit")
+	}
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+}
+
+func TestMinimumOpenDuration(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestMinimumOpenDuration", circuit.Config{
+		General: circuit.GeneralConfig{
+			MinimumOpenDuration: time.Second * 10,
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow: time.Second,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the circuit to open")
+	}
+	clk.Add(time.Second * 2)
+	// The sleep window allows a half open attempt, but it cannot close the circuit yet
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected a successful probe to leave the circuit open before MinimumOpenDuration")
+	}
+	clk.Add(time.Second * 8)
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	if c.IsOpen() {
+		t.Fatal("expected a successful probe to close the circuit after MinimumOpenDuration")
+	}
+}
+
+func TestIgnoreTimeouts(t *testing.T) {
+	for _, ignoreTimeouts := range []bool{false, true} {
+		c := circuit.NewCircuitFromConfig("TestIgnoreTimeouts", circuit.Config{
+			General: circuit.GeneralConfig{
+				ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+					RequestVolumeThreshold: 3,
+					IgnoreTimeouts:         ignoreTimeouts,
+				}),
+			},
+		})
+		for i := 0; i < 5; i++ {
+			testhelp.MustNotTesting(t, c.Execute(circuit.WithTimeout(context.Background(), time.Millisecond), func(ctx context.Context) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}, nil))
+		}
+		if c.IsOpen() == ignoreTimeouts {
+			t.Errorf("IgnoreTimeouts=%t: expected the circuit to be open=%t", ignoreTimeouts, !ignoreTimeouts)
+		}
+		if ignoreTimeouts {
+			// Failures still count
+			for i := 0; i < 3; i++ {
+				testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+			}
+			if !c.IsOpen() {
+				t.Error("expected failures to open the circuit, even when ignoring timeouts")
+			}
+		}
+	}
+}
+
+func TestConfigureOpener_RollingWindow(t *testing.T) {
+	cfg := ConfigureOpener{
+		RollingDuration: time.Second,
+		NumBuckets:      7,
+	}
+	if cfg.BucketWidth() != time.Nanosecond*142857143 || cfg.RollingWindow() != time.Nanosecond*1000000001 {
+		t.Error("expected the width rounded to the nearest nanosecond", cfg.BucketWidth(), cfg.RollingWindow())
+	}
+	cfg = ConfigureOpener{
+		RollingDuration: time.Second * 30,
+		NumBuckets:      30,
+	}
+	if cfg.BucketWidth() != time.Second || cfg.RollingWindow() != time.Second*30 {
+		t.Error("expected an even split", cfg.BucketWidth(), cfg.RollingWindow())
+	}
+}
+
+func TestManager_ReconfigureAll(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	f := Factory{
+		ConfigureOpener: ConfigureOpener{
+			RequestVolumeThreshold: 10,
+			Now:                    clk.Now,
+		},
+		ConfigureCloser: ConfigureCloser{
+			SleepWindow: time.Hour,
+		},
+	}
+	m := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{f.Configure},
+	}
+	base := circuit.Config{
+		General: circuit.GeneralConfig{
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	}
+	c := m.MustCreateCircuit("TestManager_ReconfigureAll", base)
+	for i := 0; i < 4; i++ {
+		testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	}
+	if c.IsOpen() {
+		t.Fatal("expected the circuit to stay closed below the request volume threshold")
+	}
+
+	f.ConfigureOpener.RequestVolumeThreshold = 5
+	f.ConfigureCloser.SleepWindow = time.Second
+	update := base
+	update.Execution.MaxConcurrentRequests = 3
+	m.ReconfigureAll(map[string]circuit.Config{
+		"TestManager_ReconfigureAll": update,
+	})
+	if c.ConcurrencyLimit() != 3 {
+		t.Error("expected the new concurrency limit", c.ConcurrencyLimit())
+	}
+	if c.Config().Execution.Timeout != time.Second {
+		t.Error("expected unset fields to get their defaults", c.Config().Execution.Timeout)
+	}
+	// The 4 earlier failures are kept, so one more reaches the new threshold
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the new request volume threshold to open the circuit")
+	}
+	clk.Add(time.Second)
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+		t.Fatal("expected the new sleep window to allow a half open attempt", err)
+	}
+}
+
+func TestSetExternalHealth(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestSetExternalHealth", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow: time.Second,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	ctx := context.Background()
+	c.SetExternalHealth(false)
+	if !c.IsOpen() || !c.IsExternallyUnhealthy() {
+		t.Fatal("expected an unhealthy signal to open the circuit")
+	}
+	for i := 0; i < 3; i++ {
+		clk.Add(time.Second * 2)
+		if err := c.Execute(ctx, testhelp.AlwaysPasses, nil); err == nil {
+			t.Fatal("expected no half open attempts while unhealthy")
+		}
+	}
+
+	c.SetExternalHealth(true)
+	if !c.IsOpen() {
+		t.Fatal("expected a healthy signal to leave closing to the closer")
+	}
+	for i := 0; i < 2; i++ {
+		clk.Add(time.Second)
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	if c.IsOpen() {
+		t.Fatal("expected normal probing to close the circuit once healthy")
+	}
+
+	// Errors still open a healthy circuit
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() || c.IsExternallyUnhealthy() {
+		t.Fatal("expected failures to open the circuit")
+	}
+}
+
+func TestSetErrorThresholdPercentage(t *testing.T) {
+	c := circuit.NewCircuitFromConfig("TestSetErrorThresholdPercentage", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				ErrorThresholdPercentage: 50,
+				RequestVolumeThreshold:   4,
+			}),
+		},
+	})
+	for _, invalid := range []int64{-1, 0, 101} {
+		if err := c.SetErrorThresholdPercentage(invalid); err == nil {
+			t.Errorf("expected %d to be rejected", invalid)
+		}
+	}
+	testhelp.MustTesting(t, c.SetErrorThresholdPercentage(80))
+	if pct := c.ClosedToOpen.(*Opener).Config().ErrorThresholdPercentage; pct != 80 {
+		t.Error("expected the config to show the new threshold", pct)
+	}
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	for i := 0; i < 3; i++ {
+		testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	}
+	if c.IsOpen() {
+		t.Fatal("expected a 60% error rate to stay under the raised threshold")
+	}
+	testhelp.MustTesting(t, c.SetErrorThresholdPercentage(50))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the lowered threshold to open the circuit on the same counts")
+	}
+
+	plain := circuit.NewCircuitFromConfig("TestSetErrorThresholdPercentage_Plain", circuit.Config{})
+	if err := plain.SetErrorThresholdPercentage(50); err == nil {
+		t.Error("expected an error without an ErrorThresholdSetter")
+	}
+}
+
+// labelCounter counts outcomes by the label set with circuit.SetOutcomeLabel
+type labelCounter struct {
+	circuit.RunMetricsCollection
+	mu     sync.Mutex
+	counts map[string]map[circuit.Outcome]int
+}
+
+func (l *labelCounter) LabeledOutcome(_ time.Time, label string, outcome circuit.Outcome, _ time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[label] == nil {
+		l.counts[label] = make(map[circuit.Outcome]int)
+	}
+	l.counts[label][outcome]++
+}
+
+func TestSetOutcomeLabel(t *testing.T) {
+	counter := &labelCounter{counts: make(map[string]map[circuit.Outcome]int)}
+	c := circuit.NewCircuitFromConfig("TestSetOutcomeLabel", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				ErrorThresholdPercentage: 50,
+				RequestVolumeThreshold:   6,
+			}),
+		},
+		Metrics: circuit.MetricsCollectors{
+			Run: []circuit.RunMetrics{counter},
+		},
+	})
+	ctx := context.Background()
+	labeled := func(label string, err error) func(context.Context) error {
+		return func(ctx context.Context) error {
+			circuit.SetOutcomeLabel(ctx, label)
+			return err
+		}
+	}
+	// Neither dependency has the volume to open a circuit of its own
+	for i := 0; i < 2; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, labeled("db", nil), nil))
+		testhelp.MustNotTesting(t, c.Execute(ctx, labeled("cache", errors.New("cache down")), nil))
+	}
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if c.IsOpen() {
+		t.Fatal("expected the circuit to stay closed under its request volume")
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, labeled("db", errors.New("db down")), nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the labeled outcomes to open the circuit together")
+	}
+
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	if n := counter.counts["db"][circuit.OutcomeSuccess]; n != 2 {
+		t.Error("expected 2 db successes", n)
+	}
+	if n := counter.counts["db"][circuit.OutcomeFailure]; n != 1 {
+		t.Error("expected 1 db failure", n)
+	}
+	if n := counter.counts["cache"][circuit.OutcomeFailure]; n != 2 {
+		t.Error("expected 2 cache failures", n)
+	}
+	if len(counter.counts) != 2 {
+		t.Error("expected unlabeled runs to not be reported", counter.counts)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if errs := (ConfigureOpener{}).Validate(); len(errs) != 0 {
+		t.Error("expected the zero opener config to be valid", errs)
+	}
+	if errs := (ConfigureCloser{}).Validate(); len(errs) != 0 {
+		t.Error("expected the zero closer config to be valid", errs)
+	}
+	openerErrs := ConfigureOpener{
+		ErrorThresholdPercentage: 101,
+		RequestVolumeThreshold:   -1,
+		NumBuckets:               -1,
+	}.Validate()
+	if len(openerErrs) != 3 {
+		t.Error("expected every opener problem", openerErrs)
+	}
+	if errs := (ConfigureOpener{RollingDuration: 5, NumBuckets: 10}).Validate(); len(errs) != 1 {
+		t.Error("expected buckets narrower than a nanosecond to be a problem", errs)
+	}
+	closerErrs := ConfigureCloser{
+		SleepWindow:               -time.Second,
+		HalfOpenSuccessPercentage: -1,
+		ProbeBackoffFactor:        -2,
+	}.Validate()
+	if len(closerErrs) != 3 {
+		t.Error("expected every closer problem", closerErrs)
+	}
+
+	h := circuit.Manager{ValidateConfigs: true}
+	_, err := h.CreateCircuit("TestValidate", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{ErrorThresholdPercentage: 200}),
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{SleepWindow: -time.Second}),
+		},
+	})
+	if configErr, ok := err.(*circuit.ConfigErrors); !ok || len(configErr.Errors) != 2 {
+		t.Error("expected the manager to validate the open and close logic", err)
+	}
+}
+
+func TestWithoutProbe(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestWithoutProbe", circuit.Config{
+		General: circuit.GeneralConfig{
+			OpenToClosedFactory: CloserFactory(ConfigureCloser{
+				SleepWindow:      time.Second,
+				HalfOpenAttempts: 1,
+			}),
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				RequestVolumeThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	userFacing := circuit.WithoutProbe(context.Background())
+	background := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(background, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("expected the circuit to open")
+	}
+	clk.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		if err := c.Execute(userFacing, testhelp.AlwaysPasses, nil); err == nil {
+			t.Fatal("expected an opted out call to never be the half open attempt")
+		}
+	}
+	if c.ProbeStatus().ProbedSinceOpen {
+		t.Error("expected opted out calls to not use up the half open attempt")
+	}
+	testhelp.MustTesting(t, c.Execute(background, testhelp.AlwaysPasses, nil))
+	if !c.ProbeStatus().ProbedSinceOpen {
+		t.Error("expected a call without WithoutProbe to be the half open attempt")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	f := Factory{
+		ConfigureOpener: ConfigureOpener{
+			ErrorThresholdPercentage: 50,
+			RequestVolumeThreshold:   10,
+		},
+		ConfigureCloser: ConfigureCloser{
+			SleepWindow: time.Second,
+		},
+	}
+	h := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{f.Configure},
+	}
+	c := h.MustCreateCircuit("TestSettings", circuit.Config{
+		Execution: circuit.ExecutionConfig{
+			Timeout: time.Second,
+		},
+	})
+	h.MustCreateCircuit("TestSettings_Other")
+	// Runtime overrides must show up, not the configured values
+	c.SetConcurrencyLimit(3)
+	testhelp.MustTesting(t, c.SetErrorThresholdPercentage(75))
+	cfg := c.OpenToClose.(*Closer).Config()
+	cfg.SleepWindow = 5 * time.Second
+	c.OpenToClose.(*Closer).SetConfigThreadSafe(cfg)
+	c.SetConfigThreadSafe(circuit.Config{
+		Execution: circuit.ExecutionConfig{
+			Timeout:               2 * time.Second,
+			MaxConcurrentRequests: 3,
+		},
+	})
+
+	s := c.Settings()
+	expected := circuit.Settings{
+		Name:                     "TestSettings",
+		Timeout:                  2 * time.Second,
+		MaxConcurrentRequests:    3,
+		ErrorThresholdPercentage: 75,
+		RequestVolumeThreshold:   10,
+		SleepWindow:              5 * time.Second,
+	}
+	if s != expected {
+		t.Errorf("unexpected settings\n%+v\nwant\n%+v", s, expected)
+	}
+	all := h.Settings()
+	if len(all) != 2 || all[0] != s || all[1].Name != "TestSettings_Other" {
+		t.Errorf("expected the manager to list every circuit's settings by name: %+v", all)
+	}
+}
+
+func TestWithoutMetrics(t *testing.T) {
+	c := circuit.NewCircuitFromConfig("TestWithoutMetrics", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+				ErrorThresholdPercentage: 50,
+				RequestVolumeThreshold:   2,
+			}),
+		},
+	})
+	opener := c.ClosedToOpen.(*Opener)
+	ctx := context.Background()
+	probe := circuit.WithoutMetrics(ctx)
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	for i := 0; i < 5; i++ {
+		testhelp.MustNotTesting(t, c.Execute(probe, testhelp.AlwaysFails, nil))
+	}
+	now := time.Now()
+	if opener.LegitimateAttemptsAt(now) != 1 || opener.ErrorPercentageAt(now) != 0 {
+		t.Errorf("expected failed probes to not move the error rate: %d attempts, %f%% errors",
+			opener.LegitimateAttemptsAt(now), opener.ErrorPercentageAt(now))
+	}
+	if c.IsOpen() {
+		t.Fatal("failed probes should not open the circuit")
+	}
+	// Probes still go through the concurrency limit and the open state
+	testhelp.MustNotTesting(t, c.Execute(circuit.WithMaxConcurrentRequests(probe, 0), testhelp.AlwaysPasses, nil))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(probe, testhelp.AlwaysPasses, nil))
+}
+
+func TestSeverityWeights(t *testing.T) {
+	unavailable := errors.New("503 with Retry-After")
+	newCircuit := func() *circuit.Circuit {
+		return circuit.NewCircuitFromConfig("TestSeverityWeights", circuit.Config{
+			General: circuit.GeneralConfig{
+				ClosedToOpenFactory: OpenerFactory(ConfigureOpener{
+					ErrorThresholdPercentage: 50,
+					RequestVolumeThreshold:   10,
+					SeverityWeights:          map[string]int64{"unavailable": 10},
+				}),
+				SeverityChecker: circuit.SeverityCheckerFunc(func(err error) string {
+					if err == unavailable {
+						return "unavailable"
+					}
+					return ""
+				}),
+			},
+		})
+	}
+	ctx := context.Background()
+	for name, failure := range map[string]error{
+		"error":   circuit.NewSevereError(errors.New("500"), "unavailable"),
+		"checker": unavailable,
+	} {
+		c := newCircuit()
+		for i := 0; i < 3; i++ {
+			testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		}
+		testhelp.MustNotTesting(t, c.Execute(ctx, func(_ context.Context) error {
+			return failure
+		}, nil))
+		if !c.IsOpen() {
+			t.Errorf("%s: expected one high severity failure to open the circuit", name)
+		}
+	}
+
+	c := newCircuit()
+	for i := 0; i < 3; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, func(_ context.Context) error {
+		return circuit.NewSevereError(errors.New("500"), "unweighted")
+	}, nil))
+	if c.IsOpen() {
+		t.Error("ordinary failures should not open the circuit before the request volume threshold")
+	}
+	if attempts := c.ClosedToOpen.(*Opener).LegitimateAttemptsAt(time.Now()); attempts != 5 {
+		t.Error("expected failures without a weight to count once", attempts)
+	}
+}
+
+func TestOpener_SetTimeKeeperKeepsCounts(t *testing.T) {
+	opener := OpenerFactory(ConfigureOpener{})().(*Opener)
+	now := time.Now()
+	opener.Success(now, time.Millisecond)
+	opener.ErrFailure(now, time.Millisecond)
+	later := now.Add(time.Second)
+	opener.SetTimeKeeper(circuit.TimeKeeper{
+		Now: func() time.Time { return later },
+	})
+	if opener.LegitimateAttemptsAt(later) != 2 || opener.ErrorPercentageAt(later) != 0.5 {
+		t.Errorf("expected the counts to be kept: %d attempts, %f errors",
+			opener.LegitimateAttemptsAt(later), opener.ErrorPercentageAt(later))
+	}
+	if opener.Config().Now() != later {
+		t.Error("expected the opener to use the new clock")
+	}
+}
+
+func TestWarmupDuration_ManualOpen(t *testing.T) {
+	c := circuit.NewCircuitFromConfig("TestWarmupDuration_ManualOpen", circuit.Config{
+		General: circuit.GeneralConfig{
+			WarmupDuration: time.Hour,
+		},
+	})
+	c.OpenCircuit()
+	if !c.IsOpen() {
+		t.Fatal("OpenCircuit should open the circuit during warmup")
+	}
+	c.CloseCircuit()
+	c.SetExternalHealth(false)
+	if !c.IsOpen() {
+		t.Fatal("SetExternalHealth should open the circuit during warmup")
+	}
+}
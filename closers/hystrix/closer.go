@@ -1,6 +1,9 @@
 package hystrix
 
 import (
+	"fmt"
+	"math"
+	"math/rand"
 	"sync"
 	"time"
 
@@ -17,9 +20,30 @@ type Closer struct {
 
 	concurrentSuccessfulAttempts faststats.AtomicInt64
 	closeOnCurrentCount          faststats.AtomicInt64
+	// Used when RequiredConsecutiveSuccessesToClose is set.  isOpen is true between Opened and Closed
+	consecutiveSuccessesToClose faststats.AtomicInt64
+	isOpen                      faststats.AtomicBoolean
+	// failedProbes is how many probes failed since the circuit opened.  Used when ProbeBackoffFactor is set
+	failedProbes faststats.AtomicInt64
+
+	// Used when HalfOpenSuccessPercentage is set.  The probe values must be accessed with probeMu
+	halfOpenAttempts          faststats.AtomicInt64
+	halfOpenSuccessPercentage faststats.AtomicInt64
+	probeSuccesses            int64
+	probeFailures             int64
+	probesPassed              bool
+	probeMu                   sync.Mutex
+
+	// Used when MinProbesToClose is set.  probeCount counts probes since the circuit opened, and probeWindow counts
+	// them instead when ProbeWindow is set.  probeWindow must be accessed with mu
+	minProbesToClose faststats.AtomicInt64
+	probeCount       faststats.AtomicInt64
+	probeWindow      *faststats.RollingCounter
 
 	mu     sync.Mutex
 	config ConfigureCloser
+	// circuitRand is the circuit's RandInt63n.  JitterSource takes precedence over it
+	circuitRand func(n int64) int64
 }
 
 // CloserFactory creates Closer closer
@@ -33,6 +57,11 @@ func CloserFactory(config ConfigureCloser) func() circuit.OpenToClosed {
 }
 
 var _ circuit.OpenToClosed = &Closer{}
+var _ circuit.TimeKeeperSetter = &Closer{}
+var _ circuit.RandSetter = &Closer{}
+var _ circuit.Reconfigurable = &Closer{}
+var _ circuit.Validator = &Closer{}
+var _ circuit.SettingsReporter = &Closer{}
 
 // ConfigureCloser configures values for Closer
 type ConfigureCloser struct {
@@ -42,8 +71,41 @@ type ConfigureCloser struct {
 	HalfOpenAttempts int64
 	// RequiredConcurrentSuccessful is how may consecutive passing requests are required before the circuit is closed
 	RequiredConcurrentSuccessful int64
+	// HalfOpenSuccessPercentage, if set, replaces RequiredConcurrentSuccessful.  The circuit waits for HalfOpenAttempts
+	// probes to finish, then closes if at least this percent (0 - 100) of them succeeded.  Otherwise, it tries another
+	// round of probes.
+	HalfOpenSuccessPercentage int64
+	// RequiredConsecutiveSuccessesToClose, if set, replaces RequiredConcurrentSuccessful.  The circuit stays half open
+	// until this many probes in a row succeed.  Each successful probe lets the next one through right away, without
+	// waiting for another SleepWindow, and a failed probe starts a new SleepWindow and restarts the count.
+	// HalfOpenSuccessPercentage takes precedence over it.
+	RequiredConsecutiveSuccessesToClose int64
+	// ProbeBackoffFactor, if more than 1, multiplies the SleepWindow by itself after each failed probe, so a slowly
+	// recovering dependency is probed less and less often.  The wait after a failed probe starts when it fails.  The
+	// SleepWindow goes back to normal when the circuit closes.
+	ProbeBackoffFactor float64
+	// ProbeMaxSleepWindow caps how long ProbeBackoffFactor can grow the SleepWindow, before any jitter.  Without it,
+	// the SleepWindow keeps growing.
+	ProbeMaxSleepWindow time.Duration
+	// SleepWindowJitter, if set, adds a random duration in [0, SleepWindowJitter] to the SleepWindow each time the
+	// circuit opens.  This keeps many instances from probing a recovering dependency at the same moment.
+	SleepWindowJitter time.Duration
+	// JitterSource returns a random number in [0, n).  It defaults to the circuit's GeneralConfig.RandInt63n, or
+	// rand.Int63n.  It may be shared by many circuits, so it must be thread safe.  You only want to modify this for
+	// testing.
+	JitterSource func(n int64) int64 `json:"-"`
+	// MinProbesToClose, if set, keeps the circuit half open until at least this many probes have finished, like
+	// the opener's RequestVolumeThreshold.  Until then ShouldClose is false, however the probes went.  Successes,
+	// failures, and timeouts count as probes.
+	MinProbesToClose int64
+	// ProbeWindow, if set, only counts probes toward MinProbesToClose if they finished within this long.  Without it,
+	// every probe since the circuit opened counts.
+	ProbeWindow time.Duration
 }
 
+// probeWindowBuckets is how many buckets the ProbeWindow is split into
+const probeWindowBuckets = 10
+
 // Merge this configuration with another
 func (c *ConfigureCloser) Merge(other ConfigureCloser) {
 	if c.SleepWindow == 0 {
@@ -55,6 +117,53 @@ func (c *ConfigureCloser) Merge(other ConfigureCloser) {
 	if c.RequiredConcurrentSuccessful == 0 {
 		c.RequiredConcurrentSuccessful = other.RequiredConcurrentSuccessful
 	}
+	if c.HalfOpenSuccessPercentage == 0 {
+		c.HalfOpenSuccessPercentage = other.HalfOpenSuccessPercentage
+	}
+	if c.RequiredConsecutiveSuccessesToClose == 0 {
+		c.RequiredConsecutiveSuccessesToClose = other.RequiredConsecutiveSuccessesToClose
+	}
+	if c.ProbeBackoffFactor == 0 {
+		c.ProbeBackoffFactor = other.ProbeBackoffFactor
+	}
+	if c.ProbeMaxSleepWindow == 0 {
+		c.ProbeMaxSleepWindow = other.ProbeMaxSleepWindow
+	}
+	if c.SleepWindowJitter == 0 {
+		c.SleepWindowJitter = other.SleepWindowJitter
+	}
+	if c.JitterSource == nil {
+		c.JitterSource = other.JitterSource
+	}
+	if c.MinProbesToClose == 0 {
+		c.MinProbesToClose = other.MinProbesToClose
+	}
+	if c.ProbeWindow == 0 {
+		c.ProbeWindow = other.ProbeWindow
+	}
+}
+
+// Validate returns every problem with the configuration.  Zero values are fine: they are filled by defaults.
+func (c ConfigureCloser) Validate() []error {
+	var errs []error
+	notNegative := func(field string, v interface{}, negative bool) {
+		if negative {
+			errs = append(errs, fmt.Errorf("%s must not be negative: %v", field, v))
+		}
+	}
+	notNegative("SleepWindow", c.SleepWindow, c.SleepWindow < 0)
+	notNegative("HalfOpenAttempts", c.HalfOpenAttempts, c.HalfOpenAttempts < 0)
+	notNegative("RequiredConcurrentSuccessful", c.RequiredConcurrentSuccessful, c.RequiredConcurrentSuccessful < 0)
+	notNegative("RequiredConsecutiveSuccessesToClose", c.RequiredConsecutiveSuccessesToClose, c.RequiredConsecutiveSuccessesToClose < 0)
+	notNegative("ProbeBackoffFactor", c.ProbeBackoffFactor, c.ProbeBackoffFactor < 0)
+	notNegative("ProbeMaxSleepWindow", c.ProbeMaxSleepWindow, c.ProbeMaxSleepWindow < 0)
+	notNegative("SleepWindowJitter", c.SleepWindowJitter, c.SleepWindowJitter < 0)
+	notNegative("MinProbesToClose", c.MinProbesToClose, c.MinProbesToClose < 0)
+	notNegative("ProbeWindow", c.ProbeWindow, c.ProbeWindow < 0)
+	if c.HalfOpenSuccessPercentage < 0 || c.HalfOpenSuccessPercentage > 100 {
+		errs = append(errs, fmt.Errorf("HalfOpenSuccessPercentage must be between 0 and 100: %d", c.HalfOpenSuccessPercentage))
+	}
+	return errs
 }
 
 var defaultConfigureCloser = ConfigureCloser{
@@ -75,13 +184,55 @@ var _ json.Marshaler = &Closer{}
 
 // Opened circuit. It should now check to see if it should ever allow various requests in an attempt to become closed
 func (s *Closer) Opened(now time.Time) {
+	s.isOpen.Set(true)
+	s.failedProbes.Set(0)
 	s.concurrentSuccessfulAttempts.Set(0)
+	s.resetProbes()
+	s.resetProbeCount(now)
+	s.reopenCircuitCheck.SetSleepDuration(s.sleepWindow())
 	s.reopenCircuitCheck.SleepStart(now)
 }
 
+// sleepWindow is the configured SleepWindow, grown by any failed probes, plus any jitter
+func (s *Closer) sleepWindow() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sleepWindow := s.config.SleepWindow
+	if s.config.ProbeBackoffFactor > 1 {
+		sleepWindow = backoffSleepWindow(sleepWindow, s.config.ProbeBackoffFactor, s.failedProbes.Get(), s.config.ProbeMaxSleepWindow)
+	}
+	if s.config.SleepWindowJitter <= 0 {
+		return sleepWindow
+	}
+	jitterSource := s.config.JitterSource
+	if jitterSource == nil {
+		jitterSource = s.circuitRand
+	}
+	if jitterSource == nil {
+		jitterSource = rand.Int63n
+	}
+	return sleepWindow + time.Duration(jitterSource(s.config.SleepWindowJitter.Nanoseconds()+1))
+}
+
+// backoffSleepWindow is sleepWindow * factor^failedProbes, capped at maxSleepWindow if it is set
+func backoffSleepWindow(sleepWindow time.Duration, factor float64, failedProbes int64, maxSleepWindow time.Duration) time.Duration {
+	grown := float64(sleepWindow) * math.Pow(factor, float64(failedProbes))
+	if maxSleepWindow > 0 && grown > float64(maxSleepWindow) {
+		return maxSleepWindow
+	}
+	if grown >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return time.Duration(grown)
+}
+
 // Closed circuit.  It can turn off now.
 func (s *Closer) Closed(now time.Time) {
+	s.isOpen.Set(false)
+	s.failedProbes.Set(0)
 	s.concurrentSuccessfulAttempts.Set(0)
+	s.resetProbes()
+	s.resetProbeCount(now)
 	s.reopenCircuitCheck.SleepStart(now)
 }
 
@@ -96,6 +247,12 @@ func (s *Closer) Allow(now time.Time) (shouldAllow bool) {
 // Success any time runFunc was called and appeared healthy
 func (s *Closer) Success(now time.Time, duration time.Duration) {
 	s.concurrentSuccessfulAttempts.Add(1)
+	s.recordProbe(true)
+	s.countProbe(now)
+	if s.requiresConsecutiveProbes() {
+		// Stay half open: the next probe does not wait for another sleep window
+		s.reopenCircuitCheck.ClearTimer(now)
+	}
 }
 
 // ErrBadRequest is ignored
@@ -117,18 +274,154 @@ func (s *Closer) ErrShortCircuit(now time.Time) {
 // ErrFailure resets the consecutive Successful count
 func (s *Closer) ErrFailure(now time.Time, duration time.Duration) {
 	s.concurrentSuccessfulAttempts.Set(0)
+	s.recordProbe(false)
+	s.countProbe(now)
+	s.failedProbe(now)
 }
 
 // ErrTimeout resets the consecutive Successful count
 func (s *Closer) ErrTimeout(now time.Time, duration time.Duration) {
 	s.concurrentSuccessfulAttempts.Set(0)
+	s.recordProbe(false)
+	s.countProbe(now)
+	s.failedProbe(now)
+}
+
+// requiresConsecutiveProbes is true if the circuit is open and closes on RequiredConsecutiveSuccessesToClose
+func (s *Closer) requiresConsecutiveProbes() bool {
+	return s.isOpen.Get() && s.halfOpenSuccessPercentage.Get() <= 0 && s.consecutiveSuccessesToClose.Get() > 0
+}
+
+// backsOffProbes is true if ProbeBackoffFactor is set
+func (s *Closer) backsOffProbes() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.config.ProbeBackoffFactor > 1
 }
 
-// ShouldClose is true if we hav enough successful attempts in a row.
+// failedProbe starts a new sleep window, grown by ProbeBackoffFactor, when that or RequiredConsecutiveSuccessesToClose
+// is set.  Any count of consecutive successes was already restarted.
+func (s *Closer) failedProbe(now time.Time) {
+	if !s.isOpen.Get() {
+		return
+	}
+	backsOff := s.backsOffProbes()
+	if backsOff {
+		s.failedProbes.Add(1)
+		s.reopenCircuitCheck.SetSleepDuration(s.sleepWindow())
+	}
+	if backsOff || s.requiresConsecutiveProbes() {
+		s.reopenCircuitCheck.SleepStart(now)
+	}
+}
+
+func (s *Closer) resetProbes() {
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+	s.probeSuccesses = 0
+	s.probeFailures = 0
+	s.probesPassed = false
+}
+
+// recordProbe tracks the result of a half open attempt.  Once HalfOpenAttempts results come in, it decides if enough
+// of them passed to close the circuit, and starts a new round.
+func (s *Closer) recordProbe(success bool) {
+	successPercentage := s.halfOpenSuccessPercentage.Get()
+	if successPercentage <= 0 {
+		return
+	}
+	s.probeMu.Lock()
+	defer s.probeMu.Unlock()
+	if success {
+		s.probeSuccesses++
+	} else {
+		s.probeFailures++
+	}
+	total := s.probeSuccesses + s.probeFailures
+	if total < s.halfOpenAttempts.Get() {
+		return
+	}
+	s.probesPassed = s.probeSuccesses*100 >= successPercentage*total
+	s.probeSuccesses = 0
+	s.probeFailures = 0
+}
+
+// countProbe counts a finished probe toward MinProbesToClose
+func (s *Closer) countProbe(now time.Time) {
+	if !s.isOpen.Get() || s.minProbesToClose.Get() <= 0 {
+		return
+	}
+	s.probeCount.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probeWindow != nil {
+		s.probeWindow.Inc(now)
+	}
+}
+
+func (s *Closer) resetProbeCount(now time.Time) {
+	s.probeCount.Set(0)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probeWindow != nil {
+		s.probeWindow.Reset(now)
+	}
+}
+
+// enoughProbes is true if MinProbesToClose is not set, or at least that many probes count
+func (s *Closer) enoughProbes(now time.Time) bool {
+	required := s.minProbesToClose.Get()
+	if required <= 0 {
+		return true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.probeWindow != nil {
+		return s.probeWindow.RollingSumAt(now) >= required
+	}
+	return s.probeCount.Get() >= required
+}
+
+// newProbeWindow returns the counter for ProbeWindow, or nil if it is not set.  It must be called with mu.
+func (s *Closer) newProbeWindow(window time.Duration) *faststats.RollingCounter {
+	if window <= 0 {
+		return nil
+	}
+	now := time.Now
+	if s.reopenCircuitCheck.Now != nil {
+		now = s.reopenCircuitCheck.Now
+	}
+	counter := faststats.NewRollingCounter(faststats.BucketWidth(window, probeWindowBuckets), probeWindowBuckets, now())
+	return &counter
+}
+
+// ShouldClose is true if we hav enough successful attempts in a row.  With MinProbesToClose, it is false until
+// enough probes have finished.
 func (s *Closer) ShouldClose(now time.Time) bool {
+	if !s.enoughProbes(now) {
+		return false
+	}
+	if s.halfOpenSuccessPercentage.Get() > 0 {
+		s.probeMu.Lock()
+		defer s.probeMu.Unlock()
+		return s.probesPassed
+	}
+	if required := s.consecutiveSuccessesToClose.Get(); required > 0 {
+		return s.concurrentSuccessfulAttempts.Get() >= required
+	}
 	return s.concurrentSuccessfulAttempts.Get() > s.closeOnCurrentCount.Get()
 }
 
+// ReportSettings fills in SleepWindow
+func (s *Closer) ReportSettings(settings *circuit.Settings) {
+	settings.SleepWindow = s.Config().SleepWindow
+}
+
+// Validate checks the current configuration
+func (s *Closer) Validate() []error {
+	return s.Config().Validate()
+}
+
 // Config returns the current configuration.  Use SetConfigThreadSafe to modify the current configuration.
 func (s *Closer) Config() ConfigureCloser {
 	s.mu.Lock()
@@ -136,14 +429,56 @@ func (s *Closer) Config() ConfigureCloser {
 	return s.config
 }
 
-// SetConfigThreadSafe resets the sleep duration during reopen attempts
+// SetConfigThreadSafe resets the sleep duration during reopen attempts.  Shortening SleepWindow also shortens a sleep
+// that is already in progress.
 func (s *Closer) SetConfigThreadSafe(config ConfigureCloser) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if config.SleepWindow < s.config.SleepWindow {
+		s.reopenCircuitCheck.RescheduleSleepDuration(config.SleepWindow)
+	} else {
+		s.reopenCircuitCheck.SetSleepDuration(config.SleepWindow)
+	}
+	if config.ProbeWindow != s.config.ProbeWindow {
+		s.probeWindow = s.newProbeWindow(config.ProbeWindow)
+	}
 	s.config = config
-	s.reopenCircuitCheck.SetSleepDuration(config.SleepWindow)
+	s.minProbesToClose.Set(config.MinProbesToClose)
 	s.reopenCircuitCheck.SetEventCountToAllow(config.HalfOpenAttempts)
 	s.closeOnCurrentCount.Set(config.RequiredConcurrentSuccessful)
+	s.halfOpenAttempts.Set(config.HalfOpenAttempts)
+	s.halfOpenSuccessPercentage.Set(config.HalfOpenSuccessPercentage)
+	s.consecutiveSuccessesToClose.Set(config.RequiredConsecutiveSuccessesToClose)
+}
+
+// ReconfigureFrom copies the configuration of another Closer, usually a new one from an updated factory.  Like
+// SetConfigThreadSafe, a shorter SleepWindow also shortens a sleep that is already in progress.
+func (s *Closer) ReconfigureFrom(fresh interface{}) {
+	if f, ok := fresh.(*Closer); ok && f != s {
+		s.SetConfigThreadSafe(f.Config())
+	}
+}
+
+// SetTimeKeeper makes the sleep window and probe window use the circuit's clock.  It is not safe to call while the circuit is active.
+func (s *Closer) SetTimeKeeper(t circuit.TimeKeeper) {
+	if t.Now != nil {
+		s.reopenCircuitCheck.Now = t.Now
+	}
+	if t.AfterFunc != nil {
+		s.reopenCircuitCheck.TimeAfterFunc = t.AfterFunc
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Start the probe window at the circuit's time
+	s.probeWindow = s.newProbeWindow(s.config.ProbeWindow)
+}
+
+// SetRand makes sleep window jitter use the circuit's randomness, unless JitterSource is set.  It is not safe to call
+// while the circuit is active.
+func (s *Closer) SetRand(int63n func(n int64) int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.circuitRand = int63n
 }
 
 // SetConfigNotThreadSafe just calls SetConfigThreadSafe. It is not safe to call while the circuit is active.
@@ -1,6 +1,7 @@
 package hystrix
 
 import (
+	"fmt"
 	"sync"
 	"time"
 
@@ -18,12 +19,20 @@ type Opener struct {
 
 	errorPercentage        faststats.AtomicInt64
 	requestVolumeThreshold faststats.AtomicInt64
+	ignoreTimeouts         faststats.AtomicBoolean
 
 	mu     sync.Mutex
 	config ConfigureOpener
 }
 
 var _ circuit.ClosedToOpen = &Opener{}
+var _ circuit.TimeKeeperSetter = &Opener{}
+var _ circuit.RunHealth = &Opener{}
+var _ circuit.Reconfigurable = &Opener{}
+var _ circuit.ErrorThresholdSetter = &Opener{}
+var _ circuit.Validator = &Opener{}
+var _ circuit.SettingsReporter = &Opener{}
+var _ circuit.FailureSeverityMetrics = &Opener{}
 
 // OpenerFactory creates a err % opener
 func OpenerFactory(config ConfigureOpener) func() circuit.ClosedToOpen {
@@ -47,6 +56,15 @@ type ConfigureOpener struct {
 	RollingDuration time.Duration
 	// NumBuckets is https://github.com/Netflix/Hystrix/wiki/Configuration#metricsrollingstatsnumbuckets
 	NumBuckets int
+	// IgnoreTimeouts leaves timeouts out of the error rate, for dependencies where occasional timeouts are expected.
+	// Timeouts are still reported to the circuit's other metrics.  Failures always count.  Timeouts count as errors by
+	// default: it is "Ignore" so the zero struct can fill defaults.
+	IgnoreTimeouts bool
+	// SeverityWeights is how many failures a failure with each severity counts as, so severe errors open the circuit
+	// sooner.  For example, {"unavailable": 5} makes each failure with severity "unavailable" count as 5 errors and 5
+	// attempts toward ErrorThresholdPercentage and RequestVolumeThreshold.  Severities that are missing, and weights
+	// below 1, count as 1.  See circuit.FailureSeverity.
+	SeverityWeights map[string]int64
 }
 
 // Merge this configuration with another
@@ -66,6 +84,12 @@ func (c *ConfigureOpener) Merge(other ConfigureOpener) {
 	if c.NumBuckets == 0 {
 		c.NumBuckets = other.NumBuckets
 	}
+	if !c.IgnoreTimeouts {
+		c.IgnoreTimeouts = other.IgnoreTimeouts
+	}
+	if c.SeverityWeights == nil {
+		c.SeverityWeights = other.SeverityWeights
+	}
 }
 
 var defaultConfigureOpener = ConfigureOpener{
@@ -76,6 +100,44 @@ var defaultConfigureOpener = ConfigureOpener{
 	RollingDuration: 10 * time.Second,
 }
 
+// Validate returns every problem with the configuration.  Zero values are fine: they are filled by defaults.
+func (c ConfigureOpener) Validate() []error {
+	var errs []error
+	if c.ErrorThresholdPercentage < 0 || c.ErrorThresholdPercentage > 100 {
+		errs = append(errs, fmt.Errorf("ErrorThresholdPercentage must be between 0 and 100: %d", c.ErrorThresholdPercentage))
+	}
+	if c.RequestVolumeThreshold < 0 {
+		errs = append(errs, fmt.Errorf("RequestVolumeThreshold must not be negative: %d", c.RequestVolumeThreshold))
+	}
+	if c.RollingDuration < 0 {
+		errs = append(errs, fmt.Errorf("RollingDuration must not be negative: %s", c.RollingDuration))
+	}
+	if c.NumBuckets < 0 {
+		errs = append(errs, fmt.Errorf("NumBuckets must not be negative: %d", c.NumBuckets))
+	}
+	for severity, weight := range c.SeverityWeights {
+		if weight < 1 {
+			errs = append(errs, fmt.Errorf("SeverityWeights for %q must be at least 1: %d", severity, weight))
+		}
+	}
+	if c.RollingDuration > 0 && c.NumBuckets > 0 && c.RollingDuration < time.Duration(c.NumBuckets) {
+		errs = append(errs, fmt.Errorf("RollingDuration %s is too short for %d buckets", c.RollingDuration, c.NumBuckets))
+	}
+	return errs
+}
+
+// BucketWidth is how wide each of the NumBuckets buckets is.  See faststats.BucketWidth for how RollingDuration is
+// rounded when it does not divide evenly.
+func (c *ConfigureOpener) BucketWidth() time.Duration {
+	return faststats.BucketWidth(c.RollingDuration, c.NumBuckets)
+}
+
+// RollingWindow is how much time the buckets really cover: NumBuckets * BucketWidth.  It only differs from
+// RollingDuration if RollingDuration does not divide evenly into NumBuckets.
+func (c *ConfigureOpener) RollingWindow() time.Duration {
+	return c.BucketWidth() * time.Duration(c.NumBuckets)
+}
+
 // MarshalJSON returns opener information in a JSON format
 func (e *Opener) MarshalJSON() ([]byte, error) {
 	cfg := e.Config()
@@ -123,8 +185,24 @@ func (e *Opener) ErrFailure(now time.Time, duration time.Duration) {
 	e.errorsCount.Inc(now)
 }
 
-// ErrTimeout increases error count for the circuit
+// ErrFailureSeverity adds the rest of a severe failure's weight to the error and attempt counts.  ErrFailure already
+// counted it once.
+func (e *Opener) ErrFailureSeverity(now time.Time, severity string, duration time.Duration) {
+	e.mu.Lock()
+	weight := e.config.SeverityWeights[severity]
+	e.mu.Unlock()
+	if weight <= 1 {
+		return
+	}
+	e.legitimateAttemptsCount.Add(now, weight-1)
+	e.errorsCount.Add(now, weight-1)
+}
+
+// ErrTimeout increases error count for the circuit, unless IgnoreTimeouts is set
 func (e *Opener) ErrTimeout(now time.Time, duration time.Duration) {
+	if e.ignoreTimeouts.Get() {
+		return
+	}
 	e.legitimateAttemptsCount.Inc(now)
 	e.errorsCount.Inc(now)
 }
@@ -157,24 +235,94 @@ func (e *Opener) errPercentage(now time.Time) float64 {
 	return float64(errCount) / float64(attemptCount)
 }
 
-// SetConfigThreadSafe modifies error % and request volume threshold
+// LegitimateAttemptsAt is how many successes, failures, and timeouts (unless IgnoreTimeouts) are in the rolling window
+func (e *Opener) LegitimateAttemptsAt(now time.Time) int64 {
+	return e.legitimateAttemptsCount.RollingSumAt(now)
+}
+
+// ErrorPercentageAt is the fraction, between 0 and 1, of legitimate attempts in the rolling window that failed or
+// timed out.  It is the rate ShouldOpen compares to ErrorThresholdPercentage, and is zero with no attempts.
+func (e *Opener) ErrorPercentageAt(now time.Time) float64 {
+	if pct := e.errPercentage(now); pct > 0 {
+		return pct
+	}
+	return 0
+}
+
+// SetConfigThreadSafe modifies error %, request volume threshold, IgnoreTimeouts, and SeverityWeights
 func (e *Opener) SetConfigThreadSafe(props ConfigureOpener) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 	e.config = props
 	e.errorPercentage.Set(props.ErrorThresholdPercentage)
 	e.requestVolumeThreshold.Set(props.RequestVolumeThreshold)
+	e.ignoreTimeouts.Set(props.IgnoreTimeouts)
+}
+
+// SetErrorThresholdPercentage changes ErrorThresholdPercentage while the circuit is running.  The rolling counts are
+// kept, so the next ShouldOpen judges them against the new threshold.
+func (e *Opener) SetErrorThresholdPercentage(percentage int64) error {
+	if percentage <= 0 || percentage > 100 {
+		return fmt.Errorf("error threshold percentage %d is not in (0, 100]", percentage)
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config.ErrorThresholdPercentage = percentage
+	e.errorPercentage.Set(percentage)
+	return nil
+}
+
+// ReconfigureFrom copies the thresholds of another Opener, usually a new one from an updated factory.  The rolling
+// counters, and the NumBuckets, RollingDuration and Now they were built with, are kept.
+func (e *Opener) ReconfigureFrom(fresh interface{}) {
+	f, ok := fresh.(*Opener)
+	if !ok || f == e {
+		return
+	}
+	props := f.Config()
+	current := e.Config()
+	props.Now = current.Now
+	props.NumBuckets = current.NumBuckets
+	props.RollingDuration = current.RollingDuration
+	e.SetConfigThreadSafe(props)
+}
+
+// SetTimeKeeper makes the opener use the circuit's clock.  Buckets that have not counted anything yet are recreated
+// at the circuit's current time, so they line up with a mocked clock.  Counts already recorded are kept.  It is not
+// safe to call while the circuit is active.
+func (e *Opener) SetTimeKeeper(t circuit.TimeKeeper) {
+	if t.Now == nil {
+		return
+	}
+	props := e.Config()
+	props.Now = t.Now
+	if e.errorsCount.TotalSum() == 0 && e.legitimateAttemptsCount.TotalSum() == 0 {
+		e.SetConfigNotThreadSafe(props)
+		return
+	}
+	e.SetConfigThreadSafe(props)
 }
 
 // SetConfigNotThreadSafe recreates the buckets.  It is not safe to call while the circuit is active.
 func (e *Opener) SetConfigNotThreadSafe(props ConfigureOpener) {
 	e.SetConfigThreadSafe(props)
 	now := props.Now()
-	rollingCounterBucketWidth := time.Duration(props.RollingDuration.Nanoseconds() / int64(props.NumBuckets))
+	rollingCounterBucketWidth := props.BucketWidth()
 	e.errorsCount = faststats.NewRollingCounter(rollingCounterBucketWidth, props.NumBuckets, now)
 	e.legitimateAttemptsCount = faststats.NewRollingCounter(rollingCounterBucketWidth, props.NumBuckets, now)
 }
 
+// ReportSettings fills in ErrorThresholdPercentage and RequestVolumeThreshold
+func (e *Opener) ReportSettings(s *circuit.Settings) {
+	s.ErrorThresholdPercentage = e.errorPercentage.Get()
+	s.RequestVolumeThreshold = e.requestVolumeThreshold.Get()
+}
+
+// Validate checks the current configuration
+func (e *Opener) Validate() []error {
+	return e.Config().Validate()
+}
+
 // Config returns the current configuration.  To update configuration, please call SetConfigThreadSafe or
 // SetConfigNotThreadSafe
 func (e *Opener) Config() ConfigureOpener {
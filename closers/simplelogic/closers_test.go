@@ -0,0 +1,376 @@
+package simplelogic
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/closers/hystrix"
+	"github.com/cep21/circuit/internal/clock"
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+func TestConsecutiveErrOpener(t *testing.T) {
+	c := circuit.NewCircuitFromConfig("TestConsecutiveErrOpener", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: ConsecutiveErrOpenerFactory(ConfigConsecutiveErrOpener{
+				ErrorThreshold: 3,
+			}),
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	// A success resets the consecutive count
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if c.IsOpen() {
+		t.Fatal("circuit should not open before enough consecutive failures")
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("circuit should open after enough consecutive failures, regardless of volume")
+	}
+}
+
+func TestCloseGracePeriod(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestCloseGracePeriod", circuit.Config{
+		General: circuit.GeneralConfig{
+			CloseGracePeriod: time.Second,
+			CloseGraceErrors: 2,
+			ClosedToOpenFactory: ConsecutiveErrOpenerFactory(ConfigConsecutiveErrOpener{
+				ErrorThreshold: 1,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("a circuit that never closed has no grace period")
+	}
+	c.CloseCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if c.IsOpen() {
+		t.Fatal("a single error right after closing should not reopen the circuit")
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("CloseGraceErrors errors during the grace period should reopen the circuit")
+	}
+	// Each close starts a new grace period
+	c.CloseCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if c.IsOpen() {
+		t.Fatal("the error count should start over with each close")
+	}
+	clk.Add(time.Second)
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("errors after the grace period should be judged normally")
+	}
+}
+
+func TestDecayingErrOpener(t *testing.T) {
+	o := DecayingErrOpenerFactory(ConfigDecayingErrOpener{
+		HalfLife:                 time.Second,
+		ErrorThresholdPercentage: 50,
+		RequestVolumeThreshold:   5,
+	})().(*DecayingErrOpener)
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		o.ErrFailure(now, time.Millisecond)
+	}
+	if r := o.ErrorRate(now); r != 1 {
+		t.Fatalf("expected an error rate of 1 after a burst of errors, saw %f", r)
+	}
+	if !o.ShouldOpen(now) {
+		t.Fatal("expected to open after a burst of errors")
+	}
+	// One half life later the 10 errors count as 5
+	later := now.Add(time.Second)
+	for i := 0; i < 10; i++ {
+		o.Success(later, time.Millisecond)
+	}
+	if r := o.ErrorRate(later); math.Abs(r-1.0/3.0) > .0001 {
+		t.Fatalf("expected errors to decay to 1/3 of requests, saw %f", r)
+	}
+	if o.ShouldOpen(later) {
+		t.Fatal("should not open once the errors have decayed")
+	}
+	o.Closed(later)
+	if o.ErrorRate(later) != 0 {
+		t.Fatal("expected closing to reset the error rate")
+	}
+}
+
+func TestLatencyOpener(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestLatencyOpener", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: LatencyOpenerFactory(ConfigLatencyOpener{
+				Threshold:              100 * time.Millisecond,
+				Percentile:             50,
+				RequestVolumeThreshold: 4,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	takes := func(d time.Duration) func(context.Context) error {
+		return func(_ context.Context) error {
+			clk.Add(d)
+			return nil
+		}
+	}
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, takes(time.Millisecond), nil))
+	}
+	testhelp.MustTesting(t, c.Execute(ctx, takes(200*time.Millisecond), nil))
+	if c.IsOpen() {
+		t.Fatal("a few slow requests should not move the median")
+	}
+	for i := 0; i < 3; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, takes(200*time.Millisecond), nil))
+	}
+	if !c.IsOpen() {
+		t.Fatal("circuit should open once successful requests are slow enough")
+	}
+	if o := c.ClosedToOpen.(*LatencyOpener); o.Latency(clk.Now()) != -1 {
+		t.Error("opening should reset the rolling latencies")
+	}
+}
+
+func TestLatencyOpener_NoThreshold(t *testing.T) {
+	o := LatencyOpenerFactory(ConfigLatencyOpener{})().(*LatencyOpener)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		o.Success(now, time.Hour)
+	}
+	if o.ShouldOpen(now) {
+		t.Fatal("should never open without a threshold")
+	}
+}
+
+func TestLatencyOpener_SetTimeKeeperKeepsLatencies(t *testing.T) {
+	o := LatencyOpenerFactory(ConfigLatencyOpener{})().(*LatencyOpener)
+	now := time.Now()
+	o.Success(now, time.Second)
+	later := now.Add(time.Millisecond)
+	o.SetTimeKeeper(circuit.TimeKeeper{
+		Now: func() time.Time { return later },
+	})
+	if l := o.Latency(later); l != time.Second {
+		t.Errorf("expected the recorded latency to be kept, saw %s", l)
+	}
+}
+
+func TestErrCountOpener(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	c := circuit.NewCircuitFromConfig("TestErrCountOpener", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: ErrCountOpenerFactory(ConfigErrCountOpener{
+				ErrorsToOpen: 20,
+			}),
+			TimeKeeper: circuit.TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+	})
+	ctx := context.Background()
+	// One error for every 49 successes: a 2% error rate
+	for i := 0; i < 19; i++ {
+		for j := 0; j < 49; j++ {
+			testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		}
+		testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	}
+	if c.IsOpen() {
+		t.Fatal("circuit should not open before ErrorsToOpen errors")
+	}
+	// Errors older than the rolling window no longer count
+	clk.Add(11 * time.Second)
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if c.IsOpen() {
+		t.Fatal("circuit should not count errors outside the rolling window")
+	}
+	for i := 0; i < 18; i++ {
+		testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+		for j := 0; j < 49; j++ {
+			testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+		}
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if !c.IsOpen() {
+		t.Fatal("circuit should open once the window holds ErrorsToOpen errors, regardless of successes")
+	}
+}
+
+// fixedLogic is both a ClosedToOpen and an OpenToClosed that always answers the same way and counts what it is told
+type fixedLogic struct {
+	answer    bool
+	asked     int
+	successes int
+	failures  int
+	opened    int
+}
+
+func (f *fixedLogic) ShouldOpen(time.Time) bool  { f.asked++; return f.answer }
+func (f *fixedLogic) Prevent(time.Time) bool     { f.asked++; return f.answer }
+func (f *fixedLogic) ShouldClose(time.Time) bool { f.asked++; return f.answer }
+func (f *fixedLogic) Allow(time.Time) bool       { f.asked++; return f.answer }
+
+func (f *fixedLogic) Success(time.Time, time.Duration)       { f.successes++ }
+func (f *fixedLogic) ErrFailure(time.Time, time.Duration)    { f.failures++ }
+func (f *fixedLogic) ErrTimeout(time.Time, time.Duration)    {}
+func (f *fixedLogic) ErrBadRequest(time.Time, time.Duration) {}
+func (f *fixedLogic) ErrInterrupt(time.Time, time.Duration)  {}
+func (f *fixedLogic) ErrConcurrencyLimitReject(time.Time)    {}
+func (f *fixedLogic) ErrShortCircuit(time.Time)              {}
+func (f *fixedLogic) Opened(time.Time)                       { f.opened++ }
+func (f *fixedLogic) Closed(time.Time)                       {}
+
+func TestCompositeTruthTables(t *testing.T) {
+	now := time.Now()
+	for _, combinator := range []Combinator{CombineOr, CombineAnd} {
+		for _, first := range []bool{false, true} {
+			for _, second := range []bool{false, true} {
+				expected := first || second
+				if combinator == CombineAnd {
+					expected = first && second
+				}
+				a, b := &fixedLogic{answer: first}, &fixedLogic{answer: second}
+				o := NewCompositeOpener(a, b, combinator)
+				c := NewCompositeCloser(a, b, combinator)
+				answers := map[string]bool{
+					"ShouldOpen":  o.ShouldOpen(now),
+					"Prevent":     o.Prevent(now),
+					"ShouldClose": c.ShouldClose(now),
+					"Allow":       c.Allow(now),
+				}
+				for name, got := range answers {
+					if got != expected {
+						t.Errorf("%v %s %v: %s=%v, want %v", first, combinator, second, name, got, expected)
+					}
+				}
+				if a.asked != 4 || b.asked != 4 {
+					t.Errorf("both logics should always be asked: %d %d", a.asked, b.asked)
+				}
+			}
+		}
+	}
+}
+
+func TestCompositeOpener_ForwardsMetrics(t *testing.T) {
+	a, b := &fixedLogic{}, &fixedLogic{}
+	c := circuit.NewCircuitFromConfig("TestCompositeOpener_ForwardsMetrics", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: CompositeOpenerFactory(ConfigCompositeOpener{
+				First:      func() circuit.ClosedToOpen { return a },
+				Second:     func() circuit.ClosedToOpen { return b },
+				Combinator: CombineOr,
+			}),
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	if c.IsOpen() {
+		t.Fatal("circuit should stay closed while neither logic says to open")
+	}
+	c.OpenCircuit()
+	for _, l := range []*fixedLogic{a, b} {
+		if l.successes != 1 || l.failures != 1 || l.opened != 1 {
+			t.Errorf("every metric should reach both logics: %+v", l)
+		}
+	}
+}
+
+func TestCompositeOpener_ForwardsOptionalInterfaces(t *testing.T) {
+	weighted := hystrix.OpenerFactory(hystrix.ConfigureOpener{
+		RequestVolumeThreshold:   5,
+		ErrorThresholdPercentage: 50,
+		SeverityWeights:          map[string]int64{"critical": 5},
+	})
+	c := circuit.NewCircuitFromConfig("TestCompositeOpener_ForwardsOptionalInterfaces", circuit.Config{
+		General: circuit.GeneralConfig{
+			ClosedToOpenFactory: CompositeOpenerFactory(ConfigCompositeOpener{
+				First:      func() circuit.ClosedToOpen { return &fixedLogic{} },
+				Second:     weighted,
+				Combinator: CombineOr,
+			}),
+		},
+	})
+	if err := c.SetErrorThresholdPercentage(60); err != nil {
+		t.Fatal("expected the weighted logic to take the new threshold", err)
+	}
+	if s := c.Settings(); s.ErrorThresholdPercentage != 60 || s.RequestVolumeThreshold != 5 {
+		t.Errorf("expected the weighted logic's settings, saw %+v", s)
+	}
+	if errs := c.ClosedToOpen.(circuit.Validator).Validate(); len(errs) != 0 {
+		t.Error("expected a valid config", errs)
+	}
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if h := c.HealthSnapshot(); h.RequestVolume != 1 {
+		t.Errorf("expected the weighted logic's health, saw %d attempts", h.RequestVolume)
+	}
+	// One success and one failure weighing five: 6 attempts, 5 of them failures
+	testhelp.MustNotTesting(t, c.Execute(ctx, func(_ context.Context) error {
+		return circuit.SimpleSevereError{Err: errors.New("critical failure"), Severity: "critical"}
+	}, nil))
+	if !c.IsOpen() {
+		t.Error("expected the severe failure to open the circuit")
+	}
+}
+
+func TestErrCountOpener_Defaults(t *testing.T) {
+	o := ErrCountOpenerFactory(ConfigErrCountOpener{})().(*ErrCountOpener)
+	now := time.Now()
+	for i := 0; i < 19; i++ {
+		o.ErrFailure(now, time.Millisecond)
+	}
+	if o.ShouldOpen(now) {
+		t.Fatal("expected the default to need 20 errors")
+	}
+	o.ErrFailure(now, time.Millisecond)
+	if !o.ShouldOpen(now) {
+		t.Error("expected 20 errors to open by default")
+	}
+
+	never := ErrCountOpenerFactory(ConfigErrCountOpener{ErrorsToOpen: -1})().(*ErrCountOpener)
+	for i := 0; i < 100; i++ {
+		never.ErrFailure(now, time.Millisecond)
+	}
+	if never.ShouldOpen(now) {
+		t.Error("expected a negative ErrorsToOpen to never open")
+	}
+}
+
+func TestErrCountOpener_SetTimeKeeperKeepsErrors(t *testing.T) {
+	o := ErrCountOpenerFactory(ConfigErrCountOpener{})().(*ErrCountOpener)
+	now := time.Now()
+	o.ErrFailure(now, time.Millisecond)
+	later := now.Add(time.Millisecond)
+	o.SetTimeKeeper(circuit.TimeKeeper{
+		Now: func() time.Time { return later },
+	})
+	if o.Errors(later) != 1 {
+		t.Errorf("expected the counted error to be kept, saw %d", o.Errors(later))
+	}
+}
@@ -0,0 +1,339 @@
+package simplelogic
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cep21/circuit"
+)
+
+// Combinator combines the answers of two open or close logics into one
+type Combinator int
+
+const (
+	// CombineOr is true if either logic is true
+	CombineOr Combinator = iota
+	// CombineAnd is true only if both logics are true
+	CombineAnd
+)
+
+func (c Combinator) combine(first bool, second bool) bool {
+	if c == CombineAnd {
+		return first && second
+	}
+	return first || second
+}
+
+// String returns "or" or "and"
+func (c Combinator) String() string {
+	if c == CombineAnd {
+		return "and"
+	}
+	return "or"
+}
+
+// stateLogic is what ClosedToOpen and OpenToClosed have in common: the metrics they receive
+type stateLogic interface {
+	circuit.RunMetrics
+	circuit.Metrics
+}
+
+// logicPair sends every metric to both logics, and passes circuit setup and the optional interfaces of open/close logic
+// through to the ones that implement them
+type logicPair struct {
+	first  stateLogic
+	second stateLogic
+}
+
+func (p *logicPair) each(f func(l stateLogic)) {
+	f(p.first)
+	f(p.second)
+}
+
+// Success sends Success to both logics
+func (p *logicPair) Success(now time.Time, duration time.Duration) {
+	p.each(func(l stateLogic) { l.Success(now, duration) })
+}
+
+// ErrFailure sends ErrFailure to both logics
+func (p *logicPair) ErrFailure(now time.Time, duration time.Duration) {
+	p.each(func(l stateLogic) { l.ErrFailure(now, duration) })
+}
+
+// ErrTimeout sends ErrTimeout to both logics
+func (p *logicPair) ErrTimeout(now time.Time, duration time.Duration) {
+	p.each(func(l stateLogic) { l.ErrTimeout(now, duration) })
+}
+
+// ErrBadRequest sends ErrBadRequest to both logics
+func (p *logicPair) ErrBadRequest(now time.Time, duration time.Duration) {
+	p.each(func(l stateLogic) { l.ErrBadRequest(now, duration) })
+}
+
+// ErrInterrupt sends ErrInterrupt to both logics
+func (p *logicPair) ErrInterrupt(now time.Time, duration time.Duration) {
+	p.each(func(l stateLogic) { l.ErrInterrupt(now, duration) })
+}
+
+// ErrConcurrencyLimitReject sends ErrConcurrencyLimitReject to both logics
+func (p *logicPair) ErrConcurrencyLimitReject(now time.Time) {
+	p.each(func(l stateLogic) { l.ErrConcurrencyLimitReject(now) })
+}
+
+// ErrShortCircuit sends ErrShortCircuit to both logics
+func (p *logicPair) ErrShortCircuit(now time.Time) {
+	p.each(func(l stateLogic) { l.ErrShortCircuit(now) })
+}
+
+// Opened sends Opened to both logics
+func (p *logicPair) Opened(now time.Time) {
+	p.each(func(l stateLogic) { l.Opened(now) })
+}
+
+// Closed sends Closed to both logics
+func (p *logicPair) Closed(now time.Time) {
+	p.each(func(l stateLogic) { l.Closed(now) })
+}
+
+// ErrFailureSeverity sends ErrFailureSeverity to the logics that are circuit.FailureSeverityMetrics
+func (p *logicPair) ErrFailureSeverity(now time.Time, severity string, duration time.Duration) {
+	p.each(func(l stateLogic) {
+		if s, ok := l.(circuit.FailureSeverityMetrics); ok {
+			s.ErrFailureSeverity(now, severity, duration)
+		}
+	})
+}
+
+// RunHealth is the first logic that is a circuit.RunHealth, or nil if neither is
+func (p *logicPair) RunHealth() circuit.RunHealth {
+	var ret circuit.RunHealth
+	p.each(func(l stateLogic) {
+		if h, ok := l.(circuit.RunHealth); ok && ret == nil {
+			ret = h
+		}
+	})
+	return ret
+}
+
+// SetErrorThresholdPercentage changes the threshold of the logics that are circuit.ErrorThresholdSetter.  It returns an
+// error if neither is, or the first error either returns.
+func (p *logicPair) SetErrorThresholdPercentage(percentage int64) error {
+	var ret error
+	found := false
+	p.each(func(l stateLogic) {
+		if s, ok := l.(circuit.ErrorThresholdSetter); ok {
+			found = true
+			if err := s.SetErrorThresholdPercentage(percentage); err != nil && ret == nil {
+				ret = err
+			}
+		}
+	})
+	if !found {
+		return errors.New("neither logic supports changing the error threshold")
+	}
+	return ret
+}
+
+// Validate returns the problems of both logics, if they are circuit.Validator
+func (p *logicPair) Validate() []error {
+	var errs []error
+	p.each(func(l stateLogic) {
+		if v, ok := l.(circuit.Validator); ok {
+			errs = append(errs, v.Validate()...)
+		}
+	})
+	return errs
+}
+
+// ReportSettings lets both logics fill in s, if they are circuit.SettingsReporter.  If both set a field, the second
+// logic's value is kept.
+func (p *logicPair) ReportSettings(s *circuit.Settings) {
+	p.each(func(l stateLogic) {
+		if r, ok := l.(circuit.SettingsReporter); ok {
+			r.ReportSettings(s)
+		}
+	})
+}
+
+// SetTimeKeeper passes the circuit's TimeKeeper to both logics, if they want it
+func (p *logicPair) SetTimeKeeper(t circuit.TimeKeeper) {
+	p.each(func(l stateLogic) {
+		if tk, ok := l.(circuit.TimeKeeperSetter); ok {
+			tk.SetTimeKeeper(t)
+		}
+	})
+}
+
+// SetRand passes the circuit's randomness to both logics, if they want it
+func (p *logicPair) SetRand(int63n func(n int64) int64) {
+	p.each(func(l stateLogic) {
+		if rs, ok := l.(circuit.RandSetter); ok {
+			rs.SetRand(int63n)
+		}
+	})
+}
+
+// SetConfigThreadSafe passes the circuit's config to both logics, if they are circuit.Configurable
+func (p *logicPair) SetConfigThreadSafe(props circuit.Config) {
+	p.each(func(l stateLogic) {
+		if cfg, ok := l.(circuit.Configurable); ok {
+			cfg.SetConfigThreadSafe(props)
+		}
+	})
+}
+
+// SetConfigNotThreadSafe passes the circuit's config to both logics, if they are circuit.Configurable
+func (p *logicPair) SetConfigNotThreadSafe(props circuit.Config) {
+	p.each(func(l stateLogic) {
+		if cfg, ok := l.(circuit.Configurable); ok {
+			cfg.SetConfigNotThreadSafe(props)
+		}
+	})
+}
+
+// ReconfigureFrom passes each logic of another composite to the matching logic here, if it is circuit.Reconfigurable
+func (p *logicPair) ReconfigureFrom(fresh interface{}) {
+	var other *logicPair
+	switch f := fresh.(type) {
+	case *CompositeOpener:
+		other = &f.logicPair
+	case *CompositeCloser:
+		other = &f.logicPair
+	default:
+		return
+	}
+	if r, ok := p.first.(circuit.Reconfigurable); ok {
+		r.ReconfigureFrom(other.first)
+	}
+	if r, ok := p.second.(circuit.Reconfigurable); ok {
+		r.ReconfigureFrom(other.second)
+	}
+}
+
+// CompositeOpener is closed->open logic that combines two other ClosedToOpen logics.  Every metric goes to both, and
+// both are always asked, so each keeps its own state up to date.  For example, open on a high error rate OR a high
+// latency by combining a hystrix.Opener and a LatencyOpener with CombineOr.
+type CompositeOpener struct {
+	logicPair
+	first      circuit.ClosedToOpen
+	second     circuit.ClosedToOpen
+	combinator Combinator
+}
+
+var _ circuit.ClosedToOpen = &CompositeOpener{}
+var _ circuit.OpensOnSuccess = &CompositeOpener{}
+var _ circuit.TimeKeeperSetter = &CompositeOpener{}
+var _ circuit.RandSetter = &CompositeOpener{}
+var _ circuit.Configurable = &CompositeOpener{}
+var _ circuit.Reconfigurable = &CompositeOpener{}
+var _ circuit.FailureSeverityMetrics = &CompositeOpener{}
+var _ circuit.RunHealthProvider = &CompositeOpener{}
+var _ circuit.ErrorThresholdSetter = &CompositeOpener{}
+var _ circuit.Validator = &CompositeOpener{}
+var _ circuit.SettingsReporter = &CompositeOpener{}
+
+// NewCompositeOpener combines first and second with combinator
+func NewCompositeOpener(first circuit.ClosedToOpen, second circuit.ClosedToOpen, combinator Combinator) *CompositeOpener {
+	return &CompositeOpener{
+		logicPair:  logicPair{first: first, second: second},
+		first:      first,
+		second:     second,
+		combinator: combinator,
+	}
+}
+
+// ConfigCompositeOpener configures a CompositeOpener.  First and Second are required.
+type ConfigCompositeOpener struct {
+	First      func() circuit.ClosedToOpen `json:"-"`
+	Second     func() circuit.ClosedToOpen `json:"-"`
+	Combinator Combinator
+}
+
+// CompositeOpenerFactory constructs a new CompositeOpener, with a new First and Second logic, for each circuit
+func CompositeOpenerFactory(config ConfigCompositeOpener) func() circuit.ClosedToOpen {
+	return func() circuit.ClosedToOpen {
+		return NewCompositeOpener(config.First(), config.Second(), config.Combinator)
+	}
+}
+
+// ShouldOpen combines the ShouldOpen of both logics
+func (c *CompositeOpener) ShouldOpen(now time.Time) bool {
+	first := c.first.ShouldOpen(now)
+	second := c.second.ShouldOpen(now)
+	return c.combinator.combine(first, second)
+}
+
+// Prevent combines the Prevent of both logics
+func (c *CompositeOpener) Prevent(now time.Time) bool {
+	first := c.first.Prevent(now)
+	second := c.second.Prevent(now)
+	return c.combinator.combine(first, second)
+}
+
+// OpensOnSuccess is true if either logic opens on success.  The other logic is then also asked ShouldOpen after
+// successes.
+func (c *CompositeOpener) OpensOnSuccess() bool {
+	return opensOnSuccess(c.first) || opensOnSuccess(c.second)
+}
+
+func opensOnSuccess(l circuit.ClosedToOpen) bool {
+	o, ok := l.(circuit.OpensOnSuccess)
+	return ok && o.OpensOnSuccess()
+}
+
+// CompositeCloser is open->closed logic that combines two other OpenToClosed logics.  Every metric goes to both, and
+// both are always asked, so each keeps its own state up to date.  Use CombineAnd to close only once both agree the
+// circuit has recovered.
+type CompositeCloser struct {
+	logicPair
+	first      circuit.OpenToClosed
+	second     circuit.OpenToClosed
+	combinator Combinator
+}
+
+var _ circuit.OpenToClosed = &CompositeCloser{}
+var _ circuit.TimeKeeperSetter = &CompositeCloser{}
+var _ circuit.RandSetter = &CompositeCloser{}
+var _ circuit.Configurable = &CompositeCloser{}
+var _ circuit.Reconfigurable = &CompositeCloser{}
+var _ circuit.Validator = &CompositeCloser{}
+var _ circuit.SettingsReporter = &CompositeCloser{}
+
+// NewCompositeCloser combines first and second with combinator
+func NewCompositeCloser(first circuit.OpenToClosed, second circuit.OpenToClosed, combinator Combinator) *CompositeCloser {
+	return &CompositeCloser{
+		logicPair:  logicPair{first: first, second: second},
+		first:      first,
+		second:     second,
+		combinator: combinator,
+	}
+}
+
+// ConfigCompositeCloser configures a CompositeCloser.  First and Second are required.
+type ConfigCompositeCloser struct {
+	First      func() circuit.OpenToClosed `json:"-"`
+	Second     func() circuit.OpenToClosed `json:"-"`
+	Combinator Combinator
+}
+
+// CompositeCloserFactory constructs a new CompositeCloser, with a new First and Second logic, for each circuit
+func CompositeCloserFactory(config ConfigCompositeCloser) func() circuit.OpenToClosed {
+	return func() circuit.OpenToClosed {
+		return NewCompositeCloser(config.First(), config.Second(), config.Combinator)
+	}
+}
+
+// ShouldClose combines the ShouldClose of both logics
+func (c *CompositeCloser) ShouldClose(now time.Time) bool {
+	first := c.first.ShouldClose(now)
+	second := c.second.ShouldClose(now)
+	return c.combinator.combine(first, second)
+}
+
+// Allow combines the Allow of both logics.  Both are asked, even if the first already decides the answer, so logics
+// that only allow one request per interval keep to their schedule.
+func (c *CompositeCloser) Allow(now time.Time) bool {
+	first := c.first.Allow(now)
+	second := c.second.Allow(now)
+	return c.combinator.combine(first, second)
+}
@@ -0,0 +1,168 @@
+package simplelogic
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/faststats"
+)
+
+// ErrCountOpener is closed->open logic that opens once the rolling window holds ErrorsToOpen failures and timeouts,
+// no matter how many requests succeeded.  Use it when a dependency should only tolerate a fixed number of errors, for
+// example 20 every 10 seconds, where an error percentage would hide a burst of errors under high volume.
+type ErrCountOpener struct {
+	errors faststats.RollingCounter
+
+	mu     sync.Mutex
+	config ConfigErrCountOpener
+}
+
+// ErrCountOpenerFactory constructs a new ErrCountOpener
+func ErrCountOpenerFactory(config ConfigErrCountOpener) func() circuit.ClosedToOpen {
+	return func() circuit.ClosedToOpen {
+		ret := &ErrCountOpener{}
+		config.Merge(defaultConfigErrCountOpener)
+		ret.SetConfigNotThreadSafe(config)
+		return ret
+	}
+}
+
+// ConfigErrCountOpener configures an ErrCountOpener
+type ConfigErrCountOpener struct {
+	// ErrorsToOpen is how many failures and timeouts within RollingDuration open the circuit.  It defaults to 20.  If
+	// it is negative, the circuit never opens.
+	ErrorsToOpen int64
+	// RollingDuration is how long errors are counted
+	RollingDuration time.Duration
+	// NumBuckets is how many buckets RollingDuration is split into
+	NumBuckets int
+	// Now should simulate time.Now
+	Now func() time.Time `json:"-"`
+}
+
+// Merge this config with another
+func (c *ConfigErrCountOpener) Merge(other ConfigErrCountOpener) {
+	if c.ErrorsToOpen == 0 {
+		c.ErrorsToOpen = other.ErrorsToOpen
+	}
+	if c.RollingDuration == 0 {
+		c.RollingDuration = other.RollingDuration
+	}
+	if c.NumBuckets == 0 {
+		c.NumBuckets = other.NumBuckets
+	}
+	if c.Now == nil {
+		c.Now = other.Now
+	}
+}
+
+var defaultConfigErrCountOpener = ConfigErrCountOpener{
+	ErrorsToOpen:    20,
+	RollingDuration: 10 * time.Second,
+	NumBuckets:      10,
+	Now:             time.Now,
+}
+
+// MarshalJSON returns opener information in a JSON format
+func (e *ErrCountOpener) MarshalJSON() ([]byte, error) {
+	cfg := e.Config()
+	return json.Marshal(map[string]interface{}{
+		"config": cfg,
+		"errors": e.errors.RollingSumAt(cfg.Now()),
+	})
+}
+
+var _ json.Marshaler = &ErrCountOpener{}
+
+// Closed resets the error count
+func (e *ErrCountOpener) Closed(now time.Time) {
+	e.errors.Reset(now)
+}
+
+// Opened resets the error count
+func (e *ErrCountOpener) Opened(now time.Time) {
+	e.errors.Reset(now)
+}
+
+// Prevent always returns false
+func (e *ErrCountOpener) Prevent(now time.Time) bool {
+	return false
+}
+
+// Success is ignored
+func (e *ErrCountOpener) Success(now time.Time, duration time.Duration) {}
+
+// ErrBadRequest is ignored
+func (e *ErrCountOpener) ErrBadRequest(now time.Time, duration time.Duration) {}
+
+// ErrInterrupt is ignored
+func (e *ErrCountOpener) ErrInterrupt(now time.Time, duration time.Duration) {}
+
+// ErrConcurrencyLimitReject is ignored
+func (e *ErrCountOpener) ErrConcurrencyLimitReject(now time.Time) {}
+
+// ErrShortCircuit is ignored
+func (e *ErrCountOpener) ErrShortCircuit(now time.Time) {}
+
+// ErrFailure counts an error
+func (e *ErrCountOpener) ErrFailure(now time.Time, duration time.Duration) {
+	e.errors.Inc(now)
+}
+
+// ErrTimeout counts an error
+func (e *ErrCountOpener) ErrTimeout(now time.Time, duration time.Duration) {
+	e.errors.Inc(now)
+}
+
+// Errors returns how many errors are in the rolling window
+func (e *ErrCountOpener) Errors(now time.Time) int64 {
+	return e.errors.RollingSumAt(now)
+}
+
+// ShouldOpen returns true once the rolling window holds ErrorsToOpen errors
+func (e *ErrCountOpener) ShouldOpen(now time.Time) bool {
+	errorsToOpen := e.Config().ErrorsToOpen
+	return errorsToOpen > 0 && e.errors.RollingSumAt(now) >= errorsToOpen
+}
+
+// Config returns the current configuration
+func (e *ErrCountOpener) Config() ConfigErrCountOpener {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.config
+}
+
+// SetConfigThreadSafe updates ErrorsToOpen
+func (e *ErrCountOpener) SetConfigThreadSafe(props ConfigErrCountOpener) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.config = props
+}
+
+// SetTimeKeeper makes the opener use the circuit's clock.  Buckets that have not counted anything yet are recreated
+// at the circuit's current time, so they line up with a mocked clock.  Errors already counted are kept.  It is not
+// safe to call while the circuit is active.
+func (e *ErrCountOpener) SetTimeKeeper(t circuit.TimeKeeper) {
+	if t.Now == nil {
+		return
+	}
+	props := e.Config()
+	props.Now = t.Now
+	if e.errors.TotalSum() == 0 {
+		e.SetConfigNotThreadSafe(props)
+		return
+	}
+	e.SetConfigThreadSafe(props)
+}
+
+// SetConfigNotThreadSafe recreates the buckets.  It is not safe to call while the circuit is active.
+func (e *ErrCountOpener) SetConfigNotThreadSafe(props ConfigErrCountOpener) {
+	e.SetConfigThreadSafe(props)
+	bucketWidth := faststats.BucketWidth(props.RollingDuration, props.NumBuckets)
+	e.errors = faststats.NewRollingCounter(bucketWidth, props.NumBuckets, props.Now())
+}
+
+var _ circuit.ClosedToOpen = &ErrCountOpener{}
+var _ circuit.TimeKeeperSetter = &ErrCountOpener{}
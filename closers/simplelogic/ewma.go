@@ -0,0 +1,195 @@
+package simplelogic
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+)
+
+// DecayingErrOpener is closed->open logic that opens when an exponentially decaying error rate is too high.  Each
+// request's weight halves every HalfLife, so old requests fade out smoothly instead of falling out of a bucket all at
+// once.  This behaves better than rolling windows for circuits that only see a request every few seconds.
+type DecayingErrOpener struct {
+	// errors and attempts are the decayed sums as of lastUpdate
+	errors     float64
+	attempts   float64
+	lastUpdate time.Time
+
+	mu     sync.Mutex
+	config ConfigDecayingErrOpener
+}
+
+// DecayingErrOpenerFactory constructs a new DecayingErrOpener
+func DecayingErrOpenerFactory(config ConfigDecayingErrOpener) func() circuit.ClosedToOpen {
+	return func() circuit.ClosedToOpen {
+		ret := &DecayingErrOpener{}
+		config.Merge(defaultConfigDecayingErrOpener)
+		ret.SetConfigNotThreadSafe(config)
+		return ret
+	}
+}
+
+// ConfigDecayingErrOpener configures a DecayingErrOpener
+type ConfigDecayingErrOpener struct {
+	// HalfLife is how long it takes a request to count half as much towards the error rate
+	HalfLife time.Duration
+	// ErrorThresholdPercentage is the decayed error percentage [0-100] the circuit opens at
+	ErrorThresholdPercentage int64
+	// RequestVolumeThreshold is the decayed number of requests required before the circuit can open
+	RequestVolumeThreshold int64
+}
+
+// Merge this config with another
+func (c *ConfigDecayingErrOpener) Merge(other ConfigDecayingErrOpener) {
+	if c.HalfLife == 0 {
+		c.HalfLife = other.HalfLife
+	}
+	if c.ErrorThresholdPercentage == 0 {
+		c.ErrorThresholdPercentage = other.ErrorThresholdPercentage
+	}
+	if c.RequestVolumeThreshold == 0 {
+		c.RequestVolumeThreshold = other.RequestVolumeThreshold
+	}
+}
+
+var defaultConfigDecayingErrOpener = ConfigDecayingErrOpener{
+	HalfLife:                 10 * time.Second,
+	ErrorThresholdPercentage: 50,
+	RequestVolumeThreshold:   5,
+}
+
+// MarshalJSON returns opener information in a JSON format
+func (d *DecayingErrOpener) MarshalJSON() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return json.Marshal(map[string]interface{}{
+		"config":   d.config,
+		"errors":   d.errors,
+		"attempts": d.attempts,
+	})
+}
+
+var _ json.Marshaler = &DecayingErrOpener{}
+
+// decayWithLock moves the decayed sums forward to now
+func (d *DecayingErrOpener) decayWithLock(now time.Time) {
+	if d.lastUpdate.IsZero() {
+		d.lastUpdate = now
+		return
+	}
+	elapsed := now.Sub(d.lastUpdate)
+	if elapsed <= 0 || d.config.HalfLife <= 0 {
+		// Do not move time backwards
+		return
+	}
+	decay := math.Exp2(-float64(elapsed) / float64(d.config.HalfLife))
+	d.errors *= decay
+	d.attempts *= decay
+	d.lastUpdate = now
+}
+
+func (d *DecayingErrOpener) record(now time.Time, isError bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.decayWithLock(now)
+	d.attempts++
+	if isError {
+		d.errors++
+	}
+}
+
+func (d *DecayingErrOpener) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.errors = 0
+	d.attempts = 0
+	d.lastUpdate = time.Time{}
+}
+
+// ErrorRate returns the decayed error rate [0.0 - 1.0] at a moment in time
+func (d *DecayingErrOpener) ErrorRate(now time.Time) float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.decayWithLock(now)
+	if d.attempts == 0 {
+		return 0
+	}
+	return d.errors / d.attempts
+}
+
+// Closed resets the decayed counts
+func (d *DecayingErrOpener) Closed(now time.Time) {
+	d.reset()
+}
+
+// Opened resets the decayed counts
+func (d *DecayingErrOpener) Opened(now time.Time) {
+	d.reset()
+}
+
+// Prevent always returns false
+func (d *DecayingErrOpener) Prevent(now time.Time) bool {
+	return false
+}
+
+// Success adds a healthy request
+func (d *DecayingErrOpener) Success(now time.Time, duration time.Duration) {
+	d.record(now, false)
+}
+
+// ErrBadRequest is ignored
+func (d *DecayingErrOpener) ErrBadRequest(now time.Time, duration time.Duration) {}
+
+// ErrInterrupt is ignored
+func (d *DecayingErrOpener) ErrInterrupt(now time.Time, duration time.Duration) {}
+
+// ErrConcurrencyLimitReject is ignored
+func (d *DecayingErrOpener) ErrConcurrencyLimitReject(now time.Time) {}
+
+// ErrShortCircuit is ignored
+func (d *DecayingErrOpener) ErrShortCircuit(now time.Time) {}
+
+// ErrFailure adds an unhealthy request
+func (d *DecayingErrOpener) ErrFailure(now time.Time, duration time.Duration) {
+	d.record(now, true)
+}
+
+// ErrTimeout adds an unhealthy request
+func (d *DecayingErrOpener) ErrTimeout(now time.Time, duration time.Duration) {
+	d.record(now, true)
+}
+
+// ShouldOpen returns true if the decayed volume and error rate are both high enough
+func (d *DecayingErrOpener) ShouldOpen(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.decayWithLock(now)
+	if d.attempts == 0 || d.attempts < float64(d.config.RequestVolumeThreshold) {
+		return false
+	}
+	return d.errors/d.attempts*100 >= float64(d.config.ErrorThresholdPercentage)
+}
+
+// Config returns the current configuration
+func (d *DecayingErrOpener) Config() ConfigDecayingErrOpener {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.config
+}
+
+// SetConfigThreadSafe updates the half life and thresholds
+func (d *DecayingErrOpener) SetConfigThreadSafe(props ConfigDecayingErrOpener) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.config = props
+}
+
+// SetConfigNotThreadSafe updates the half life and thresholds
+func (d *DecayingErrOpener) SetConfigNotThreadSafe(props ConfigDecayingErrOpener) {
+	d.SetConfigThreadSafe(props)
+}
+
+var _ circuit.ClosedToOpen = &DecayingErrOpener{}
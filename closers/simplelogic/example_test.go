@@ -0,0 +1,41 @@
+package simplelogic_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/closers/simplelogic"
+)
+
+// This example opens circuits for a low traffic dependency after 3 failures in a row, no matter how few requests
+// the circuit has seen.
+func ExampleConsecutiveErrOpenerFactory() {
+	h := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{
+			func(circuitName string) circuit.Config {
+				// Only circuits for the batch dependency use consecutive error logic
+				if !strings.HasPrefix(circuitName, "batch.") {
+					return circuit.Config{}
+				}
+				return circuit.Config{
+					General: circuit.GeneralConfig{
+						ClosedToOpenFactory: simplelogic.ConsecutiveErrOpenerFactory(simplelogic.ConfigConsecutiveErrOpener{
+							ErrorThreshold: 3,
+						}),
+					},
+				}
+			},
+		},
+	}
+	c := h.MustCreateCircuit("batch.upload")
+	for i := 0; i < 3; i++ {
+		_ = c.Execute(context.Background(), func(_ context.Context) error {
+			return errors.New("dependency is down")
+		}, nil)
+	}
+	fmt.Println("circuit is open:", c.IsOpen())
+	// Output: circuit is open: true
+}
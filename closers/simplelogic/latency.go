@@ -0,0 +1,215 @@
+package simplelogic
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/faststats"
+)
+
+// LatencyOpener is closed->open logic that opens when requests get slow, even if they succeed.  It tracks the rolling
+// latency of every run, and opens once a percentile of them reaches Threshold.  Each successful request asks it whether
+// to open, which sorts the rolling latencies, so keep NumBuckets*BucketSize modest.
+type LatencyOpener struct {
+	durations faststats.RollingPercentile
+	attempts  faststats.RollingCounter
+
+	mu     sync.Mutex
+	config ConfigLatencyOpener
+}
+
+// LatencyOpenerFactory constructs a new LatencyOpener
+func LatencyOpenerFactory(config ConfigLatencyOpener) func() circuit.ClosedToOpen {
+	return func() circuit.ClosedToOpen {
+		ret := &LatencyOpener{}
+		config.Merge(defaultConfigLatencyOpener)
+		ret.SetConfigNotThreadSafe(config)
+		return ret
+	}
+}
+
+// ConfigLatencyOpener configures a LatencyOpener
+type ConfigLatencyOpener struct {
+	// Threshold is the latency that opens the circuit once Percentile of requests take at least that long.  If it is
+	// zero, the circuit never opens.
+	Threshold time.Duration
+	// Percentile [0 - 100] of run latencies compared to Threshold
+	Percentile float64
+	// RequestVolumeThreshold is how many requests the rolling window needs before the circuit can open
+	RequestVolumeThreshold int64
+	// RollingDuration is how long latencies are remembered
+	RollingDuration time.Duration
+	// NumBuckets is how many buckets RollingDuration is split into
+	NumBuckets int
+	// BucketSize is how many latencies each bucket keeps
+	BucketSize int
+	// Now should simulate time.Now
+	Now func() time.Time `json:"-"`
+}
+
+// Merge this config with another
+func (c *ConfigLatencyOpener) Merge(other ConfigLatencyOpener) {
+	if c.Threshold == 0 {
+		c.Threshold = other.Threshold
+	}
+	if c.Percentile == 0 {
+		c.Percentile = other.Percentile
+	}
+	if c.RequestVolumeThreshold == 0 {
+		c.RequestVolumeThreshold = other.RequestVolumeThreshold
+	}
+	if c.RollingDuration == 0 {
+		c.RollingDuration = other.RollingDuration
+	}
+	if c.NumBuckets == 0 {
+		c.NumBuckets = other.NumBuckets
+	}
+	if c.BucketSize == 0 {
+		c.BucketSize = other.BucketSize
+	}
+	if c.Now == nil {
+		c.Now = other.Now
+	}
+}
+
+var defaultConfigLatencyOpener = ConfigLatencyOpener{
+	Percentile:             99,
+	RequestVolumeThreshold: 20,
+	RollingDuration:        10 * time.Second,
+	NumBuckets:             10,
+	BucketSize:             100,
+	Now:                    time.Now,
+}
+
+// MarshalJSON returns opener information in a JSON format
+func (l *LatencyOpener) MarshalJSON() ([]byte, error) {
+	cfg := l.Config()
+	now := cfg.Now()
+	return json.Marshal(map[string]interface{}{
+		"config":   cfg,
+		"attempts": l.attempts.RollingSumAt(now),
+		"latency":  l.durations.SnapshotAt(now).Percentile(cfg.Percentile).String(),
+	})
+}
+
+var _ json.Marshaler = &LatencyOpener{}
+
+func (l *LatencyOpener) record(now time.Time, duration time.Duration) {
+	l.attempts.Inc(now)
+	l.durations.AddDuration(duration, now)
+}
+
+func (l *LatencyOpener) reset(now time.Time) {
+	l.attempts.Reset(now)
+	l.durations.Reset(now)
+}
+
+// Closed resets the rolling latencies
+func (l *LatencyOpener) Closed(now time.Time) {
+	l.reset(now)
+}
+
+// Opened resets the rolling latencies
+func (l *LatencyOpener) Opened(now time.Time) {
+	l.reset(now)
+}
+
+// Prevent always returns false
+func (l *LatencyOpener) Prevent(now time.Time) bool {
+	return false
+}
+
+// OpensOnSuccess returns true: slow requests can succeed
+func (l *LatencyOpener) OpensOnSuccess() bool {
+	return true
+}
+
+// Success records the request latency
+func (l *LatencyOpener) Success(now time.Time, duration time.Duration) {
+	l.record(now, duration)
+}
+
+// ErrBadRequest is ignored
+func (l *LatencyOpener) ErrBadRequest(now time.Time, duration time.Duration) {}
+
+// ErrInterrupt is ignored
+func (l *LatencyOpener) ErrInterrupt(now time.Time, duration time.Duration) {}
+
+// ErrConcurrencyLimitReject is ignored
+func (l *LatencyOpener) ErrConcurrencyLimitReject(now time.Time) {}
+
+// ErrShortCircuit is ignored
+func (l *LatencyOpener) ErrShortCircuit(now time.Time) {}
+
+// ErrFailure records the request latency
+func (l *LatencyOpener) ErrFailure(now time.Time, duration time.Duration) {
+	l.record(now, duration)
+}
+
+// ErrTimeout records the request latency
+func (l *LatencyOpener) ErrTimeout(now time.Time, duration time.Duration) {
+	l.record(now, duration)
+}
+
+// Latency returns the configured percentile of the rolling latencies, or -1 if there are none
+func (l *LatencyOpener) Latency(now time.Time) time.Duration {
+	return l.durations.SnapshotAt(now).Percentile(l.Config().Percentile)
+}
+
+// ShouldOpen returns true if there are enough requests and the configured percentile of them is too slow
+func (l *LatencyOpener) ShouldOpen(now time.Time) bool {
+	cfg := l.Config()
+	if cfg.Threshold <= 0 {
+		return false
+	}
+	attemptCount := l.attempts.RollingSumAt(now)
+	if attemptCount == 0 || attemptCount < cfg.RequestVolumeThreshold {
+		return false
+	}
+	return l.durations.SnapshotAt(now).Percentile(cfg.Percentile) >= cfg.Threshold
+}
+
+// Config returns the current configuration
+func (l *LatencyOpener) Config() ConfigLatencyOpener {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.config
+}
+
+// SetConfigThreadSafe updates the threshold, percentile, and request volume
+func (l *LatencyOpener) SetConfigThreadSafe(props ConfigLatencyOpener) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = props
+}
+
+// SetTimeKeeper makes the opener use the circuit's clock.  Buckets that have not counted anything yet are recreated
+// at the circuit's current time, so they line up with a mocked clock.  Latencies already recorded are kept.  It is
+// not safe to call while the circuit is active.
+func (l *LatencyOpener) SetTimeKeeper(t circuit.TimeKeeper) {
+	if t.Now == nil {
+		return
+	}
+	props := l.Config()
+	props.Now = t.Now
+	if l.attempts.TotalSum() == 0 {
+		l.SetConfigNotThreadSafe(props)
+		return
+	}
+	l.SetConfigThreadSafe(props)
+}
+
+// SetConfigNotThreadSafe recreates the buckets.  It is not safe to call while the circuit is active.
+func (l *LatencyOpener) SetConfigNotThreadSafe(props ConfigLatencyOpener) {
+	l.SetConfigThreadSafe(props)
+	now := props.Now()
+	bucketWidth := faststats.BucketWidth(props.RollingDuration, props.NumBuckets)
+	l.attempts = faststats.NewRollingCounter(bucketWidth, props.NumBuckets, now)
+	l.durations = faststats.NewRollingPercentile(bucketWidth, props.NumBuckets, props.BucketSize, now)
+}
+
+var _ circuit.ClosedToOpen = &LatencyOpener{}
+var _ circuit.OpensOnSuccess = &LatencyOpener{}
+var _ circuit.TimeKeeperSetter = &LatencyOpener{}
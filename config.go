@@ -1,12 +1,20 @@
 package circuit
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/cep21/circuit/faststats"
 )
 
-// Config controls how a circuit operates
+// Config controls how a circuit operates.  It round trips through encoding/json, so it can be loaded from a file.
+// Durations are written as strings like "250ms", and numbers of nanoseconds are also accepted.  Functions, factories,
+// and metric collectors are skipped: set those in code, for example with Manager.DefaultCircuitProperties.
 type Config struct {
 	General   GeneralConfig
 	Execution ExecutionConfig
@@ -24,6 +32,33 @@ type GeneralConfig struct {
 	ForceOpen bool `json:",omitempty"`
 	// ForcedClosed is https://github.com/Netflix/Hystrix/wiki/Configuration#circuitbreakerforceclosed
 	ForcedClosed bool `json:",omitempty"`
+	// DryRun keeps tracking metrics and open/closed state, but always calls runFunc, even when the circuit is open.
+	// Use it to check your open and close logic against real traffic before letting the circuit reject anything.
+	DryRun bool `json:",omitempty"`
+	// ManualClose keeps an open circuit open until CloseCircuit is called.  OpenToClosed is never asked to allow half
+	// open attempts or to close the circuit, but still receives metrics.  Use it for dependencies that a person or an
+	// external health check should bring back.
+	ManualClose bool `json:",omitempty"`
+	// MinimumOpenDuration is how long an opened circuit stays open before OpenToClosed may close it, even if half open
+	// attempts succeed sooner.  Use it to stop a flapping dependency from closing the circuit every sleep window.
+	// Half open attempts still happen on OpenToClosed's schedule, so whichever of this and the sleep window is longer
+	// decides when the circuit can close.  CloseCircuit ignores it.
+	MinimumOpenDuration time.Duration `json:",omitempty"`
+	// WarmupDuration is how long after the circuit is created ClosedToOpen is not asked to open it.  Metrics are still
+	// collected, so the first failure after warmup is judged on everything seen so far.  OpenCircuit still works.
+	WarmupDuration time.Duration `json:",omitempty"`
+	// CloseGracePeriod is how long after each close errors are dampened, so one stray error from a dependency that is
+	// still warming up does not reopen the circuit right away.  During it, ClosedToOpen is only asked to open the
+	// circuit once CloseGraceErrors errors have happened since the close.  Unlike WarmupDuration, it starts again every
+	// time the circuit closes.  Metrics are still collected, and OpenCircuit still works.
+	CloseGracePeriod time.Duration `json:",omitempty"`
+	// CloseGraceErrors is how many errors, counting the current one, the circuit must see during CloseGracePeriod
+	// before ClosedToOpen is asked to open it.  Zero means no error during the grace period can open it.
+	CloseGraceErrors int64 `json:",omitempty"`
+	// MaxReportedDuration, if set, caps every duration given to metrics, so one pathological request does not skew
+	// latency histograms.  Timeouts still use the real time taken.  ClosedToOpen and OpenToClosed also see the capped
+	// durations, so latency based logic should use thresholds below it.  By default, durations are not capped.
+	MaxReportedDuration time.Duration `json:",omitempty"`
 	// GoLostErrors can receive errors that would otherwise be lost by `Go` executions.  For example, if Go returns
 	// early but some long time later an error or panic eventually happens.
 	GoLostErrors func(err error, panics interface{}) `json:"-"`
@@ -33,10 +68,51 @@ type GeneralConfig struct {
 	// OpenToClosedFactory creates logic that determines if the circuit should go from Open to Closed state.
 	// By default, it never closes
 	OpenToClosedFactory func() OpenToClosed `json:"-"`
+	// Labels are dimensions, like region or tier, for metrics about this circuit.  They are passed to every metric
+	// collector that implements LabelSetter.  Labels merge key by key.
+	Labels map[string]string `json:",omitempty"`
 	// CustomConfig is anything you want.
 	CustomConfig map[interface{}]interface{} `json:"-"`
 	// TimeKeeper returns the current way to keep time.  You only want to modify this for testing.
 	TimeKeeper TimeKeeper `json:"-"`
+	// RandInt63n returns a random number in [0, n) for any randomized open/close logic, such as sleep window jitter.
+	// It is called concurrently, so it must be thread safe.  It defaults to math/rand's Int63n.  Use NewSeededRand to
+	// make tests reproducible.
+	RandInt63n func(n int64) int64 `json:"-"`
+	// RunTracer, if set, traces each call to Execute.  See the RunTracer interface.
+	RunTracer RunTracer `json:"-"`
+	// BadRequestChecker, if set, can mark errors from runFunc as bad requests, in addition to errors that implement
+	// BadRequest
+	BadRequestChecker BadRequestChecker `json:"-"`
+	// SeverityChecker, if set, can give failures from runFunc a severity, in addition to errors that implement
+	// FailureSeverity
+	SeverityChecker SeverityChecker `json:"-"`
+	// OnStateChange, if set, is called after the circuit opens (isOpen=true) or closes (isOpen=false).  It is called
+	// synchronously from the goroutine that changed the state, but never while holding a circuit lock, so it may call
+	// back into the circuit.
+	OnStateChange func(c *Circuit, isOpen bool) `json:"-"`
+	// Logger, if set, is told when the circuit opens, closes, or allows a half open attempt, and when a burst of
+	// concurrency limit rejections starts and ends.  Nothing is logged for calls that run normally.
+	Logger Logger `json:"-"`
+	// RecentOutcomeCount, if above zero, keeps the last RecentOutcomeCount run events for Circuit.RecentOutcomes.  It is
+	// meant for debug endpoints, so it is off by default.
+	RecentOutcomeCount int `json:",omitempty"`
+	// OnAdmission, if set, is called with the circuit's decision for each call before runFunc could run.  It gets no
+	// durations or errors, so it is cheaper than RunMetrics for counting admissions at high volume.  It is called
+	// synchronously, so it must be fast and safe for concurrent use.
+	OnAdmission func(c *Circuit, decision Admission) `json:"-"`
+	// BudgetFunc, if set, is asked before each call is admitted whether ctx has any request budget left, such as a
+	// remaining RPC budget propagated across services.  Calls it denies are rejected with a *BudgetExhaustedError and
+	// OutcomeBudgetExhausted without calling runFunc or any RunMetrics, so overload elsewhere never opens the
+	// circuit.  The fallback still runs, as it does for short circuits.  It is called synchronously, so it must be
+	// fast and safe for concurrent use.
+	BudgetFunc func(ctx context.Context) (allowed bool) `json:"-"`
+	// ShortCircuitError, if set, makes the error Execute returns for a short circuit, such as a sentinel or a gRPC
+	// status, instead of the default *CircuitOpenError or *PreventedError.  It is only used when no fallback runs:
+	// fallbacks still get the default error.  A hedged fallback is given no error, so if it fails too, the custom error
+	// is the Err of the *FallbackError Execute returns.  ctx is the call's context, so FromContext names the circuit.  Returning
+	// nil keeps the default error.
+	ShortCircuitError func(ctx context.Context) error `json:"-"`
 }
 
 // ExecutionConfig is https://github.com/Netflix/Hystrix/wiki/Configuration#execution
@@ -45,9 +121,36 @@ type ExecutionConfig struct {
 	Timeout time.Duration
 	// MaxConcurrentRequests is https://github.com/Netflix/Hystrix/wiki/Configuration#executionisolationsemaphoremaxconcurrentrequests
 	MaxConcurrentRequests int64
+	// MaxConcurrencyWait, if set, is how long a call waits for a slot when MaxConcurrentRequests are already running,
+	// before being rejected.  Time spent waiting does not count towards Timeout.  By default, calls are rejected
+	// immediately.
+	MaxConcurrencyWait time.Duration `json:",omitempty"`
+	// MaxTimeoutOverride, if set, is the longest timeout a context from WithTimeout can ask for.  Longer or disabled
+	// (<= 0) overrides use MaxTimeoutOverride instead.
+	MaxTimeoutOverride time.Duration `json:",omitempty"`
+	// TotalBudget, if set, is how long runFunc and the fallback may take together.  runFunc's timeout is capped at the
+	// budget, which starts when Execute is called, and the fallback's context ends once the budget is used up, so a
+	// fallback only gets what runFunc left.  This holds even with Fallback.Timeout.
+	TotalBudget time.Duration `json:",omitempty"`
+	// ConcurrencyPool, if set, is a concurrency limit this circuit shares with other circuits.  A call needs a slot in
+	// both MaxConcurrentRequests and the pool to run.
+	ConcurrencyPool *ConcurrencyPool `json:"-"`
+	// ConcurrencyLimiter, if set, decides which calls may run instead of MaxConcurrentRequests, which is then ignored
+	// along with MaxConcurrencyWait and WithMaxConcurrentRequests.  ConcurrentCommands still counts running calls, and
+	// a ConcurrencyPool is still asked for a slot once the limiter admits a call.
+	ConcurrencyLimiter ConcurrencyLimiter `json:"-"`
+	// ReuseContexts pools the contexts Execute makes for each call, so the success path does not allocate.  Only use
+	// it if neither runFunc, the fallback, nor a RunTracer keeps the context, or anything made from it, after Execute
+	// returns: it is reset and given to another call.  Go never reuses contexts, since its goroutines may outlive the
+	// call.  A timeout is only pooled if the context passed to Execute can never be canceled, like
+	// context.Background(): other calls still allocate one.
+	ReuseContexts bool `json:",omitempty"`
 	// Normally if the parent context is canceled before a timeout is reached, we don't consider the circuit
 	// unhealth.  Set this to true to consider those circuits unhealthy.
 	IgnoreInterrputs bool `json:",omitempty"`
+	// RecoverPanics turns a panic inside runFunc into a *PanicError.  The panic counts as a failure and the fallback is
+	// called.  By default, panics crash the program like they would without a circuit.
+	RecoverPanics bool `json:",omitempty"`
 }
 
 // FallbackConfig is https://github.com/Netflix/Hystrix/wiki/Configuration#fallback
@@ -57,6 +160,27 @@ type FallbackConfig struct {
 	Disabled bool `json:",omitempty"`
 	// MaxConcurrentRequests is https://github.com/Netflix/Hystrix/wiki/Configuration#fallback.isolation.semaphore.maxConcurrentRequests
 	MaxConcurrentRequests int64
+	// Timeout, if set, gives the fallback a context that ignores the deadline and cancellation of the context passed to
+	// Execute, and instead times out after this duration.  This lets the fallback run even when the calling context has
+	// already expired.  By default, the fallback uses the context passed to Execute
+	Timeout time.Duration `json:",omitempty"`
+	// Default, if set, is the fallback for Execute calls that do not pass one.  Use it to serve the same degraded
+	// response from every call site.  A fallbackFunc passed to Execute replaces it for that call.
+	Default func(ctx context.Context, err error) error `json:"-"`
+	// Hedge calls the fallback at the same time as runFunc, instead of after runFunc fails, for latency critical reads
+	// from a second source like a cache.  Execute returns the first of the two to succeed and cancels the other's
+	// context, without waiting for it to return.  The fallback's err is nil, since runFunc has not failed yet.  If
+	// both fail, Execute returns a *FallbackError with both errors.  Only runFunc needs a slot under
+	// Execution.MaxConcurrentRequests: the fallback ignores Fallback.MaxConcurrentRequests and is not counted by
+	// ConcurrentFallbacks.  A bad request from runFunc still skips the fallback's result.
+	//
+	// Hedged calls do not keep the usual order of metric callbacks.  FallbackMetrics can be called before runFunc's
+	// outcome, and ExecuteDuration is called when Execute returns, so the loser's outcome may be reported after it.
+	// FallbackMetrics that implement HedgeFallbackMetrics are told which side won.  Hedged calls never reuse contexts,
+	// even with Execution.ReuseContexts, since the losing side may still be running when Execute returns.  For the same
+	// reason, runFunc and the fallback must not write to variables the caller reads after Execute without
+	// synchronization: the loser can write them at any time.  RunWithResult is safe to use with Hedge.
+	Hedge bool `json:",omitempty"`
 }
 
 // MetricsCollectors can receive metrics during a circuit.  They should be fast, as they will
@@ -71,8 +195,38 @@ type MetricsCollectors struct {
 type TimeKeeper struct {
 	// Now should simulate time.Now
 	Now func() time.Time
-	// AfterFunc should simulate time.AfterFunc
+	// AfterFunc should simulate time.AfterFunc.  Execution.Timeout uses it, so a test can end a runFunc's context
+	// right away by firing the timer, instead of sleeping past the timeout.  It may return a nil *time.Timer.
 	AfterFunc func(time.Duration, func()) *time.Timer
+
+	// realAfterFunc is only set by the default TimeKeeper, so a circuit knows AfterFunc is time.AfterFunc without
+	// comparing functions
+	realAfterFunc bool
+}
+
+// TimeKeeperSetter is implemented by open/close logic that keeps time on its own, for example with timers.  Circuits
+// pass their TimeKeeper to any ClosedToOpen or OpenToClosed that implements it, so a mocked clock reaches them too.
+type TimeKeeperSetter interface {
+	// SetTimeKeeper is called once, while the circuit is being configured.  Nil fields should be ignored.
+	SetTimeKeeper(t TimeKeeper)
+}
+
+// RandSetter is implemented by open/close logic that uses randomness.  Circuits pass their RandInt63n to any
+// ClosedToOpen or OpenToClosed that implements it.
+type RandSetter interface {
+	// SetRand is called once, while the circuit is being configured
+	SetRand(int63n func(n int64) int64)
+}
+
+// NewSeededRand returns a thread safe RandInt63n with a fixed seed, so randomized logic repeats between runs
+func NewSeededRand(seed int64) func(n int64) int64 {
+	var mu sync.Mutex
+	r := rand.New(rand.NewSource(seed))
+	return func(n int64) int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return r.Int63n(n)
+	}
 }
 
 // Configurable is anything that can receive configuration changes while live
@@ -85,12 +239,211 @@ type Configurable interface {
 	SetConfigNotThreadSafe(props Config)
 }
 
+// Logger receives lines about notable circuit events.  *log.Logger implements it.  It is called synchronously from the
+// goroutine that caused the event, so it should not block.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Reconfigurable is implemented by open/close logic that is configured by its factory, rather than by Config.
+// Manager.ReconfigureAll calls the new factory and passes the logic it returns to ReconfigureFrom, which should copy
+// over any settings that are safe to change live while keeping the stats it has accumulated.  The new logic is then
+// thrown away.
+type Reconfigurable interface {
+	// ReconfigureFrom can be called while the circuit is being used.  It should ignore logic of a type it does not know.
+	ReconfigureFrom(fresh interface{})
+}
+
+// Validator is implemented by configuration, and by open/close logic, that can check itself for mistakes.  Manager
+// calls it when ValidateConfigs is set.
+type Validator interface {
+	// Validate returns every problem found, or nil if there are none
+	Validate() []error
+}
+
+var _ Validator = Config{}
+
+// Validate returns every problem with the config, instead of stopping at the first one.  Zero values are fine: they
+// are filled by defaults.  So are a negative Execution.Timeout and negative MaxConcurrentRequests, which turn those
+// limits off.
+// Open/close logic is configured by its factories, so it is not checked here.
+func (c Config) Validate() []error {
+	var errs []error
+	notNegative := func(field string, d time.Duration) {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative: %s", field, d))
+		}
+	}
+	notNegative("Execution.MaxConcurrencyWait", c.Execution.MaxConcurrencyWait)
+	notNegative("Execution.MaxTimeoutOverride", c.Execution.MaxTimeoutOverride)
+	notNegative("Execution.TotalBudget", c.Execution.TotalBudget)
+	notNegative("Fallback.Timeout", c.Fallback.Timeout)
+	notNegative("General.MinimumOpenDuration", c.General.MinimumOpenDuration)
+	notNegative("General.WarmupDuration", c.General.WarmupDuration)
+	notNegative("General.CloseGracePeriod", c.General.CloseGracePeriod)
+	notNegative("General.MaxReportedDuration", c.General.MaxReportedDuration)
+	if c.General.CloseGraceErrors < 0 {
+		errs = append(errs, fmt.Errorf("General.CloseGraceErrors must not be negative: %d", c.General.CloseGraceErrors))
+	}
+	if c.General.RecentOutcomeCount < 0 {
+		errs = append(errs, fmt.Errorf("General.RecentOutcomeCount must not be negative: %d", c.General.RecentOutcomeCount))
+	}
+	if c.General.ForceOpen && c.General.ForcedClosed {
+		errs = append(errs, errors.New("General.ForceOpen and General.ForcedClosed cannot both be set"))
+	}
+	return errs
+}
+
+// jsonDuration is a time.Duration that is written to JSON as a string like "250ms"
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var nanoseconds int64
+	if err := json.Unmarshal(b, &nanoseconds); err == nil {
+		*d = jsonDuration(nanoseconds)
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string or a number of nanoseconds: %s", b)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(parsed)
+	return nil
+}
+
+// MarshalJSON writes durations as strings like "250ms"
+func (g GeneralConfig) MarshalJSON() ([]byte, error) {
+	type plain GeneralConfig
+	return json.Marshal(struct {
+		plain
+		WarmupDuration      jsonDuration `json:",omitempty"`
+		MinimumOpenDuration jsonDuration `json:",omitempty"`
+		CloseGracePeriod    jsonDuration `json:",omitempty"`
+		MaxReportedDuration jsonDuration `json:",omitempty"`
+	}{
+		plain:               plain(g),
+		WarmupDuration:      jsonDuration(g.WarmupDuration),
+		MinimumOpenDuration: jsonDuration(g.MinimumOpenDuration),
+		CloseGracePeriod:    jsonDuration(g.CloseGracePeriod),
+		MaxReportedDuration: jsonDuration(g.MaxReportedDuration),
+	})
+}
+
+// UnmarshalJSON accepts durations as strings like "250ms" or numbers of nanoseconds
+func (g *GeneralConfig) UnmarshalJSON(b []byte) error {
+	type plain GeneralConfig
+	into := struct {
+		*plain
+		WarmupDuration      jsonDuration
+		MinimumOpenDuration jsonDuration
+		CloseGracePeriod    jsonDuration
+		MaxReportedDuration jsonDuration
+	}{
+		plain:               (*plain)(g),
+		WarmupDuration:      jsonDuration(g.WarmupDuration),
+		MinimumOpenDuration: jsonDuration(g.MinimumOpenDuration),
+		CloseGracePeriod:    jsonDuration(g.CloseGracePeriod),
+		MaxReportedDuration: jsonDuration(g.MaxReportedDuration),
+	}
+	if err := json.Unmarshal(b, &into); err != nil {
+		return err
+	}
+	g.WarmupDuration = time.Duration(into.WarmupDuration)
+	g.MinimumOpenDuration = time.Duration(into.MinimumOpenDuration)
+	g.CloseGracePeriod = time.Duration(into.CloseGracePeriod)
+	g.MaxReportedDuration = time.Duration(into.MaxReportedDuration)
+	return nil
+}
+
+// MarshalJSON writes durations as strings like "250ms"
+func (c ExecutionConfig) MarshalJSON() ([]byte, error) {
+	type plain ExecutionConfig
+	return json.Marshal(struct {
+		plain
+		Timeout            jsonDuration
+		MaxConcurrencyWait jsonDuration `json:",omitempty"`
+		MaxTimeoutOverride jsonDuration `json:",omitempty"`
+		TotalBudget        jsonDuration `json:",omitempty"`
+	}{
+		plain:              plain(c),
+		Timeout:            jsonDuration(c.Timeout),
+		MaxConcurrencyWait: jsonDuration(c.MaxConcurrencyWait),
+		MaxTimeoutOverride: jsonDuration(c.MaxTimeoutOverride),
+		TotalBudget:        jsonDuration(c.TotalBudget),
+	})
+}
+
+// UnmarshalJSON accepts durations as strings like "250ms" or numbers of nanoseconds
+func (c *ExecutionConfig) UnmarshalJSON(b []byte) error {
+	type plain ExecutionConfig
+	into := struct {
+		*plain
+		Timeout            jsonDuration
+		MaxConcurrencyWait jsonDuration
+		MaxTimeoutOverride jsonDuration
+		TotalBudget        jsonDuration
+	}{
+		plain:              (*plain)(c),
+		Timeout:            jsonDuration(c.Timeout),
+		MaxConcurrencyWait: jsonDuration(c.MaxConcurrencyWait),
+		MaxTimeoutOverride: jsonDuration(c.MaxTimeoutOverride),
+		TotalBudget:        jsonDuration(c.TotalBudget),
+	}
+	if err := json.Unmarshal(b, &into); err != nil {
+		return err
+	}
+	c.Timeout = time.Duration(into.Timeout)
+	c.MaxConcurrencyWait = time.Duration(into.MaxConcurrencyWait)
+	c.MaxTimeoutOverride = time.Duration(into.MaxTimeoutOverride)
+	c.TotalBudget = time.Duration(into.TotalBudget)
+	return nil
+}
+
+// MarshalJSON writes Timeout as a string like "250ms"
+func (c FallbackConfig) MarshalJSON() ([]byte, error) {
+	type plain FallbackConfig
+	return json.Marshal(struct {
+		plain
+		Timeout jsonDuration `json:",omitempty"`
+	}{plain: plain(c), Timeout: jsonDuration(c.Timeout)})
+}
+
+// UnmarshalJSON accepts Timeout as a string like "250ms" or a number of nanoseconds
+func (c *FallbackConfig) UnmarshalJSON(b []byte) error {
+	type plain FallbackConfig
+	into := struct {
+		*plain
+		Timeout jsonDuration
+	}{plain: (*plain)(c), Timeout: jsonDuration(c.Timeout)}
+	if err := json.Unmarshal(b, &into); err != nil {
+		return err
+	}
+	c.Timeout = time.Duration(into.Timeout)
+	return nil
+}
+
+var _ json.Marshaler = GeneralConfig{}
+var _ json.Unmarshaler = &GeneralConfig{}
+var _ json.Marshaler = ExecutionConfig{}
+var _ json.Unmarshaler = &ExecutionConfig{}
+var _ json.Marshaler = FallbackConfig{}
+var _ json.Unmarshaler = &FallbackConfig{}
+
 func (t *TimeKeeper) merge(other TimeKeeper) {
 	if t.Now == nil {
 		t.Now = other.Now
 	}
 	if t.AfterFunc == nil {
 		t.AfterFunc = other.AfterFunc
+		t.realAfterFunc = other.realAfterFunc
 	}
 }
 
@@ -98,6 +451,27 @@ func (c *ExecutionConfig) merge(other ExecutionConfig) {
 	if !c.IgnoreInterrputs {
 		c.IgnoreInterrputs = other.IgnoreInterrputs
 	}
+	if !c.RecoverPanics {
+		c.RecoverPanics = other.RecoverPanics
+	}
+	if !c.ReuseContexts {
+		c.ReuseContexts = other.ReuseContexts
+	}
+	if c.MaxConcurrencyWait == 0 {
+		c.MaxConcurrencyWait = other.MaxConcurrencyWait
+	}
+	if c.MaxTimeoutOverride == 0 {
+		c.MaxTimeoutOverride = other.MaxTimeoutOverride
+	}
+	if c.TotalBudget == 0 {
+		c.TotalBudget = other.TotalBudget
+	}
+	if c.ConcurrencyPool == nil {
+		c.ConcurrencyPool = other.ConcurrencyPool
+	}
+	if c.ConcurrencyLimiter == nil {
+		c.ConcurrencyLimiter = other.ConcurrencyLimiter
+	}
 	if c.MaxConcurrentRequests == 0 {
 		c.MaxConcurrentRequests = other.MaxConcurrentRequests
 	}
@@ -113,6 +487,15 @@ func (c *FallbackConfig) merge(other FallbackConfig) {
 	if !c.Disabled {
 		c.Disabled = other.Disabled
 	}
+	if c.Timeout == 0 {
+		c.Timeout = other.Timeout
+	}
+	if c.Default == nil {
+		c.Default = other.Default
+	}
+	if !c.Hedge {
+		c.Hedge = other.Hedge
+	}
 }
 
 func (g *GeneralConfig) mergeCustomConfig(other GeneralConfig) {
@@ -128,7 +511,50 @@ func (g *GeneralConfig) mergeCustomConfig(other GeneralConfig) {
 	}
 }
 
+func (g *GeneralConfig) mergeLabels(other GeneralConfig) {
+	if len(other.Labels) != 0 {
+		if g.Labels == nil {
+			g.Labels = make(map[string]string, len(other.Labels))
+		}
+		for k, v := range other.Labels {
+			if _, exists := g.Labels[k]; !exists {
+				g.Labels[k] = v
+			}
+		}
+	}
+}
+
 func (g *GeneralConfig) merge(other GeneralConfig) {
+	if !g.Disabled {
+		g.Disabled = other.Disabled
+	}
+	if !g.ForceOpen {
+		g.ForceOpen = other.ForceOpen
+	}
+	if !g.ForcedClosed {
+		g.ForcedClosed = other.ForcedClosed
+	}
+	if !g.DryRun {
+		g.DryRun = other.DryRun
+	}
+	if !g.ManualClose {
+		g.ManualClose = other.ManualClose
+	}
+	if g.MinimumOpenDuration == 0 {
+		g.MinimumOpenDuration = other.MinimumOpenDuration
+	}
+	if g.WarmupDuration == 0 {
+		g.WarmupDuration = other.WarmupDuration
+	}
+	if g.CloseGracePeriod == 0 {
+		g.CloseGracePeriod = other.CloseGracePeriod
+	}
+	if g.CloseGraceErrors == 0 {
+		g.CloseGraceErrors = other.CloseGraceErrors
+	}
+	if g.MaxReportedDuration == 0 {
+		g.MaxReportedDuration = other.MaxReportedDuration
+	}
 	if g.ClosedToOpenFactory == nil {
 		g.ClosedToOpenFactory = other.ClosedToOpenFactory
 	}
@@ -136,10 +562,41 @@ func (g *GeneralConfig) merge(other GeneralConfig) {
 		g.OpenToClosedFactory = other.OpenToClosedFactory
 	}
 	g.mergeCustomConfig(other)
+	g.mergeLabels(other)
 
 	if g.GoLostErrors == nil {
 		g.GoLostErrors = other.GoLostErrors
 	}
+	if g.RunTracer == nil {
+		g.RunTracer = other.RunTracer
+	}
+	if g.BadRequestChecker == nil {
+		g.BadRequestChecker = other.BadRequestChecker
+	}
+	if g.SeverityChecker == nil {
+		g.SeverityChecker = other.SeverityChecker
+	}
+	if g.OnStateChange == nil {
+		g.OnStateChange = other.OnStateChange
+	}
+	if g.Logger == nil {
+		g.Logger = other.Logger
+	}
+	if g.OnAdmission == nil {
+		g.OnAdmission = other.OnAdmission
+	}
+	if g.BudgetFunc == nil {
+		g.BudgetFunc = other.BudgetFunc
+	}
+	if g.ShortCircuitError == nil {
+		g.ShortCircuitError = other.ShortCircuitError
+	}
+	if g.RecentOutcomeCount == 0 {
+		g.RecentOutcomeCount = other.RecentOutcomeCount
+	}
+	if g.RandInt63n == nil {
+		g.RandInt63n = other.RandInt63n
+	}
 	g.TimeKeeper.merge(other.TimeKeeper)
 }
 
@@ -150,7 +607,8 @@ func (m *MetricsCollectors) merge(other MetricsCollectors) {
 }
 
 // Merge these properties with another command's properties.  Anything set to the zero value, will takes values from
-// other.
+// other.  Go cannot tell an unset field from one set to the zero value, so zero always means "inherit": to overlay
+// overrides onto a base config, call override.Merge(base).  Metric collectors are appended, not replaced.
 func (c *Config) Merge(other Config) *Config {
 	c.Execution.merge(other.Execution)
 	c.Fallback.merge(other.Fallback)
@@ -165,15 +623,29 @@ type atomicCircuitConfig struct {
 	Execution struct {
 		ExecutionTimeout      faststats.AtomicInt64
 		MaxConcurrentRequests faststats.AtomicInt64
+		MaxConcurrencyWait    faststats.AtomicInt64
+		MaxTimeoutOverride    faststats.AtomicInt64
+		TotalBudget           faststats.AtomicInt64
+		RecoverPanics         faststats.AtomicBoolean
+		ReuseContexts         faststats.AtomicBoolean
 	}
 	Fallback struct {
 		Disabled              faststats.AtomicBoolean
 		MaxConcurrentRequests faststats.AtomicInt64
+		Timeout               faststats.AtomicInt64
+		Hedge                 faststats.AtomicBoolean
 	}
 	CircuitBreaker struct {
-		ForceOpen    faststats.AtomicBoolean
-		ForcedClosed faststats.AtomicBoolean
-		Disabled     faststats.AtomicBoolean
+		ForceOpen           faststats.AtomicBoolean
+		ForcedClosed        faststats.AtomicBoolean
+		Disabled            faststats.AtomicBoolean
+		DryRun              faststats.AtomicBoolean
+		ManualClose         faststats.AtomicBoolean
+		WarmupDuration      faststats.AtomicInt64
+		MinimumOpenDuration faststats.AtomicInt64
+		CloseGracePeriod    faststats.AtomicInt64
+		CloseGraceErrors    faststats.AtomicInt64
+		MaxReportedDuration faststats.AtomicInt64
 	}
 	GoSpecific struct {
 		IgnoreInterrputs faststats.AtomicBoolean
@@ -184,14 +656,57 @@ func (a *atomicCircuitConfig) reset(config Config) {
 	a.CircuitBreaker.ForcedClosed.Set(config.General.ForcedClosed)
 	a.CircuitBreaker.ForceOpen.Set(config.General.ForceOpen)
 	a.CircuitBreaker.Disabled.Set(config.General.Disabled)
+	a.CircuitBreaker.DryRun.Set(config.General.DryRun)
+	a.CircuitBreaker.ManualClose.Set(config.General.ManualClose)
+	a.CircuitBreaker.WarmupDuration.Set(config.General.WarmupDuration.Nanoseconds())
+	a.CircuitBreaker.MinimumOpenDuration.Set(config.General.MinimumOpenDuration.Nanoseconds())
+	a.CircuitBreaker.CloseGracePeriod.Set(config.General.CloseGracePeriod.Nanoseconds())
+	a.CircuitBreaker.CloseGraceErrors.Set(config.General.CloseGraceErrors)
+	a.CircuitBreaker.MaxReportedDuration.Set(config.General.MaxReportedDuration.Nanoseconds())
 
 	a.Execution.ExecutionTimeout.Set(config.Execution.Timeout.Nanoseconds())
 	a.Execution.MaxConcurrentRequests.Set(config.Execution.MaxConcurrentRequests)
+	a.Execution.MaxConcurrencyWait.Set(config.Execution.MaxConcurrencyWait.Nanoseconds())
+	a.Execution.MaxTimeoutOverride.Set(config.Execution.MaxTimeoutOverride.Nanoseconds())
+	a.Execution.TotalBudget.Set(config.Execution.TotalBudget.Nanoseconds())
+	a.Execution.RecoverPanics.Set(config.Execution.RecoverPanics)
+	a.Execution.ReuseContexts.Set(config.Execution.ReuseContexts)
 
 	a.GoSpecific.IgnoreInterrputs.Set(config.Execution.IgnoreInterrputs)
 
 	a.Fallback.Disabled.Set(config.Fallback.Disabled)
 	a.Fallback.MaxConcurrentRequests.Set(config.Fallback.MaxConcurrentRequests)
+	a.Fallback.Timeout.Set(config.Fallback.Timeout.Nanoseconds())
+	a.Fallback.Hedge.Set(config.Fallback.Hedge)
+}
+
+// setLiveFields copies the fields reset reads from other, leaving the fields that cannot change while a circuit is
+// running as they are
+func (c *Config) setLiveFields(other Config) {
+	c.General.ForcedClosed = other.General.ForcedClosed
+	c.General.ForceOpen = other.General.ForceOpen
+	c.General.Disabled = other.General.Disabled
+	c.General.DryRun = other.General.DryRun
+	c.General.ManualClose = other.General.ManualClose
+	c.General.WarmupDuration = other.General.WarmupDuration
+	c.General.MinimumOpenDuration = other.General.MinimumOpenDuration
+	c.General.CloseGracePeriod = other.General.CloseGracePeriod
+	c.General.CloseGraceErrors = other.General.CloseGraceErrors
+	c.General.MaxReportedDuration = other.General.MaxReportedDuration
+
+	c.Execution.Timeout = other.Execution.Timeout
+	c.Execution.MaxConcurrentRequests = other.Execution.MaxConcurrentRequests
+	c.Execution.MaxConcurrencyWait = other.Execution.MaxConcurrencyWait
+	c.Execution.MaxTimeoutOverride = other.Execution.MaxTimeoutOverride
+	c.Execution.TotalBudget = other.Execution.TotalBudget
+	c.Execution.RecoverPanics = other.Execution.RecoverPanics
+	c.Execution.ReuseContexts = other.Execution.ReuseContexts
+	c.Execution.IgnoreInterrputs = other.Execution.IgnoreInterrputs
+
+	c.Fallback.Disabled = other.Fallback.Disabled
+	c.Fallback.MaxConcurrentRequests = other.Fallback.MaxConcurrentRequests
+	c.Fallback.Timeout = other.Fallback.Timeout
+	c.Fallback.Hedge = other.Fallback.Hedge
 }
 
 var defaultExecutionConfig = ExecutionConfig{
@@ -207,9 +722,11 @@ var defaultGoSpecificConfig = GeneralConfig{
 	ClosedToOpenFactory: neverOpensFactory,
 	OpenToClosedFactory: neverClosesFactory,
 	TimeKeeper: TimeKeeper{
-		Now:       time.Now,
-		AfterFunc: time.AfterFunc,
+		Now:           time.Now,
+		AfterFunc:     time.AfterFunc,
+		realAfterFunc: true,
 	},
+	RandInt63n: rand.Int63n,
 }
 
 var defaultCommandProperties = Config{
@@ -0,0 +1,176 @@
+package circuit
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConfig_MergePartialOverride(t *testing.T) {
+	base := Config{
+		Execution: ExecutionConfig{
+			Timeout:               time.Second,
+			MaxConcurrentRequests: 10,
+		},
+		Fallback: FallbackConfig{
+			MaxConcurrentRequests: 5,
+		},
+		General: GeneralConfig{
+			ForceOpen: true,
+		},
+	}
+	override := Config{
+		Execution: ExecutionConfig{
+			Timeout: time.Millisecond,
+		},
+		Fallback: FallbackConfig{
+			Disabled: true,
+		},
+	}
+	override.Merge(base)
+	if override.Execution.Timeout != time.Millisecond {
+		t.Error("override timeout should win", override.Execution.Timeout)
+	}
+	if override.Execution.MaxConcurrentRequests != 10 {
+		t.Error("unset fields should come from base", override.Execution.MaxConcurrentRequests)
+	}
+	if override.Fallback.MaxConcurrentRequests != 5 || !override.Fallback.Disabled {
+		t.Error("fallback should merge both configs", override.Fallback)
+	}
+	if !override.General.ForceOpen {
+		t.Error("general flags should come from base")
+	}
+}
+
+func TestConfig_MergeAppendsMetrics(t *testing.T) {
+	base := Config{
+		Metrics: MetricsCollectors{
+			Circuit: []Metrics{&countingCircuitMetrics{}},
+		},
+	}
+	override := Config{
+		Metrics: MetricsCollectors{
+			Circuit: []Metrics{&countingCircuitMetrics{}},
+		},
+	}
+	override.Merge(base)
+	if len(override.Metrics.Circuit) != 2 {
+		t.Error("expect metric collectors from both configs", len(override.Metrics.Circuit))
+	}
+}
+
+func TestConfig_JSONRoundTrip(t *testing.T) {
+	original := Config{
+		General: GeneralConfig{
+			ForceOpen:      true,
+			DryRun:         true,
+			WarmupDuration: 5 * time.Second,
+		},
+		Execution: ExecutionConfig{
+			Timeout:               250 * time.Millisecond,
+			MaxConcurrentRequests: 12,
+			RecoverPanics:         true,
+		},
+		Fallback: FallbackConfig{
+			Disabled:              true,
+			MaxConcurrentRequests: 3,
+			Timeout:               time.Minute,
+		},
+	}
+	b, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{`"Timeout":"250ms"`, `"WarmupDuration":"5s"`, `"Timeout":"1m0s"`} {
+		if !strings.Contains(string(b), expected) {
+			t.Errorf("expected %s in %s", expected, b)
+		}
+	}
+	var decoded Config
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, original) {
+		t.Errorf("config did not round trip: %+v != %+v", decoded, original)
+	}
+}
+
+func TestConfig_UnmarshalJSON(t *testing.T) {
+	var cfg Config
+	err := json.Unmarshal([]byte(`{"Execution": {"Timeout": 1000, "MaxConcurrentRequests": 4}, "Fallback": {"Timeout": "2s"}}`), &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Execution.Timeout != time.Microsecond || cfg.Execution.MaxConcurrentRequests != 4 {
+		t.Error("expected numbers to be read as nanoseconds", cfg.Execution)
+	}
+	if cfg.Fallback.Timeout != 2*time.Second {
+		t.Error("expected strings to be parsed as durations", cfg.Fallback)
+	}
+	if err := json.Unmarshal([]byte(`{"Execution": {"Timeout": "soon"}}`), &cfg); err == nil {
+		t.Error("expected an invalid duration to fail")
+	}
+}
+
+func TestNewSeededRand(t *testing.T) {
+	a := NewSeededRand(3)
+	b := NewSeededRand(3)
+	for i := 0; i < 10; i++ {
+		if a(1000) != b(1000) {
+			t.Fatal("expected the same seed to give the same numbers")
+		}
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if n := a(10); n < 0 || n >= 10 {
+					t.Error("out of range", n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestConfig_Validate(t *testing.T) {
+	if errs := (Config{}).Validate(); len(errs) != 0 {
+		t.Error("expected the zero config to be valid", errs)
+	}
+	disabledLimits := Config{
+		Execution: ExecutionConfig{
+			Timeout:               -1,
+			MaxConcurrentRequests: -1,
+		},
+	}
+	if errs := disabledLimits.Validate(); len(errs) != 0 {
+		t.Error("expected negative limits to be valid", errs)
+	}
+	errs := Config{
+		Execution: ExecutionConfig{
+			MaxConcurrencyWait: -time.Second,
+		},
+		Fallback: FallbackConfig{
+			Timeout: -time.Second,
+		},
+		General: GeneralConfig{
+			ForceOpen:          true,
+			ForcedClosed:       true,
+			RecentOutcomeCount: -1,
+		},
+	}.Validate()
+	expected := []string{"Execution.MaxConcurrencyWait", "Fallback.Timeout", "General.RecentOutcomeCount", "General.ForceOpen"}
+	if len(errs) != len(expected) {
+		t.Fatal("expected every problem to be reported", errs)
+	}
+	for i, field := range expected {
+		if !strings.HasPrefix(errs[i].Error(), field) {
+			t.Errorf("expected problem %d to be about %s: %s", i, field, errs[i])
+		}
+	}
+}
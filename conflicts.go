@@ -0,0 +1,149 @@
+package circuit
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// configSource is a config returned by one of a Manager's constructors, and the name of that constructor
+type configSource struct {
+	name   string
+	config Config
+}
+
+// configConflicts finds fields that more than one source sets to different values.  sources must be in the order
+// they are merged, so the first source to set a field is the one whose value is used.  Functions, slices, and other
+// values that cannot be compared, like metric collectors, are never conflicts.  Maps are compared key by key.
+func configConflicts(sources []configSource) []error {
+	type setBy struct {
+		source string
+		value  interface{}
+	}
+	seen := make(map[string]setBy)
+	var errs []error
+	for _, s := range sources {
+		flattenConfig("", reflect.ValueOf(s.config), func(path string, value interface{}) {
+			first, exists := seen[path]
+			if !exists {
+				seen[path] = setBy{source: s.name, value: value}
+				return
+			}
+			if first.value != value {
+				errs = append(errs, fmt.Errorf("%s sets %s to %v, but %s already set it to %v, which is used", s.name, path, value, first.source, first.value))
+			}
+		})
+	}
+	return errs
+}
+
+// flattenConfig calls set with the path and value of every comparable, non zero field under v
+func flattenConfig(path string, v reflect.Value, set func(path string, value interface{})) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			fieldPath := field.Name
+			if path != "" {
+				fieldPath = path + "." + field.Name
+			}
+			flattenConfig(fieldPath, v.Field(i), set)
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			value := v.MapIndex(key)
+			if comparableValue(key) && comparableValue(value) && !zeroValue(value) {
+				set(fmt.Sprintf("%s[%v]", path, key.Interface()), value.Interface())
+			}
+		}
+	default:
+		if comparableValue(v) && !zeroValue(v) {
+			set(path, v.Interface())
+		}
+	}
+}
+
+// configCovers is true if every field other sets is already set to the same value in stored, so merging other into
+// stored would change nothing.  Slices, like metric collectors, match if each of other's elements is one of stored's.
+// Functions, and other values that cannot be compared, only match when other leaves them unset.
+func configCovers(stored Config, other Config) bool {
+	return valueCovers(reflect.ValueOf(stored), reflect.ValueOf(other))
+}
+
+func valueCovers(stored reflect.Value, other reflect.Value) bool {
+	switch other.Kind() {
+	case reflect.Struct:
+		for i := 0; i < other.NumField(); i++ {
+			if other.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if !valueCovers(stored.Field(i), other.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		for _, key := range other.MapKeys() {
+			value := stored.MapIndex(key)
+			if !value.IsValid() || !valueCovers(value, other.MapIndex(key)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		for i := 0; i < other.Len(); i++ {
+			if !sliceContains(stored, other.Index(i)) {
+				return false
+			}
+		}
+		return true
+	}
+	if !comparableValue(other) {
+		return nilValue(other)
+	}
+	return zeroValue(other) || other.Interface() == stored.Interface()
+}
+
+// sliceContains is true if v, which must be comparable, is == to an element of slice
+func sliceContains(slice reflect.Value, v reflect.Value) bool {
+	if !comparableValue(v) {
+		return false
+	}
+	for i := 0; i < slice.Len(); i++ {
+		if comparableValue(slice.Index(i)) && slice.Index(i).Interface() == v.Interface() {
+			return true
+		}
+	}
+	return false
+}
+
+// nilValue is true if v is a nil function, interface, map, pointer, or slice
+func nilValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice, reflect.Chan:
+		return v.IsNil()
+	}
+	return false
+}
+
+// comparableValue is true if v can be compared with ==.  Interfaces are judged by the value they hold.
+func comparableValue(v reflect.Value) bool {
+	if !v.CanInterface() {
+		return false
+	}
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return false
+		}
+		v = v.Elem()
+	}
+	return v.Kind() != reflect.Func && v.Type().Comparable()
+}
+
+// zeroValue is true if v, which must be comparable, is its type's zero value.  It is reflect.Value.IsZero, which is
+// not available before Go 1.13.
+func zeroValue(v reflect.Value) bool {
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
@@ -0,0 +1,448 @@
+package circuit
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cep21/circuit/faststats"
+)
+
+type contextKey int
+
+const (
+	maxConcurrentRequestsKey contextKey = iota
+	withoutFallbackKey
+	timeoutKey
+	circuitNameKey
+	outcomeLabelKey
+	withoutProbeKey
+	withoutMetricsKey
+	weightKey
+	nestedBadRequestKey
+	// overridesKey is set by every per-call override, so one lookup tells if a context has any
+	overridesKey
+)
+
+// isOverride is true for the keys of per-call overrides, which only apply to the circuit they are given to
+func (k contextKey) isOverride() bool {
+	switch k {
+	case maxConcurrentRequestsKey, withoutFallbackKey, timeoutKey, withoutProbeKey, withoutMetricsKey, weightKey, overridesKey:
+		return true
+	}
+	return false
+}
+
+// overrideContext holds one per-call override
+type overrideContext struct {
+	context.Context
+	key   contextKey
+	value interface{}
+}
+
+// withOverride returns ctx with a per-call override
+func withOverride(ctx context.Context, key contextKey, value interface{}) context.Context {
+	return &overrideContext{Context: ctx, key: key, value: value}
+}
+
+// Value is the override for its key, true for overridesKey, and the parent's value for anything else
+func (o *overrideContext) Value(key interface{}) interface{} {
+	if key == o.key {
+		return o.value
+	}
+	if key == overridesKey {
+		return true
+	}
+	return o.Context.Value(key)
+}
+
+// withoutOverridesContext hides every per-call override of its parent
+type withoutOverridesContext struct {
+	context.Context
+}
+
+// Value is nil for per-call overrides, and the parent's value for anything else
+func (w withoutOverridesContext) Value(key interface{}) interface{} {
+	if k, ok := key.(contextKey); ok && k.isOverride() {
+		return nil
+	}
+	return w.Context.Value(key)
+}
+
+// withoutOverrides hides the per-call overrides of ctx, like WithTimeout and WithoutFallback, from circuits called
+// with it.  Each circuit passes it to runFunc and the fallback, so overrides never apply to nested circuits.
+func withoutOverrides(ctx context.Context) context.Context {
+	if ctx.Value(overridesKey) == nil {
+		return ctx
+	}
+	return withoutOverridesContext{Context: ctx}
+}
+
+// WithMaxConcurrentRequests returns a context that overrides the circuit's Execution.MaxConcurrentRequests for
+// Execute calls made with it.  Use this to let a few important calls through even when normal traffic has reached the
+// concurrency limit.  The override replaces the limit, so a lower value rejects calls the circuit would allow.  Calls
+// made with the override still count towards the circuit's concurrency.
+//
+// Like every With and Without helper of this package, the override only applies to the circuit it is given to: the
+// contexts passed to runFunc and the fallback do not carry it, so circuits called from them, including the secondary
+// of WrapFallback, keep their own limits.
+func WithMaxConcurrentRequests(ctx context.Context, maxConcurrentRequests int64) context.Context {
+	return withOverride(ctx, maxConcurrentRequestsKey, maxConcurrentRequests)
+}
+
+func maxConcurrentRequestsFromContext(ctx context.Context) (int64, bool) {
+	ret, ok := ctx.Value(maxConcurrentRequestsKey).(int64)
+	return ret, ok
+}
+
+// WithWeight returns a context whose Execute calls ask Execution.ConcurrencyLimiter for weight instead of 1, such as
+// an estimate of their cost.  It does nothing for circuits without a ConcurrencyLimiter.  Circuits called from runFunc
+// or the fallback do not see the weight, and ask their own limiters for 1.
+func WithWeight(ctx context.Context, weight int64) context.Context {
+	return withOverride(ctx, weightKey, weight)
+}
+
+// weightFromContext is the weight set by WithWeight, or 1
+func weightFromContext(ctx context.Context) int64 {
+	if ret, ok := ctx.Value(weightKey).(int64); ok {
+		return ret
+	}
+	return 1
+}
+
+// WithoutFallback returns a context that makes Execute skip the fallback function and return runFunc's error (or
+// the circuit open error) unchanged.  Skipped fallbacks are reported to FallbackMetrics that implement
+// FallbackSkippedMetrics.  Only the circuit given the context skips its fallback: circuits called from runFunc still
+// call theirs.
+func WithoutFallback(ctx context.Context) context.Context {
+	return withOverride(ctx, withoutFallbackKey, true)
+}
+
+func withoutFallbackFromContext(ctx context.Context) bool {
+	ret, _ := ctx.Value(withoutFallbackKey).(bool)
+	return ret
+}
+
+// WithTimeout returns a context that overrides the circuit's Execution.Timeout for Execute calls made with it.  The
+// override is clamped to Execution.MaxTimeoutOverride, if that is set.  Use it for the few calls that need a longer
+// budget than the rest of the circuit.  Circuits called from runFunc or the fallback keep their own Execution.Timeout,
+// though still bound by the deadline of the context they are given.
+func WithTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return withOverride(ctx, timeoutKey, timeout)
+}
+
+func timeoutFromContext(ctx context.Context) (time.Duration, bool) {
+	ret, ok := ctx.Value(timeoutKey).(time.Duration)
+	return ret, ok
+}
+
+// WithoutProbe returns a context whose Execute calls are never chosen as the half open attempt of an open circuit.
+// They are short circuited until the circuit closes, leaving the risk of probing to calls without it, such as
+// background traffic.  OpenToClosed is not asked to allow them, so they do not use up its attempts.  Circuits called
+// from runFunc or the fallback may still pick the call as their half open attempt.
+func WithoutProbe(ctx context.Context) context.Context {
+	return withOverride(ctx, withoutProbeKey, true)
+}
+
+func withoutProbeFromContext(ctx context.Context) bool {
+	ret, _ := ctx.Value(withoutProbeKey).(bool)
+	return ret
+}
+
+// WithoutMetrics returns a context whose Execute calls are not counted, for synthetic checks like health probes that
+// should exercise a dependency without affecting the circuit.  They still need a concurrency slot and follow the
+// timeout, but nothing is reported to the circuit's run metrics, so rolling stats and the open/close logic never
+// see them.  They are short circuited while the circuit is open, and are never the half open attempt, like calls
+// made WithoutProbe.  Fallback metrics are still reported.  Circuits called from runFunc or the fallback count the call
+// as usual: wrap their contexts with WithoutMetrics too if they should not.
+func WithoutMetrics(ctx context.Context) context.Context {
+	return withOverride(ctx, withoutMetricsKey, true)
+}
+
+func withoutMetricsFromContext(ctx context.Context) bool {
+	ret, _ := ctx.Value(withoutMetricsKey).(bool)
+	return ret
+}
+
+// FromContext returns the name of the circuit whose runFunc or fallbackFunc is running with ctx, or "" outside of a
+// circuit.  Inside nested circuits, it is the innermost one.  Use it to tag log lines.  Disabled circuits do not set it.
+func FromContext(ctx context.Context) string {
+	ret, _ := ctx.Value(circuitNameKey).(string)
+	return ret
+}
+
+func withCircuitName(ctx context.Context, name interface{}) *nameContext {
+	return &nameContext{Context: ctx, name: name}
+}
+
+// SetOutcomeLabel labels the outcome of the runFunc running with ctx, such as which of several dependencies behind one
+// circuit failed.  Collectors that implement OutcomeLabelMetrics then break the circuit's metrics down by label, while
+// the circuit still opens and closes on every outcome together.  The last label set before runFunc returns is used.  It
+// does nothing outside a runFunc, or if no run collector implements OutcomeLabelMetrics.
+func SetOutcomeLabel(ctx context.Context, label string) {
+	if l, ok := ctx.Value(outcomeLabelKey).(*outcomeLabel); ok {
+		l.label.Store(label)
+	}
+}
+
+// outcomeLabel holds the label from SetOutcomeLabel.  It is atomic because a runFunc started with Go may still be
+// running after the circuit stopped waiting for it.
+type outcomeLabel struct {
+	label atomic.Value // string
+}
+
+func (l *outcomeLabel) get() string {
+	ret, _ := l.label.Load().(string)
+	return ret
+}
+
+func withOutcomeLabel(ctx context.Context) (context.Context, *outcomeLabel) {
+	l := &outcomeLabel{}
+	return context.WithValue(ctx, outcomeLabelKey, l), l
+}
+
+// timerContext is a context.WithDeadline whose deadline is enforced by a TimeKeeper's AfterFunc, so a mocked clock
+// decides when it expires.  Once the timer fires, Err is context.DeadlineExceeded.  Contexts derived from it only see
+// context.Canceled, which is why withTimer only uses it for a custom AfterFunc.
+type timerContext struct {
+	context.Context
+	deadline time.Time
+	expired  faststats.AtomicBoolean
+}
+
+// withTimer is context.WithDeadline, enforced by afterFunc if it is set.  The timeoutTimer is nil for a plain deadline,
+// which contexts derived from it also see as context.DeadlineExceeded.
+func withTimer(parent context.Context, deadline time.Time, timeout time.Duration, afterFunc func(time.Duration, func()) *time.Timer) (context.Context, timeoutTimer, context.CancelFunc) {
+	if afterFunc == nil {
+		ctx, cancel := context.WithDeadline(parent, deadline)
+		return ctx, nil, cancel
+	}
+	inner, cancel := context.WithCancel(parent)
+	ret := &timerContext{Context: inner, deadline: deadline}
+	if timeout <= 0 {
+		// Already past the deadline
+		ret.expired.Set(true)
+		cancel()
+		return ret, ret, cancel
+	}
+	t := afterFunc(timeout, func() {
+		// Ending after the parent does is not a timeout
+		if inner.Err() == nil {
+			ret.expired.Set(true)
+		}
+		cancel()
+	})
+	return ret, ret, func() {
+		if t != nil {
+			t.Stop()
+		}
+		cancel()
+	}
+}
+
+// Deadline is the earlier of the parent's deadline and this one
+func (t *timerContext) Deadline() (time.Time, bool) {
+	if parent, ok := t.Context.Deadline(); ok && parent.Before(t.deadline) {
+		return parent, true
+	}
+	return t.deadline, true
+}
+
+// Err is context.DeadlineExceeded if the timer ended the context
+func (t *timerContext) Err() error {
+	err := t.Context.Err()
+	if err != nil && t.expired.Get() {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// timedOut is true if the timer ended the context.  It is false for a nil timerContext.
+func (t *timerContext) timedOut() bool {
+	return t != nil && t.expired.Get()
+}
+
+// timeoutTimer is what run needs from the context that enforces its timeout
+type timeoutTimer interface {
+	timedOut() bool
+}
+
+// nameContext holds the circuit's name for the contexts of one call.  It also remembers the last bad request that a
+// circuit nested in the call returned, since Execute unwraps bad requests.  With Execution.ReuseContexts, it is
+// returned to nameContexts once the call ends.
+type nameContext struct {
+	context.Context
+	name             interface{}
+	nestedBadRequest atomic.Value // badRequestMark
+}
+
+// badRequestMark is a bad request that a nested circuit returned.  It boxes err, which atomic.Value needs to hold
+// errors of different types.
+type badRequestMark struct {
+	err error
+}
+
+var nameContexts = sync.Pool{New: func() interface{} { return &nameContext{} }}
+
+func reuseNameContext(parent context.Context, name interface{}) *nameContext {
+	n := nameContexts.Get().(*nameContext)
+	n.Context, n.name = parent, name
+	return n
+}
+
+// Value is the circuit's name for circuitNameKey, and the parent's value for anything else
+func (n *nameContext) Value(key interface{}) interface{} {
+	switch key {
+	case circuitNameKey:
+		return n.name
+	case nestedBadRequestKey:
+		return n
+	}
+	return n.Context.Value(key)
+}
+
+func (n *nameContext) release() {
+	n.Context, n.name = nil, nil
+	n.nestedBadRequest.Store(badRequestMark{})
+	nameContexts.Put(n)
+}
+
+// markNestedBadRequest tells the circuit calling with ctx, if there is one, that err was a bad request
+func markNestedBadRequest(ctx context.Context, err error) {
+	if n, ok := ctx.Value(nestedBadRequestKey).(*nameContext); ok {
+		n.nestedBadRequest.Store(badRequestMark{err: err})
+	}
+}
+
+// isNestedBadRequest is true if err is the bad request a circuit nested in the call with ctx returned
+func isNestedBadRequest(ctx context.Context, err error) bool {
+	if err == nil || !reflect.TypeOf(err).Comparable() {
+		return false
+	}
+	n, ok := ctx.Value(nestedBadRequestKey).(*nameContext)
+	if !ok {
+		return false
+	}
+	mark, _ := n.nestedBadRequest.Load().(badRequestMark)
+	return mark.err == err
+}
+
+// closedChan is Done for a reusableTimer that expired before anything asked for Done
+var closedChan = make(chan struct{})
+
+func init() {
+	close(closedChan)
+}
+
+// reusableTimer is timerContext, for calls with Execution.ReuseContexts whose parent context can never be canceled.
+// It is returned to reusableTimers once the call ends, keeping its timer and, if it never closed, its Done channel.
+type reusableTimer struct {
+	context.Context
+	deadline time.Time
+	// fire is expire, bound once so scheduling it does not allocate
+	fire func()
+	// timer is from time.AfterFunc if realTimer, so it can be Reset instead of replaced
+	timer     *time.Timer
+	realTimer bool
+
+	mu      sync.Mutex
+	done    chan struct{}
+	expired bool
+}
+
+var reusableTimers = sync.Pool{New: func() interface{} {
+	t := &reusableTimer{}
+	t.fire = t.expire
+	return t
+}}
+
+// reuseTimer is withTimer using a pooled reusableTimer.  If afterFunc is nil, it uses time.AfterFunc and reuses the
+// timer from the last call.
+func reuseTimer(parent context.Context, deadline time.Time, timeout time.Duration, afterFunc func(time.Duration, func()) *time.Timer) *reusableTimer {
+	t := reusableTimers.Get().(*reusableTimer)
+	t.Context, t.deadline = parent, deadline
+	switch {
+	case timeout <= 0:
+		// Already past the deadline
+		t.expire()
+	case afterFunc == nil && t.realTimer:
+		t.timer.Reset(timeout)
+	case afterFunc == nil:
+		t.timer, t.realTimer = time.AfterFunc(timeout, t.fire), true
+	default:
+		t.timer, t.realTimer = afterFunc(timeout, t.fire), false
+	}
+	return t
+}
+
+func (t *reusableTimer) expire() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expired {
+		return
+	}
+	t.expired = true
+	if t.done != nil {
+		close(t.done)
+	}
+}
+
+// Deadline is the earlier of the parent's deadline and this one
+func (t *reusableTimer) Deadline() (time.Time, bool) {
+	if parent, ok := t.Context.Deadline(); ok && parent.Before(t.deadline) {
+		return parent, true
+	}
+	return t.deadline, true
+}
+
+// Done is closed once the timer fires.  The channel is only made the first time Done is called.
+func (t *reusableTimer) Done() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done == nil {
+		if t.expired {
+			return closedChan
+		}
+		t.done = make(chan struct{})
+	}
+	return t.done
+}
+
+// Err is context.DeadlineExceeded once the timer fires.  The parent can never be canceled, so it is nil until then.
+func (t *reusableTimer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.expired {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+func (t *reusableTimer) timedOut() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.expired
+}
+
+// release stops the timer and returns t to reusableTimers.  If the timer could not be stopped before it fired, expire
+// may still be about to run, so t is dropped instead of reused.
+func (t *reusableTimer) release() {
+	stopped := t.timer != nil && t.timer.Stop()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !stopped && !t.expired {
+		return
+	}
+	if t.expired {
+		// A closed channel cannot be reused
+		t.done = nil
+	}
+	t.expired = false
+	t.Context = nil
+	if !t.realTimer {
+		t.timer = nil
+	}
+	reusableTimers.Put(t)
+}
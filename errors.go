@@ -1,11 +1,257 @@
 package circuit
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 var errThrottledConcucrrentCommands = &circuitError{concurrencyLimitReached: true, msg: "throttling connections to command"}
 var errCircuitOpen = &circuitError{circuitOpen: true, msg: "circuit is open"}
+var errDraining = &circuitError{msg: "circuit is draining"}
+var errBudgetExhausted = &circuitError{msg: "request budget exhausted"}
 
-// circuitError is used for internally generated errors
+// ErrCircuitOpen matches, with errors.Is, every error returned because the circuit is open or ClosedToOpen prevented
+// the request
+var ErrCircuitOpen error = errCircuitOpen
+
+// ErrConcurrencyLimitReached matches, with errors.Is, every error returned because too many commands or fallbacks were
+// already running
+var ErrConcurrencyLimitReached error = errThrottledConcucrrentCommands
+
+// ErrDraining matches, with errors.Is, every error returned because the circuit is draining
+var ErrDraining error = errDraining
+
+// ErrBudgetExhausted matches, with errors.Is, every error returned because GeneralConfig.BudgetFunc denied a call
+var ErrBudgetExhausted error = errBudgetExhausted
+
+// Reasons a circuit can reject a request.  They are the values of RejectedError.Reason.
+const (
+	ReasonCircuitOpen              = "circuit open"
+	ReasonPrevented                = "prevented"
+	ReasonConcurrencyLimit         = "concurrency limit"
+	ReasonFallbackConcurrencyLimit = "fallback concurrency limit"
+	ReasonDraining                 = "draining"
+	ReasonBudgetExhausted          = "budget exhausted"
+)
+
+// RejectedError is implemented by every error a circuit returns when it refuses to call runFunc or fallbackFunc.  Use
+// errors.As to branch on Reason, or errors.Is with ErrCircuitOpen and ErrConcurrencyLimitReached.
+type RejectedError interface {
+	error
+	// CircuitName is the name of the circuit that rejected the request
+	CircuitName() string
+	// Reason is one of the Reason constants
+	Reason() string
+}
+
+// rejectionOf returns err as a RejectedError, or nil if it is not one.  On Go 1.13 and later, it also looks through
+// wrapped errors with errors.As.
+func rejectionOf(err error) RejectedError {
+	if rejected, ok := err.(RejectedError); ok {
+		return rejected
+	}
+	return wrappedRejection(err)
+}
+
+// CircuitOpenError is returned when an open circuit refuses to call runFunc
+type CircuitOpenError struct {
+	Name string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit is open: circuit=%s", e.Name)
+}
+
+// CircuitName returns the name of the circuit that is open
+func (e *CircuitOpenError) CircuitName() string {
+	return e.Name
+}
+
+// Reason returns ReasonCircuitOpen
+func (e *CircuitOpenError) Reason() string {
+	return ReasonCircuitOpen
+}
+
+// Is matches ErrCircuitOpen
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CiruitOpen always returns true
+func (e *CircuitOpenError) CiruitOpen() bool {
+	return true
+}
+
+// ConcurrencyLimitReached always returns false
+func (e *CircuitOpenError) ConcurrencyLimitReached() bool {
+	return false
+}
+
+// PreventedError is returned when a closed circuit's ClosedToOpen.Prevent refuses to call runFunc
+type PreventedError struct {
+	Name string
+}
+
+func (e *PreventedError) Error() string {
+	return fmt.Sprintf("circuit prevented the request: circuit=%s", e.Name)
+}
+
+// CircuitName returns the name of the circuit that prevented the request
+func (e *PreventedError) CircuitName() string {
+	return e.Name
+}
+
+// Reason returns ReasonPrevented
+func (e *PreventedError) Reason() string {
+	return ReasonPrevented
+}
+
+// Is matches ErrCircuitOpen, which was returned for prevented requests before PreventedError existed
+func (e *PreventedError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// CiruitOpen always returns true
+func (e *PreventedError) CiruitOpen() bool {
+	return true
+}
+
+// ConcurrencyLimitReached always returns false
+func (e *PreventedError) ConcurrencyLimitReached() bool {
+	return false
+}
+
+// ConcurrencyLimitError is returned when too many commands, or too many fallbacks if Fallback is true, are running
+type ConcurrencyLimitError struct {
+	Name     string
+	Fallback bool
+}
+
+func (e *ConcurrencyLimitError) Error() string {
+	if e.Fallback {
+		return fmt.Sprintf("throttling concurrency to fallbacks: circuit=%s", e.Name)
+	}
+	return fmt.Sprintf("throttling connections to command: circuit=%s", e.Name)
+}
+
+// CircuitName returns the name of the circuit that reached its limit
+func (e *ConcurrencyLimitError) CircuitName() string {
+	return e.Name
+}
+
+// Reason returns ReasonFallbackConcurrencyLimit for fallbacks and ReasonConcurrencyLimit otherwise
+func (e *ConcurrencyLimitError) Reason() string {
+	if e.Fallback {
+		return ReasonFallbackConcurrencyLimit
+	}
+	return ReasonConcurrencyLimit
+}
+
+// Is matches ErrConcurrencyLimitReached
+func (e *ConcurrencyLimitError) Is(target error) bool {
+	return target == ErrConcurrencyLimitReached
+}
+
+// CiruitOpen always returns false
+func (e *ConcurrencyLimitError) CiruitOpen() bool {
+	return false
+}
+
+// ConcurrencyLimitReached always returns true
+func (e *ConcurrencyLimitError) ConcurrencyLimitReached() bool {
+	return true
+}
+
+// DrainingError is returned for new calls to a circuit after Drain
+type DrainingError struct {
+	Name string
+}
+
+func (e *DrainingError) Error() string {
+	return fmt.Sprintf("circuit is draining: circuit=%s", e.Name)
+}
+
+// CircuitName returns the name of the circuit that is draining
+func (e *DrainingError) CircuitName() string {
+	return e.Name
+}
+
+// Reason returns ReasonDraining
+func (e *DrainingError) Reason() string {
+	return ReasonDraining
+}
+
+// Is matches ErrDraining
+func (e *DrainingError) Is(target error) bool {
+	return target == ErrDraining
+}
+
+// CiruitOpen always returns false
+func (e *DrainingError) CiruitOpen() bool {
+	return false
+}
+
+// ConcurrencyLimitReached always returns false
+func (e *DrainingError) ConcurrencyLimitReached() bool {
+	return false
+}
+
+// BudgetExhaustedError is returned when GeneralConfig.BudgetFunc says a call has no budget left
+type BudgetExhaustedError struct {
+	Name string
+}
+
+func (e *BudgetExhaustedError) Error() string {
+	return fmt.Sprintf("request budget exhausted: circuit=%s", e.Name)
+}
+
+// CircuitName returns the name of the circuit that rejected the call
+func (e *BudgetExhaustedError) CircuitName() string {
+	return e.Name
+}
+
+// Reason returns ReasonBudgetExhausted
+func (e *BudgetExhaustedError) Reason() string {
+	return ReasonBudgetExhausted
+}
+
+// Is matches ErrBudgetExhausted
+func (e *BudgetExhaustedError) Is(target error) bool {
+	return target == ErrBudgetExhausted
+}
+
+// CiruitOpen always returns false
+func (e *BudgetExhaustedError) CiruitOpen() bool {
+	return false
+}
+
+// ConcurrencyLimitReached always returns false
+func (e *BudgetExhaustedError) ConcurrencyLimitReached() bool {
+	return false
+}
+
+// rejectionErrors are allocated once per circuit, so rejecting a request does not allocate
+type rejectionErrors struct {
+	open                *CircuitOpenError
+	prevented           *PreventedError
+	concurrencyLimit    *ConcurrencyLimitError
+	fallbackConcurrency *ConcurrencyLimitError
+	draining            *DrainingError
+	budgetExhausted     *BudgetExhaustedError
+}
+
+func newRejectionErrors(name string) rejectionErrors {
+	return rejectionErrors{
+		open:                &CircuitOpenError{Name: name},
+		prevented:           &PreventedError{Name: name},
+		concurrencyLimit:    &ConcurrencyLimitError{Name: name},
+		fallbackConcurrency: &ConcurrencyLimitError{Name: name, Fallback: true},
+		draining:            &DrainingError{Name: name},
+		budgetExhausted:     &BudgetExhaustedError{Name: name},
+	}
+}
+
+// circuitError is the sentinel type behind ErrCircuitOpen and ErrConcurrencyLimitReached
 type circuitError struct {
 	concurrencyLimitReached bool
 	circuitOpen             bool
@@ -31,25 +277,102 @@ type BadRequest interface {
 	BadRequest() bool
 }
 
-// IsBadRequest returns true if the error is of type BadRequest
+// IsBadRequest returns true if the error is of type BadRequest.  On Go 1.13 and later, it also looks through wrapped
+// errors with errors.As.
 func IsBadRequest(err error) bool {
 	if err == nil {
 		return false
 	}
-	br, ok := err.(BadRequest)
-	return ok && br.BadRequest()
+	if br, ok := err.(BadRequest); ok {
+		return br.BadRequest()
+	}
+	return wrapsBadRequest(err)
+}
+
+// BadRequestChecker decides if an error returned by runFunc is a bad request.  Use it to classify errors you do not
+// control, such as gRPC status codes, without wrapping them.  Errors that implement BadRequest are always bad requests.
+type BadRequestChecker interface {
+	CheckBadRequest(err error) bool
+}
+
+// BadRequestCheckerFunc adapts a function into a BadRequestChecker
+type BadRequestCheckerFunc func(err error) bool
+
+// CheckBadRequest calls f(err)
+func (f BadRequestCheckerFunc) CheckBadRequest(err error) bool {
+	return f(err)
+}
+
+var _ BadRequestChecker = BadRequestCheckerFunc(nil)
+
+// AnyBadRequest combines checkers into one that marks an error as a bad request if any of them do.  They are asked in
+// order, and the rest are skipped once one says yes.  Nil checkers are ignored.
+func AnyBadRequest(checkers ...BadRequestChecker) BadRequestChecker {
+	return BadRequestCheckerFunc(func(err error) bool {
+		for _, c := range checkers {
+			if c != nil && c.CheckBadRequest(err) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AllBadRequest combines checkers into one that marks an error as a bad request only if every one of them does.  They
+// are asked in order, and the rest are skipped once one says no.  Nil checkers are ignored, and without any checkers
+// nothing is a bad request, so an empty list never hides failures.
+func AllBadRequest(checkers ...BadRequestChecker) BadRequestChecker {
+	return BadRequestCheckerFunc(func(err error) bool {
+		asked := false
+		for _, c := range checkers {
+			if c == nil {
+				continue
+			}
+			if !c.CheckBadRequest(err) {
+				return false
+			}
+			asked = true
+		}
+		return asked
+	})
 }
 
-// SimpleBadRequest is a simple wrapper for an error to mark it as a bad request
+// SimpleBadRequest is a simple wrapper for an error to mark it as a bad request.  When runFunc returns one, Execute
+// returns the wrapped error, so callers see the original error while the circuit does not count it as a failure.  A
+// circuit whose runFunc calls this one still sees the original error as a bad request.
 type SimpleBadRequest struct {
 	Err error
 }
 
+// NewBadRequest wraps err so the circuit treats it as a bad request.  It returns nil if err is nil.
+func NewBadRequest(err error) error {
+	if err == nil {
+		return nil
+	}
+	return SimpleBadRequest{Err: err}
+}
+
 // Cause returns the wrapped error
 func (s SimpleBadRequest) Cause() error {
 	return s.Err
 }
 
+// Unwrap returns the wrapped error
+func (s SimpleBadRequest) Unwrap() error {
+	return s.Err
+}
+
+// unwrapSimpleBadRequest returns the error inside err if it is a SimpleBadRequest
+func unwrapSimpleBadRequest(err error) error {
+	switch br := err.(type) {
+	case SimpleBadRequest:
+		return br.Err
+	case *SimpleBadRequest:
+		return br.Err
+	}
+	return err
+}
+
 // Cause returns the wrapped error
 func (s SimpleBadRequest) Error() string {
 	return s.Err.Error()
@@ -63,4 +386,58 @@ func (s SimpleBadRequest) BadRequest() bool {
 var _ error = &SimpleBadRequest{}
 var _ BadRequest = &SimpleBadRequest{}
 
+// PanicError is the error Execute returns when runFunc panics and Execution.RecoverPanics is set.  It counts as a
+// failure, so the fallback is still called.
+type PanicError struct {
+	// Value is what runFunc panicked with
+	Value interface{}
+	// Stack is the stack trace of the goroutine that panicked
+	Stack []byte
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("runFunc panicked: %v", p.Value)
+}
+
+var _ error = &PanicError{}
+
+// FallbackError is returned when the fallback fails too.  Err is the error runFunc returned, or the circuit's rejection,
+// and FallbackErr is the error the fallback returned.  On Go 1.13 and later, errors.Is and errors.As match either one.
+// A fallback that returns the error it was given gets that error back from Execute unwrapped.
+type FallbackError struct {
+	Err         error
+	FallbackErr error
+}
+
+func (e *FallbackError) Error() string {
+	return fmt.Sprintf("fallback failed: %s: original error: %s", e.FallbackErr, e.Err)
+}
+
+// Unwrap returns the fallback's error, then the original error
+func (e *FallbackError) Unwrap() []error {
+	return []error{e.FallbackErr, e.Err}
+}
+
+var _ error = &FallbackError{}
+
+// ConfigErrors is returned by Manager.CreateCircuit, with ValidateConfigs set, for a circuit whose config or open/close
+// logic is invalid.  Errors has every problem Validate found.
+type ConfigErrors struct {
+	Name   string
+	Errors []error
+}
+
+func (e *ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid config for circuit %s: %s", e.Name, strings.Join(msgs, "; "))
+}
+
+var _ error = &ConfigErrors{}
 var _ error = &circuitError{}
+var _ RejectedError = &CircuitOpenError{}
+var _ RejectedError = &PreventedError{}
+var _ RejectedError = &ConcurrencyLimitError{}
+var _ RejectedError = &DrainingError{}
@@ -0,0 +1,25 @@
+//go:build go1.13
+// +build go1.13
+
+package circuit
+
+import "errors"
+
+// Is matches the fallback's error or the original error.  Go 1.20 and later also find both through Unwrap.
+func (e *FallbackError) Is(target error) bool {
+	return errors.Is(e.FallbackErr, target) || errors.Is(e.Err, target)
+}
+
+// As finds target in the fallback's error, then in the original error
+func (e *FallbackError) As(target interface{}) bool {
+	return errors.As(e.FallbackErr, target) || errors.As(e.Err, target)
+}
+
+// wrappedRejection is the first error wrapped by err that is a RejectedError, or nil
+func wrappedRejection(err error) RejectedError {
+	var rejected RejectedError
+	if errors.As(err, &rejected) {
+		return rejected
+	}
+	return nil
+}
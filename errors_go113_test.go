@@ -0,0 +1,105 @@
+//go:build go1.13
+// +build go1.13
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+type alwaysPrevents struct {
+	neverOpens
+}
+
+func (a alwaysPrevents) Prevent(now time.Time) bool {
+	return true
+}
+
+func expectRejected(t *testing.T, err error, name string, reason string, sentinel error) {
+	t.Helper()
+	var rejected RejectedError
+	if !errors.As(fmt.Errorf("wrapped: %w", err), &rejected) {
+		t.Fatal("expected a RejectedError", err)
+	}
+	if rejected.CircuitName() != name || rejected.Reason() != reason {
+		t.Errorf("expected circuit %s to reject with %q, saw %s %q", name, reason, rejected.CircuitName(), rejected.Reason())
+	}
+	if !errors.Is(err, sentinel) {
+		t.Error("expected the error to match its sentinel", err)
+	}
+}
+
+func TestRejectedErrors(t *testing.T) {
+	c := NewCircuitFromConfig("TestRejectedErrors", Config{})
+	err := c.Execute(WithMaxConcurrentRequests(context.Background(), 0), testhelp.AlwaysPasses, nil)
+	expectRejected(t, err, "TestRejectedErrors", ReasonConcurrencyLimit, ErrConcurrencyLimitReached)
+	if errors.Is(err, ErrCircuitOpen) {
+		t.Error("a concurrency limit is not an open circuit")
+	}
+
+	c.threadSafeConfig.Fallback.MaxConcurrentRequests.Set(0)
+	err = c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback)
+	expectRejected(t, err, "TestRejectedErrors", ReasonFallbackConcurrencyLimit, ErrConcurrencyLimitReached)
+
+	c.OpenCircuit()
+	err = c.Execute(context.Background(), testhelp.AlwaysPasses, nil)
+	expectRejected(t, err, "TestRejectedErrors", ReasonCircuitOpen, ErrCircuitOpen)
+	if errors.Is(err, ErrConcurrencyLimitReached) {
+		t.Error("an open circuit is not a concurrency limit")
+	}
+	if oldStyle, ok := err.(interface{ CiruitOpen() bool }); !ok || !oldStyle.CiruitOpen() {
+		t.Error("expected the error to still report CiruitOpen")
+	}
+
+	c.Drain()
+	err = c.Execute(context.Background(), testhelp.AlwaysPasses, nil)
+	expectRejected(t, err, "TestRejectedErrors", ReasonDraining, ErrDraining)
+}
+
+func TestRejectedErrors_Prevented(t *testing.T) {
+	c := NewCircuitFromConfig("TestRejectedErrors_Prevented", Config{
+		General: GeneralConfig{
+			ClosedToOpenFactory: func() ClosedToOpen {
+				return alwaysPrevents{}
+			},
+		},
+	})
+	err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil)
+	expectRejected(t, err, "TestRejectedErrors_Prevented", ReasonPrevented, ErrCircuitOpen)
+}
+
+func TestFallbackError(t *testing.T) {
+	c := NewCircuitFromConfig("TestFallbackError", Config{})
+	primaryErr := errors.New("primary failed")
+	fallbackErr := errors.New("fallback failed")
+	err := c.Execute(context.Background(), func(_ context.Context) error {
+		return fmt.Errorf("wrapped: %w", primaryErr)
+	}, func(_ context.Context, _ error) error {
+		return fallbackErr
+	})
+	if !errors.Is(err, primaryErr) || !errors.Is(err, fallbackErr) {
+		t.Error("expected both causes to be reachable", err)
+	}
+	var fallbackFailed *FallbackError
+	if !errors.As(err, &fallbackFailed) || fallbackFailed.FallbackErr != fallbackErr {
+		t.Error("expected a FallbackError", err)
+	}
+
+	c.OpenCircuit()
+	err = c.Execute(context.Background(), testhelp.AlwaysPasses, testhelp.AlwaysFailsFallback)
+	expectRejected(t, err, "TestFallbackError", ReasonCircuitOpen, ErrCircuitOpen)
+
+	// A fallback that passes the error along returns it as is
+	err = c.Execute(context.Background(), testhelp.AlwaysPasses, func(_ context.Context, err error) error {
+		return err
+	})
+	if _, ok := err.(*CircuitOpenError); !ok {
+		t.Error("expected the original error back", err)
+	}
+}
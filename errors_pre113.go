@@ -0,0 +1,9 @@
+//go:build !go1.13
+// +build !go1.13
+
+package circuit
+
+// wrappedRejection needs errors.As, so only top level RejectedError errors are found before Go 1.13
+func wrappedRejection(err error) RejectedError {
+	return nil
+}
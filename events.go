@@ -0,0 +1,211 @@
+package circuit
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit/faststats"
+)
+
+// Event is a single run event of a circuit, as sent by EventChannel
+type Event struct {
+	// CircuitName is the name of the circuit the event is from
+	CircuitName string
+	// Outcome is which RunMetrics function the event matches
+	Outcome Outcome
+	// Duration is how long runFunc ran.  It is zero for short circuits and concurrency limit rejections.
+	Duration time.Duration
+	// Time is when the event happened, using the circuit's TimeKeeper
+	Time time.Time
+}
+
+// SlowConsumerPolicy is what an EventChannel does with an event when its buffer is full
+type SlowConsumerPolicy int
+
+const (
+	// DropEvents drops the event and counts it in Dropped.  It never slows down Execute.
+	DropEvents SlowConsumerPolicy = iota
+	// BlockOnFullBuffer waits until the consumer reads.  Execute does not return until its event is sent.
+	BlockOnFullBuffer
+)
+
+// EventChannel is a RunMetrics that sends each run event, as an Event, to a buffered channel.  Use it to process
+// metrics away from the hot path, without implementing RunMetrics.  Attach it with Config.Metrics.Run or
+// AppendRunMetrics, or use Circuit.Events.  The channel is never closed.
+type EventChannel struct {
+	events      chan Event
+	circuitName string
+	policy      SlowConsumerPolicy
+	dropped     faststats.AtomicInt64
+}
+
+var _ RunMetrics = &EventChannel{}
+
+// NewEventChannel creates an EventChannel for the named circuit, holding up to bufferSize events the consumer has not
+// read yet.  A bufferSize below zero is treated as zero.
+func NewEventChannel(circuitName string, bufferSize int, policy SlowConsumerPolicy) *EventChannel {
+	if bufferSize < 0 {
+		bufferSize = 0
+	}
+	return &EventChannel{
+		events:      make(chan Event, bufferSize),
+		circuitName: circuitName,
+		policy:      policy,
+	}
+}
+
+// Events returns the channel events are sent to
+func (e *EventChannel) Events() <-chan Event {
+	return e.events
+}
+
+// Dropped is how many events were dropped because the buffer was full
+func (e *EventChannel) Dropped() int64 {
+	return e.dropped.Get()
+}
+
+func (e *EventChannel) send(now time.Time, outcome Outcome, duration time.Duration) {
+	ev := Event{
+		CircuitName: e.circuitName,
+		Outcome:     outcome,
+		Duration:    duration,
+		Time:        now,
+	}
+	if e.policy == BlockOnFullBuffer {
+		e.events <- ev
+		return
+	}
+	select {
+	case e.events <- ev:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Success sends an OutcomeSuccess event
+func (e *EventChannel) Success(now time.Time, duration time.Duration) {
+	e.send(now, OutcomeSuccess, duration)
+}
+
+// ErrFailure sends an OutcomeFailure event
+func (e *EventChannel) ErrFailure(now time.Time, duration time.Duration) {
+	e.send(now, OutcomeFailure, duration)
+}
+
+// ErrTimeout sends an OutcomeTimeout event
+func (e *EventChannel) ErrTimeout(now time.Time, duration time.Duration) {
+	e.send(now, OutcomeTimeout, duration)
+}
+
+// ErrBadRequest sends an OutcomeBadRequest event
+func (e *EventChannel) ErrBadRequest(now time.Time, duration time.Duration) {
+	e.send(now, OutcomeBadRequest, duration)
+}
+
+// ErrInterrupt sends an OutcomeInterrupt event
+func (e *EventChannel) ErrInterrupt(now time.Time, duration time.Duration) {
+	e.send(now, OutcomeInterrupt, duration)
+}
+
+// ErrConcurrencyLimitReject sends an OutcomeConcurrencyLimitReject event
+func (e *EventChannel) ErrConcurrencyLimitReject(now time.Time) {
+	e.send(now, OutcomeConcurrencyLimitReject, 0)
+}
+
+// ErrShortCircuit sends an OutcomeShortCircuit event
+func (e *EventChannel) ErrShortCircuit(now time.Time) {
+	e.send(now, OutcomeShortCircuit, 0)
+}
+
+// Events subscribes to the circuit's run events, from the next Execute call onward.  See EventChannel for how
+// bufferSize and policy apply.  Each call makes a new subscription that lasts as long as the circuit, so a consumer
+// should keep reading, or use DropEvents.
+func (c *Circuit) Events(bufferSize int, policy SlowConsumerPolicy) <-chan Event {
+	e := NewEventChannel(c.Name(), bufferSize, policy)
+	c.AppendRunMetrics(e)
+	return e.Events()
+}
+
+// outcomeRing is a RunMetrics that keeps the last few run events, for Circuit.RecentOutcomes
+type outcomeRing struct {
+	circuitName string
+	mu          sync.Mutex
+	events      []Event
+	// next is where the next event goes.  Once the ring is full, it is also the oldest event
+	next int
+	full bool
+}
+
+var _ RunMetrics = &outcomeRing{}
+
+func newOutcomeRing(circuitName string, size int) *outcomeRing {
+	return &outcomeRing{
+		circuitName: circuitName,
+		events:      make([]Event, size),
+	}
+}
+
+func (o *outcomeRing) record(now time.Time, outcome Outcome, duration time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events[o.next] = Event{
+		CircuitName: o.circuitName,
+		Outcome:     outcome,
+		Duration:    duration,
+		Time:        now,
+	}
+	o.next++
+	if o.next == len(o.events) {
+		o.next = 0
+		o.full = true
+	}
+}
+
+// snapshot copies the events, oldest first
+func (o *outcomeRing) snapshot() []Event {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.full {
+		return append([]Event(nil), o.events[:o.next]...)
+	}
+	ret := make([]Event, 0, len(o.events))
+	ret = append(ret, o.events[o.next:]...)
+	return append(ret, o.events[:o.next]...)
+}
+
+func (o *outcomeRing) Success(now time.Time, duration time.Duration) {
+	o.record(now, OutcomeSuccess, duration)
+}
+
+func (o *outcomeRing) ErrFailure(now time.Time, duration time.Duration) {
+	o.record(now, OutcomeFailure, duration)
+}
+
+func (o *outcomeRing) ErrTimeout(now time.Time, duration time.Duration) {
+	o.record(now, OutcomeTimeout, duration)
+}
+
+func (o *outcomeRing) ErrBadRequest(now time.Time, duration time.Duration) {
+	o.record(now, OutcomeBadRequest, duration)
+}
+
+func (o *outcomeRing) ErrInterrupt(now time.Time, duration time.Duration) {
+	o.record(now, OutcomeInterrupt, duration)
+}
+
+func (o *outcomeRing) ErrConcurrencyLimitReject(now time.Time) {
+	o.record(now, OutcomeConcurrencyLimitReject, 0)
+}
+
+func (o *outcomeRing) ErrShortCircuit(now time.Time) {
+	o.record(now, OutcomeShortCircuit, 0)
+}
+
+// RecentOutcomes returns a copy of the last GeneralConfig.RecentOutcomeCount run events, oldest first.  It is nil
+// unless RecentOutcomeCount is set.
+func (c *Circuit) RecentOutcomes() []Event {
+	if c.recentOutcomes == nil {
+		return nil
+	}
+	return c.recentOutcomes.snapshot()
+}
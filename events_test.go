@@ -0,0 +1,96 @@
+package circuit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+func TestCircuit_Events(t *testing.T) {
+	c := NewCircuitFromConfig("TestCircuit_Events", Config{})
+	events := c.Events(10, BlockOnFullBuffer)
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, func(_ context.Context) error {
+		return SimpleBadRequest{Err: errors.New("bad")}
+	}, nil))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	for _, expected := range []Outcome{OutcomeSuccess, OutcomeFailure, OutcomeBadRequest, OutcomeShortCircuit} {
+		select {
+		case ev := <-events:
+			if ev.Outcome != expected || ev.CircuitName != "TestCircuit_Events" || ev.Time.IsZero() {
+				t.Errorf("expected a %s event, saw %+v", expected, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected an event for", expected)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Error("unexpected event", ev)
+	default:
+	}
+}
+
+func TestEventChannel_Drops(t *testing.T) {
+	e := NewEventChannel("TestEventChannel_Drops", 2, DropEvents)
+	c := NewCircuitFromConfig("TestEventChannel_Drops", Config{
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{e},
+		},
+	})
+	for i := 0; i < 5; i++ {
+		testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	}
+	if e.Dropped() != 3 {
+		t.Error("expected events past the buffer to be dropped", e.Dropped())
+	}
+	if len(e.Events()) != 2 {
+		t.Error("expected the buffer to hold the first events", len(e.Events()))
+	}
+}
+
+func TestCircuit_RecentOutcomes(t *testing.T) {
+	c := NewCircuitFromConfig("TestCircuit_RecentOutcomes", Config{})
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	if c.RecentOutcomes() != nil {
+		t.Error("expected no recent outcomes by default")
+	}
+
+	c = NewCircuitFromConfig("TestCircuit_RecentOutcomes", Config{
+		General: GeneralConfig{
+			RecentOutcomeCount: 3,
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if recent := c.RecentOutcomes(); len(recent) != 1 || recent[0].Outcome != OutcomeSuccess {
+		t.Error("expected the one outcome so far", recent)
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, func(_ context.Context) error {
+		return SimpleBadRequest{Err: errors.New("bad")}
+	}, nil))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	recent := c.RecentOutcomes()
+	expected := []Outcome{OutcomeFailure, OutcomeBadRequest, OutcomeShortCircuit}
+	if len(recent) != len(expected) {
+		t.Fatal("expected only the last 3 outcomes", recent)
+	}
+	for i, ev := range recent {
+		if ev.Outcome != expected[i] || ev.CircuitName != "TestCircuit_RecentOutcomes" || ev.Time.IsZero() {
+			t.Errorf("expected outcome %d to be %s, saw %+v", i, expected[i], ev)
+		}
+	}
+	// The result is a copy
+	recent[0].Outcome = OutcomeSuccess
+	if c.RecentOutcomes()[0].Outcome != OutcomeFailure {
+		t.Error("expected RecentOutcomes to return a copy")
+	}
+}
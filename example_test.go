@@ -153,6 +153,27 @@ func ExampleCircuit_Execute_helloworld() {
 	// Output: err=<nil>
 }
 
+// This example shows a fallback that is protected by its own circuit.  When both circuits are open, the error says
+// which circuit rejected the call.
+func ExampleCircuit_WrapFallback() {
+	h := circuit.Manager{}
+	primary := h.MustCreateCircuit("primary-db")
+	secondary := h.MustCreateCircuit("replica-db")
+	primary.OpenCircuit()
+	secondary.OpenCircuit()
+	err := primary.Execute(context.Background(), func(ctx context.Context) error {
+		return nil
+	}, secondary.WrapFallback(func(ctx context.Context, err error) error {
+		return nil
+	}))
+	if fallbackFailed, ok := err.(*circuit.FallbackError); ok {
+		if rejected, ok := fallbackFailed.FallbackErr.(circuit.RejectedError); ok {
+			fmt.Println("rejected by", rejected.CircuitName())
+		}
+	}
+	// Output: rejected by replica-db
+}
+
 // It is recommended to use `circuit.Execute` and a context aware function.  If, however, you want to exit
 // your run function early and leave it hanging (possibly forever), then you can call `circuit.Go`.
 func ExampleCircuit_Go() {
@@ -273,6 +294,37 @@ func ExampleBadRequest() {
 	// Output: Result of 10/0 is someone tried to divide by zero
 }
 
+// statusError stands in for errors from a library you do not control, like gRPC status errors
+type statusError struct {
+	code int
+}
+
+func (s *statusError) Error() string {
+	return fmt.Sprintf("status code %d", s.code)
+}
+
+// This example shows how to mark errors you cannot wrap as bad requests.  With gRPC, you would check
+// status.Code(err) for codes.InvalidArgument or codes.NotFound, while codes.Unavailable stays a circuit failure.
+func ExampleBadRequestChecker() {
+	const invalidArgument = 3
+	c := circuit.NewCircuitFromConfig("status-checker", circuit.Config{
+		General: circuit.GeneralConfig{
+			BadRequestChecker: circuit.BadRequestCheckerFunc(func(err error) bool {
+				se, ok := err.(*statusError)
+				return ok && se.code == invalidArgument
+			}),
+		},
+	})
+	err := c.Execute(context.Background(), func(ctx context.Context) error {
+		return &statusError{code: invalidArgument}
+	}, func(ctx context.Context, err error) error {
+		fmt.Println("fallbacks are not called for bad requests")
+		return nil
+	})
+	fmt.Println("Result of execution:", err)
+	// Output: Result of execution: status code 3
+}
+
 // If you wanted to publish hystrix information on Expvar, you can register your manager.
 func ExampleManager_Var() {
 	h := circuit.Manager{}
@@ -293,3 +345,30 @@ func ExampleConfig_custommetrics() {
 	circuit.NewCircuitFromConfig("custom-metrics", config)
 	// Output:
 }
+
+// Tests can exercise timeouts without sleeping.  Execution.Timeout uses the TimeKeeper's AfterFunc, so a test that
+// keeps the timer can fire it whenever it wants the runFunc to time out.
+func ExampleTimeKeeper_forceTimeout() {
+	var fireTimeout func()
+	c := circuit.NewCircuitFromConfig("force-timeout", circuit.Config{
+		Execution: circuit.ExecutionConfig{
+			Timeout: time.Hour,
+		},
+		General: circuit.GeneralConfig{
+			TimeKeeper: circuit.TimeKeeper{
+				AfterFunc: func(_ time.Duration, f func()) *time.Timer {
+					fireTimeout = f
+					return nil
+				},
+			},
+		},
+	})
+	info, err := c.ExecuteWithInfo(context.Background(), func(ctx context.Context) error {
+		// Pretend the call hangs for longer than an hour
+		fireTimeout()
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil)
+	fmt.Println(info.Outcome, err)
+	// Output: timeout context deadline exceeded
+}
@@ -24,6 +24,28 @@ func (a *AtomicBoolean) Set(value bool) {
 	}
 }
 
+// CompareAndSwap sets the boolean to newVal if it is currently expected, returning true if it did
+func (a *AtomicBoolean) CompareAndSwap(expected bool, newVal bool) bool {
+	return atomic.CompareAndSwapUint32(&a.flag, boolToUint32(expected), boolToUint32(newVal))
+}
+
+func boolToUint32(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Toggle atomically flips the boolean, returning the value before the flip
+func (a *AtomicBoolean) Toggle() bool {
+	for {
+		old := atomic.LoadUint32(&a.flag)
+		if atomic.CompareAndSwapUint32(&a.flag, old, old^1) {
+			return old == 1
+		}
+	}
+}
+
 // String returns "true" or "false"
 func (a *AtomicBoolean) String() string {
 	return strconv.FormatBool(a.Get())
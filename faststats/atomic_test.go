@@ -2,6 +2,7 @@ package faststats
 
 import (
 	"encoding/json"
+	"sync"
 	"testing"
 	"time"
 )
@@ -36,6 +37,31 @@ func TestAtomicInt64(t *testing.T) {
 	}
 }
 
+func TestAtomicInt64_CompareAndSwap(t *testing.T) {
+	var x AtomicInt64
+	concurrency := 10
+	for round := int64(0); round < 100; round++ {
+		var winners AtomicInt64
+		wg := sync.WaitGroup{}
+		for i := 0; i < concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if x.CompareAndSwap(round, round+1) {
+					winners.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if winners.Get() != 1 {
+			t.Fatalf("expected exactly one CAS winner in round %d, saw %d", round, winners.Get())
+		}
+	}
+	if x.Get() != 100 {
+		t.Error("expected one increment per round")
+	}
+}
+
 func TestAtomicBoolean(t *testing.T) {
 	var b AtomicBoolean
 	b.Set(true)
@@ -57,3 +83,40 @@ func TestAtomicBoolean(t *testing.T) {
 		t.Error("Value not stored in correctly")
 	}
 }
+
+func TestAtomicBoolean_Toggle(t *testing.T) {
+	var b AtomicBoolean
+	if b.Toggle() {
+		t.Error("expected false before the first toggle")
+	}
+	if !b.Get() {
+		t.Error("expected true after the first toggle")
+	}
+	b.Set(false)
+	concurrency := 101
+	wg := sync.WaitGroup{}
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.Toggle()
+		}()
+	}
+	wg.Wait()
+	if b.Get() != (concurrency%2 == 1) {
+		t.Error("final value does not match the parity of toggles")
+	}
+}
+
+func TestAtomicBoolean_CompareAndSwap(t *testing.T) {
+	var b AtomicBoolean
+	if b.CompareAndSwap(true, false) {
+		t.Error("should not swap when the value is not expected")
+	}
+	if !b.CompareAndSwap(false, true) {
+		t.Error("should swap when the value is expected")
+	}
+	if !b.Get() {
+		t.Error("expected the swapped value to be stored")
+	}
+}
@@ -0,0 +1,28 @@
+package faststatstest_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cep21/circuit/faststats"
+	"github.com/cep21/circuit/faststats/faststatstest"
+)
+
+// Drive a TimedCheck with a virtual clock so the sleep window ends exactly when the test says so
+func ExampleManualTimer() {
+	timer := faststatstest.NewManualTimer(time.Unix(0, 0))
+	check := faststats.TimedCheck{
+		TimeAfterFunc: timer.AfterFunc,
+		Now:           timer.Now,
+	}
+	check.SetSleepDuration(time.Second)
+	check.SleepStartNow()
+	fmt.Println("at start:", check.CheckNow())
+	timer.Advance(time.Second - time.Millisecond)
+	fmt.Println("just before:", check.CheckNow(), timer.Pending())
+	timer.Advance(time.Millisecond)
+	fmt.Println("after timer fires:", check.CheckNow())
+	// Output: at start: false
+	// just before: false 1
+	// after timer fires: true
+}
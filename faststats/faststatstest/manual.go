@@ -0,0 +1,83 @@
+// Package faststatstest contains helpers for deterministically testing code built on faststats.
+package faststatstest
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ManualTimer is a virtual clock whose AfterFunc and Now methods can be assigned to TimedCheck.TimeAfterFunc and
+// TimedCheck.Now.  Scheduled callbacks only run when a test moves the clock past their deadline with Advance or Set.
+type ManualTimer struct {
+	currentTime time.Time
+	scheduled   []scheduledFunc
+	mu          sync.Mutex
+}
+
+type scheduledFunc struct {
+	when time.Time
+	f    func()
+}
+
+// NewManualTimer returns a ManualTimer whose virtual clock starts at now
+func NewManualTimer(now time.Time) *ManualTimer {
+	return &ManualTimer{
+		currentTime: now,
+	}
+}
+
+// Now returns the current virtual time
+func (m *ManualTimer) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.currentTime
+}
+
+// AfterFunc records f to run once the virtual clock reaches Now() + d.  It is compatible with the TimeAfterFunc field
+// of TimedCheck.  The returned timer is already stopped, so calling Stop on it is harmless; it does not cancel f.
+func (m *ManualTimer) AfterFunc(d time.Duration, f func()) *time.Timer {
+	m.mu.Lock()
+	m.scheduled = append(m.scheduled, scheduledFunc{when: m.currentTime.Add(d), f: f})
+	m.mu.Unlock()
+	t := time.NewTimer(time.Hour)
+	t.Stop()
+	return t
+}
+
+// Pending returns how many scheduled callbacks have not fired yet
+func (m *ManualTimer) Pending() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.scheduled)
+}
+
+// Advance moves the virtual clock forward by d, firing every callback that is now due.  It returns the new time.
+func (m *ManualTimer) Advance(d time.Duration) time.Time {
+	return m.Set(m.Now().Add(d))
+}
+
+// Set moves the virtual clock to t, firing every callback whose deadline is not after t in deadline order.  Callbacks
+// run on the calling goroutine, after the internal lock is released.
+func (m *ManualTimer) Set(t time.Time) time.Time {
+	m.mu.Lock()
+	m.currentTime = t
+	var due []scheduledFunc
+	remaining := m.scheduled[:0]
+	for _, s := range m.scheduled {
+		if s.when.After(t) {
+			remaining = append(remaining, s)
+		} else {
+			due = append(due, s)
+		}
+	}
+	m.scheduled = remaining
+	m.mu.Unlock()
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].when.Before(due[j].when)
+	})
+	for _, s := range due {
+		s.f()
+	}
+	return t
+}
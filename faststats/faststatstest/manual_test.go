@@ -0,0 +1,31 @@
+package faststatstest
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManualTimer_Advance(t *testing.T) {
+	start := time.Unix(100, 0)
+	m := NewManualTimer(start)
+	var fired []int
+	m.AfterFunc(2*time.Second, func() { fired = append(fired, 2) })
+	m.AfterFunc(time.Second, func() { fired = append(fired, 1) })
+	m.AfterFunc(time.Hour, func() { fired = append(fired, 3) }).Stop()
+	if m.Advance(999*time.Millisecond) != start.Add(999*time.Millisecond) {
+		t.Fatal("expected Advance to return the new time")
+	}
+	if len(fired) != 0 {
+		t.Fatal("nothing should fire before its deadline")
+	}
+	m.Advance(5 * time.Second)
+	if len(fired) != 2 || fired[0] != 1 || fired[1] != 2 {
+		t.Fatal("expected callbacks to fire in deadline order", fired)
+	}
+	if m.Pending() != 1 {
+		t.Fatal("expected one pending callback", m.Pending())
+	}
+	if !m.Now().Equal(start.Add(5*time.Second + 999*time.Millisecond)) {
+		t.Fatal("unexpected current time", m.Now())
+	}
+}
@@ -0,0 +1,47 @@
+package faststats
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimiter allows up to a limit of events in a sliding window of time.  It counts allowed events in a
+// RollingCounter, so events leave the window a bucket at a time: more buckets make the window slide more smoothly.
+// It is safe for concurrent use and does not depend on circuits, so it can throttle anything.
+type SlidingWindowLimiter struct {
+	limit   int64
+	allowed RollingCounter
+	// mu makes checking the rolling sum and counting the event one step, so concurrent calls never go over limit
+	mu sync.Mutex
+}
+
+// NewSlidingWindowLimiter allows limit events in every window, tracked with numBuckets buckets starting at now.  The
+// window is split with BucketWidth.  A limit below 1 never allows events.
+func NewSlidingWindowLimiter(limit int64, window time.Duration, numBuckets int, now time.Time) *SlidingWindowLimiter {
+	return &SlidingWindowLimiter{
+		limit:   limit,
+		allowed: NewRollingCounter(BucketWidth(window, numBuckets), numBuckets, now),
+	}
+}
+
+// Allow returns true, and counts the event, if fewer than the limit of events were allowed in the window ending at now.
+// Events that are not allowed are not counted, so a steady stream of rejected events does not extend the throttling.
+func (s *SlidingWindowLimiter) Allow(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.allowed.RollingSumAt(now) >= s.limit {
+		return false
+	}
+	s.allowed.Inc(now)
+	return true
+}
+
+// Limit returns how many events are allowed in each window
+func (s *SlidingWindowLimiter) Limit() int64 {
+	return s.limit
+}
+
+// Allowed returns how many events were allowed in the window ending at now
+func (s *SlidingWindowLimiter) Allowed(now time.Time) int64 {
+	return s.allowed.RollingSumAt(now)
+}
@@ -0,0 +1,89 @@
+package faststats
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLimiter_Burst(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingWindowLimiter(5, time.Second, 10, now)
+	for i := 0; i < 5; i++ {
+		if !l.Allow(now) {
+			t.Fatalf("expected event %d of a burst to be allowed", i)
+		}
+	}
+	if l.Allow(now) {
+		t.Fatal("expected the burst to stop at the limit")
+	}
+	// Still inside the window: the burst has not expired
+	if l.Allow(now.Add(time.Second - time.Millisecond)) {
+		t.Fatal("expected events to stay throttled until the burst leaves the window")
+	}
+	if l.Allowed(now) != 5 {
+		t.Error("rejected events should not be counted", l.Allowed(now))
+	}
+	// Once the bucket holding the burst leaves the window, a whole new burst is allowed
+	later := now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		if !l.Allow(later) {
+			t.Fatalf("expected event %d of the next burst to be allowed", i)
+		}
+	}
+	if l.Allow(later) {
+		t.Fatal("expected the next burst to stop at the limit")
+	}
+}
+
+func TestSlidingWindowLimiter_Slides(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingWindowLimiter(4, time.Second, 10, now)
+	// Two events at the start of the window, two right before its end
+	l.Allow(now)
+	l.Allow(now)
+	end := now.Add(900 * time.Millisecond)
+	l.Allow(end)
+	l.Allow(end)
+	if l.Allow(end) {
+		t.Fatal("expected the limit to count events across the whole window")
+	}
+	// Only the first two events leave the window
+	next := now.Add(time.Second)
+	if !l.Allow(next) || !l.Allow(next) {
+		t.Fatal("expected room for the events that left the window")
+	}
+	if l.Allow(next) {
+		t.Fatal("expected the events at the end of the last window to still count")
+	}
+}
+
+func TestSlidingWindowLimiter_NoLimit(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingWindowLimiter(0, time.Second, 10, now)
+	if l.Allow(now) {
+		t.Fatal("a limit of zero should never allow events")
+	}
+}
+
+func TestSlidingWindowLimiter_Concurrent(t *testing.T) {
+	now := time.Now()
+	l := NewSlidingWindowLimiter(100, time.Minute, 10, now)
+	var wg sync.WaitGroup
+	var allowed AtomicInt64
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if l.Allow(now) {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed.Get() != 100 {
+		t.Error("expected exactly the limit to be allowed", allowed.Get())
+	}
+}
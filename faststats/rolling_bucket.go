@@ -24,6 +24,24 @@ func (r *RollingBuckets) String() string {
 	return fmt.Sprintf("RollingBucket(num=%d, width=%s)", r.NumBuckets, r.BucketWidth)
 }
 
+// liveIndex returns the index of the bucket holding t, or -1 if that bucket is not in the window as of the last
+// Advance.  It does not advance the window.
+func (r *RollingBuckets) liveIndex(t time.Time) int {
+	if r.NumBuckets == 0 {
+		return -1
+	}
+	diff := t.Sub(r.StartTime)
+	if diff < 0 {
+		return -1
+	}
+	absIndex := diff.Nanoseconds() / r.BucketWidth.Nanoseconds()
+	lastAbsVal := r.LastAbsIndex.Get()
+	if absIndex > lastAbsVal || absIndex <= lastAbsVal-int64(r.NumBuckets) {
+		return -1
+	}
+	return int(absIndex % int64(r.NumBuckets))
+}
+
 // Advance to now, clearing buckets as needed
 func (r *RollingBuckets) Advance(now time.Time, clearBucket func(int)) int {
 	if r.NumBuckets == 0 {
@@ -44,7 +62,7 @@ func (r *RollingBuckets) Advance(now time.Time, clearBucket func(int)) int {
 	if indexDiff < 0 {
 		// This point is backwards in time.  We should return a valid
 		// index past where we are
-		if indexDiff >= r.NumBuckets {
+		if -indexDiff >= r.NumBuckets {
 			// We rolled past the list.  This point is before the start
 			// of our rolling window.  We should just do what ... ignore it?
 			return -1
@@ -21,8 +21,21 @@ type RollingCounter struct {
 	rollingBucket RollingBuckets
 }
 
-// NewRollingCounter initializes a rolling counter with a bucket width and # of buckets
+// Smallest values NewRollingCounter allows.  Anything smaller would divide by zero or index out of range.
+const (
+	minRollingCounterBucketWidth = time.Millisecond
+	minRollingCounterNumBuckets  = 1
+)
+
+// NewRollingCounter initializes a rolling counter with a bucket width and # of buckets.  A bucketWidth below 1ms is
+// raised to 1ms and a numBuckets below 1 is raised to 1.
 func NewRollingCounter(bucketWidth time.Duration, numBuckets int, now time.Time) RollingCounter {
+	if bucketWidth < minRollingCounterBucketWidth {
+		bucketWidth = minRollingCounterBucketWidth
+	}
+	if numBuckets < minRollingCounterNumBuckets {
+		numBuckets = minRollingCounterNumBuckets
+	}
 	ret := RollingCounter{
 		buckets: make([]AtomicInt64, numBuckets),
 		rollingBucket: RollingBuckets{
@@ -34,6 +47,22 @@ func NewRollingCounter(bucketWidth time.Duration, numBuckets int, now time.Time)
 	return ret
 }
 
+// BucketWidth splits window into numBuckets buckets of equal width, for NewRollingCounter and NewRollingPercentile.
+// The width is rounded to the nearest nanosecond and raised to at least 1ms, so when window does not divide evenly the
+// buckets cover numBuckets * width, which can be slightly more or less than window.  A numBuckets below 1 is treated
+// as 1.
+func BucketWidth(window time.Duration, numBuckets int) time.Duration {
+	if numBuckets < minRollingCounterNumBuckets {
+		numBuckets = minRollingCounterNumBuckets
+	}
+	n := int64(numBuckets)
+	width := time.Duration((window.Nanoseconds() + n/2) / n)
+	if width < minRollingCounterBucketWidth {
+		return minRollingCounterBucketWidth
+	}
+	return width
+}
+
 var _ json.Marshaler = &RollingCounter{}
 var _ json.Unmarshaler = &RollingCounter{}
 var _ fmt.Stringer = &RollingCounter{}
@@ -56,15 +85,31 @@ func (r *RollingCounter) MarshalJSON() ([]byte, error) {
 }
 
 // UnmarshalJSON stores the previous JSON encoding.  Note, this is *NOT* thread safe.
+//
+// Buckets that have fallen out of the rolling window are discarded the next time the counter is used at a later time.
+// If the encoding has no bucket information, the rolling window is restored as the zero value and only the total sum
+// is kept.
 func (r *RollingCounter) UnmarshalJSON(b []byte) error {
 	var into jsonCounter
 	if err := json.Unmarshal(b, &into); err != nil {
 		return err
 	}
+	r.totalSum = AtomicInt64{}
+	if into.TotalSum != nil {
+		r.totalSum.Set(into.TotalSum.Get())
+	}
+	if into.RollingBucket == nil || into.RollingSum == nil || len(into.Buckets) != into.RollingBucket.NumBuckets {
+		r.buckets = nil
+		r.rollingSum = AtomicInt64{}
+		r.rollingBucket = RollingBuckets{}
+		return nil
+	}
 	r.buckets = into.Buckets
-	r.rollingSum = *into.RollingSum
-	r.totalSum = *into.TotalSum
-	r.rollingBucket = *into.RollingBucket
+	r.rollingSum.Set(into.RollingSum.Get())
+	r.rollingBucket.NumBuckets = into.RollingBucket.NumBuckets
+	r.rollingBucket.StartTime = into.RollingBucket.StartTime
+	r.rollingBucket.BucketWidth = into.RollingBucket.BucketWidth
+	r.rollingBucket.LastAbsIndex.Set(into.RollingBucket.LastAbsIndex.Get())
 	return nil
 }
 
@@ -83,9 +128,15 @@ func (r *RollingCounter) StringAt(now time.Time) string {
 	return fmt.Sprintf("rolling_sum=%d total_sum=%d parts=(%s)", r.RollingSumAt(now), r.TotalSum(), strings.Join(parts, ","))
 }
 
-// Inc adds a single event to the current bucket
+// Inc adds a single event to the current bucket.  It is Add(now, 1).
 func (r *RollingCounter) Inc(now time.Time) {
-	r.totalSum.Add(1)
+	r.Add(now, 1)
+}
+
+// Add adds delta to the current bucket, for counters that track an amount, like bytes processed, rather than a count
+// of events.  The rolling and total sums include it.  Like Inc, it is lock free and safe to call concurrently.
+func (r *RollingCounter) Add(now time.Time, delta int64) {
+	r.totalSum.Add(delta)
 	if len(r.buckets) == 0 {
 		return
 	}
@@ -93,11 +144,12 @@ func (r *RollingCounter) Inc(now time.Time) {
 	if idx < 0 {
 		return
 	}
-	r.buckets[idx].Add(1)
-	r.rollingSum.Add(1)
+	r.buckets[idx].Add(delta)
+	r.rollingSum.Add(delta)
 }
 
-// RollingSumAt returns the total number of events in the rolling time window
+// RollingSumAt returns the total number of events in the rolling time window.  Buckets that expired before now are
+// cleared first, so the sum only counts live buckets.  It is safe to call while other goroutines Inc.
 func (r *RollingCounter) RollingSumAt(now time.Time) int64 {
 	r.rollingBucket.Advance(now, r.clearBucket)
 	return r.rollingSum.Get()
@@ -109,13 +161,33 @@ func (r *RollingCounter) RollingSum() int64 {
 	return r.rollingSum.Get()
 }
 
-// TotalSum returns the total number of events of all time
+// TotalSum returns the total number of events of all time.  Use RollingSumAt for the sum over the rolling window.
 func (r *RollingCounter) TotalSum() int64 {
 	return r.totalSum.Get()
 }
 
-// GetBuckets returns a copy of the buckets in order backwards in time
+// RollingPercentageOf returns part's rolling sum as a percentage (0 - 100) of total's, both at now.  Use it for error
+// rates, with the errors as part and the attempts as total.  It is 0 if total has no events in the window.  The
+// counters are read one after the other, part first, so a writer that increments total before part is never seen
+// above 100%.  Anything above is clamped to 100.
+func RollingPercentageOf(part *RollingCounter, total *RollingCounter, now time.Time) float64 {
+	partSum := part.RollingSumAt(now)
+	totalSum := total.RollingSumAt(now)
+	if totalSum <= 0 {
+		return 0
+	}
+	if partSum >= totalSum {
+		return 100
+	}
+	return float64(partSum) * 100 / float64(totalSum)
+}
+
+// GetBuckets returns a copy of the buckets in order backwards in time.  It is nil for a counter without buckets, like
+// one restored from JSON without bucket information.
 func (r *RollingCounter) GetBuckets(now time.Time) []int64 {
+	if r.rollingBucket.NumBuckets == 0 {
+		return nil
+	}
 	r.rollingBucket.Advance(now, r.clearBucket)
 	startIdx := int(r.rollingBucket.LastAbsIndex.Get() % int64(r.rollingBucket.NumBuckets))
 	ret := make([]int64, r.rollingBucket.NumBuckets)
@@ -129,12 +201,55 @@ func (r *RollingCounter) GetBuckets(now time.Time) []int64 {
 	return ret
 }
 
+// GetAt returns the count of the bucket holding t, or zero if that bucket is no longer, or not yet, in the window.
+// Unlike GetBuckets, it does not advance the window, so it changes nothing: the window is where the last Inc or read
+// left it.
+func (r *RollingCounter) GetAt(t time.Time) int64 {
+	idx := r.rollingBucket.liveIndex(t)
+	if idx < 0 {
+		return 0
+	}
+	return r.buckets[idx].Get()
+}
+
+// TimedBucket is the count of events in one bucket of a RollingCounter
+type TimedBucket struct {
+	// Start is when the bucket starts.  It ends one bucket width later.
+	Start time.Time
+	Count int64
+}
+
+// GetTimedBuckets is GetBuckets, but includes when each bucket starts.  Buckets are in order backwards in time.  Starts
+// are the counter's start time plus a whole number of bucket widths, so counters created with the same start time and
+// width line up bucket for bucket.  The returned slice is a copy.
+func (r *RollingCounter) GetTimedBuckets(now time.Time) []TimedBucket {
+	if r.rollingBucket.NumBuckets == 0 {
+		return nil
+	}
+	r.rollingBucket.Advance(now, r.clearBucket)
+	lastAbsIndex := r.rollingBucket.LastAbsIndex.Get()
+	ret := make([]TimedBucket, r.rollingBucket.NumBuckets)
+	for i := 0; i < r.rollingBucket.NumBuckets; i++ {
+		absIndex := lastAbsIndex - int64(i)
+		idx := int(absIndex % int64(r.rollingBucket.NumBuckets))
+		if idx < 0 {
+			idx += r.rollingBucket.NumBuckets
+		}
+		ret[i] = TimedBucket{
+			Start: r.rollingBucket.StartTime.Add(time.Duration(absIndex) * r.rollingBucket.BucketWidth),
+			Count: r.buckets[idx].Get(),
+		}
+	}
+	return ret
+}
+
 func (r *RollingCounter) clearBucket(idx int) {
 	toDec := r.buckets[idx].Swap(0)
 	r.rollingSum.Add(-toDec)
 }
 
-// Reset the counter to all zero values.
+// Reset the counter to all zero values.  The window is first advanced to now, so counts made later than now are also
+// cleared.  Like Inc, it is lock free and safe to call concurrently.  TotalSum is not reset.
 func (r *RollingCounter) Reset(now time.Time) {
 	r.rollingBucket.Advance(now, r.clearBucket)
 	for i := 0; i < r.rollingBucket.NumBuckets; i++ {
@@ -23,6 +23,160 @@ func TestRollingCounter_Empty(t *testing.T) {
 	}
 }
 
+func TestRollingCounter_InvalidParameters(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		bucketWidth time.Duration
+		numBuckets  int
+	}{
+		{0, 0},
+		{-time.Second, -1},
+		{time.Second, 0},
+		{0, 10},
+	} {
+		x := NewRollingCounter(tc.bucketWidth, tc.numBuckets, now)
+		x.Inc(now)
+		x.Inc(now.Add(time.Second))
+		if x.TotalSum() != 2 {
+			t.Error("expected every event to count", tc.bucketWidth, tc.numBuckets)
+		}
+		if s := x.RollingSumAt(now.Add(time.Second)); s < 1 {
+			t.Error("expected the current bucket to count", tc.bucketWidth, tc.numBuckets, s)
+		}
+		if len(x.GetBuckets(now)) < 1 {
+			t.Error("expected at least one bucket", tc.bucketWidth, tc.numBuckets)
+		}
+	}
+}
+
+func TestRollingCounter_GetTimedBuckets(t *testing.T) {
+	now := time.Now()
+	x := NewRollingCounter(time.Millisecond, 3, now)
+	x.Inc(now)
+	x.Inc(now.Add(time.Millisecond * 2))
+	x.Inc(now.Add(time.Millisecond * 2))
+	b := x.GetTimedBuckets(now.Add(time.Millisecond * 2))
+	if len(b) != 3 {
+		t.Fatal("expected one entry per bucket", len(b))
+	}
+	expected := []TimedBucket{
+		{Start: now.Add(time.Millisecond * 2), Count: 2},
+		{Start: now.Add(time.Millisecond), Count: 0},
+		{Start: now, Count: 1},
+	}
+	for i := range expected {
+		if !b[i].Start.Equal(expected[i].Start) || b[i].Count != expected[i].Count {
+			t.Errorf("bucket %d: expected %v, saw %v", i, expected[i], b[i])
+		}
+	}
+	empty := RollingCounter{}
+	if empty.GetTimedBuckets(now) != nil {
+		t.Error("expected no buckets from an empty counter")
+	}
+}
+
+func TestRollingCounter_GetTimedBucketsAligned(t *testing.T) {
+	start := time.Now()
+	width := time.Millisecond * 100
+	x := NewRollingCounter(width, 5, start)
+	x.Inc(start.Add(time.Millisecond * 550))
+	now := start.Add(time.Millisecond * 730)
+	b := x.GetTimedBuckets(now)
+	for i, bucket := range b {
+		if offset := bucket.Start.Sub(start); offset%width != 0 {
+			t.Errorf("bucket %d starts %s after the counter, not on a bucket boundary", i, offset)
+		}
+		if i > 0 && b[i-1].Start.Sub(bucket.Start) != width {
+			t.Errorf("bucket %d is not one width before bucket %d", i, i-1)
+		}
+	}
+	if !b[0].Start.Equal(start.Add(time.Millisecond * 700)) {
+		t.Error("expected the current bucket to start at 700ms", b[0])
+	}
+	if b[2].Count != 1 || !b[2].Start.Equal(start.Add(time.Millisecond*500)) {
+		t.Error("expected the event in the bucket starting at 500ms", b)
+	}
+	b[0].Count = 100
+	if x.GetTimedBuckets(now)[0].Count != 0 {
+		t.Error("expected GetTimedBuckets to return a copy")
+	}
+}
+
+func TestRollingCounter_GetAt(t *testing.T) {
+	start := time.Now()
+	width := time.Millisecond * 100
+	x := NewRollingCounter(width, 5, start)
+	x.Inc(start.Add(time.Millisecond * 50))
+	x.Inc(start.Add(time.Millisecond * 320))
+	x.Inc(start.Add(time.Millisecond * 350))
+	for offset, expected := range map[time.Duration]int64{
+		0:                       1,
+		time.Millisecond * 99:   1,
+		time.Millisecond * 100:  0,
+		time.Millisecond * 300:  2,
+		time.Millisecond * 399:  2,
+		time.Millisecond * 400:  0,
+		-time.Millisecond:       0,
+		time.Millisecond * 1000: 0,
+	} {
+		if got := x.GetAt(start.Add(offset)); got != expected {
+			t.Errorf("expected %d events at %s, saw %d", expected, offset, got)
+		}
+	}
+
+	// Moving the window past the first bucket drops it, but GetAt itself never moves the window
+	x.Inc(start.Add(time.Millisecond * 520))
+	if x.GetAt(start) != 0 {
+		t.Error("expected the first bucket to have left the window")
+	}
+	if x.GetAt(start.Add(time.Millisecond*300)) != 2 || x.RollingSumAt(start.Add(time.Millisecond*520)) != 3 {
+		t.Error("expected later buckets to stay", x.StringAt(start.Add(time.Millisecond*520)))
+	}
+}
+
+func TestRollingCounter_Add(t *testing.T) {
+	start := time.Now()
+	width := time.Millisecond * 100
+	x := NewRollingCounter(width, 3, start)
+	x.Add(start, 1024)
+	x.Inc(start.Add(time.Millisecond * 10))
+	x.Add(start.Add(time.Millisecond*150), 512)
+	x.Add(start.Add(time.Millisecond*250), -12)
+	now := start.Add(time.Millisecond * 250)
+	if buckets := x.GetBuckets(now); len(buckets) != 3 || buckets[0] != -12 || buckets[1] != 512 || buckets[2] != 1025 {
+		t.Error("expected each bucket to hold the sum of its deltas", buckets)
+	}
+	if x.RollingSumAt(now) != 1525 || x.TotalSum() != 1525 {
+		t.Error("expected the sums to include every delta", x.StringAt(now))
+	}
+	// The first bucket leaves the window, but stays in the total
+	later := start.Add(time.Millisecond * 350)
+	x.Add(later, 100)
+	if x.RollingSumAt(later) != 600 || x.TotalSum() != 1625 {
+		t.Error("expected the expired bucket to leave only the rolling sum", x.StringAt(later))
+	}
+}
+
+func TestBucketWidth(t *testing.T) {
+	for _, tc := range []struct {
+		window     time.Duration
+		numBuckets int
+		width      time.Duration
+	}{
+		{time.Second * 30, 30, time.Second},
+		{time.Second * 10, 3, time.Nanosecond * 3333333333},
+		{time.Second, 7, time.Nanosecond * 142857143},
+		{time.Second * 30, 7, time.Nanosecond * 4285714286},
+		{time.Nanosecond * 20, 3, time.Millisecond},
+		{time.Second, 0, time.Second},
+		{time.Second, -2, time.Second},
+	} {
+		if width := BucketWidth(tc.window, tc.numBuckets); width != tc.width {
+			t.Errorf("%s in %d buckets: expected a width of %s, saw %s", tc.window, tc.numBuckets, tc.width, width)
+		}
+	}
+}
+
 func TestRollingCounter_MovingBackwards(t *testing.T) {
 	now := time.Now()
 	x := NewRollingCounter(time.Millisecond, 10, now)
@@ -39,6 +193,26 @@ func TestRollingCounter_MovingBackwards(t *testing.T) {
 	}
 }
 
+func TestRollingCounter_RollingSumAtExpires(t *testing.T) {
+	now := time.Now()
+	x := NewRollingCounter(time.Millisecond, 3, now)
+	x.Inc(now)
+	x.Inc(now.Add(time.Millisecond))
+	x.Inc(now.Add(time.Millisecond * 2))
+	if s := x.RollingSumAt(now.Add(time.Millisecond * 2)); s != 3 {
+		t.Errorf("expect all three buckets to count, saw %d", s)
+	}
+	if s := x.RollingSumAt(now.Add(time.Millisecond * 3)); s != 2 {
+		t.Errorf("expect oldest bucket to expire, saw %d", s)
+	}
+	if s := x.RollingSumAt(now.Add(time.Millisecond * 5)); s != 0 {
+		t.Errorf("expect every bucket to expire, saw %d", s)
+	}
+	if x.TotalSum() != 3 {
+		t.Error("total sum should not expire")
+	}
+}
+
 func TestRollingCounter_NormalConsistency(t *testing.T) {
 	now := time.Now()
 	bucketSize := 100
@@ -259,3 +433,101 @@ func TestRollingCounter_MoveForward(t *testing.T) {
 		t.Errorf("Should see a sum of 1 after advancing past all the buckets, saw %d", s)
 	}
 }
+
+func TestRollingCounter_JSONRestore(t *testing.T) {
+	now := time.Now()
+	x := NewRollingCounter(time.Second, 10, now)
+	x.Inc(now)
+	x.Inc(now.Add(time.Second * 3))
+	asBytes, err := json.Marshal(&x)
+	if err != nil {
+		t.Fatal("unexpected error marshalling", err)
+	}
+	var y RollingCounter
+	if err := json.Unmarshal(asBytes, &y); err != nil {
+		t.Fatal("unexpected error unmarshalling", err)
+	}
+	if y.TotalSum() != 2 {
+		t.Error("expected total sum to restore", y.TotalSum())
+	}
+	if s := y.RollingSumAt(now.Add(time.Second * 5)); s != 2 {
+		t.Error("expected both events inside the window", s)
+	}
+	if s := y.RollingSumAt(now.Add(time.Second * 12)); s != 1 {
+		t.Error("expected the oldest event to leave the window", s)
+	}
+
+	var z RollingCounter
+	if err := json.Unmarshal([]byte(`{"TotalSum": 5}`), &z); err != nil {
+		t.Fatal("unexpected error unmarshalling", err)
+	}
+	if z.TotalSum() != 5 || z.RollingSumAt(now) != 0 {
+		t.Error("expected a zero rolling window when bucket information is missing")
+	}
+	if b := z.GetBuckets(now); len(b) != 0 {
+		t.Error("expected no buckets when bucket information is missing", b)
+	}
+	if s := z.String(); !strings.Contains(s, "total_sum=5") {
+		t.Error("expected the restored total sum in the string", s)
+	}
+}
+
+func TestRollingCounter_Reset(t *testing.T) {
+	now := time.Now()
+	x := NewRollingCounter(time.Second, 10, now)
+	for i := 0; i < 10; i++ {
+		x.Inc(now.Add(time.Duration(i) * time.Second))
+	}
+	resetAt := now.Add(time.Second * 9)
+	if s := x.RollingSumAt(resetAt); s != 10 {
+		t.Fatal("expected every event in the window", s)
+	}
+	x.Reset(resetAt)
+	if s := x.RollingSumAt(resetAt); s != 0 {
+		t.Error("expected reset to clear the window", s)
+	}
+	for _, b := range x.GetBuckets(resetAt) {
+		if b != 0 {
+			t.Error("expected every bucket to be zero", x.GetBuckets(resetAt))
+		}
+	}
+	if x.TotalSum() != 10 {
+		t.Error("reset should not change the total sum", x.TotalSum())
+	}
+	x.Inc(resetAt.Add(time.Second))
+	if s := x.RollingSumAt(resetAt.Add(time.Second)); s != 1 {
+		t.Error("expected counting to continue after reset", s)
+	}
+}
+
+func TestRollingPercentageOf(t *testing.T) {
+	now := time.Now()
+	errs := NewRollingCounter(time.Second, 10, now)
+	total := NewRollingCounter(time.Second, 10, now)
+	if p := RollingPercentageOf(&errs, &total, now); p != 0 {
+		t.Error("expected 0% without any events", p)
+	}
+	// One in four requests fails, spread over the window
+	for i := 0; i < 40; i++ {
+		at := now.Add(time.Duration(i) * time.Second / 4)
+		total.Inc(at)
+		if i%4 == 0 {
+			errs.Inc(at)
+		}
+	}
+	end := now.Add(time.Second * 9)
+	if p := RollingPercentageOf(&errs, &total, end); p != 25 {
+		t.Error("expected a 25% error rate", p)
+	}
+	// Once the old buckets expire, only new events count
+	later := now.Add(time.Second * 30)
+	errs.Add(later, 3)
+	total.Add(later, 6)
+	if p := RollingPercentageOf(&errs, &total, later); p != 50 {
+		t.Error("expected only the live buckets to count", p)
+	}
+	errs.Add(later, 100)
+	if p := RollingPercentageOf(&errs, &total, later); p != 100 {
+		t.Error("expected the percentage to be clamped to 100", p)
+	}
+}
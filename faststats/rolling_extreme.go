@@ -0,0 +1,154 @@
+package faststats
+
+import (
+	"math"
+	"time"
+)
+
+// RollingMax tracks the largest value observed in each bucket of a sliding window, like RollingCounter tracks counts
+type RollingMax struct {
+	extreme rollingExtreme
+}
+
+// RollingMin tracks the smallest value observed in each bucket of a sliding window, like RollingCounter tracks counts
+type RollingMin struct {
+	extreme rollingExtreme
+}
+
+// NewRollingMax initializes a RollingMax with a bucket width and # of buckets.  Invalid sizes are raised like
+// NewRollingCounter does.
+func NewRollingMax(bucketWidth time.Duration, numBuckets int, now time.Time) RollingMax {
+	return RollingMax{
+		extreme: newRollingExtreme(bucketWidth, numBuckets, now, math.MinInt64, func(current int64, value int64) bool {
+			return value > current
+		}),
+	}
+}
+
+// NewRollingMin initializes a RollingMin with a bucket width and # of buckets.  Invalid sizes are raised like
+// NewRollingCounter does.
+func NewRollingMin(bucketWidth time.Duration, numBuckets int, now time.Time) RollingMin {
+	return RollingMin{
+		extreme: newRollingExtreme(bucketWidth, numBuckets, now, math.MaxInt64, func(current int64, value int64) bool {
+			return value < current
+		}),
+	}
+}
+
+// Observe records value in the current bucket
+func (r *RollingMax) Observe(value int64, now time.Time) {
+	r.extreme.observe(value, now)
+}
+
+// MaxAt returns the largest value observed in any live bucket.  It returns false if no live bucket has a value.
+func (r *RollingMax) MaxAt(now time.Time) (int64, bool) {
+	return r.extreme.extremeAt(now)
+}
+
+// Reset clears every bucket
+func (r *RollingMax) Reset(now time.Time) {
+	r.extreme.reset(now)
+}
+
+// Observe records value in the current bucket
+func (r *RollingMin) Observe(value int64, now time.Time) {
+	r.extreme.observe(value, now)
+}
+
+// MinAt returns the smallest value observed in any live bucket.  It returns false if no live bucket has a value.
+func (r *RollingMin) MinAt(now time.Time) (int64, bool) {
+	return r.extreme.extremeAt(now)
+}
+
+// Reset clears every bucket
+func (r *RollingMin) Reset(now time.Time) {
+	r.extreme.reset(now)
+}
+
+// rollingExtreme keeps the most extreme value of each bucket.  Empty buckets hold empty, which no observed value can
+// replace in the wrong direction.
+type rollingExtreme struct {
+	buckets       []AtomicInt64
+	empty         int64
+	replaces      func(current int64, value int64) bool
+	rollingBucket RollingBuckets
+}
+
+func newRollingExtreme(bucketWidth time.Duration, numBuckets int, now time.Time, empty int64, replaces func(current int64, value int64) bool) rollingExtreme {
+	if bucketWidth < minRollingCounterBucketWidth {
+		bucketWidth = minRollingCounterBucketWidth
+	}
+	if numBuckets < minRollingCounterNumBuckets {
+		numBuckets = minRollingCounterNumBuckets
+	}
+	ret := rollingExtreme{
+		buckets:  make([]AtomicInt64, numBuckets),
+		empty:    empty,
+		replaces: replaces,
+		rollingBucket: RollingBuckets{
+			NumBuckets:  numBuckets,
+			BucketWidth: bucketWidth,
+			StartTime:   now,
+		},
+	}
+	for i := range ret.buckets {
+		ret.buckets[i].Set(empty)
+	}
+	return ret
+}
+
+func (r *rollingExtreme) clearBucket(idx int) {
+	r.buckets[idx].Set(r.empty)
+}
+
+func (r *rollingExtreme) observe(value int64, now time.Time) {
+	if len(r.buckets) == 0 {
+		return
+	}
+	idx := r.rollingBucket.Advance(now, r.clearBucket)
+	if idx < 0 {
+		return
+	}
+	for {
+		current := r.buckets[idx].Get()
+		if current != r.empty && !r.replaces(current, value) {
+			return
+		}
+		if r.buckets[idx].CompareAndSwap(current, value) {
+			return
+		}
+	}
+}
+
+func (r *rollingExtreme) extremeAt(now time.Time) (int64, bool) {
+	if len(r.buckets) == 0 {
+		return 0, false
+	}
+	r.rollingBucket.Advance(now, r.clearBucket)
+	ret := r.empty
+	found := false
+	for i := range r.buckets {
+		v := r.buckets[i].Get()
+		if v == r.empty {
+			continue
+		}
+		if !found || r.replaces(ret, v) {
+			ret = v
+			found = true
+		}
+	}
+	if !found {
+		return 0, false
+	}
+	return ret, true
+}
+
+func (r *rollingExtreme) reset(now time.Time) {
+	if len(r.buckets) == 0 {
+		return
+	}
+	r.rollingBucket.Advance(now, r.clearBucket)
+	for i := range r.buckets {
+		r.clearBucket(i)
+	}
+}
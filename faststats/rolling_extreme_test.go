@@ -0,0 +1,73 @@
+package faststats
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRollingMax(t *testing.T) {
+	now := time.Now()
+	x := NewRollingMax(time.Second, 3, now)
+	if _, ok := x.MaxAt(now); ok {
+		t.Fatal("expected no max before any value is observed")
+	}
+	x.Observe(5, now)
+	x.Observe(2, now)
+	x.Observe(9, now.Add(time.Second))
+	x.Observe(-1, now.Add(2*time.Second))
+	if m, ok := x.MaxAt(now.Add(2 * time.Second)); !ok || m != 9 {
+		t.Fatal("expected the max of every live bucket", m)
+	}
+	// The bucket holding 9 expires, leaving only -1
+	if m, ok := x.MaxAt(now.Add(4 * time.Second)); !ok || m != -1 {
+		t.Fatal("expected the max to recompute once buckets expire", m)
+	}
+	if _, ok := x.MaxAt(now.Add(10 * time.Second)); ok {
+		t.Fatal("expected no max once every bucket expires")
+	}
+	x.Observe(3, now.Add(10*time.Second))
+	x.Reset(now.Add(10 * time.Second))
+	if _, ok := x.MaxAt(now.Add(10 * time.Second)); ok {
+		t.Fatal("expected reset to clear the max")
+	}
+}
+
+func TestRollingMin(t *testing.T) {
+	now := time.Now()
+	x := NewRollingMin(time.Second, 2, now)
+	x.Observe(5, now)
+	x.Observe(2, now)
+	x.Observe(7, now.Add(time.Second))
+	if m, ok := x.MinAt(now.Add(time.Second)); !ok || m != 2 {
+		t.Fatal("expected the min of every live bucket", m)
+	}
+	if m, ok := x.MinAt(now.Add(2 * time.Second)); !ok || m != 7 {
+		t.Fatal("expected the min to recompute once buckets expire", m)
+	}
+}
+
+func TestRollingMax_Empty(t *testing.T) {
+	x := RollingMax{}
+	x.Observe(1, time.Now())
+	if _, ok := x.MaxAt(time.Now()); ok {
+		t.Fatal("the zero value should not track anything")
+	}
+}
+
+func TestRollingMax_Races(t *testing.T) {
+	now := time.Now()
+	x := NewRollingMax(time.Millisecond, 10, now)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				x.Observe(int64(i*1000+j), time.Now())
+				x.MaxAt(time.Now())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
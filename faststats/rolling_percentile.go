@@ -7,6 +7,7 @@ import (
 	"math"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/cep21/circuit/internal/evar"
@@ -16,6 +17,26 @@ import (
 type RollingPercentile struct {
 	buckets       []durationsBucket
 	rollingBucket RollingBuckets
+
+	// Only 1 in sampleRate durations are recorded.  Zero and one record all of them.
+	sampleRate  AtomicInt64
+	sampleCount AtomicInt64
+	// If set, full buckets evict a random duration instead of the oldest one
+	reservoir AtomicBoolean
+	// randInt63n is the func(n int64) int64 from SetRand.  Without one, randState seeds a source of this
+	// RollingPercentile's own.
+	randInt63n atomic.Value
+	randState  AtomicInt64
+	// If set, SnapshotAt reuses a snapshot for this many nanoseconds
+	cacheDuration AtomicInt64
+	cached        atomic.Value // *cachedSnapshot
+}
+
+// cachedSnapshot is a snapshot SnapshotAt can return until expires
+type cachedSnapshot struct {
+	snap    SortedDurations
+	taken   time.Time
+	expires time.Time
 }
 
 // SortedDurations is a sorted list of time.Duration that allows fast Percentile operations
@@ -116,6 +137,7 @@ func NewRollingPercentile(bucketWidth time.Duration, numBuckets int, bucketSize
 			StartTime:   now,
 		},
 	}
+	ret.randState.Set(now.UnixNano())
 	return ret
 }
 
@@ -138,6 +160,18 @@ func (r *RollingPercentile) Var() expvar.Var {
 
 // SortedDurations creates a raw []time.Duration in sorted order that is stored in these buckets
 func (r *RollingPercentile) SortedDurations(now time.Time) []time.Duration {
+	ret := r.SamplesAt(now)
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
+
+// SamplesAt returns a point in time copy of every duration stored in the buckets of the window that ends at now, in
+// no particular order, so external code can build its own histogram.  Expired buckets are left out.  Durations are
+// read atomically, so it is safe to call while other goroutines add durations, but those durations may or may not be
+// included.  The copy is never changed by later calls, or reused by the cache of SetCacheDuration.
+func (r *RollingPercentile) SamplesAt(now time.Time) []time.Duration {
 	if len(r.buckets) == 0 {
 		return nil
 	}
@@ -146,9 +180,6 @@ func (r *RollingPercentile) SortedDurations(now time.Time) []time.Duration {
 	for idx := range r.buckets {
 		ret = append(ret, r.buckets[idx].Durations()...)
 	}
-	sort.Slice(ret, func(i, j int) bool {
-		return ret[i] < ret[j]
-	})
 	return ret
 }
 
@@ -157,30 +188,154 @@ func (r *RollingPercentile) Snapshot() SortedDurations {
 	return r.SnapshotAt(time.Now())
 }
 
-// SnapshotAt is an optimization on Snapshot that takes the current time
+// SnapshotAt is an optimization on Snapshot that takes the current time.  With SetCacheDuration, the snapshot may be
+// shared with other callers, so do not modify it.
 func (r *RollingPercentile) SnapshotAt(now time.Time) SortedDurations {
-	return SortedDurations(r.SortedDurations(now))
+	cacheDuration := r.cacheDuration.Duration()
+	if cacheDuration <= 0 {
+		return SortedDurations(r.SortedDurations(now))
+	}
+	if c, ok := r.cached.Load().(*cachedSnapshot); ok && c != nil && !now.Before(c.taken) && now.Before(c.expires) {
+		return c.snap
+	}
+	snap := SortedDurations(r.SortedDurations(now))
+	r.cached.Store(&cachedSnapshot{snap: snap, taken: now, expires: now.Add(cacheDuration)})
+	return snap
+}
+
+// SetCacheDuration makes SnapshotAt, and everything built on it like QuantilesAt, reuse a snapshot for up to d
+// instead of copying and sorting the durations on every call.  Use it when something reads percentiles often, like
+// a metrics scrape of many circuits.  Durations added meanwhile only show up once the cached snapshot expires.  Zero,
+// the default, caches nothing.  It is safe to call while other goroutines add durations.
+func (r *RollingPercentile) SetCacheDuration(d time.Duration) {
+	r.cacheDuration.Set(d.Nanoseconds())
+	r.cached.Store((*cachedSnapshot)(nil))
+}
+
+// Quantiles is QuantilesAt using time.Now
+func (r *RollingPercentile) Quantiles(ps ...float64) []time.Duration {
+	return r.QuantilesAt(time.Now(), ps...)
+}
+
+// QuantilesAt returns the percentile of the current rolling window for each of ps, in the same order.  ps use the
+// same scale as SortedDurations.Percentile.  The durations are copied and sorted once, no matter how many ps are
+// asked for, so this is cheaper than taking a snapshot per percentile.  With no durations, every result is -1.
+func (r *RollingPercentile) QuantilesAt(now time.Time, ps ...float64) []time.Duration {
+	snap := r.SnapshotAt(now)
+	ret := make([]time.Duration, len(ps))
+	for i, p := range ps {
+		ret[i] = snap.Percentile(p)
+	}
+	return ret
 }
 
 func (r *RollingPercentile) clearBucket(idx int) {
 	r.buckets[idx].clear()
 }
 
+// SetSampleRate records only 1 in every n durations passed to AddDuration, so busy callers spend less time recording
+// and each bucket's bucketSize durations are spread over more of its time.  Every nth duration is kept, which keeps the
+// shape of the distribution, so percentiles need no adjustment.  Only the number of stored durations shrinks, by a
+// factor of n.  An n below 2 records every duration.  It is safe to call while other goroutines add durations.
+func (r *RollingPercentile) SetSampleRate(n int64) {
+	if n < 1 {
+		n = 1
+	}
+	r.sampleRate.Set(n)
+}
+
+// SampleRate is the n of SetSampleRate: 1 in every SampleRate durations is recorded
+func (r *RollingPercentile) SampleRate() int64 {
+	if n := r.sampleRate.Get(); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// SetReservoirSampling changes what happens once a bucket holds bucketSize durations.  By default, each new duration
+// replaces the oldest one, so a busy bucket only describes its last bucketSize durations.  With reservoir sampling,
+// each new duration replaces a random one with a probability that keeps every duration of the bucket equally likely
+// to be stored, so a traffic spike is described by a uniform sample of all of it.  Either way, a bucket never stores
+// more than bucketSize durations.  It is safe to call while other goroutines add durations.
+func (r *RollingPercentile) SetReservoirSampling(enabled bool) {
+	r.reservoir.Set(enabled)
+}
+
+// SetRand makes reservoir sampling use int63n, which returns a random number in [0, n), so sampling can repeat between
+// runs with a seeded source.  int63n must be safe to call from many goroutines.  By default, each RollingPercentile
+// has a lock free source of its own, seeded by its start time.  nil goes back to the default.
+func (r *RollingPercentile) SetRand(int63n func(n int64) int64) {
+	r.randInt63n.Store(int63n)
+}
+
+// splitMixGamma is the splitmix64 increment, 0x9E3779B97F4A7C15, as an int64
+const splitMixGamma = -7046029254386353131
+
+// int63n returns a random number in [0, n) from SetRand's source, or a splitmix64 generator whose state is advanced
+// atomically so concurrent callers never wait on each other
+func (r *RollingPercentile) int63n(n int64) int64 {
+	if f, ok := r.randInt63n.Load().(func(n int64) int64); ok && f != nil {
+		return f(n)
+	}
+	z := uint64(r.randState.Add(splitMixGamma))
+	z = (z ^ (z >> 30)) * 0xBF58476D1CE4E5B9
+	z = (z ^ (z >> 27)) * 0x94D049BB133111EB
+	z ^= z >> 31
+	return int64(z>>1) % n
+}
+
 // AddDuration adds a duration to the rolling buckets
 func (r *RollingPercentile) AddDuration(d time.Duration, now time.Time) {
 	if len(r.buckets) == 0 {
 		return
 	}
+	if n := r.sampleRate.Get(); n > 1 && r.sampleCount.Add(1)%n != 0 {
+		return
+	}
 	idx := r.rollingBucket.Advance(now, r.clearBucket)
+	if idx < 0 {
+		return
+	}
+	if r.reservoir.Get() {
+		r.buckets[idx].sampleDuration(d, r)
+		return
+	}
 	r.buckets[idx].addDuration(d)
 }
 
+// Merge adds the durations stored in other into this rolling percentile.  Durations are added at the time of the
+// bucket they were stored in, so durations outside this rolling window are dropped.  They were already sampled by
+// other, so they are copied into the matching buckets without applying this rolling percentile's SetSampleRate or
+// SetReservoirSampling again.
+func (r *RollingPercentile) Merge(other *RollingPercentile) {
+	if other == nil || len(other.buckets) == 0 || len(r.buckets) == 0 {
+		return
+	}
+	lastAbsIndex := other.rollingBucket.LastAbsIndex.Get()
+	for i := int64(0); i < int64(other.rollingBucket.NumBuckets) && i <= lastAbsIndex; i++ {
+		absIndex := lastAbsIndex - i
+		bucketTime := other.rollingBucket.StartTime.Add(time.Duration(absIndex * other.rollingBucket.BucketWidth.Nanoseconds()))
+		durations := other.buckets[absIndex%int64(other.rollingBucket.NumBuckets)].Durations()
+		if len(durations) == 0 {
+			continue
+		}
+		idx := r.rollingBucket.Advance(bucketTime, r.clearBucket)
+		if idx < 0 {
+			continue
+		}
+		for _, d := range durations {
+			r.buckets[idx].addDuration(d)
+		}
+	}
+}
+
 // Reset the counter to all zero values.
 func (r *RollingPercentile) Reset(now time.Time) {
 	r.rollingBucket.Advance(now, r.clearBucket)
 	for i := 0; i < r.rollingBucket.NumBuckets; i++ {
 		r.clearBucket(i)
 	}
+	r.cached.Store((*cachedSnapshot)(nil))
 }
 
 // durationsBucket supports atomically adding durations to a size limited list
@@ -260,3 +415,20 @@ func (b *durationsBucket) addDuration(d time.Duration) {
 	arrayIndex := nextIndex % int64(len(b.durationsSomeInvalid))
 	b.durationsSomeInvalid[arrayIndex].Set(d.Nanoseconds())
 }
+
+// sampleDuration is addDuration, but once the bucket is full it keeps d with a probability of bucketSize over the
+// number of durations seen, replacing a random stored duration.  The stored durations stay a uniform sample of every
+// duration seen.  r picks which one.  IterateDurations cursors do not apply to sampled buckets.
+func (b *durationsBucket) sampleDuration(d time.Duration, r *RollingPercentile) {
+	if len(b.durationsSomeInvalid) == 0 {
+		return
+	}
+	nextIndex := b.currentIndex.Add(1) - 1
+	if nextIndex < int64(len(b.durationsSomeInvalid)) {
+		b.durationsSomeInvalid[nextIndex].Set(d.Nanoseconds())
+		return
+	}
+	if replace := r.int63n(nextIndex + 1); replace < int64(len(b.durationsSomeInvalid)) {
+		b.durationsSomeInvalid[replace].Set(d.Nanoseconds())
+	}
+}
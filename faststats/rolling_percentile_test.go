@@ -2,6 +2,10 @@ package faststats
 
 import (
 	"encoding/json"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -97,6 +101,28 @@ func TestRollingPercentile_AddDuration(t *testing.T) {
 	})
 }
 
+func TestRollingPercentile_Quantiles(t *testing.T) {
+	now := time.Now()
+	x := NewRollingPercentile(time.Second, 10, 100, now)
+	if q := x.QuantilesAt(now, 50, 99); len(q) != 2 || q[0] != -1 || q[1] != -1 {
+		t.Error("expected -1 for every quantile of an empty window", q)
+	}
+	for i := 1; i <= 50; i++ {
+		x.AddDuration(time.Millisecond*time.Duration(i*7%50+1), now.Add(time.Duration(i)*time.Millisecond*100))
+	}
+	now = now.Add(time.Second * 5)
+	ps := []float64{0, 50, 90, 99, 12.5, 100}
+	quantiles := x.QuantilesAt(now, ps...)
+	if len(quantiles) != len(ps) {
+		t.Fatal("expected a result per quantile", quantiles)
+	}
+	for i, p := range ps {
+		if expected := x.SnapshotAt(now).Percentile(p); quantiles[i] != expected {
+			t.Errorf("p%v: batch=%s individual=%s", p, quantiles[i], expected)
+		}
+	}
+}
+
 func expectSnap(t *testing.T, name string, snap SortedDurations, size int, mean time.Duration, percentiles map[float64]time.Duration) {
 	if len(snap) != size {
 		t.Errorf("Unexpected size: %d vs %d for %s", len(snap), size, name)
@@ -149,3 +175,209 @@ func TestRollingPercentile_Movement(t *testing.T) {
 		100: -1,
 	})
 }
+
+func TestRollingPercentile_SamplesAt(t *testing.T) {
+	now := time.Now()
+	x := NewRollingPercentile(time.Millisecond*100, 10, 100, now)
+	if samples := x.SamplesAt(now); len(samples) != 0 {
+		t.Fatal("expected no samples before any are added", samples)
+	}
+	x.AddDuration(time.Millisecond, now)
+	x.AddDuration(time.Millisecond*3, now.Add(time.Millisecond*500))
+	x.AddDuration(time.Millisecond*2, now.Add(time.Millisecond*1050))
+	samples := x.SamplesAt(now.Add(time.Millisecond * 1050))
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	if len(samples) != 2 || samples[0] != time.Millisecond*2 || samples[1] != time.Millisecond*3 {
+		t.Fatalf("expected the live samples without the expired one, saw %v", samples)
+	}
+	// The copy belongs to the caller
+	samples[0] = time.Hour
+	if again := x.SamplesAt(now.Add(time.Millisecond * 1050)); len(again) != 2 || (again[0] != time.Millisecond*2 && again[1] != time.Millisecond*2) {
+		t.Fatalf("expected changing a copy to leave the samples alone, saw %v", again)
+	}
+	if samples := x.SamplesAt(now.Add(time.Hour)); len(samples) != 0 {
+		t.Fatal("expected every sample to expire", samples)
+	}
+	var empty RollingPercentile
+	if empty.SamplesAt(now) != nil {
+		t.Fatal("expected no samples without buckets")
+	}
+}
+
+func TestRollingPercentile_Merge(t *testing.T) {
+	now := time.Now()
+	x := NewRollingPercentile(time.Millisecond*100, 10, 100, now)
+	x.AddDuration(time.Millisecond, now.Add(time.Millisecond*900))
+
+	y := NewRollingPercentile(time.Millisecond*100, 10, 100, now.Add(-time.Second))
+	// Falls outside x's window
+	y.AddDuration(time.Millisecond*8, now.Add(-time.Millisecond*500))
+	y.AddDuration(time.Millisecond*2, now.Add(time.Millisecond*100))
+	y.AddDuration(time.Millisecond*3, now.Add(time.Millisecond*500))
+
+	x.Merge(&y)
+	snap := x.SnapshotAt(now.Add(time.Millisecond * 900))
+	expectSnap(t, "after merge", snap, 3, time.Millisecond*2, map[float64]time.Duration{
+		0:   time.Millisecond,
+		50:  time.Millisecond * 2,
+		100: time.Millisecond * 3,
+	})
+}
+
+func TestRollingPercentile_MergeOlderWindow(t *testing.T) {
+	now := time.Now()
+	x := NewRollingPercentile(time.Second, 10, 100, now)
+	x.AddDuration(time.Millisecond, now.Add(time.Minute))
+
+	// y's only sample is 59 seconds older than x's window
+	y := NewRollingPercentile(time.Second, 10, 100, now)
+	y.AddDuration(time.Hour, now.Add(time.Second))
+
+	x.Merge(&y)
+	snap := x.SnapshotAt(now.Add(time.Minute))
+	expectSnap(t, "after merge", snap, 1, time.Millisecond, map[float64]time.Duration{
+		100: time.Millisecond,
+	})
+}
+
+func TestRollingPercentile_MergeSampled(t *testing.T) {
+	now := time.Now()
+	x := NewRollingPercentile(time.Second, 10, 100, now)
+	x.SetSampleRate(10)
+	x.SetReservoirSampling(true)
+	y := NewRollingPercentile(time.Second, 10, 100, now)
+	for i := 0; i < 20; i++ {
+		y.AddDuration(time.Millisecond*time.Duration(i), now.Add(time.Second))
+	}
+	// y already chose its samples, so x keeps all of them
+	x.Merge(&y)
+	if snap := x.SnapshotAt(now.Add(time.Second)); len(snap) != 20 {
+		t.Error("expected every merged duration to be kept", len(snap))
+	}
+}
+
+func TestRollingPercentile_SampleRate(t *testing.T) {
+	now := time.Now()
+	full := NewRollingPercentile(time.Minute, 1, 10000, now)
+	sampled := NewRollingPercentile(time.Minute, 1, 10000, now)
+	sampled.SetSampleRate(10)
+	if sampled.SampleRate() != 10 || full.SampleRate() != 1 {
+		t.Fatal("unexpected sample rates", sampled.SampleRate(), full.SampleRate())
+	}
+	// Spread 0-9999ms over the requests in an order that does not repeat every 10 requests
+	for i := 0; i < 10000; i++ {
+		d := time.Millisecond * time.Duration(i*7919%10000)
+		full.AddDuration(d, now)
+		sampled.AddDuration(d, now)
+	}
+	fullSnap := full.SnapshotAt(now)
+	sampledSnap := sampled.SnapshotAt(now)
+	if len(fullSnap) != 10000 || len(sampledSnap) != 1000 {
+		t.Fatal("expected 1 in 10 durations to be stored", len(fullSnap), len(sampledSnap))
+	}
+	for _, p := range []float64{25, 50, 90, 99} {
+		expected, got := fullSnap.Percentile(p), sampledSnap.Percentile(p)
+		if diff := got - expected; diff > time.Millisecond*200 || diff < -time.Millisecond*200 {
+			t.Errorf("p%v: sampled %s is too far from %s", p, got, expected)
+		}
+	}
+}
+
+func TestRollingPercentile_ReservoirSampling(t *testing.T) {
+	now := time.Now()
+	recent := NewRollingPercentile(time.Minute, 1, 500, now)
+	sampled := NewRollingPercentile(time.Minute, 1, 500, now)
+	sampled.SetReservoirSampling(true)
+	// A spike of steadily slower requests, 100 times more than a bucket holds
+	for i := 1; i <= 50000; i++ {
+		d := time.Millisecond * time.Duration(i)
+		recent.AddDuration(d, now)
+		sampled.AddDuration(d, now)
+		if i%1000 == 0 {
+			if n := len(sampled.SnapshotAt(now)); n > 500 {
+				t.Fatalf("expected at most 500 stored durations, saw %d", n)
+			}
+		}
+	}
+	if n := len(sampled.SnapshotAt(now)); n != 500 {
+		t.Fatalf("expected a full bucket, saw %d", n)
+	}
+	if p50 := recent.SnapshotAt(now).Percentile(50); p50 < time.Millisecond*49000 {
+		t.Errorf("expected the default to keep only the most recent durations, saw p50=%s", p50)
+	}
+	// The true percentiles are p% of 50s.  A uniform sample of 500 is well within 5s of them.
+	snap := sampled.SnapshotAt(now)
+	for _, p := range []float64{10, 50, 90} {
+		expected := time.Duration(p / 100 * float64(time.Millisecond*50000))
+		if diff := snap.Percentile(p) - expected; diff > time.Second*5 || diff < -time.Second*5 {
+			t.Errorf("p%v: sampled %s is too far from %s", p, snap.Percentile(p), expected)
+		}
+	}
+}
+
+func TestRollingPercentile_SetRand(t *testing.T) {
+	now := time.Now()
+	sample := func(seed int64) SortedDurations {
+		x := NewRollingPercentile(time.Minute, 1, 10, now)
+		x.SetReservoirSampling(true)
+		x.SetRand(rand.New(rand.NewSource(seed)).Int63n)
+		for i := 1; i <= 1000; i++ {
+			x.AddDuration(time.Duration(i), now)
+		}
+		return x.SnapshotAt(now)
+	}
+	first, second := sample(1), sample(1)
+	if len(first) != 10 || !reflect.DeepEqual(first, second) {
+		t.Errorf("expected the same seed to keep the same durations: %v and %v", first, second)
+	}
+	if reflect.DeepEqual(first, sample(2)) {
+		t.Error("expected another seed to keep other durations")
+	}
+}
+
+func TestRollingPercentile_CacheDuration(t *testing.T) {
+	now := time.Now()
+	x := NewRollingPercentile(time.Second, 10, 100, now)
+	x.SetCacheDuration(250 * time.Millisecond)
+	x.AddDuration(time.Millisecond, now)
+	x.AddDuration(3*time.Millisecond, now)
+	first := x.SnapshotAt(now)
+	// New durations do not invalidate the cache: they show up once it expires
+	x.AddDuration(5*time.Millisecond, now)
+	second := x.SnapshotAt(now.Add(100 * time.Millisecond))
+	if len(second) != 2 || &second[0] != &first[0] {
+		t.Fatalf("expected reads within the cache duration to reuse the sorted snapshot, saw %v", second)
+	}
+	if q := x.QuantilesAt(now.Add(200*time.Millisecond), 50); q[0] != 2*time.Millisecond {
+		t.Errorf("expected QuantilesAt to use the cached snapshot, saw %s", q[0])
+	}
+	refreshed := x.SnapshotAt(now.Add(250 * time.Millisecond))
+	if len(refreshed) != 3 || refreshed.Percentile(50) != 3*time.Millisecond {
+		t.Fatalf("expected an expired cache to take a new snapshot, saw %v", refreshed)
+	}
+	x.Reset(now.Add(300 * time.Millisecond))
+	if n := len(x.SnapshotAt(now.Add(300 * time.Millisecond))); n != 0 {
+		t.Errorf("expected Reset to drop the cached snapshot, saw %d durations", n)
+	}
+	x.SetCacheDuration(0)
+	x.AddDuration(time.Millisecond, now.Add(300*time.Millisecond))
+	if a, b := x.SnapshotAt(now.Add(300*time.Millisecond)), x.SnapshotAt(now.Add(300*time.Millisecond)); &a[0] == &b[0] {
+		t.Error("expected every read to sort again without a cache duration")
+	}
+}
+
+func BenchmarkRollingPercentile_AddDuration(b *testing.B) {
+	for _, rate := range []int64{1, 10, 100} {
+		b.Run("rate="+strconv.FormatInt(rate, 10), func(b *testing.B) {
+			now := time.Now()
+			x := NewRollingPercentile(time.Second, 10, 1000, now)
+			x.SetSampleRate(rate)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					x.AddDuration(time.Millisecond, now)
+				}
+			})
+		})
+	}
+}
@@ -10,16 +10,28 @@ import (
 // TimedCheck lets X events happen every sleepDuration units of time.  For optimizations, it uses TimeAfterFunc to reset
 // an internal atomic boolean for when events are allowed.  This timer could run a little bit behind real time since
 // it depends on when the OS decides to trigger the timer.
+//
+// The zero value is ready to use: it allows one event per interval and does not sleep, so every Check passes until
+// SetSleepDuration is called.
 type TimedCheck struct {
 	sleepDuration     AtomicInt64
 	eventCountToAllow AtomicInt64
 
 	isFastFail        AtomicBoolean
 	isFailFastVersion AtomicInt64
+	// timerless is set once TimeAfterFunc returns a nil timer.  Nothing may ever clear isFastFail then, so Check
+	// compares against nextOpenTime instead.
+	timerless AtomicBoolean
 
+	// TimeAfterFunc should call f after the duration, like time.AfterFunc, which is the default.  Mocks may return
+	// nil: the check then stops relying on f and allows events again once Check is called at or after the end of
+	// the sleep.
 	TimeAfterFunc func(time.Duration, func()) *time.Timer
+	// Now is used by CheckNow and SleepStartNow.  It defaults to time.Now
+	Now func() time.Time
 
-	// All 3 of these variables must be accessed with the RWMutex
+	// All 4 of these variables must be accessed with the RWMutex
+	sleepStartTime             time.Time
 	nextOpenTime               time.Time
 	currentlyAllowedEventCount int64
 	lastSetTimer               *time.Timer
@@ -72,11 +84,38 @@ func (c *TimedCheck) UnmarshalJSON(b []byte) error {
 }
 
 // SetSleepDuration modifies how long time timed check will sleep.  It will not change
-// alredy sleeping checks, but will change during the next check.
+// alredy sleeping checks, but will change during the next check.  Use RescheduleSleepDuration to also shorten a sleep
+// in progress.
 func (c *TimedCheck) SetSleepDuration(newDuration time.Duration) {
 	c.sleepDuration.Set(newDuration.Nanoseconds())
 }
 
+// RescheduleSleepDuration is SetSleepDuration, but a shorter duration also applies to a check that is already
+// sleeping: the pending sleep ends at its original start plus newDuration instead.  A longer duration only applies to
+// the next sleep.
+func (c *TimedCheck) RescheduleSleepDuration(newDuration time.Duration) {
+	c.SetSleepDuration(newDuration)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.isFastFail.Get() {
+		return
+	}
+	newOpenTime := c.sleepStartTime.Add(newDuration)
+	if !newOpenTime.Before(c.nextOpenTime) {
+		return
+	}
+	if c.lastSetTimer != nil {
+		c.lastSetTimer.Stop()
+		c.lastSetTimer = nil
+	}
+	c.nextOpenTime = newOpenTime
+	remaining := newOpenTime.Sub(c.now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	c.lastSetTimer = c.scheduleFastFailReset(remaining)
+}
+
 func (c *TimedCheck) afterFunc(d time.Duration, f func()) *time.Timer {
 	if c.TimeAfterFunc == nil {
 		return time.AfterFunc(d, f)
@@ -84,9 +123,19 @@ func (c *TimedCheck) afterFunc(d time.Duration, f func()) *time.Timer {
 	return c.TimeAfterFunc(d, f)
 }
 
+func (c *TimedCheck) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
+
 // SetEventCountToAllow configures how many times Check() can return true before moving time
-// to the next interval
+// to the next interval.  Values less than 1 are treated as 1.
 func (c *TimedCheck) SetEventCountToAllow(newCount int64) {
+	if newCount < 1 {
+		newCount = 1
+	}
 	c.eventCountToAllow.Set(newCount)
 }
 
@@ -97,26 +146,84 @@ func (c *TimedCheck) SleepStart(now time.Time) {
 	c.mu.Unlock()
 }
 
+// SleepStartNow is SleepStart using the configured Now
+func (c *TimedCheck) SleepStartNow() {
+	c.SleepStart(c.now())
+}
+
+// ClearTimer stops any pending sleep and allows checks again, starting at now
+func (c *TimedCheck) ClearTimer(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastSetTimer != nil {
+		c.lastSetTimer.Stop()
+		c.lastSetTimer = nil
+	}
+	// Bump the version so any timer callback already in flight does nothing
+	c.isFailFastVersion.Add(1)
+	c.nextOpenTime = now
+	c.currentlyAllowedEventCount = 0
+	c.isFastFail.Set(false)
+}
+
+// Remaining returns how long until Check is allowed to return true again.  It returns zero if checks are already
+// allowed.
+func (c *TimedCheck) Remaining(now time.Time) time.Duration {
+	if !c.isFastFail.Get() {
+		return 0
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.nextOpenTime.After(now) {
+		return 0
+	}
+	return c.nextOpenTime.Sub(now)
+}
+
 func (c *TimedCheck) resetOpenTimeWithLock(now time.Time) {
 	if c.lastSetTimer != nil {
 		c.lastSetTimer.Stop()
 		c.lastSetTimer = nil
 	}
+	c.sleepStartTime = now
 	c.nextOpenTime = now.Add(c.sleepDuration.Duration())
 	c.currentlyAllowedEventCount = 0
+	if c.sleepDuration.Get() <= 0 {
+		// Nothing to sleep for.  Allow checks right away, rather than after a zero length timer eventually runs
+		c.isFailFastVersion.Add(1)
+		c.isFastFail.Set(false)
+		return
+	}
 	c.isFastFail.Set(true)
+	c.lastSetTimer = c.scheduleFastFailReset(c.sleepDuration.Duration())
+}
+
+// eventCount is eventCountToAllow, treating the zero value as 1
+func (c *TimedCheck) eventCount() int64 {
+	if count := c.eventCountToAllow.Get(); count > 0 {
+		return count
+	}
+	return 1
+}
+
+// scheduleFastFailReset clears isFastFail after d, unless the sleep is restarted or rescheduled first
+func (c *TimedCheck) scheduleFastFailReset(d time.Duration) *time.Timer {
 	currentVersion := c.isFailFastVersion.Add(1)
-	c.lastSetTimer = c.afterFunc(c.sleepDuration.Duration(), func() {
+	t := c.afterFunc(d, func() {
 		// If sleep start is called again, don't reset from an old version
 		if currentVersion == c.isFailFastVersion.Get() {
 			c.isFastFail.Set(false)
 		}
 	})
+	if t == nil {
+		c.timerless.Set(true)
+	}
+	return t
 }
 
 // Check returns true if a check is allowed at this time
 func (c *TimedCheck) Check(now time.Time) bool {
-	if c.isFastFail.Get() {
+	if c.isFastFail.Get() && !c.timerless.Get() {
 		return false
 	}
 	c.mu.RLock()
@@ -132,9 +239,18 @@ func (c *TimedCheck) Check(now time.Time) bool {
 	if c.nextOpenTime.After(now) {
 		return false
 	}
+	if c.timerless.Get() {
+		// No timer is going to end the sleep, but it is over
+		c.isFastFail.Set(false)
+	}
 	c.currentlyAllowedEventCount++
-	if c.currentlyAllowedEventCount >= c.eventCountToAllow.Get() {
+	if c.currentlyAllowedEventCount >= c.eventCount() {
 		c.resetOpenTimeWithLock(now)
 	}
 	return true
 }
+
+// CheckNow is Check using the configured Now
+func (c *TimedCheck) CheckNow() bool {
+	return c.Check(c.now())
+}
@@ -17,6 +17,34 @@ func TestTimedCheck_Empty(t *testing.T) {
 	}
 }
 
+func TestTimedCheck_ZeroValue(t *testing.T) {
+	var x TimedCheck
+	now := time.Now()
+	x.SleepStart(now)
+	if x.Remaining(now) != 0 {
+		t.Error("expected no sleep without a sleep duration", x.Remaining(now))
+	}
+	for i := 0; i < 3; i++ {
+		if !x.Check(now) {
+			t.Fatal("expected every check to pass without a sleep duration", i)
+		}
+	}
+
+	c := clock.MockClock{}
+	c.Set(now)
+	x.TimeAfterFunc = c.AfterFunc
+	x.SetSleepDuration(time.Second)
+	if !x.Check(now) {
+		t.Fatal("expected the first check to pass")
+	}
+	if x.Check(now) {
+		t.Fatal("expected the zero event count to allow one event per interval")
+	}
+	if !x.Check(c.Set(now.Add(time.Second))) {
+		t.Fatal("expected a check after the sleep")
+	}
+}
+
 func TestTimedCheck_Check(t *testing.T) {
 	c := clock.MockClock{}
 	x := TimedCheck{
@@ -88,3 +116,197 @@ func TestTimedCheckRaces(t *testing.T) {
 	})
 	wg.Wait()
 }
+
+func TestTimedCheck_ClearTimer(t *testing.T) {
+	c := clock.MockClock{}
+	x := TimedCheck{
+		TimeAfterFunc: c.AfterFunc,
+	}
+	x.SetSleepDuration(time.Second)
+	now := time.Now()
+	c.Set(now)
+	x.SleepStart(now)
+	if x.Check(now) {
+		t.Fatal("Should not check right after sleep start")
+	}
+	x.ClearTimer(now)
+	if !x.Check(now) {
+		t.Fatal("Should check right after clearing the timer")
+	}
+}
+
+func TestTimedCheck_Remaining(t *testing.T) {
+	c := clock.MockClock{}
+	x := TimedCheck{
+		TimeAfterFunc: c.AfterFunc,
+	}
+	x.SetSleepDuration(time.Second)
+	now := time.Now()
+	c.Set(now)
+	if x.Remaining(now) != 0 {
+		t.Fatal("Should have nothing remaining before sleeping")
+	}
+	x.SleepStart(now)
+	if r := x.Remaining(now); r != time.Second {
+		t.Fatalf("Expected a full second remaining, saw %s", r)
+	}
+	if r := x.Remaining(c.Set(now.Add(time.Millisecond * 250))); r != time.Millisecond*750 {
+		t.Fatalf("Expected 750ms remaining, saw %s", r)
+	}
+	if r := x.Remaining(c.Set(now.Add(time.Second * 2))); r != 0 {
+		t.Fatalf("Expected nothing remaining after the sleep, saw %s", r)
+	}
+}
+
+func TestTimedCheck_SetEventCountToAllow(t *testing.T) {
+	testCases := []struct {
+		name          string
+		eventCount    int64
+		expectedCount int
+	}{
+		{name: "zero", eventCount: 0, expectedCount: 1},
+		{name: "negative", eventCount: -5, expectedCount: 1},
+		{name: "three", eventCount: 3, expectedCount: 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := clock.MockClock{}
+			x := TimedCheck{
+				TimeAfterFunc: c.AfterFunc,
+			}
+			x.SetSleepDuration(time.Second)
+			x.SetEventCountToAllow(tc.eventCount)
+			now := time.Now()
+			c.Set(now)
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				if x.Check(now) {
+					allowed++
+				}
+			}
+			if allowed != tc.expectedCount {
+				t.Errorf("expected %d allowed checks, saw %d", tc.expectedCount, allowed)
+			}
+		})
+	}
+}
+
+func TestTimedCheck_CheckNow(t *testing.T) {
+	c := clock.MockClock{}
+	x := TimedCheck{
+		TimeAfterFunc: c.AfterFunc,
+		Now:           c.Now,
+	}
+	x.SetSleepDuration(time.Second)
+	c.Set(time.Now())
+	x.SleepStartNow()
+	if x.CheckNow() {
+		t.Fatal("Should not check at first")
+	}
+	c.Add(time.Second)
+	if !x.CheckNow() {
+		t.Fatal("Should check after the sleep duration")
+	}
+}
+
+func TestTimedCheck_RescheduleSleepDuration(t *testing.T) {
+	c := clock.MockClock{}
+	x := TimedCheck{
+		TimeAfterFunc: c.AfterFunc,
+		Now:           c.Now,
+	}
+	now := time.Now()
+	c.Set(now)
+	x.SetSleepDuration(time.Minute)
+	x.SleepStart(now)
+
+	c.Set(now.Add(time.Second * 10))
+	x.RescheduleSleepDuration(time.Second * 20)
+	if x.CheckNow() {
+		t.Fatal("should still sleep before the shorter window ends")
+	}
+	if r := x.Remaining(c.Now()); r != time.Second*10 {
+		t.Fatal("expected the sleep to end relative to its original start", r)
+	}
+	if !x.Check(c.Set(now.Add(time.Second * 20))) {
+		t.Fatal("should reopen once the shorter window ends")
+	}
+	if x.Check(c.Set(now.Add(time.Second * 30))) {
+		t.Fatal("the next sleep should use the new duration")
+	}
+	if !x.Check(c.Set(now.Add(time.Second * 40))) {
+		t.Fatal("should reopen after the new duration")
+	}
+}
+
+func TestTimedCheck_RescheduleSleepDurationLonger(t *testing.T) {
+	c := clock.MockClock{}
+	x := TimedCheck{
+		TimeAfterFunc: c.AfterFunc,
+		Now:           c.Now,
+	}
+	now := time.Now()
+	c.Set(now)
+	x.SetSleepDuration(time.Second)
+	x.SleepStart(now)
+	x.RescheduleSleepDuration(time.Minute)
+	if !x.Check(c.Set(now.Add(time.Second))) {
+		t.Fatal("a longer duration should not extend the current sleep")
+	}
+	if x.Check(c.Set(now.Add(time.Second * 30))) {
+		t.Fatal("a longer duration should apply to the next sleep")
+	}
+}
+
+func TestTimedCheck_RescheduleSleepDurationPastDeadline(t *testing.T) {
+	c := clock.MockClock{}
+	x := TimedCheck{
+		TimeAfterFunc: c.AfterFunc,
+		Now:           c.Now,
+	}
+	now := time.Now()
+	c.Set(now)
+	x.SetSleepDuration(time.Minute)
+	x.SleepStart(now)
+	c.Set(now.Add(time.Second * 30))
+	x.RescheduleSleepDuration(time.Second * 10)
+	if !x.CheckNow() {
+		t.Fatal("a window that already ended should reopen immediately")
+	}
+}
+
+func TestTimedCheck_NilTimer(t *testing.T) {
+	scheduled := 0
+	x := TimedCheck{
+		TimeAfterFunc: func(time.Duration, func()) *time.Timer {
+			// Never calls f, like a mock that does not schedule anything
+			scheduled++
+			return nil
+		},
+	}
+	x.SetSleepDuration(time.Second)
+	now := time.Now()
+	x.SleepStart(now)
+	if scheduled != 1 {
+		t.Fatal("expected the sleep to ask for a timer")
+	}
+	if x.Check(now.Add(time.Millisecond * 999)) {
+		t.Fatal("should not check before the sleep ends")
+	}
+	if x.Remaining(now) != time.Second {
+		t.Error("expected the whole sleep to remain", x.Remaining(now))
+	}
+	if !x.Check(now.Add(time.Second)) {
+		t.Fatal("expected to check once the sleep ends, even though no timer ended it")
+	}
+	if x.Check(now.Add(time.Second)) {
+		t.Fatal("expected checking to start another sleep")
+	}
+	if !x.Check(now.Add(time.Second * 2)) {
+		t.Fatal("expected to check after the next sleep")
+	}
+	x.RescheduleSleepDuration(time.Millisecond)
+	if !x.Check(now.Add(time.Second*2 + time.Millisecond)) {
+		t.Fatal("expected a shortened sleep to end without a timer")
+	}
+}
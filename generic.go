@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package circuit
+
+import "context"
+
+// RunWithResult calls Execute on the circuit, passing through the typed result of runFunc or fallbackFunc.  It behaves
+// exactly like Execute: if Execute returns an error, the zero value of T is returned with it.
+func RunWithResult[T any](ctx context.Context, c *Circuit, runFunc func(context.Context) (T, error), fallbackFunc func(context.Context, error) (T, error)) (T, error) {
+	// Each side sends its result on its own channel, since with Fallback.Hedge the losing side may still be running
+	// when Execute returns
+	runResult := make(chan T, 1)
+	var fallbackResult chan T
+	var fallback func(context.Context, error) error
+	if fallbackFunc != nil {
+		fallbackResult = make(chan T, 1)
+		fallback = func(ctx context.Context, err error) error {
+			result, fallbackErr := fallbackFunc(ctx, err)
+			if fallbackErr != nil {
+				return fallbackErr
+			}
+			fallbackResult <- result
+			return nil
+		}
+	}
+	info, err := c.ExecuteWithInfo(ctx, func(ctx context.Context) error {
+		result, runErr := runFunc(ctx)
+		if runErr != nil {
+			return runErr
+		}
+		runResult <- result
+		return nil
+	}, fallback)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	// Unless a hedged fallback won, runFunc's result is used if it sent one.  Otherwise the fallback succeeded before
+	// Execute returned.
+	if !info.FallbackWon {
+		select {
+		case ret := <-runResult:
+			return ret, nil
+		default:
+		}
+	}
+	return <-fallbackResult, nil
+}
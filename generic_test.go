@@ -0,0 +1,70 @@
+//go:build go1.18
+// +build go1.18
+
+package circuit
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRunWithResult(t *testing.T) {
+	c := NewCircuitFromConfig("TestRunWithResult", Config{})
+	ctx := context.Background()
+	failingRun := func(_ context.Context) (int, error) {
+		return 0, errors.New("failure")
+	}
+
+	ret, err := RunWithResult(ctx, c, func(_ context.Context) (int, error) {
+		return 1, nil
+	}, nil)
+	if err != nil || ret != 1 {
+		t.Errorf("expected the run result, saw %d %v", ret, err)
+	}
+
+	ret, err = RunWithResult(ctx, c, failingRun, func(_ context.Context, _ error) (int, error) {
+		return 2, nil
+	})
+	if err != nil || ret != 2 {
+		t.Errorf("expected the fallback result, saw %d %v", ret, err)
+	}
+
+	c.OpenCircuit()
+	ret, err = RunWithResult(ctx, c, func(_ context.Context) (int, error) {
+		return 3, nil
+	}, nil)
+	if err == nil || ret != 0 {
+		t.Errorf("expected a short circuit with the zero value, saw %d %v", ret, err)
+	}
+}
+
+func TestRunWithResult_Hedge(t *testing.T) {
+	c := NewCircuitFromConfig("TestRunWithResult_Hedge", Config{
+		Fallback: FallbackConfig{
+			Hedge: true,
+		},
+	})
+	ctx := context.Background()
+	for i := 0; i < 100; i++ {
+		// The loser returns after Execute does, so the race detector catches it writing a shared result
+		ret, err := RunWithResult(ctx, c, func(ctx context.Context) (int, error) {
+			<-ctx.Done()
+			return 1, nil
+		}, func(_ context.Context, _ error) (int, error) {
+			return 2, nil
+		})
+		if err != nil || ret != 2 {
+			t.Errorf("expected the fallback to win, saw %d %v", ret, err)
+		}
+		ret, err = RunWithResult(ctx, c, func(_ context.Context) (int, error) {
+			return 3, nil
+		}, func(ctx context.Context, _ error) (int, error) {
+			<-ctx.Done()
+			return 4, nil
+		})
+		if err != nil || ret != 3 {
+			t.Errorf("expected runFunc to win, saw %d %v", ret, err)
+		}
+	}
+}
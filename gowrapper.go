@@ -2,6 +2,8 @@ package circuit
 
 import (
 	"context"
+	"runtime/debug"
+	"sync/atomic"
 
 	"github.com/cep21/circuit/faststats"
 )
@@ -11,23 +13,46 @@ import (
 type goroutineWrapper struct {
 	skipCatchPanics faststats.AtomicBoolean
 	lostErrors      func(err error, panics interface{})
+	// recoverPanics, if set and true, makes run re-panic with the *PanicError so the circuit can keep the original stack
+	recoverPanics *faststats.AtomicBoolean
+	// abandoned counts goroutines still running a function that Go already returned from
+	abandoned faststats.AtomicInt64
+	// onAbandoned, if set, is called each time Go returns before its goroutine ends
+	onAbandoned func(stillRunning int64)
 }
 
+// States of a single goroutine started by runWithPanics
+const (
+	goroutineRunning int32 = iota
+	goroutineFinished
+	goroutineAbandoned
+)
+
 func (g *goroutineWrapper) run(runFunc func(context.Context) error) func(context.Context) error {
+	return g.runWithPanics(runFunc, g.recoverPanics != nil && g.recoverPanics.Get())
+}
+
+func (g *goroutineWrapper) runWithPanics(runFunc func(context.Context) error, wrapPanics bool) func(context.Context) error {
 	if runFunc == nil {
 		return nil
 	}
 	return func(ctx context.Context) error {
-		var panicResult chan interface{}
+		var panicResult chan *PanicError
 		if !g.skipCatchPanics.Get() {
-			panicResult = make(chan interface{}, 1)
+			panicResult = make(chan *PanicError, 1)
 		}
 		runFuncErr := make(chan error, 1)
+		state := goroutineRunning
 		go func() {
+			defer func() {
+				if !atomic.CompareAndSwapInt32(&state, goroutineRunning, goroutineFinished) {
+					g.abandoned.Add(-1)
+				}
+			}()
 			if panicResult != nil {
 				defer func() {
 					if r := recover(); r != nil {
-						panicResult <- r
+						panicResult <- &PanicError{Value: r, Stack: debug.Stack()}
 					}
 				}()
 			}
@@ -35,6 +60,12 @@ func (g *goroutineWrapper) run(runFunc func(context.Context) error) func(context
 		}()
 		select {
 		case <-ctx.Done():
+			if atomic.CompareAndSwapInt32(&state, goroutineRunning, goroutineAbandoned) {
+				stillRunning := g.abandoned.Add(1)
+				if g.onAbandoned != nil {
+					g.onAbandoned(stillRunning)
+				}
+			}
 			// runFuncErr is a lost error.
 			if g.lostErrors != nil {
 				go g.waitForErrors(runFuncErr, panicResult)
@@ -43,7 +74,10 @@ func (g *goroutineWrapper) run(runFunc func(context.Context) error) func(context
 		case err := <-runFuncErr:
 			return err
 		case panicVal := <-panicResult:
-			panic(panicVal)
+			if wrapPanics {
+				panic(panicVal)
+			}
+			panic(panicVal.Value)
 		}
 	}
 }
@@ -53,18 +87,18 @@ func (g *goroutineWrapper) fallback(runFunc func(context.Context, error) error)
 		return nil
 	}
 	return func(ctx context.Context, err error) error {
-		return g.run(func(funcCtx context.Context) error {
+		return g.runWithPanics(func(funcCtx context.Context) error {
 			return runFunc(funcCtx, err)
-		})(ctx)
+		}, false)(ctx)
 	}
 }
 
-func (g *goroutineWrapper) waitForErrors(runFuncErr chan error, panicResults chan interface{}) {
+func (g *goroutineWrapper) waitForErrors(runFuncErr chan error, panicResults chan *PanicError) {
 	select {
 	case err := <-runFuncErr:
 		g.lostErrors(err, nil)
 	case panicResult := <-panicResults:
-		g.lostErrors(nil, panicResult)
+		g.lostErrors(nil, panicResult.Value)
 	}
 	close(runFuncErr)
 	close(panicResults)
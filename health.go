@@ -0,0 +1,185 @@
+package circuit
+
+import (
+	"sort"
+	"time"
+)
+
+// RunHealth is implemented by RunMetrics that track how healthy runFunc is over a rolling window, like
+// rolling.RunStats.  HealthSnapshot reads from the first one it finds.
+type RunHealth interface {
+	// LegitimateAttemptsAt is how many runFunc calls count towards the circuit's health
+	LegitimateAttemptsAt(now time.Time) int64
+	// ErrorPercentageAt is the fraction, between 0 and 1, of legitimate attempts that failed
+	ErrorPercentageAt(now time.Time) float64
+}
+
+// PreviousRunHealth is implemented by RunMetrics that also remember the rolling window before the current one, like
+// rolling.RunStats, so error rates can be compared from one window to the next
+type PreviousRunHealth interface {
+	// PreviousErrorPercentageAt is the fraction, between 0 and 1, of legitimate attempts that failed in the rolling
+	// window that ended where the current one begins
+	PreviousErrorPercentageAt(now time.Time) float64
+}
+
+// RunHealthProvider is implemented by RunMetrics that combine others, like the composite logic of simplelogic, and so
+// only have a RunHealth if one of them is.  The circuit uses the RunHealth it returns, or looks further if that is nil.
+type RunHealthProvider interface {
+	RunHealth() RunHealth
+}
+
+// HealthSnapshot is a point in time view of a circuit, meant to be encoded as JSON for debug endpoints
+type HealthSnapshot struct {
+	Name               string
+	IsOpen             bool
+	LastTransitionTime time.Time
+	// ExternallyUnhealthy is true if SetExternalHealth marked the circuit unhealthy
+	ExternallyUnhealthy bool
+	// Time is when the snapshot was taken, using the circuit's TimeKeeper
+	Time                time.Time
+	ConcurrentCommands  int64
+	ConcurrentFallbacks int64
+	// RequestVolume and ErrorPercentage are zero unless a RunMetrics implements RunHealth
+	RequestVolume   int64
+	ErrorPercentage float64
+	// PreviousErrorPercentage is zero unless a RunMetrics implements PreviousRunHealth
+	PreviousErrorPercentage float64
+	Config                  Config
+	// Opener and Closer hold the circuit's open and close logic, which usually encode their thresholds as JSON
+	Opener ClosedToOpen
+	Closer OpenToClosed
+}
+
+// HealthSnapshot returns everything known about the circuit's health.  Every time based value is read with the same
+// now, so the fields agree with each other, but the circuit keeps running while the snapshot is taken.
+func (c *Circuit) HealthSnapshot() HealthSnapshot {
+	now := c.now()
+	ret := HealthSnapshot{
+		Name:                c.Name(),
+		IsOpen:              c.IsOpen(),
+		LastTransitionTime:  c.LastTransitionTime(),
+		ExternallyUnhealthy: c.IsExternallyUnhealthy(),
+		Time:                now,
+		ConcurrentCommands:  c.ConcurrentCommands(),
+		ConcurrentFallbacks: c.ConcurrentFallbacks(),
+		Config:              c.Config(),
+		Opener:              c.ClosedToOpen,
+		Closer:              c.OpenToClose,
+	}
+	if health := c.runHealth(); health != nil {
+		ret.RequestVolume = health.LegitimateAttemptsAt(now)
+		ret.ErrorPercentage = health.ErrorPercentageAt(now)
+	}
+	if previous := c.previousRunHealth(); previous != nil {
+		ret.PreviousErrorPercentage = previous.PreviousErrorPercentageAt(now)
+	}
+	return ret
+}
+
+// Snapshot returns the HealthSnapshot of every tracked circuit, sorted by name, for a debug endpoint.  The circuits
+// are listed in one pass, then each is snapshotted in turn, so circuits created or deleted during the call may or may
+// not be included.  It never creates circuits or changes their state.
+func (h *Manager) Snapshot() []HealthSnapshot {
+	var ret []HealthSnapshot
+	h.Each(func(_ string, c *Circuit) {
+		ret = append(ret, c.HealthSnapshot())
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// ErrorPercentage is the fraction, between 0 and 1, of legitimate runFunc attempts that failed or timed out in the
+// current rolling window.  Bad requests and interrupts do not count.  It is read from the first RunMetrics that
+// implements RunHealth.  ClosedToOpen comes before any configured collector, so if the circuit's opener implements
+// RunHealth, like the hystrix Opener, this is the same rate it opens on.  It is zero with no traffic or no RunHealth.
+func (c *Circuit) ErrorPercentage() float64 {
+	if health := c.runHealth(); health != nil {
+		return health.ErrorPercentageAt(c.now())
+	}
+	return 0
+}
+
+// PreviousErrorPercentage is ErrorPercentage for the rolling window just before the current one, so alerts can fire
+// on how fast the error rate changes rather than its level.  It is read from the first RunMetrics that implements
+// PreviousRunHealth, like rolling.RunStats, which may not be the one ErrorPercentage reads from: compare against that
+// collector's own ErrorPercentageAt if their windows differ.  It is zero with no traffic or no PreviousRunHealth.
+func (c *Circuit) PreviousErrorPercentage() float64 {
+	if previous := c.previousRunHealth(); previous != nil {
+		return previous.PreviousErrorPercentageAt(c.now())
+	}
+	return 0
+}
+
+func (c *Circuit) previousRunHealth() PreviousRunHealth {
+	for _, m := range c.CmdMetricCollector {
+		if previous, ok := providedRunHealth(m).(PreviousRunHealth); ok {
+			return previous
+		}
+	}
+	return nil
+}
+
+func (c *Circuit) runHealth() RunHealth {
+	for _, m := range c.CmdMetricCollector {
+		if health, ok := providedRunHealth(m).(RunHealth); ok {
+			return health
+		}
+	}
+	return nil
+}
+
+// providedRunHealth is the RunHealth a RunHealthProvider returns, which may be nil, or else m itself
+func providedRunHealth(m RunMetrics) interface{} {
+	if p, ok := m.(RunHealthProvider); ok {
+		if health := p.RunHealth(); health != nil {
+			return health
+		}
+		return nil
+	}
+	return m
+}
+
+// ProbeStatus describes the half open attempts of a circuit, to help find circuits that are stuck open because their
+// OpenToClosed logic never lets a request through
+type ProbeStatus struct {
+	IsOpen bool
+	// OpenFor is how long the circuit has been open.  It is zero if the circuit is closed, or forced open without ever
+	// opening on its own.
+	OpenFor time.Duration
+	// ProbedSinceOpen is true if OpenToClose has allowed any attempt since the circuit last opened
+	ProbedSinceOpen bool
+	// LastProbe is when OpenToClose last allowed an attempt, and LastSuccessfulProbe is when an attempt last
+	// succeeded while the circuit was open.  They are the zero time if that never happened.
+	LastProbe           time.Time
+	LastSuccessfulProbe time.Time
+	// SinceSuccessfulProbe is how long ago LastSuccessfulProbe was.  It is zero if there has never been one.
+	SinceSuccessfulProbe time.Duration
+}
+
+// ProbeStatus reports on the circuit's half open attempts, using the circuit's TimeKeeper
+func (c *Circuit) ProbeStatus() ProbeStatus {
+	now := c.now()
+	ret := ProbeStatus{
+		IsOpen:              c.IsOpen(),
+		LastProbe:           timeFromUnixNano(c.lastProbeTime.Get()),
+		LastSuccessfulProbe: timeFromUnixNano(c.lastSuccessfulProbeTime.Get()),
+	}
+	if !ret.LastSuccessfulProbe.IsZero() {
+		ret.SinceSuccessfulProbe = now.Sub(ret.LastSuccessfulProbe)
+	}
+	openedAt := c.LastTransitionTime()
+	if c.isOpen.Get() && !openedAt.IsZero() {
+		ret.OpenFor = now.Sub(openedAt)
+		ret.ProbedSinceOpen = !ret.LastProbe.IsZero() && !ret.LastProbe.Before(openedAt)
+	}
+	return ret
+}
+
+func timeFromUnixNano(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
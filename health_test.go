@@ -0,0 +1,165 @@
+package circuit
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit/internal/clock"
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+type fixedRunHealth struct {
+	countingRunMetrics
+}
+
+func (f *fixedRunHealth) LegitimateAttemptsAt(now time.Time) int64 {
+	return f.calls.Get()
+}
+
+func (f *fixedRunHealth) ErrorPercentageAt(now time.Time) float64 {
+	return .5
+}
+
+func TestCircuit_HealthSnapshot(t *testing.T) {
+	now := time.Now()
+	c := NewCircuitFromConfig("TestCircuit_HealthSnapshot", Config{
+		Execution: ExecutionConfig{
+			MaxConcurrentRequests: 7,
+		},
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now: func() time.Time {
+					return now
+				},
+			},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{&fixedRunHealth{}},
+		},
+	})
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+		t.Fatal("expected no error", err)
+	}
+	c.OpenCircuit()
+	snapshot := c.HealthSnapshot()
+	if snapshot.Name != "TestCircuit_HealthSnapshot" || !snapshot.IsOpen {
+		t.Error("expected name and open state", snapshot)
+	}
+	if !snapshot.LastTransitionTime.Equal(now) || !snapshot.Time.Equal(now) {
+		t.Error("expected times from the circuit's clock", snapshot)
+	}
+	if snapshot.RequestVolume != 1 || snapshot.ErrorPercentage != .5 {
+		t.Error("expected health from the RunHealth collector", snapshot)
+	}
+	if snapshot.Config.Execution.MaxConcurrentRequests != 7 {
+		t.Error("expected the circuit's config", snapshot.Config)
+	}
+	if _, err := json.Marshal(snapshot); err != nil {
+		t.Error("expected snapshot to encode as JSON", err)
+	}
+}
+
+func TestManager_Snapshot(t *testing.T) {
+	h := Manager{}
+	for _, name := range []string{"b", "a", "c"} {
+		h.MustCreateCircuit(name)
+	}
+	h.GetCircuit("b").OpenCircuit()
+	snapshots := h.Snapshot()
+	if len(snapshots) != 3 || snapshots[0].Name != "a" || snapshots[1].Name != "b" || snapshots[2].Name != "c" {
+		t.Fatal("expected a snapshot of every circuit, sorted by name", snapshots)
+	}
+	if !snapshots[1].IsOpen || snapshots[0].IsOpen {
+		t.Error("expected each circuit's own state", snapshots)
+	}
+	b, err := json.Marshal(snapshots)
+	if err != nil {
+		t.Fatal("expected snapshots to encode as JSON", err)
+	}
+	var decoded []map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal("expected valid JSON", err)
+	}
+	for i, name := range []string{"a", "b", "c"} {
+		if decoded[i]["Name"] != name {
+			t.Errorf("expected circuit %s in the JSON: %s", name, b)
+		}
+	}
+	if len(h.AllCircuits()) != 3 {
+		t.Error("snapshots should not create circuits")
+	}
+	var nilManager *Manager
+	if nilManager.Snapshot() != nil {
+		t.Error("nil managers have no circuits")
+	}
+}
+
+func TestCircuit_ErrorPercentage(t *testing.T) {
+	c := NewCircuitFromConfig("TestCircuit_ErrorPercentage", Config{})
+	if c.ErrorPercentage() != 0 {
+		t.Error("expected zero without a RunHealth collector", c.ErrorPercentage())
+	}
+	c = NewCircuitFromConfig("TestCircuit_ErrorPercentage", Config{
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{&fixedRunHealth{}},
+		},
+	})
+	if c.ErrorPercentage() != .5 {
+		t.Error("expected the RunHealth collector's error percentage", c.ErrorPercentage())
+	}
+}
+
+// probingCloser allows every half open attempt, but never closes the circuit
+type probingCloser struct {
+	neverCloses
+}
+
+func (p probingCloser) Allow(now time.Time) bool {
+	return true
+}
+
+func TestCircuit_ProbeStatus(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	timeKeeper := TimeKeeper{
+		Now:       clk.Now,
+		AfterFunc: clk.AfterFunc,
+	}
+	h := Manager{}
+	stuck := h.MustCreateCircuit("stuck", Config{General: GeneralConfig{TimeKeeper: timeKeeper}})
+	probing := h.MustCreateCircuit("probing", Config{General: GeneralConfig{
+		TimeKeeper:          timeKeeper,
+		OpenToClosedFactory: func() OpenToClosed { return probingCloser{} },
+	}})
+	h.MustCreateCircuit("manual", Config{General: GeneralConfig{TimeKeeper: timeKeeper, ManualClose: true}}).OpenCircuit()
+	h.MustCreateCircuit("forced", Config{General: GeneralConfig{TimeKeeper: timeKeeper, ForceOpen: true}})
+	h.MustCreateCircuit("closed", Config{General: GeneralConfig{TimeKeeper: timeKeeper}})
+	if status := stuck.ProbeStatus(); status.IsOpen || status.OpenFor != 0 || status.ProbedSinceOpen {
+		t.Error("expected a closed circuit to report no probes", status)
+	}
+
+	stuck.OpenCircuit()
+	probing.OpenCircuit()
+	clk.Add(time.Minute)
+	// The default closer never allows an attempt, so the stuck circuit short circuits forever
+	testhelp.MustNotTesting(t, stuck.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, probing.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	clk.Add(time.Minute)
+
+	status := stuck.ProbeStatus()
+	if !status.IsOpen || status.OpenFor != 2*time.Minute || status.ProbedSinceOpen || !status.LastProbe.IsZero() {
+		t.Error("expected the stuck circuit to report no probes", status)
+	}
+	status = probing.ProbeStatus()
+	if !status.ProbedSinceOpen || !status.LastSuccessfulProbe.Equal(status.LastProbe) || status.SinceSuccessfulProbe != time.Minute {
+		t.Error("expected the probing circuit to report its successful probe", status)
+	}
+	if names := h.StuckOpenCircuits(time.Minute); len(names) != 1 || names[0] != "stuck" {
+		t.Error("expected only the circuit that never probes to be stuck", names)
+	}
+	if names := h.StuckOpenCircuits(time.Hour); len(names) != 0 {
+		t.Error("expected no circuits stuck for longer than they have been open", names)
+	}
+}
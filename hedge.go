@@ -0,0 +1,109 @@
+package circuit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// hedgeRun is what the runFunc side of a hedged call returned
+type hedgeRun struct {
+	outcome  Outcome
+	duration time.Duration
+	err      error
+}
+
+// hedgeCancel cancels the fallback side of a hedged call.  The fallback may detach from the caller's context for
+// Fallback.Timeout, so it is canceled through a context made inside the call to fallbackFunc.
+type hedgeCancel struct {
+	mu       sync.Mutex
+	canceled bool
+	cancel   func()
+}
+
+// wrap returns fallbackFunc, called with a context that stop cancels
+func (h *hedgeCancel) wrap(fallbackFunc func(context.Context, error) error) func(context.Context, error) error {
+	return func(ctx context.Context, err error) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		h.mu.Lock()
+		h.cancel = cancel
+		if h.canceled {
+			cancel()
+		}
+		h.mu.Unlock()
+		return fallbackFunc(ctx, err)
+	}
+}
+
+// stop cancels the fallback's context, or the context it will be given if it has not been called yet
+func (h *hedgeCancel) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.canceled = true
+	if h.cancel != nil {
+		h.cancel()
+	}
+}
+
+// hedge is run and fallback at the same time, for Fallback.Hedge.  It returns as soon as either succeeds, canceling
+// the other without waiting for it.  runDuration is how long runFunc ran if it returned before the call was decided.
+func (c *Circuit) hedge(ctx context.Context, runFunc func(context.Context) error, fallbackFunc func(context.Context, error) error, budgetEnd time.Time) (info ExecutionInfo, runDuration time.Duration, err error) {
+	startTime := c.now()
+	runCtx, cancelRun := context.WithCancel(ctx)
+	var fallbackCancel hedgeCancel
+	runDone := make(chan hedgeRun, 1)
+	fallbackDone := make(chan error, 1)
+	go func() {
+		defer cancelRun()
+		outcome, duration, err := c.run(runCtx, runFunc, budgetEnd, false)
+		runDone <- hedgeRun{outcome: outcome, duration: duration, err: err}
+	}()
+	go func() {
+		// runFunc has not failed, so there is no error to give the fallback.  Only runFunc is limited by concurrency.
+		fallbackDone <- c.callFallback(ctx, nil, fallbackCancel.wrap(fallbackFunc), false, budgetEnd)
+	}()
+
+	// Until runFunc returns, it is being interrupted
+	info = ExecutionInfo{Outcome: OutcomeInterrupt, FallbackCalled: true}
+	var runErr error
+	for runDone != nil || fallbackDone != nil {
+		select {
+		case r := <-runDone:
+			runDone = nil
+			info.Outcome, runDuration, runErr = r.outcome, r.duration, r.err
+			if r.err == nil {
+				fallbackCancel.stop()
+				now := c.now()
+				c.FallbackMetricCollector.PrimaryWon(now, now.Sub(startTime))
+				return info, runDuration, nil
+			}
+			// A bad request skips the fallback, even one that is already running
+			if r.outcome == OutcomeBadRequest {
+				fallbackCancel.stop()
+				return info, runDuration, unwrapSimpleBadRequest(r.err)
+			}
+		case fallbackErr := <-fallbackDone:
+			fallbackDone = nil
+			if fallbackErr == nil {
+				cancelRun()
+				info.FallbackWon = true
+				now := c.now()
+				c.FallbackMetricCollector.FallbackWon(now, now.Sub(startTime))
+				return info, runDuration, nil
+			}
+			err = fallbackErr
+		}
+	}
+	// Both failed.  The fallback was called without runFunc's error, so add it, made by ShortCircuitError if runFunc was
+	// short circuited.
+	if info.Outcome == OutcomeShortCircuit && c.shortCircuitError != nil {
+		if custom := c.shortCircuitError(ctx); custom != nil {
+			runErr = custom
+		}
+	}
+	if fallbackErr, ok := err.(*FallbackError); ok && fallbackErr.Err == nil {
+		fallbackErr.Err = runErr
+	}
+	return info, runDuration, err
+}
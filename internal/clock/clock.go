@@ -37,7 +37,7 @@ func (m *MockClock) triggerCallbacks() {
 	newArray := []timedCallbacks{}
 	toCall := []timedCallbacks{}
 	for _, c := range m.callbacks {
-		if m.currentTime.After(c.when) {
+		if c.when.After(m.currentTime) {
 			newArray = append(newArray, c)
 		} else {
 			toCall = append(toCall, c)
@@ -0,0 +1,27 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMockClock_AfterFunc(t *testing.T) {
+	c := MockClock{}
+	c.Set(time.Unix(1500000000, 0))
+	called := 0
+	c.AfterFunc(time.Second, func() {
+		called++
+	})
+	c.Add(time.Millisecond * 500)
+	if called != 0 {
+		t.Fatal("Callbacks should not run before they are due")
+	}
+	c.Add(time.Millisecond * 500)
+	if called != 1 {
+		t.Fatal("Callbacks should run once they are due")
+	}
+	c.Add(time.Second)
+	if called != 1 {
+		t.Fatal("Callbacks should only run once")
+	}
+}
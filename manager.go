@@ -3,22 +3,60 @@ package circuit
 import (
 	"errors"
 	"expvar"
+	"fmt"
+	"sort"
 	"sync"
+	"time"
 )
 
 // CommandPropertiesConstructor is a generic function that can create command properties to configure a circuit by name
 // It is safe to leave not configured properties their empty value.
 type CommandPropertiesConstructor func(circuitName string) Config
 
+// ChainedPropertiesConstructor is like CommandPropertiesConstructor, but also receives the configuration built so far
+// for the circuit.  It should return only the additions it wants and must not modify accumulated.
+type ChainedPropertiesConstructor func(circuitName string, accumulated Config) Config
+
+// MetricsConstructor creates the metric collectors for a circuit by name
+type MetricsConstructor func(circuitName string) MetricsCollectors
+
+// managerCreationStripes is how many locks CreateCircuit spreads circuit names over
+const managerCreationStripes = 32
+
 // Manager manages circuits with unique names
 type Manager struct {
 	// DefaultCircuitProperties is a list of Config constructors called, in reverse order,
-	// to append or modify configuration for your circuit.
+	// to append or modify configuration for your circuit.  The Manager never calls its constructors concurrently, even
+	// for circuits with different names, so they may keep state without locking.
 	DefaultCircuitProperties []CommandPropertiesConstructor
+	// ChainedCircuitProperties is a list of constructors called, in order, after DefaultCircuitProperties.  Each one
+	// sees the config accumulated from CreateCircuit's configs, DefaultCircuitProperties, and every earlier chained
+	// constructor.  Its result only fills fields that are still unset, so earlier values always take precedence.
+	ChainedCircuitProperties []ChainedPropertiesConstructor
+	// DefaultMetrics is a list of collector constructors called for every circuit CreateCircuit makes.  Their
+	// collectors are added after any from the circuit's configs, so no circuit is created without them.
+	DefaultMetrics []MetricsConstructor
+	// ExpectedCircuits pre-sizes the map of circuits, so creating many circuits at startup does not repeatedly grow it
+	ExpectedCircuits int
+	// ValidateConfigs makes CreateCircuit check each circuit's final config, and its open/close logic if that is a
+	// Validator, before tracking it.  Invalid circuits are not created, and a *ConfigErrors lists every problem.
+	ValidateConfigs bool
+	// DetectConfigConflicts makes CreateCircuit check that no two of DefaultCircuitProperties and
+	// ChainedCircuitProperties set the same field of a circuit's config to different values, which usually means two
+	// constructors claim the same circuit by mistake.  Circuits with conflicts are not created, and a *ConfigErrors
+	// lists every conflict.  Configs passed to CreateCircuit are meant to override the constructors, so they never
+	// conflict.  Fields that cannot be compared, like functions and metric collectors, are not checked.
+	DetectConfigConflicts bool
 
 	circuitMap map[string]*Circuit
 	// mu locks circuitMap, not DefaultCircuitProperties
 	mu sync.RWMutex
+	// creationLocks serialize CreateCircuit for names that hash to the same stripe, so circuits are built outside mu
+	// and each name is only built once
+	creationLocks [managerCreationStripes]sync.Mutex
+	// constructing serializes calls to DefaultCircuitProperties, ChainedCircuitProperties, and DefaultMetrics.  It is
+	// only held while they run, so building circuits of different names still happens concurrently.
+	constructing sync.Mutex
 }
 
 // AllCircuits returns every hystrix circuit tracked
@@ -35,6 +73,58 @@ func (h *Manager) AllCircuits() []*Circuit {
 	return ret
 }
 
+// Each calls f for every circuit tracked, in no particular order.  It iterates over a snapshot of the circuits, so f
+// may call back into the Manager.  Circuits created or removed while iterating may or may not be seen.
+func (h *Manager) Each(f func(name string, c *Circuit)) {
+	if h == nil {
+		return
+	}
+	h.mu.RLock()
+	names := make([]string, 0, len(h.circuitMap))
+	circuits := make([]*Circuit, 0, len(h.circuitMap))
+	for name, c := range h.circuitMap {
+		names = append(names, name)
+		circuits = append(circuits, c)
+	}
+	h.mu.RUnlock()
+	for i := range circuits {
+		f(names[i], circuits[i])
+	}
+}
+
+// OpenCircuits returns the sorted names of every tracked circuit that is currently open, according to IsOpen.  It
+// never changes circuit state, so it is safe to call from health checks.  Each circuit is checked once, so circuits
+// opening or closing during the call may or may not be included.
+func (h *Manager) OpenCircuits() []string {
+	var ret []string
+	h.Each(func(name string, c *Circuit) {
+		if c.IsOpen() {
+			ret = append(ret, name)
+		}
+	})
+	sort.Strings(ret)
+	return ret
+}
+
+// StuckOpenCircuits returns the sorted names of every tracked circuit that has been open for at least threshold
+// without OpenToClose allowing a single half open attempt.  That usually means a misconfigured sleep window or a bug
+// in the close logic, so alert on it.  Circuits that are forced open, use ManualClose, or are marked unhealthy by
+// SetExternalHealth never probe on purpose, so they are not included.
+func (h *Manager) StuckOpenCircuits(threshold time.Duration) []string {
+	var ret []string
+	h.Each(func(name string, c *Circuit) {
+		if c.threadSafeConfig.CircuitBreaker.ForceOpen.Get() || c.threadSafeConfig.CircuitBreaker.ManualClose.Get() || c.IsExternallyUnhealthy() {
+			return
+		}
+		status := c.ProbeStatus()
+		if status.IsOpen && !status.ProbedSinceOpen && status.OpenFor >= threshold {
+			ret = append(ret, name)
+		}
+	})
+	sort.Strings(ret)
+	return ret
+}
+
 // Var allows you to expose all your hystrix circuits on expvar
 func (h *Manager) Var() expvar.Var {
 	return expvar.Func(func() interface{} {
@@ -51,8 +141,8 @@ func (h *Manager) Var() expvar.Var {
 	})
 }
 
-// GetCircuit returns the circuit with a given name, or nil if the circuit does not exist.  You should not call this
-// in live code.  Instead, store the circuit somewhere and use the circuit directly.
+// GetCircuit returns the circuit with a given name, or nil if the circuit does not exist.  It never creates circuits.
+// You should not call this in live code.  Instead, store the circuit somewhere and use the circuit directly.
 func (h *Manager) GetCircuit(name string) *Circuit {
 	if h == nil {
 		return nil
@@ -62,7 +152,52 @@ func (h *Manager) GetCircuit(name string) *Circuit {
 	return h.circuitMap[name]
 }
 
-// MustCreateCircuit calls CreateCircuit, but panics if the circuit name already exists
+// Exists returns true if the manager tracks a circuit with a given name.  Like GetCircuit, it never creates circuits,
+// so it is safe to call from metrics endpoints.
+func (h *Manager) Exists(name string) bool {
+	return h.GetCircuit(name) != nil
+}
+
+// Delete stops tracking the circuit with a given name, returning true if it was tracked.  Callers that already hold the
+// circuit can continue to use it.  A new circuit with the same name can be created after it is deleted.
+func (h *Manager) Delete(name string) (bool, error) {
+	if h == nil {
+		return false, errors.New("cannot delete a circuit from a nil manager")
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, exists := h.circuitMap[name]; !exists {
+		return false, nil
+	}
+	delete(h.circuitMap, name)
+	if len(h.circuitMap) == 0 {
+		// Go maps never shrink.  Drop the empty map so its memory can be reclaimed.
+		h.circuitMap = nil
+	}
+	return true, nil
+}
+
+// SetBypass turns dry run on or off for the circuit with a given name, so calls pass through to runFunc even while it
+// is open.  Use it from an admin endpoint during an incident, then turn it off again to restore the circuit.  The
+// circuit keeps tracking metrics and open/closed state either way.  ReconfigureAll resets it to the configured DryRun.
+func (h *Manager) SetBypass(name string, on bool) error {
+	c := h.GetCircuit(name)
+	if c == nil {
+		return errors.New("no circuit with that name exists")
+	}
+	c.SetDryRun(on)
+	return nil
+}
+
+// IsBypassed returns true if the circuit with a given name exists and is in dry run mode
+func (h *Manager) IsBypassed(name string) bool {
+	c := h.GetCircuit(name)
+	return c != nil && c.IsDryRun()
+}
+
+// MustCreateCircuit calls CreateCircuit, but panics if the circuit name already exists with a different config, or the
+// config is invalid or conflicts when ValidateConfigs or DetectConfigConflicts is set.  Use it at startup, so config mistakes stop the
+// program.
 func (h *Manager) MustCreateCircuit(name string, config ...Config) *Circuit {
 	c, err := h.CreateCircuit(name, config...)
 	if err != nil {
@@ -71,25 +206,139 @@ func (h *Manager) MustCreateCircuit(name string, config ...Config) *Circuit {
 	return c
 }
 
-// CreateCircuit creates a new circuit, or returns error if a circuit with that name already exists
+// CreateCircuit creates a new circuit.  If a circuit with that name already exists, it is returned when configs are
+// empty or already applied to it, and otherwise an error is returned, so new config is never silently dropped.
+// Configs that set functions, like a TimeKeeper, always differ, since functions cannot be compared.  Circuits with
+// different names are built concurrently, but the Manager's constructors are called one at a time.
 func (h *Manager) CreateCircuit(name string, configs ...Config) (*Circuit, error) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-	if h.circuitMap == nil {
-		h.circuitMap = make(map[string]*Circuit, 5)
-	}
+	creating := h.creationLock(name)
+	creating.Lock()
+	defer creating.Unlock()
 	finalConfig := Config{}
 	for _, c := range configs {
 		finalConfig.Merge(c)
 	}
+	// Checked before building, so config constructors only run for circuits that are kept
+	if existing := h.GetCircuit(name); existing != nil {
+		if !configCovers(existing.Config(), finalConfig) {
+			return nil, errors.New("circuit with that name already exists with a different config")
+		}
+		return existing, nil
+	}
+	finalConfig, errs := h.constructConfig(name, finalConfig)
+	if len(errs) != 0 {
+		return nil, &ConfigErrors{Name: name, Errors: errs}
+	}
+	c := NewCircuitFromConfig(name, finalConfig)
+	if h.ValidateConfigs {
+		if errs := validateCircuit(c); len(errs) != 0 {
+			return nil, &ConfigErrors{Name: name, Errors: errs}
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.circuitMap == nil {
+		size := h.ExpectedCircuits
+		if size < 5 {
+			size = 5
+		}
+		h.circuitMap = make(map[string]*Circuit, size)
+	}
+	h.circuitMap[name] = c
+	return c, nil
+}
+
+// constructConfig merges what the constructors return for name into finalConfig, the way CreateCircuit does.  It returns
+// any conflicts between constructors instead, without calling DefaultMetrics.
+func (h *Manager) constructConfig(name string, finalConfig Config) (Config, []error) {
+	h.constructing.Lock()
+	defer h.constructing.Unlock()
+	var sources []configSource
 	// Merge in reverse order so the most recently appending constructor is more important
 	for i := len(h.DefaultCircuitProperties) - 1; i >= 0; i-- {
-		finalConfig.Merge(h.DefaultCircuitProperties[i](name))
+		cfg := h.DefaultCircuitProperties[i](name)
+		if h.DetectConfigConflicts {
+			sources = append(sources, configSource{name: fmt.Sprintf("DefaultCircuitProperties[%d]", i), config: cfg})
+		}
+		finalConfig.Merge(cfg)
 	}
-	_, exists := h.circuitMap[name]
-	if exists {
-		return nil, errors.New("circuit with that name already exists")
+	for i, chained := range h.ChainedCircuitProperties {
+		cfg := chained(name, finalConfig)
+		if h.DetectConfigConflicts {
+			sources = append(sources, configSource{name: fmt.Sprintf("ChainedCircuitProperties[%d]", i), config: cfg})
+		}
+		finalConfig.Merge(cfg)
+	}
+	if errs := configConflicts(sources); len(errs) != 0 {
+		return finalConfig, errs
+	}
+	for _, metrics := range h.DefaultMetrics {
+		finalConfig.Metrics.merge(metrics(name))
+	}
+	return finalConfig, nil
+}
+
+// ReconfigureAll applies new configuration to every tracked circuit without recreating it, so rolling stats and open
+// state are kept.  Each circuit's config is built the way CreateCircuit builds it: configs[name], if there is one,
+// then DefaultCircuitProperties and ChainedCircuitProperties, which are called again.  Circuits missing from configs
+// only get what the constructors return, so include any config that was originally passed to CreateCircuit.
+//
+// Everything SetConfigThreadSafe changes is applied, such as timeouts, concurrency limits, and the General flags.
+// Open/close logic that is Reconfigurable, like the hystrix Opener and Closer, is also updated from a new instance
+// made by the config's factories, which changes thresholds and sleep windows.  Other fields are ignored for circuits
+// that already exist, and Config keeps returning their old values: metric collectors, labels, the TimeKeeper and
+// RandInt63n, the default fallback, bucket counts and rolling durations, and the factories of logic that is not
+// Reconfigurable.
+func (h *Manager) ReconfigureAll(configs map[string]Config) {
+	h.Each(func(name string, c *Circuit) {
+		creating := h.creationLock(name)
+		creating.Lock()
+		defer creating.Unlock()
+		finalConfig := Config{}
+		finalConfig.Merge(configs[name])
+		h.constructing.Lock()
+		for i := len(h.DefaultCircuitProperties) - 1; i >= 0; i-- {
+			finalConfig.Merge(h.DefaultCircuitProperties[i](name))
+		}
+		for _, chained := range h.ChainedCircuitProperties {
+			finalConfig.Merge(chained(name, finalConfig))
+		}
+		h.constructing.Unlock()
+		// Unlike construction, SetConfigThreadSafe applies zero values as they are
+		finalConfig.Merge(defaultCommandProperties)
+		// Start from the circuit's own config, so Config and Clone keep what cannot change
+		live := c.Config()
+		live.setLiveFields(finalConfig)
+		if r, ok := c.ClosedToOpen.(Reconfigurable); ok && finalConfig.General.ClosedToOpenFactory != nil {
+			r.ReconfigureFrom(finalConfig.General.ClosedToOpenFactory())
+			live.General.ClosedToOpenFactory = finalConfig.General.ClosedToOpenFactory
+		}
+		if r, ok := c.OpenToClose.(Reconfigurable); ok && finalConfig.General.OpenToClosedFactory != nil {
+			r.ReconfigureFrom(finalConfig.General.OpenToClosedFactory())
+			live.General.OpenToClosedFactory = finalConfig.General.OpenToClosedFactory
+		}
+		c.SetConfigThreadSafe(live)
+	})
+}
+
+// validateCircuit returns the problems with a circuit's config and open/close logic
+func validateCircuit(c *Circuit) []error {
+	errs := c.Config().Validate()
+	if v, ok := c.ClosedToOpen.(Validator); ok {
+		errs = append(errs, v.Validate()...)
+	}
+	if v, ok := c.OpenToClose.(Validator); ok {
+		errs = append(errs, v.Validate()...)
+	}
+	return errs
+}
+
+// creationLock returns the lock stripe for a circuit name, using FNV-1a so hashing does not allocate
+func (h *Manager) creationLock(name string) *sync.Mutex {
+	hash := uint32(2166136261)
+	for i := 0; i < len(name); i++ {
+		hash ^= uint32(name[i])
+		hash *= 16777619
 	}
-	h.circuitMap[name] = NewCircuitFromConfig(name, finalConfig)
-	return h.circuitMap[name], nil
+	return &h.creationLocks[hash%managerCreationStripes]
 }
@@ -1,8 +1,16 @@
 package circuit
 
 import (
+	"context"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/cep21/circuit/internal/clock"
+	"github.com/cep21/circuit/internal/testhelp"
 )
 
 func TestManager_Empty(t *testing.T) {
@@ -12,6 +20,79 @@ func TestManager_Empty(t *testing.T) {
 	}
 }
 
+func TestManager_LookupsDoNotCreate(t *testing.T) {
+	h := Manager{}
+	h.MustCreateCircuit("exists")
+	if h.GetCircuit("unknown") != nil || h.Exists("unknown") {
+		t.Error("found a circuit that does not exist")
+	}
+	if !h.Exists("exists") {
+		t.Error("expected the created circuit to exist")
+	}
+	if len(h.AllCircuits()) != 1 || len(h.circuitMap) != 1 {
+		t.Error("lookups should not add circuits", h.AllCircuits())
+	}
+	var nilManager *Manager
+	if nilManager.Exists("exists") {
+		t.Error("nil managers have no circuits")
+	}
+}
+
+func TestManager_CreateExisting(t *testing.T) {
+	h := Manager{}
+	cfg := Config{Execution: ExecutionConfig{MaxConcurrentRequests: 5}}
+	c := h.MustCreateCircuit("exists", cfg)
+	for name, configs := range map[string][]Config{
+		"same config": {cfg},
+		"no config":   nil,
+	} {
+		if again, err := h.CreateCircuit("exists", configs...); err != nil || again != c {
+			t.Errorf("%s: expected the existing circuit, saw %v", name, err)
+		}
+	}
+	if again, err := h.CreateCircuit("exists", Config{Execution: ExecutionConfig{MaxConcurrentRequests: 10}}); err == nil || again != nil {
+		t.Error("expected an error creating a circuit that exists with a different config")
+	}
+	if _, err := h.CreateCircuit("exists", Config{General: GeneralConfig{TimeKeeper: TimeKeeper{Now: time.Now}}}); err == nil {
+		t.Error("expected configs that set functions to differ")
+	}
+	if h.GetCircuit("exists") != c || c.threadSafeConfig.Execution.MaxConcurrentRequests.Get() != 5 {
+		t.Error("expected the existing circuit and its config to be kept")
+	}
+}
+
+func TestManager_ReconfigureAllKeepsConfig(t *testing.T) {
+	clk := &clock.MockClock{}
+	now := time.Now()
+	clk.Set(now)
+	collector := &countingRunMetrics{}
+	h := Manager{}
+	c := h.MustCreateCircuit("keeps", Config{
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now:       clk.Now,
+				AfterFunc: clk.AfterFunc,
+			},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collector},
+		},
+	})
+	h.ReconfigureAll(map[string]Config{
+		"keeps": {Execution: ExecutionConfig{Timeout: time.Minute}},
+	})
+	cfg := c.Config()
+	if cfg.Execution.Timeout != time.Minute {
+		t.Error("expected the new timeout", cfg.Execution.Timeout)
+	}
+	if len(cfg.Metrics.Run) != 1 || cfg.Metrics.Run[0] != collector {
+		t.Error("expected the circuit's collectors to be kept", cfg.Metrics.Run)
+	}
+	if cfg.General.TimeKeeper.Now == nil || !cfg.General.TimeKeeper.Now().Equal(now) {
+		t.Error("expected the circuit's TimeKeeper to be kept")
+	}
+}
+
 func TestManager_Var(t *testing.T) {
 	h := Manager{}
 	c := h.MustCreateCircuit("hello-world", Config{})
@@ -35,6 +116,25 @@ func TestSimpleCreate(t *testing.T) {
 	}
 }
 
+func TestManager_AllCircuitsNames(t *testing.T) {
+	h := Manager{}
+	names := map[string]bool{"a": false, "b": false, "c": false}
+	for name := range names {
+		h.MustCreateCircuit(name)
+	}
+	for _, c := range h.AllCircuits() {
+		if _, exists := names[c.Name()]; !exists {
+			t.Error("unexpected name", c.Name())
+		}
+		names[c.Name()] = true
+	}
+	for name, seen := range names {
+		if !seen {
+			t.Error("never saw circuit", name)
+		}
+	}
+}
+
 func TestDoubleCreate(t *testing.T) {
 	h := Manager{}
 	h.MustCreateCircuit("hello-world", Config{})
@@ -43,9 +143,324 @@ func TestDoubleCreate(t *testing.T) {
 		defer func() {
 			foundErr = recover()
 		}()
-		h.MustCreateCircuit("hello-world", Config{})
+		h.MustCreateCircuit("hello-world", Config{Execution: ExecutionConfig{MaxConcurrentRequests: 3}})
 	}()
 	if foundErr == nil {
-		t.Error("Expect panic when must creating twice")
+		t.Error("Expect panic when must creating twice with a different config")
+	}
+}
+
+func TestManager_ConcurrentCreate(t *testing.T) {
+	const names = 50
+	var mu sync.Mutex
+	constructed := make(map[string]int)
+	h := Manager{
+		ExpectedCircuits: names,
+		DefaultCircuitProperties: []CommandPropertiesConstructor{
+			func(circuitName string) Config {
+				mu.Lock()
+				constructed[circuitName]++
+				mu.Unlock()
+				return Config{}
+			},
+		},
+	}
+	created := make([]int64, names)
+	wg := sync.WaitGroup{}
+	for i := 0; i < names*4; i++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			if c, err := h.CreateCircuit(strconv.Itoa(idx)); err == nil && c == h.GetCircuit(strconv.Itoa(idx)) {
+				atomic.AddInt64(&created[idx], 1)
+			}
+		}(i % names)
+	}
+	wg.Wait()
+	for i := 0; i < names; i++ {
+		name := strconv.Itoa(i)
+		if created[i] != 4 || constructed[name] != 1 || !h.Exists(name) {
+			t.Errorf("expected circuit %s to be returned 4 times and configured once, saw %d and %d", name, created[i], constructed[name])
+		}
+	}
+	if len(h.AllCircuits()) != names {
+		t.Error("unexpected circuits", len(h.AllCircuits()))
+	}
+}
+
+func TestManager_ConstructorsSerialized(t *testing.T) {
+	const names = 20
+	// Constructors without their own locking, so the race detector catches concurrent calls
+	calls := make(map[string]int)
+	h := Manager{
+		DefaultCircuitProperties: []CommandPropertiesConstructor{
+			func(circuitName string) Config {
+				calls["default"]++
+				return Config{}
+			},
+		},
+		ChainedCircuitProperties: []ChainedPropertiesConstructor{
+			func(circuitName string, _ Config) Config {
+				calls["chained"]++
+				return Config{}
+			},
+		},
+		DefaultMetrics: []MetricsConstructor{
+			func(circuitName string) MetricsCollectors {
+				calls["metrics"]++
+				return MetricsCollectors{}
+			},
+		},
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < names; i++ {
+		wg.Add(2)
+		go func(idx int) {
+			defer wg.Done()
+			h.MustCreateCircuit(strconv.Itoa(idx))
+		}(i)
+		go func() {
+			defer wg.Done()
+			h.ReconfigureAll(nil)
+		}()
+	}
+	wg.Wait()
+	if calls["metrics"] != names || calls["default"] != calls["chained"] || calls["default"] < names {
+		t.Error("unexpected constructor calls", calls)
+	}
+}
+
+func TestManager_Each(t *testing.T) {
+	h := Manager{}
+	h.MustCreateCircuit("a", Config{})
+	h.MustCreateCircuit("b", Config{})
+	seen := make(map[string]bool)
+	h.Each(func(name string, c *Circuit) {
+		if name != c.Name() {
+			t.Error("name does not match circuit", name, c.Name())
+		}
+		// Calling back into the manager should not deadlock
+		if h.GetCircuit(name) != c {
+			t.Error("unexpected circuit for name", name)
+		}
+		seen[name] = true
+	})
+	if len(seen) != 2 || !seen["a"] || !seen["b"] {
+		t.Error("did not see every circuit", seen)
+	}
+}
+
+func TestManager_OpenCircuits(t *testing.T) {
+	h := Manager{}
+	if open := h.OpenCircuits(); len(open) != 0 {
+		t.Error("expected no open circuits", open)
+	}
+	h.MustCreateCircuit("closed", Config{})
+	h.MustCreateCircuit("opened", Config{}).OpenCircuit()
+	h.MustCreateCircuit("forced", Config{General: GeneralConfig{ForceOpen: true}})
+	h.MustCreateCircuit("alsoclosed", Config{})
+	open := h.OpenCircuits()
+	if len(open) != 2 || open[0] != "forced" || open[1] != "opened" {
+		t.Error("unexpected open circuits", open)
+	}
+	if !h.GetCircuit("opened").IsOpen() || h.GetCircuit("closed").IsOpen() {
+		t.Error("expected OpenCircuits to not change circuit state")
+	}
+}
+
+func TestManager_SetBypass(t *testing.T) {
+	h := Manager{}
+	c := h.MustCreateCircuit("bypassed", Config{})
+	c.OpenCircuit()
+	ctx := context.Background()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, h.SetBypass("bypassed", true))
+	if !h.IsBypassed("bypassed") {
+		t.Error("expected the circuit to be bypassed")
+	}
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, h.SetBypass("bypassed", false))
+	if h.IsBypassed("bypassed") {
+		t.Error("expected the bypass to be turned off")
+	}
+	if !c.IsOpen() {
+		t.Fatal("the circuit should stay open while bypassed")
+	}
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, h.SetBypass("missing", true))
+	if h.IsBypassed("missing") {
+		t.Error("circuits that do not exist are not bypassed")
+	}
+}
+
+func TestManager_DefaultMetrics(t *testing.T) {
+	defaults := make(map[string]*countingRunMetrics)
+	h := Manager{
+		DefaultMetrics: []MetricsConstructor{
+			func(circuitName string) MetricsCollectors {
+				defaults[circuitName] = &countingRunMetrics{}
+				return MetricsCollectors{Run: []RunMetrics{defaults[circuitName]}}
+			},
+		},
+	}
+	own := &countingRunMetrics{}
+	withOwn := h.MustCreateCircuit("withOwn", Config{Metrics: MetricsCollectors{Run: []RunMetrics{own}}})
+	plain := h.MustCreateCircuit("plain")
+	ctx := context.Background()
+	testhelp.MustTesting(t, withOwn.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, plain.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, plain.Execute(ctx, testhelp.AlwaysFails, nil))
+	if n := own.calls.Get(); n != 1 {
+		t.Errorf("per circuit collectors should still work, saw %d calls", n)
+	}
+	if n := defaults["withOwn"].calls.Get(); n != 1 {
+		t.Errorf("expected the default collector to see withOwn's call, saw %d", n)
+	}
+	if n := defaults["plain"].calls.Get(); n != 2 {
+		t.Errorf("expected the default collector to see plain's calls, saw %d", n)
+	}
+}
+
+func TestManager_Delete(t *testing.T) {
+	h := Manager{}
+	c := h.MustCreateCircuit("hello-world", Config{})
+	deleted, err := h.Delete("hello-world")
+	testhelp.MustTesting(t, err)
+	if !deleted {
+		t.Error("expected the circuit to be deleted")
+	}
+	if h.GetCircuit("hello-world") != nil {
+		t.Error("deleted circuit should not be found")
+	}
+	deleted, err = h.Delete("hello-world")
+	testhelp.MustTesting(t, err)
+	if deleted {
+		t.Error("deleting twice should not find the circuit")
+	}
+	// The circuit should keep working for callers that hold it
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	h.MustCreateCircuit("hello-world", Config{})
+}
+
+func TestManager_DeleteMany(t *testing.T) {
+	h := Manager{}
+	for i := 0; i < 1000; i++ {
+		h.MustCreateCircuit(strconv.Itoa(i), Config{})
+	}
+	for i := 0; i < 1000; i++ {
+		if deleted, err := h.Delete(strconv.Itoa(i)); err != nil || !deleted {
+			t.Fatal("expected each circuit to be deleted", i, err)
+		}
+	}
+	if len(h.AllCircuits()) != 0 {
+		t.Error("expected no circuits after deleting them all")
+	}
+	if h.circuitMap != nil {
+		t.Error("expected the empty circuit map to be released")
+	}
+}
+
+func TestManager_ChainedCircuitProperties(t *testing.T) {
+	h := Manager{
+		ChainedCircuitProperties: []ChainedPropertiesConstructor{
+			func(circuitName string, accumulated Config) Config {
+				return Config{
+					Execution: ExecutionConfig{
+						Timeout: time.Second,
+					},
+				}
+			},
+			func(circuitName string, accumulated Config) Config {
+				if accumulated.Execution.Timeout != time.Second {
+					t.Error("later constructors should see earlier ones", accumulated.Execution.Timeout)
+				}
+				return Config{
+					Execution: ExecutionConfig{
+						Timeout:               time.Minute,
+						MaxConcurrentRequests: 3,
+					},
+				}
+			},
+		},
+	}
+	c := h.MustCreateCircuit("TestManager_ChainedCircuitProperties")
+	cfg := c.Config()
+	if cfg.Execution.Timeout != time.Second {
+		t.Error("earlier constructors should take precedence", cfg.Execution.Timeout)
+	}
+	if cfg.Execution.MaxConcurrentRequests != 3 {
+		t.Error("every constructor should contribute", cfg.Execution.MaxConcurrentRequests)
+	}
+}
+
+func TestManager_ValidateConfigs(t *testing.T) {
+	invalid := Config{
+		Execution: ExecutionConfig{
+			MaxTimeoutOverride: -time.Second,
+		},
+		General: GeneralConfig{
+			WarmupDuration: -time.Second,
+		},
+	}
+	h := Manager{}
+	if _, err := h.CreateCircuit("unchecked", invalid); err != nil {
+		t.Error("expected configs to not be validated by default", err)
+	}
+	h.ValidateConfigs = true
+	c, err := h.CreateCircuit("checked", invalid)
+	configErr, ok := err.(*ConfigErrors)
+	if c != nil || !ok || configErr.Name != "checked" || len(configErr.Errors) != 2 {
+		t.Fatal("expected every problem in a *ConfigErrors", err)
+	}
+	if h.Exists("checked") {
+		t.Error("expected the invalid circuit to not be tracked")
+	}
+	if _, err := h.CreateCircuit("checked", Config{}); err != nil {
+		t.Error("expected a valid config to be created", err)
+	}
+}
+
+func TestManager_DetectConfigConflicts(t *testing.T) {
+	h := Manager{
+		DefaultCircuitProperties: []CommandPropertiesConstructor{
+			func(name string) Config {
+				return Config{Execution: ExecutionConfig{Timeout: time.Second}, General: GeneralConfig{Labels: map[string]string{"tier": "web"}}}
+			},
+			func(name string) Config {
+				return Config{Execution: ExecutionConfig{Timeout: time.Second * 2, MaxConcurrentRequests: 5}}
+			},
+		},
+		ChainedCircuitProperties: []ChainedPropertiesConstructor{
+			func(name string, accumulated Config) Config {
+				return Config{General: GeneralConfig{Labels: map[string]string{"tier": "db"}, OnStateChange: func(*Circuit, bool) {}}}
+			},
+		},
+	}
+	if _, err := h.CreateCircuit("unchecked"); err != nil {
+		t.Fatal("expected conflicts to not be detected by default", err)
+	}
+	h.DetectConfigConflicts = true
+	c, err := h.CreateCircuit("checked")
+	configErr, ok := err.(*ConfigErrors)
+	if c != nil || !ok || len(configErr.Errors) != 2 {
+		t.Fatal("expected both conflicts in a *ConfigErrors", err)
+	}
+	expected := []string{
+		"DefaultCircuitProperties[0] sets Execution.Timeout to 1s, but DefaultCircuitProperties[1] already set it to 2s, which is used",
+		"ChainedCircuitProperties[0] sets General.Labels[tier] to db, but DefaultCircuitProperties[0] already set it to web, which is used",
+	}
+	for i, msg := range expected {
+		if configErr.Errors[i].Error() != msg {
+			t.Errorf("unexpected conflict %d: %s", i, configErr.Errors[i])
+		}
+	}
+	if h.Exists("checked") {
+		t.Error("expected the conflicting circuit to not be tracked")
+	}
+	// Configs passed to CreateCircuit override the constructors on purpose
+	h.ChainedCircuitProperties = nil
+	h.DefaultCircuitProperties = h.DefaultCircuitProperties[1:]
+	if _, err := h.CreateCircuit("checked", Config{Execution: ExecutionConfig{Timeout: time.Minute}}); err != nil {
+		t.Error("expected an explicit config to never conflict", err)
 	}
 }
@@ -1,5 +1,6 @@
 /*
 Package metriceventstream allows exposing your circuit's health as a metric stream that you can visualize with the
-hystrix dashboard.  Note, you do not have to use hystrix open/close logic to take advantage of this.
+hystrix dashboard.  Note, you do not have to use hystrix open/close logic to take advantage of this.  Set an
+Encoder to send the same stream in the shape another dashboard expects.
 */
 package metriceventstream
@@ -0,0 +1,35 @@
+package metriceventstream
+
+import (
+	"github.com/cep21/circuit"
+)
+
+// Encoder builds the bytes sent to clients for one circuit on each tick.  Use it to send a shape other than the
+// hystrix dashboard's, such as newline delimited JSON, while keeping the stream's fan out and client handling.
+// Encode is called at most twice per circuit per tick, no matter how many clients are listening: once without
+// buckets, and once with them if any client asked for "buckets=1".  Circuits that return an error are left out of
+// that tick.
+type Encoder interface {
+	Encode(cb *circuit.Circuit, withBuckets bool) ([]byte, error)
+	// ContentType is the Content-Type header sent to clients
+	ContentType() string
+}
+
+// HystrixEncoder is the default Encoder.  It writes server sent events in the format the hystrix dashboard reads.
+type HystrixEncoder struct{}
+
+var _ Encoder = HystrixEncoder{}
+
+// Encode writes the circuit's rolling stats as a "data:" event of hystrix command metrics
+func (HystrixEncoder) Encode(cb *circuit.Circuit, withBuckets bool) ([]byte, error) {
+	commandMetrics := collectCommandMetrics(cb)
+	if withBuckets {
+		commandMetrics.Buckets = collectCommandBuckets(cb)
+	}
+	return encodeEvent(commandMetrics)
+}
+
+// ContentType is text/event-stream
+func (HystrixEncoder) ContentType() string {
+	return "text/event-stream"
+}
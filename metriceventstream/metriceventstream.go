@@ -2,8 +2,11 @@ package metriceventstream
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,20 +22,62 @@ import (
 // See https://github.com/Netflix/Hystrix/wiki/Metrics-and-Monitoring#metrics-event-stream.  It requires that your
 // metrics are monitored by rolling stats, because it uses them to get health information.
 type MetricEventStream struct {
-	Manager      *circuit.Manager
+	Manager *circuit.Manager
+	// TickDuration is how often events are sent.  It defaults to one second.  Durations below MinTickDuration are
+	// treated as MinTickDuration: shorter ticks spend more time encoding every circuit than the dashboard can show.
 	TickDuration time.Duration
+	// Encoder builds what is sent for each circuit.  It defaults to HystrixEncoder.
+	Encoder Encoder
 
-	eventStreams map[*http.Request]chan []byte
+	eventStreams map[*http.Request]*streamClient
 	closeChan    chan struct{}
-	mu           sync.Mutex
-	once         sync.Once
+	// handlers tracks ServeHTTP calls that are still streaming
+	handlers  sync.WaitGroup
+	mu        sync.Mutex
+	once      sync.Once
+	closeOnce sync.Once
 }
 
 var _ http.Handler = &MetricEventStream{}
 
+// clientBufferSize is how many ticks of events a client can fall behind before it is dropped
+const clientBufferSize = 64
+
+// streamClient is a single connected dashboard
+type streamClient struct {
+	events chan []byte
+	// dropped is closed when the client falls too far behind
+	dropped chan struct{}
+	filter  streamFilter
+}
+
+// streamFilter is what a client asked to see
+type streamFilter struct {
+	// prefix limits the client to circuits with names that start with it
+	prefix string
+	// withBuckets adds per bucket counts to each event
+	withBuckets bool
+}
+
+func newStreamClient(bufferSize int, filter streamFilter) *streamClient {
+	return &streamClient{
+		events:  make(chan []byte, bufferSize),
+		dropped: make(chan struct{}),
+		filter:  filter,
+	}
+}
+
+// circuitEvent is the encoded metric event for a single circuit
+type circuitEvent struct {
+	name string
+	data []byte
+	// dataWithBuckets is only encoded if a client asked for buckets
+	dataWithBuckets []byte
+}
+
 func (m *MetricEventStream) doOnce() {
 	m.closeChan = make(chan struct{})
-	m.eventStreams = make(map[*http.Request]chan []byte)
+	m.eventStreams = make(map[*http.Request]*streamClient)
 }
 
 type writableFlusher interface {
@@ -40,14 +85,28 @@ type writableFlusher interface {
 	http.ResponseWriter
 }
 
+// MinTickDuration is the shortest TickDuration a MetricEventStream uses
+const MinTickDuration = 10 * time.Millisecond
+
 func (m *MetricEventStream) tickDuration() time.Duration {
 	if m.TickDuration == 0 {
 		return time.Second
 	}
+	if m.TickDuration < MinTickDuration {
+		return MinTickDuration
+	}
 	return m.TickDuration
 }
 
-// ServeHTTP sends a never ending list of metric events
+func (m *MetricEventStream) encoder() Encoder {
+	if m.Encoder == nil {
+		return HystrixEncoder{}
+	}
+	return m.Encoder
+}
+
+// ServeHTTP sends a never ending list of metric events.  Add the query parameter "prefix" to only send events for
+// circuits with names that start with that prefix.  Add "buckets=1" to include the count of each rolling bucket.
 func (m *MetricEventStream) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	m.once.Do(m.doOnce)
 	// Make sure that the writer supports flushing.
@@ -56,18 +115,32 @@ func (m *MetricEventStream) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 		http.Error(rw, "Streaming unsupported!", http.StatusInternalServerError)
 		return
 	}
-	rw.Header().Add("Content-Type", "text/event-stream")
+	rw.Header().Add("Content-Type", m.encoder().ContentType())
 	rw.Header().Set("Cache-Control", "no-cache")
 	rw.Header().Set("Connection", "keep-alive")
 
-	myBytesToWrite := make(chan []byte, 1024)
+	withBuckets, _ := strconv.ParseBool(req.URL.Query().Get("buckets"))
+	client := newStreamClient(clientBufferSize, streamFilter{
+		prefix:      req.URL.Query().Get("prefix"),
+		withBuckets: withBuckets,
+	})
 	m.mu.Lock()
-	m.eventStreams[req] = myBytesToWrite
+	select {
+	case <-m.closeChan:
+		// Don't accept new clients once we are shutting down
+		m.mu.Unlock()
+		http.Error(rw, "Event stream closed", http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	m.eventStreams[req] = client
+	m.handlers.Add(1)
 	m.mu.Unlock()
 	defer func() {
 		m.mu.Lock()
 		delete(m.eventStreams, req)
 		m.mu.Unlock()
+		m.handlers.Done()
 	}()
 
 	for {
@@ -78,7 +151,10 @@ func (m *MetricEventStream) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 		case <-m.closeChan:
 			// The event stream was asked to close
 			return
-		case toWriteBytes := <-myBytesToWrite:
+		case <-client.dropped:
+			// We could not keep up with events
+			return
+		case toWriteBytes := <-client.events:
 			_, err := flusher.Write(toWriteBytes)
 			if err != nil {
 				// This writer is bad.  Bye felicia
@@ -90,28 +166,86 @@ func (m *MetricEventStream) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 }
 
 func (m *MetricEventStream) listenerCount() int {
+	m.once.Do(m.doOnce)
 	m.mu.Lock()
 	ret := len(m.eventStreams)
 	m.mu.Unlock()
 	return ret
 }
 
-func (m *MetricEventStream) sendEvent(event []byte) {
+// listenerFilters returns the circuit name prefixes clients want, and if any want buckets.  The prefixes are empty if
+// nobody is listening.
+func (m *MetricEventStream) listenerFilters() (map[string]struct{}, bool) {
 	m.mu.Lock()
-	placesToSend := make([]chan []byte, 0, len(m.eventStreams))
-	for _, stream := range m.eventStreams {
-		placesToSend = append(placesToSend, stream)
+	defer m.mu.Unlock()
+	ret := make(map[string]struct{}, len(m.eventStreams))
+	wantBuckets := false
+	for _, client := range m.eventStreams {
+		ret[client.filter.prefix] = struct{}{}
+		wantBuckets = wantBuckets || client.filter.withBuckets
 	}
-	m.mu.Unlock()
-	for _, stream := range placesToSend {
+	return ret, wantBuckets
+}
+
+func matchesAnyPrefix(name string, prefixes map[string]struct{}) bool {
+	for prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func payloadForFilter(events []circuitEvent, filter streamFilter) []byte {
+	var buf bytes.Buffer
+	for _, event := range events {
+		if !strings.HasPrefix(event.name, filter.prefix) {
+			continue
+		}
+		if filter.withBuckets {
+			buf.Write(event.dataWithBuckets)
+		} else {
+			buf.Write(event.data)
+		}
+	}
+	return buf.Bytes()
+}
+
+// sendEvents sends each client the events it asked for.  Clients with the same filter share the same bytes, so
+// clients must not modify them.
+func (m *MetricEventStream) sendEvents(events []circuitEvent) {
+	payloads := make(map[streamFilter][]byte)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for req, client := range m.eventStreams {
+		payload, exists := payloads[client.filter]
+		if !exists {
+			payload = payloadForFilter(events, client.filter)
+			payloads[client.filter] = payload
+		}
+		if len(payload) == 0 {
+			continue
+		}
 		select {
-		case stream <- event:
+		case client.events <- payload:
 		default:
-			// chan full.  Maybe not flushing fast enough.  Move on.
+			// chan full.  Not flushing fast enough, so drop the client rather than block everyone else.
+			delete(m.eventStreams, req)
+			close(client.dropped)
 		}
 	}
 }
 
+func encodeEvent(commandMetrics *streamCmdMetric) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	mustWrite(buf, "data:")
+	if err := json.NewEncoder(buf).Encode(commandMetrics); err != nil {
+		return nil, err
+	}
+	mustWrite(buf, "\n")
+	return buf.Bytes(), nil
+}
+
 func mustWrite(w io.Writer, s string) {
 	_, err := io.WriteString(w, s)
 	if err != nil {
@@ -119,42 +253,79 @@ func mustWrite(w io.Writer, s string) {
 	}
 }
 
-// Start should be called once per MetricEventStream.  It runs forever, until Close is called.
+// Start should be called once per MetricEventStream.  It runs forever, until Close is called.  Ticks are scheduled
+// from when Start is called, so they do not drift as encoding takes time.  A tick that is missed because encoding took
+// too long is skipped, rather than sent late in a burst.
 func (m *MetricEventStream) Start() error {
 	m.once.Do(m.doOnce)
+	ticker := time.NewTicker(m.tickDuration())
+	defer ticker.Stop()
 	for {
 		select {
-		case <-time.After(m.tickDuration()):
+		case <-ticker.C:
 			// Don't collect events if nobody is listening
-			if m.listenerCount() == 0 {
+			prefixes, wantBuckets := m.listenerFilters()
+			if len(prefixes) == 0 {
 				continue
 			}
-			for _, circuit := range m.Manager.AllCircuits() {
-				buf := &bytes.Buffer{}
-				mustWrite(buf, "data:")
-				commandMetrics := collectCommandMetrics(circuit)
-				encoder := json.NewEncoder(buf)
-				err := encoder.Encode(commandMetrics)
+			// Encode each circuit someone wants once per tick, no matter how many clients are listening
+			encoder := m.encoder()
+			allCircuits := m.Manager.AllCircuits()
+			events := make([]circuitEvent, 0, len(allCircuits))
+			for _, circuit := range allCircuits {
+				if !matchesAnyPrefix(circuit.Name(), prefixes) {
+					continue
+				}
+				data, err := encoder.Encode(circuit, false)
 				if err != nil {
 					continue
 				}
-
-				mustWrite(buf, "\n")
-				m.sendEvent(buf.Bytes())
+				event := circuitEvent{name: circuit.Name(), data: data}
+				if wantBuckets {
+					if event.dataWithBuckets, err = encoder.Encode(circuit, true); err != nil {
+						continue
+					}
+				}
+				events = append(events, event)
 			}
+			m.sendEvents(events)
 		case <-m.closeChan:
 			return nil
 		}
 	}
 }
 
-// Close ends the Start function
+// Close ends the Start function and tells every connected client to stop.  It is safe to call multiple times.
 func (m *MetricEventStream) Close() error {
 	m.once.Do(m.doOnce)
-	close(m.closeChan)
+	m.closeOnce.Do(func() {
+		// Close while holding the lock so ServeHTTP never registers a client after we start shutting down
+		m.mu.Lock()
+		close(m.closeChan)
+		m.mu.Unlock()
+	})
 	return nil
 }
 
+// Shutdown calls Close, then waits for every ServeHTTP call to return or for ctx to end.  It is safe to call
+// multiple times.
+func (m *MetricEventStream) Shutdown(ctx context.Context) error {
+	if err := m.Close(); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		m.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func collectCommandMetrics(cb *circuit.Circuit) *streamCmdMetric {
 	builtInRollingCmdMetricCollector := rolling.FindCommandMetrics(cb)
 	if builtInRollingCmdMetricCollector == nil {
@@ -168,6 +339,8 @@ func collectCommandMetrics(cb *circuit.Circuit) *streamCmdMetric {
 	}
 	now := cb.Config().General.TimeKeeper.Now()
 	snap := builtInRollingCmdMetricCollector.Latencies.SnapshotAt(now)
+	// The window the buckets really cover, which is rounded if the duration does not divide evenly into buckets
+	rollingConfig := builtInRollingCmdMetricCollector.Config()
 	circuitConfig := cb.Config()
 	return attachHystrixProperties(cb, &streamCmdMetric{
 		Type:           "HystrixCommand",
@@ -226,9 +399,38 @@ func collectCommandMetrics(cb *circuit.Circuit) *streamCmdMetric {
 		// Fallback config
 		FallbackIsolationSemaphoreMaxConcurrentRequests: circuitConfig.Fallback.MaxConcurrentRequests,
 
-		RollingStatsWindow: builtInRollingCmdMetricCollector.Config().RollingStatsDuration.Nanoseconds() / time.Millisecond.Nanoseconds(),
+		RollingStatsWindow: rollingConfig.RollingStatsWindow().Nanoseconds() / time.Millisecond.Nanoseconds(),
 	})
 }
+
+// collectCommandBuckets reads each rolling counter's buckets, newest first
+func collectCommandBuckets(cb *circuit.Circuit) *streamCmdBuckets {
+	runStats := rolling.FindCommandMetrics(cb)
+	if runStats == nil {
+		runStats = &rolling.RunStats{}
+	}
+	now := cb.Config().General.TimeKeeper.Now()
+	return &streamCmdBuckets{
+		Success:           toStreamBuckets(runStats.Successes.GetTimedBuckets(now)),
+		Failure:           toStreamBuckets(runStats.ErrFailures.GetTimedBuckets(now)),
+		Timeout:           toStreamBuckets(runStats.ErrTimeouts.GetTimedBuckets(now)),
+		ShortCircuited:    toStreamBuckets(runStats.ErrShortCircuits.GetTimedBuckets(now)),
+		SemaphoreRejected: toStreamBuckets(runStats.ErrConcurrencyLimitRejects.GetTimedBuckets(now)),
+		BadRequests:       toStreamBuckets(runStats.ErrBadRequests.GetTimedBuckets(now)),
+	}
+}
+
+func toStreamBuckets(buckets []faststats.TimedBucket) []streamBucket {
+	ret := make([]streamBucket, 0, len(buckets))
+	for _, b := range buckets {
+		ret = append(ret, streamBucket{
+			Start: b.Start.UnixNano() / time.Millisecond.Nanoseconds(),
+			Count: b.Count,
+		})
+	}
+	return ret
+}
+
 func attachHystrixProperties(cb *circuit.Circuit, into *streamCmdMetric) *streamCmdMetric {
 	if asHystrix, ok := cb.ClosedToOpen.(*hystrix.Opener); ok {
 		into.CircuitBreakerErrorThresholdPercent = asHystrix.Config().ErrorThresholdPercentage
@@ -321,6 +523,25 @@ type streamCmdMetric struct {
 	TotalCountShortCircuited    int64 `json:"countShortCircuited"`
 	TotalCountTimeout           int64 `json:"countTimeout"`
 	TotalCountBadRequests       int64 `json:"countBadRequests"`
+
+	// Only set if the client asked for buckets
+	Buckets *streamCmdBuckets `json:"buckets,omitempty"`
+}
+
+// streamCmdBuckets holds each rolling counter's buckets, newest first
+type streamCmdBuckets struct {
+	Success           []streamBucket `json:"success"`
+	Failure           []streamBucket `json:"failure"`
+	Timeout           []streamBucket `json:"timeout"`
+	ShortCircuited    []streamBucket `json:"shortCircuited"`
+	SemaphoreRejected []streamBucket `json:"semaphoreRejected"`
+	BadRequests       []streamBucket `json:"badRequests"`
+}
+
+type streamBucket struct {
+	// Start is in milliseconds since the epoch, like currentTime
+	Start int64 `json:"start"`
+	Count int64 `json:"count"`
 }
 
 type streamCmdLatency struct {
@@ -2,12 +2,16 @@ package metriceventstream
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/metrics/rolling"
 )
 
 func TestMetricEventStream(t *testing.T) {
@@ -46,3 +50,360 @@ func TestMetricEventStream(t *testing.T) {
 	// And finally wait for start to end
 	<-eventStreamStartResult
 }
+
+func TestMetricEventStream_ManyClients(t *testing.T) {
+	h := &circuit.Manager{}
+	h.MustCreateCircuit("hello-world", circuit.Config{})
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 10,
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+
+	numClients := 10
+	recorders := make([]*httptest.ResponseRecorder, numClients)
+	wg := sync.WaitGroup{}
+	for i := 0; i < numClients; i++ {
+		recorders[i] = httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream", nil)
+		reqContext, cancelData := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancelData()
+		wg.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			eventStream.ServeHTTP(rec, req.WithContext(reqContext))
+		}(recorders[i])
+	}
+	wg.Wait()
+	for i, rec := range recorders {
+		if !strings.Contains(rec.Body.String(), "hello-world") {
+			t.Errorf("client %d did not see my hello world circuit", i)
+		}
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Error("no error expected from closing event stream")
+	}
+	<-eventStreamStartResult
+}
+
+func TestMetricEventStream_Prefix(t *testing.T) {
+	h := &circuit.Manager{}
+	h.MustCreateCircuit("payments.charge", circuit.Config{})
+	h.MustCreateCircuit("payments.refund", circuit.Config{})
+	h.MustCreateCircuit("search.query", circuit.Config{})
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 10,
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream?prefix=payments.", nil)
+	reqContext, cancelData := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancelData()
+	eventStream.ServeHTTP(recorder, req.WithContext(reqContext))
+
+	bodyOfRequest := recorder.Body.String()
+	if !strings.Contains(bodyOfRequest, "payments.charge") || !strings.Contains(bodyOfRequest, "payments.refund") {
+		t.Error("Did not see my payments circuits in the body")
+	}
+	if strings.Contains(bodyOfRequest, "search.query") {
+		t.Error("Did not expect to see circuits outside the prefix")
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Error("no error expected from closing event stream")
+	}
+	<-eventStreamStartResult
+}
+
+func TestMetricEventStream_Shutdown(t *testing.T) {
+	h := &circuit.Manager{}
+	h.MustCreateCircuit("hello-world", circuit.Config{})
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 10,
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+
+	handlerDone := make(chan struct{})
+	go func() {
+		defer close(handlerDone)
+		req := httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream", nil)
+		eventStream.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+	for eventStream.listenerCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := eventStream.Shutdown(ctx); err != nil {
+		t.Fatal("expected shutdown to finish before the deadline", err)
+	}
+	select {
+	case <-handlerDone:
+	case <-ctx.Done():
+		t.Fatal("handler should exit once shutdown returns")
+	}
+	<-eventStreamStartResult
+
+	// Calling it again is safe
+	if err := eventStream.Shutdown(ctx); err != nil {
+		t.Fatal("expected second shutdown to work", err)
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Fatal("expected close after shutdown to work", err)
+	}
+
+	// New clients are turned away
+	recorder := httptest.NewRecorder()
+	eventStream.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatal("expected clients after shutdown to be rejected", recorder.Code)
+	}
+}
+
+func TestMetricEventStream_Buckets(t *testing.T) {
+	sf := rolling.StatFactory{}
+	h := &circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{sf.CreateConfig},
+	}
+	c := h.MustCreateCircuit("hello-world", circuit.Config{})
+	if err := c.Execute(context.Background(), func(_ context.Context) error {
+		return nil
+	}, nil); err != nil {
+		t.Error("no error expected from always passes")
+	}
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 10,
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+
+	withBuckets := httptest.NewRecorder()
+	withoutBuckets := httptest.NewRecorder()
+	reqContext, cancelData := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancelData()
+	wg := sync.WaitGroup{}
+	for url, rec := range map[string]*httptest.ResponseRecorder{
+		"http://localhost:8080/hystrix.stream?buckets=1": withBuckets,
+		"http://localhost:8080/hystrix.stream":           withoutBuckets,
+	} {
+		wg.Add(1)
+		go func(url string, rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			eventStream.ServeHTTP(rec, httptest.NewRequest("GET", url, nil).WithContext(reqContext))
+		}(url, rec)
+	}
+	wg.Wait()
+
+	firstEvent := strings.SplitN(strings.TrimPrefix(withBuckets.Body.String(), "data:"), "\n", 2)[0]
+	var event struct {
+		Buckets *streamCmdBuckets `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(firstEvent), &event); err != nil {
+		t.Fatal("expected a JSON event", err, firstEvent)
+	}
+	if event.Buckets == nil || len(event.Buckets.Success) == 0 {
+		t.Fatal("expected buckets when asked for", firstEvent)
+	}
+	successes := int64(0)
+	for _, b := range event.Buckets.Success {
+		if b.Start == 0 {
+			t.Error("expected every bucket to have a start time")
+		}
+		successes += b.Count
+	}
+	if successes != 1 {
+		t.Error("expected the success to be in a bucket", successes)
+	}
+	if strings.Contains(withoutBuckets.Body.String(), `"buckets"`) {
+		t.Error("did not expect buckets unless asked for")
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Error("no error expected from closing event stream")
+	}
+	<-eventStreamStartResult
+}
+
+func TestMetricEventStream_DropsSlowClients(t *testing.T) {
+	eventStream := MetricEventStream{}
+	eventStream.once.Do(eventStream.doOnce)
+	req := httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream", nil)
+	client := newStreamClient(1, streamFilter{})
+	eventStream.eventStreams[req] = client
+	events := []circuitEvent{{name: "hello-world", data: []byte("data:{}\n"), dataWithBuckets: []byte("data:{}\n")}}
+	eventStream.sendEvents(events)
+	if eventStream.listenerCount() != 1 {
+		t.Fatal("client should still be listening")
+	}
+	eventStream.sendEvents(events)
+	if eventStream.listenerCount() != 0 {
+		t.Fatal("slow client should be dropped")
+	}
+	select {
+	case <-client.dropped:
+	default:
+		t.Fatal("slow client should be told it was dropped")
+	}
+}
+
+func TestMetricEventStream_Rejections(t *testing.T) {
+	sf := rolling.StatFactory{}
+	h := &circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{sf.CreateConfig},
+	}
+	c := h.MustCreateCircuit("hello-world", circuit.Config{})
+	passes := func(_ context.Context) error {
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Execute(circuit.WithMaxConcurrentRequests(context.Background(), 0), passes, nil); err == nil {
+			t.Fatal("expected a concurrency limit rejection")
+		}
+	}
+	c.OpenCircuit()
+	if err := c.Execute(context.Background(), passes, nil); err == nil {
+		t.Fatal("expected a short circuit")
+	}
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 10,
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+	recorder := httptest.NewRecorder()
+	reqContext, cancelData := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancelData()
+	eventStream.ServeHTTP(recorder, httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream?buckets=1", nil).WithContext(reqContext))
+
+	firstEvent := strings.SplitN(strings.TrimPrefix(recorder.Body.String(), "data:"), "\n", 2)[0]
+	var event struct {
+		RollingCountSemaphoreRejected int64             `json:"rollingCountSemaphoreRejected"`
+		RollingCountShortCircuited    int64             `json:"rollingCountShortCircuited"`
+		CountSemaphoreRejected        int64             `json:"countSemaphoreRejected"`
+		CountShortCircuited           int64             `json:"countShortCircuited"`
+		Buckets                       *streamCmdBuckets `json:"buckets"`
+	}
+	if err := json.Unmarshal([]byte(firstEvent), &event); err != nil {
+		t.Fatal("expected a JSON event", err, firstEvent)
+	}
+	if event.RollingCountSemaphoreRejected != 2 || event.CountSemaphoreRejected != 2 {
+		t.Error("expected concurrency limit rejections to be semaphore rejections", firstEvent)
+	}
+	if event.RollingCountShortCircuited != 1 || event.CountShortCircuited != 1 {
+		t.Error("expected open circuit rejections to be short circuits", firstEvent)
+	}
+	sum := func(buckets []streamBucket) (total int64) {
+		for _, b := range buckets {
+			total += b.Count
+		}
+		return total
+	}
+	if event.Buckets == nil || sum(event.Buckets.SemaphoreRejected) != 2 || sum(event.Buckets.ShortCircuited) != 1 {
+		t.Error("expected separate rejection buckets", firstEvent)
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Error("no error expected from closing event stream")
+	}
+	<-eventStreamStartResult
+}
+
+// ndjsonEncoder writes one line of JSON per circuit
+type ndjsonEncoder struct{}
+
+func (ndjsonEncoder) Encode(cb *circuit.Circuit, withBuckets bool) ([]byte, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"circuit": cb.Name(),
+		"open":    cb.IsOpen(),
+	})
+	return append(b, '\n'), err
+}
+
+func (ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func TestMetricEventStream_Encoder(t *testing.T) {
+	h := &circuit.Manager{}
+	h.MustCreateCircuit("hello-world", circuit.Config{}).OpenCircuit()
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 10,
+		Encoder:      ndjsonEncoder{},
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream", nil)
+	reqContext, cancelData := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancelData()
+	eventStream.ServeHTTP(recorder, req.WithContext(reqContext))
+
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Error("expected the encoder's content type", contentType)
+	}
+	body := recorder.Body.String()
+	line := `{"circuit":"hello-world","open":true}` + "\n"
+	if body == "" || strings.Replace(body, line, "", -1) != "" {
+		t.Errorf("expected only lines from the encoder, saw %q", body)
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Error("no error expected from closing event stream")
+	}
+	<-eventStreamStartResult
+}
+
+func TestMetricEventStream_TickCadence(t *testing.T) {
+	h := &circuit.Manager{}
+	h.MustCreateCircuit("hello-world", circuit.Config{}).OpenCircuit()
+	eventStream := MetricEventStream{
+		Manager:      h,
+		TickDuration: time.Millisecond * 50,
+		Encoder:      ndjsonEncoder{},
+	}
+	eventStreamStartResult := make(chan error)
+	go func() {
+		eventStreamStartResult <- eventStream.Start()
+	}()
+
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "http://localhost:8080/hystrix.stream", nil)
+	reqContext, cancelData := context.WithTimeout(context.Background(), time.Millisecond*525)
+	defer cancelData()
+	eventStream.ServeHTTP(recorder, req.WithContext(reqContext))
+
+	// About 10 ticks happen while the client listens.  Allow for slow test machines, but not for bursts.
+	events := strings.Count(recorder.Body.String(), "\n")
+	if events < 5 || events > 11 {
+		t.Errorf("expected about 10 events at a 50ms tick over 525ms, saw %d", events)
+	}
+	if err := eventStream.Close(); err != nil {
+		t.Error("no error expected from closing event stream")
+	}
+	<-eventStreamStartResult
+}
+
+func TestMetricEventStream_MinTickDuration(t *testing.T) {
+	eventStream := MetricEventStream{TickDuration: time.Nanosecond}
+	if d := eventStream.tickDuration(); d != MinTickDuration {
+		t.Errorf("expected tiny ticks to use MinTickDuration, saw %s", d)
+	}
+}
@@ -5,7 +5,8 @@ import (
 	"time"
 )
 
-// RunMetricsCollection send metrics to multiple RunMetrics
+// RunMetricsCollection send metrics to multiple RunMetrics, in order.  It holds no state of its own, so it is as safe
+// for concurrent use as the collectors inside it.
 type RunMetricsCollection []RunMetrics
 
 var _ RunMetrics = &RunMetricsCollection{}
@@ -89,7 +90,25 @@ func (r RunMetricsCollection) ErrInterrupt(now time.Time, duration time.Duration
 	}
 }
 
-// FallbackMetricsCollection sends fallback metrics to all collectors
+// ExecuteDuration sends ExecuteDuration to all collectors that implement ExecuteDurationMetrics
+func (r RunMetricsCollection) ExecuteDuration(now time.Time, runDuration time.Duration, totalDuration time.Duration) {
+	for _, c := range r {
+		if e, ok := c.(ExecuteDurationMetrics); ok {
+			e.ExecuteDuration(now, runDuration, totalDuration)
+		}
+	}
+}
+
+// Attempt sends Attempt to all collectors that implement AttemptMetrics
+func (r RunMetricsCollection) Attempt(now time.Time) {
+	for _, c := range r {
+		if a, ok := c.(AttemptMetrics); ok {
+			a.Attempt(now)
+		}
+	}
+}
+
+// FallbackMetricsCollection sends fallback metrics to all collectors, in order
 type FallbackMetricsCollection []FallbackMetrics
 
 var _ FallbackMetrics = &FallbackMetricsCollection{}
@@ -115,6 +134,60 @@ func (r FallbackMetricsCollection) ErrFailure(now time.Time, duration time.Durat
 	}
 }
 
+// Skipped sends Skipped to all collectors that implement FallbackSkippedMetrics
+func (r FallbackMetricsCollection) Skipped(now time.Time) {
+	for _, c := range r {
+		if s, ok := c.(FallbackSkippedMetrics); ok {
+			s.Skipped(now)
+		}
+	}
+}
+
+// ShortCircuitSuccess sends ShortCircuitSuccess to all collectors that implement ShortCircuitFallbackMetrics
+func (r FallbackMetricsCollection) ShortCircuitSuccess(now time.Time, duration time.Duration) {
+	for _, c := range r {
+		if s, ok := c.(ShortCircuitFallbackMetrics); ok {
+			s.ShortCircuitSuccess(now, duration)
+		}
+	}
+}
+
+// ShortCircuitFailure sends ShortCircuitFailure to all collectors that implement ShortCircuitFallbackMetrics
+func (r FallbackMetricsCollection) ShortCircuitFailure(now time.Time, duration time.Duration) {
+	for _, c := range r {
+		if s, ok := c.(ShortCircuitFallbackMetrics); ok {
+			s.ShortCircuitFailure(now, duration)
+		}
+	}
+}
+
+// ErrNestedShortCircuit sends ErrNestedShortCircuit to all collectors that implement NestedCircuitFallbackMetrics
+func (r FallbackMetricsCollection) ErrNestedShortCircuit(now time.Time, duration time.Duration) {
+	for _, c := range r {
+		if n, ok := c.(NestedCircuitFallbackMetrics); ok {
+			n.ErrNestedShortCircuit(now, duration)
+		}
+	}
+}
+
+// PrimaryWon sends PrimaryWon to all collectors that implement HedgeFallbackMetrics
+func (r FallbackMetricsCollection) PrimaryWon(now time.Time, duration time.Duration) {
+	for _, c := range r {
+		if h, ok := c.(HedgeFallbackMetrics); ok {
+			h.PrimaryWon(now, duration)
+		}
+	}
+}
+
+// FallbackWon sends FallbackWon to all collectors that implement HedgeFallbackMetrics
+func (r FallbackMetricsCollection) FallbackWon(now time.Time, duration time.Duration) {
+	for _, c := range r {
+		if h, ok := c.(HedgeFallbackMetrics); ok {
+			h.FallbackWon(now, duration)
+		}
+	}
+}
+
 // Var exposes run collectors as expvar
 func (r FallbackMetricsCollection) Var() expvar.Var {
 	return expvar.Func(func() interface{} {
@@ -160,7 +233,16 @@ type Metrics interface {
 
 // RunMetrics is guaranteed to execute one (and only one) of the following functions each time the circuit
 // attempts to call a run function. Methods with durations are when run was actually executed.  Methods without
-// durations never called run, probably because of the circuit.
+// durations never called run, probably because of the circuit.  Each outcome has its own method, so a collector can
+// keep a latency histogram per outcome by observing duration in that method: for example, to see fast failures next
+// to slow successes.
+//
+// Within one Execute, callbacks always happen in the same order: AttemptMetrics.Attempt, then the outcome and any
+// FailureSeverityMetrics.ErrFailureSeverity, then any Opened or Closed the outcome caused, then
+// OutcomeLabelMetrics.LabeledOutcome, then FallbackMetrics, and last ExecuteDurationMetrics.ExecuteDuration.  Each
+// callback reaches the circuit's open and close logic first, then Metrics.Run in the order collectors were
+// configured, then collectors added with AppendRunMetrics.  Hedged calls are the exception, since runFunc and the
+// fallback run at the same time: see Fallback.Hedge.
 type RunMetrics interface {
 	// Success each time `Execute` does not return an error
 	Success(now time.Time, duration time.Duration)
@@ -188,6 +270,57 @@ type RunMetrics interface {
 	ErrShortCircuit(now time.Time)
 }
 
+// ExecuteDurationMetrics can be implemented by RunMetrics that want to split the latency of Execute between runFunc
+// and the fallback.  The durations passed to RunMetrics and FallbackMetrics are not changed.
+type ExecuteDurationMetrics interface {
+	// ExecuteDuration is called once when each Execute ends, after any fallback, unless the circuit is disabled or
+	// draining.  runDuration is how long runFunc ran,
+	// or zero if it was never called.  totalDuration is how long Execute took, including the fallback.
+	ExecuteDuration(now time.Time, runDuration time.Duration, totalDuration time.Duration)
+}
+
+var _ ExecuteDurationMetrics = RunMetricsCollection(nil)
+
+// AttemptMetrics can be implemented by RunMetrics that want to know when a request is admitted, before runFunc is
+// called.  Use it to track requests in flight: every Attempt is followed by exactly one of Success, ErrFailure,
+// ErrTimeout, ErrBadRequest, or ErrInterrupt, unless runFunc panics without Execution.RecoverPanics.  Short circuits
+// and concurrency limit rejections are never attempts.
+type AttemptMetrics interface {
+	// Attempt is called synchronously, right before runFunc, once the circuit allows the request
+	Attempt(now time.Time)
+}
+
+var _ AttemptMetrics = RunMetricsCollection(nil)
+
+// OutcomeLabelMetrics can be implemented by RunMetrics that want to break a circuit's metrics down by the label a
+// runFunc reports with SetOutcomeLabel.  LabeledOutcome is called in addition to the matching RunMetrics method, and
+// only for runs that set a label.  outcome is one of OutcomeSuccess, OutcomeFailure, OutcomeTimeout, OutcomeBadRequest,
+// or OutcomeInterrupt.
+type OutcomeLabelMetrics interface {
+	LabeledOutcome(now time.Time, label string, outcome Outcome, duration time.Duration)
+}
+
+var _ OutcomeLabelMetrics = RunMetricsCollection(nil)
+
+// LabeledOutcome sends LabeledOutcome to all collectors that implement OutcomeLabelMetrics
+func (r RunMetricsCollection) LabeledOutcome(now time.Time, label string, outcome Outcome, duration time.Duration) {
+	for _, c := range r {
+		if l, ok := c.(OutcomeLabelMetrics); ok {
+			l.LabeledOutcome(now, label, outcome, duration)
+		}
+	}
+}
+
+// wantsOutcomeLabels is true if any collector in r implements OutcomeLabelMetrics
+func wantsOutcomeLabels(r []RunMetrics) bool {
+	for _, c := range r {
+		if _, ok := c.(OutcomeLabelMetrics); ok {
+			return true
+		}
+	}
+	return false
+}
+
 // FallbackMetrics is guaranteed to execute one (and only one) of the following functions each time a fallback is executed.
 // Methods with durations are when the fallback is actually executed.  Methods without durations are when the fallback was
 // never called, probably because of some circuit condition.
@@ -201,4 +334,102 @@ type FallbackMetrics interface {
 	ErrConcurrencyLimitReject(now time.Time)
 }
 
+// FallbackSkippedMetrics can be implemented by FallbackMetrics that also want to know when a fallback was skipped
+// because Execute was called with WithoutFallback.  It is separate from FallbackMetrics so existing collectors keep
+// working.
+type FallbackSkippedMetrics interface {
+	// Skipped each time a fallback was given, but not called
+	Skipped(now time.Time)
+}
+
+// ShortCircuitFallbackMetrics can be implemented by FallbackMetrics that want to tell fallbacks for rejected requests
+// apart from fallbacks for failed ones.  Its methods are called in addition to Success or ErrFailure, when the
+// fallback ran because the circuit never called runFunc: it was open, or at its concurrency limit.  Subtract them from
+// Success and ErrFailure to count fallbacks for runFunc errors and timeouts.
+type ShortCircuitFallbackMetrics interface {
+	// ShortCircuitSuccess each time the fallback for a rejected request succeeds
+	ShortCircuitSuccess(now time.Time, duration time.Duration)
+	// ShortCircuitFailure each time the fallback for a rejected request fails
+	ShortCircuitFailure(now time.Time, duration time.Duration)
+}
+
+var _ ShortCircuitFallbackMetrics = FallbackMetricsCollection(nil)
+
+// NestedCircuitFallbackMetrics can be implemented by FallbackMetrics that want to tell fallbacks that failed because
+// they called another circuit that was open apart from other fallback failures.  ErrNestedShortCircuit is called in
+// addition to ErrFailure when the fallback's error is a RejectedError from a different circuit, with ReasonCircuitOpen
+// or ReasonPrevented.
+type NestedCircuitFallbackMetrics interface {
+	// ErrNestedShortCircuit each time the fallback failed because a circuit it called was open
+	ErrNestedShortCircuit(now time.Time, duration time.Duration)
+}
+
+var _ NestedCircuitFallbackMetrics = FallbackMetricsCollection(nil)
+
+// HedgeFallbackMetrics can be implemented by FallbackMetrics that want to know which side of a call made with
+// Fallback.Hedge succeeded first.  duration is how long the winner took, measured from when both were started.
+// Neither is called if both fail, or runFunc returns a bad request.  The usual run and fallback metrics are still
+// reported for each side once it returns.
+type HedgeFallbackMetrics interface {
+	// PrimaryWon each time runFunc succeeded before the fallback
+	PrimaryWon(now time.Time, duration time.Duration)
+	// FallbackWon each time the fallback succeeded before runFunc
+	FallbackWon(now time.Time, duration time.Duration)
+}
+
+var _ HedgeFallbackMetrics = FallbackMetricsCollection(nil)
+
+// LabelSetter can be implemented by any RunMetrics, FallbackMetrics, or Metrics that wants the labels of the
+// circuit it reports on.  SetLabels is called before any other metric: each time the circuit's config is set with
+// SetConfigNotThreadSafe, and when the collector is appended.  labels may be nil and must not be modified.
+type LabelSetter interface {
+	SetLabels(labels map[string]string)
+}
+
+var _ LabelSetter = RunMetricsCollection(nil)
+var _ LabelSetter = FallbackMetricsCollection(nil)
+var _ LabelSetter = MetricsCollection(nil)
+
+func setLabels(c interface{}, labels map[string]string) {
+	if ls, ok := c.(LabelSetter); ok {
+		ls.SetLabels(labels)
+	}
+}
+
+// SetLabels sends SetLabels to all collectors that implement LabelSetter
+func (r RunMetricsCollection) SetLabels(labels map[string]string) {
+	for _, c := range r {
+		setLabels(c, labels)
+	}
+}
+
+// SetLabels sends SetLabels to all collectors that implement LabelSetter
+func (r FallbackMetricsCollection) SetLabels(labels map[string]string) {
+	for _, c := range r {
+		setLabels(c, labels)
+	}
+}
+
+// SetLabels sends SetLabels to all collectors that implement LabelSetter
+func (r MetricsCollection) SetLabels(labels map[string]string) {
+	for _, c := range r {
+		setLabels(c, labels)
+	}
+}
+
+// MetricsCloner can be implemented by any RunMetrics, FallbackMetrics, or Metrics that keeps state for one circuit, so
+// Circuit.Clone gives the new circuit its own collector instead of sharing this one.  CloneMetrics returns a new,
+// empty collector with the same configuration, for circuitName.  It must implement the same metric interfaces.
+type MetricsCloner interface {
+	CloneMetrics(circuitName string) interface{}
+}
+
+// cloneMetrics returns the clone of m if it is a MetricsCloner, or m itself
+func cloneMetrics(m interface{}, circuitName string) interface{} {
+	if mc, ok := m.(MetricsCloner); ok {
+		return mc.CloneMetrics(circuitName)
+	}
+	return m
+}
+
 var _ FallbackMetrics = RunMetrics(nil)
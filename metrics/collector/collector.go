@@ -0,0 +1,245 @@
+package collector
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/faststats"
+)
+
+// Collector counts every run, fallback, and open/close event of a circuit.  Attach it with Config.  It is safe for
+// concurrent use.
+type Collector struct {
+	Run      Run
+	Fallback Fallback
+	Circuit  Circuit
+}
+
+// Config returns a circuit.Config that reports to c
+func (c *Collector) Config() circuit.Config {
+	return circuit.Config{
+		Metrics: circuit.MetricsCollectors{
+			Run:      []circuit.RunMetrics{&c.Run},
+			Fallback: []circuit.FallbackMetrics{&c.Fallback},
+			Circuit:  []circuit.Metrics{&c.Circuit},
+		},
+	}
+}
+
+// durations records the durations passed to metrics, in order
+type durations struct {
+	mu        sync.Mutex
+	durations []time.Duration
+}
+
+func (d *durations) add(duration time.Duration) {
+	d.mu.Lock()
+	d.durations = append(d.durations, duration)
+	d.mu.Unlock()
+}
+
+func (d *durations) get() []time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ret := make([]time.Duration, len(d.durations))
+	copy(ret, d.durations)
+	return ret
+}
+
+// Run is a circuit.RunMetrics that counts each event
+type Run struct {
+	successes               faststats.AtomicInt64
+	failures                faststats.AtomicInt64
+	timeouts                faststats.AtomicInt64
+	badRequests             faststats.AtomicInt64
+	interrupts              faststats.AtomicInt64
+	concurrencyLimitRejects faststats.AtomicInt64
+	shortCircuits           faststats.AtomicInt64
+	durations               durations
+}
+
+var _ circuit.RunMetrics = &Run{}
+
+// Success counts a successful runFunc
+func (r *Run) Success(now time.Time, duration time.Duration) {
+	r.successes.Add(1)
+	r.durations.add(duration)
+}
+
+// ErrFailure counts a failed runFunc
+func (r *Run) ErrFailure(now time.Time, duration time.Duration) {
+	r.failures.Add(1)
+	r.durations.add(duration)
+}
+
+// ErrTimeout counts a runFunc that timed out
+func (r *Run) ErrTimeout(now time.Time, duration time.Duration) {
+	r.timeouts.Add(1)
+	r.durations.add(duration)
+}
+
+// ErrBadRequest counts a runFunc that returned a bad request
+func (r *Run) ErrBadRequest(now time.Time, duration time.Duration) {
+	r.badRequests.Add(1)
+	r.durations.add(duration)
+}
+
+// ErrInterrupt counts a runFunc that ended because its context did
+func (r *Run) ErrInterrupt(now time.Time, duration time.Duration) {
+	r.interrupts.Add(1)
+	r.durations.add(duration)
+}
+
+// ErrConcurrencyLimitReject counts a runFunc that was not called because of the concurrency limit
+func (r *Run) ErrConcurrencyLimitReject(now time.Time) {
+	r.concurrencyLimitRejects.Add(1)
+}
+
+// ErrShortCircuit counts a runFunc that was not called because the circuit was open
+func (r *Run) ErrShortCircuit(now time.Time) {
+	r.shortCircuits.Add(1)
+}
+
+// Successes is how many times Success was called
+func (r *Run) Successes() int64 {
+	return r.successes.Get()
+}
+
+// Failures is how many times ErrFailure was called
+func (r *Run) Failures() int64 {
+	return r.failures.Get()
+}
+
+// Timeouts is how many times ErrTimeout was called
+func (r *Run) Timeouts() int64 {
+	return r.timeouts.Get()
+}
+
+// BadRequests is how many times ErrBadRequest was called
+func (r *Run) BadRequests() int64 {
+	return r.badRequests.Get()
+}
+
+// Interrupts is how many times ErrInterrupt was called
+func (r *Run) Interrupts() int64 {
+	return r.interrupts.Get()
+}
+
+// ConcurrencyLimitRejects is how many times ErrConcurrencyLimitReject was called
+func (r *Run) ConcurrencyLimitRejects() int64 {
+	return r.concurrencyLimitRejects.Get()
+}
+
+// ShortCircuits is how many times ErrShortCircuit was called
+func (r *Run) ShortCircuits() int64 {
+	return r.shortCircuits.Get()
+}
+
+// Errors is how many times any method other than Success was called
+func (r *Run) Errors() int64 {
+	return r.Failures() + r.Timeouts() + r.BadRequests() + r.Interrupts() + r.ConcurrencyLimitRejects() + r.ShortCircuits()
+}
+
+// Total is how many run events were counted, of any kind
+func (r *Run) Total() int64 {
+	return r.Successes() + r.Errors()
+}
+
+// Durations returns a copy of every duration passed to a method, in the order they were called.  Events without a
+// duration, like short circuits, are not included.
+func (r *Run) Durations() []time.Duration {
+	return r.durations.get()
+}
+
+// Fallback is a circuit.FallbackMetrics that counts each event.  It also counts skipped fallbacks.
+type Fallback struct {
+	successes               faststats.AtomicInt64
+	failures                faststats.AtomicInt64
+	concurrencyLimitRejects faststats.AtomicInt64
+	skipped                 faststats.AtomicInt64
+	durations               durations
+}
+
+var _ circuit.FallbackMetrics = &Fallback{}
+var _ circuit.FallbackSkippedMetrics = &Fallback{}
+
+// Success counts a successful fallback
+func (f *Fallback) Success(now time.Time, duration time.Duration) {
+	f.successes.Add(1)
+	f.durations.add(duration)
+}
+
+// ErrFailure counts a failed fallback
+func (f *Fallback) ErrFailure(now time.Time, duration time.Duration) {
+	f.failures.Add(1)
+	f.durations.add(duration)
+}
+
+// ErrConcurrencyLimitReject counts a fallback that was not called because of the concurrency limit
+func (f *Fallback) ErrConcurrencyLimitReject(now time.Time) {
+	f.concurrencyLimitRejects.Add(1)
+}
+
+// Skipped counts a fallback that was not called because of circuit.WithoutFallback
+func (f *Fallback) Skipped(now time.Time) {
+	f.skipped.Add(1)
+}
+
+// Successes is how many times Success was called
+func (f *Fallback) Successes() int64 {
+	return f.successes.Get()
+}
+
+// Failures is how many times ErrFailure was called
+func (f *Fallback) Failures() int64 {
+	return f.failures.Get()
+}
+
+// ConcurrencyLimitRejects is how many times ErrConcurrencyLimitReject was called
+func (f *Fallback) ConcurrencyLimitRejects() int64 {
+	return f.concurrencyLimitRejects.Get()
+}
+
+// Skips is how many times Skipped was called
+func (f *Fallback) Skips() int64 {
+	return f.skipped.Get()
+}
+
+// Errors is how many times ErrFailure or ErrConcurrencyLimitReject was called
+func (f *Fallback) Errors() int64 {
+	return f.Failures() + f.ConcurrencyLimitRejects()
+}
+
+// Durations returns a copy of every duration passed to a method, in the order they were called
+func (f *Fallback) Durations() []time.Duration {
+	return f.durations.get()
+}
+
+// Circuit is a circuit.Metrics that counts how many times the circuit opened and closed
+type Circuit struct {
+	opened faststats.AtomicInt64
+	closed faststats.AtomicInt64
+}
+
+var _ circuit.Metrics = &Circuit{}
+
+// Opened counts the circuit opening
+func (c *Circuit) Opened(now time.Time) {
+	c.opened.Add(1)
+}
+
+// Closed counts the circuit closing
+func (c *Circuit) Closed(now time.Time) {
+	c.closed.Add(1)
+}
+
+// Opens is how many times Opened was called
+func (c *Circuit) Opens() int64 {
+	return c.opened.Get()
+}
+
+// Closes is how many times Closed was called
+func (c *Circuit) Closes() int64 {
+	return c.closed.Get()
+}
@@ -0,0 +1,68 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+func TestCollector(t *testing.T) {
+	var c Collector
+	cb := circuit.NewCircuitFromConfig("TestCollector", c.Config())
+	ctx := context.Background()
+	testhelp.MustTesting(t, cb.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, cb.Execute(ctx, testhelp.AlwaysFails, testhelp.AlwaysPassesFallback))
+	testhelp.MustNotTesting(t, cb.Execute(ctx, func(_ context.Context) error {
+		return circuit.SimpleBadRequest{Err: errors.New("bad")}
+	}, nil))
+	testhelp.MustNotTesting(t, cb.Execute(circuit.WithoutFallback(ctx), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback))
+	cb.OpenCircuit()
+	testhelp.MustNotTesting(t, cb.Execute(ctx, testhelp.AlwaysPasses, testhelp.AlwaysFailsFallback))
+	cb.CloseCircuit()
+
+	expectCount := func(name string, got int64, expected int64) {
+		t.Helper()
+		if got != expected {
+			t.Errorf("expected %d %s, saw %d", expected, name, got)
+		}
+	}
+	expectCount("run successes", c.Run.Successes(), 1)
+	expectCount("run failures", c.Run.Failures(), 2)
+	expectCount("run bad requests", c.Run.BadRequests(), 1)
+	expectCount("run short circuits", c.Run.ShortCircuits(), 1)
+	expectCount("run timeouts", c.Run.Timeouts(), 0)
+	expectCount("run errors", c.Run.Errors(), 4)
+	expectCount("run events", c.Run.Total(), 5)
+	expectCount("run durations", int64(len(c.Run.Durations())), 4)
+	expectCount("fallback successes", c.Fallback.Successes(), 1)
+	expectCount("fallback failures", c.Fallback.Failures(), 1)
+	expectCount("fallback skips", c.Fallback.Skips(), 1)
+	expectCount("fallback durations", int64(len(c.Fallback.Durations())), 2)
+	expectCount("opens", c.Circuit.Opens(), 1)
+	expectCount("closes", c.Circuit.Closes(), 1)
+}
+
+func TestRun_Concurrent(t *testing.T) {
+	var r Run
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				r.Success(time.Now(), time.Millisecond)
+				r.ErrTimeout(time.Now(), time.Second)
+				r.Durations()
+			}
+		}()
+	}
+	wg.Wait()
+	if r.Successes() != 1000 || r.Timeouts() != 1000 || len(r.Durations()) != 2000 {
+		t.Error("expected every event to be counted", r.Successes(), r.Timeouts(), len(r.Durations()))
+	}
+}
@@ -0,0 +1,4 @@
+/*
+Package collector contains in memory RunMetrics and FallbackMetrics that count each event, for assertions in tests.
+*/
+package collector
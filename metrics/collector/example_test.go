@@ -0,0 +1,23 @@
+package collector_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/metrics/collector"
+)
+
+// This example counts the events of a circuit, to assert on them in a test
+func ExampleCollector() {
+	var c collector.Collector
+	cb := circuit.NewCircuitFromConfig("tested-circuit", c.Config())
+	_ = cb.Execute(context.Background(), func(_ context.Context) error {
+		return errors.New("upstream is down")
+	}, func(_ context.Context, _ error) error {
+		return nil
+	})
+	fmt.Println(c.Run.Failures(), c.Fallback.Successes())
+	// Output: 1 1
+}
@@ -0,0 +1,6 @@
+/*
+Package openmetrics serves the state of every circuit in a circuit.Manager in the Prometheus text exposition format,
+without depending on the Prometheus client library.  Counts and latencies come from rolling stats, so circuits should
+be created with a rolling.StatFactory.  Circuits without rolling stats still show up, with their counts as zero.
+*/
+package openmetrics
@@ -0,0 +1,171 @@
+package openmetrics
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/metrics/rolling"
+)
+
+// ContentType is the Content-Type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler is a HTTP handler that writes every circuit of Manager in the Prometheus text exposition format.  Metric
+// names match the ones prometheusmetrics uses, so dashboards work with either, except for latency: prometheusmetrics
+// has a run_duration_seconds histogram, and Handler has run_duration_quantile_seconds gauges over the rolling window.
+type Handler struct {
+	Manager *circuit.Manager
+	// Namespace prefixes every metric name, like the namespace of prometheusmetrics.NewCommandFactory.  It is optional.
+	Namespace string
+}
+
+var _ http.Handler = &Handler{}
+
+// latencyQuantiles are the quantiles of the run latency gauges
+var latencyQuantiles = []float64{0.5, 0.9, 0.99}
+
+// circuitStats is what is written for one circuit
+type circuitStats struct {
+	name      string
+	circuit   *circuit.Circuit
+	run       *rolling.RunStats
+	fallbacks *rolling.FallbackStats
+}
+
+// ServeHTTP writes the current metrics of every circuit, sorted by circuit name
+func (h *Handler) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", ContentType)
+	w := bufio.NewWriter(rw)
+	h.write(w)
+	_ = w.Flush()
+}
+
+func (h *Handler) write(w *bufio.Writer) {
+	var all []circuitStats
+	h.Manager.Each(func(name string, c *circuit.Circuit) {
+		stats := circuitStats{
+			name:      name,
+			circuit:   c,
+			run:       rolling.FindCommandMetrics(c),
+			fallbacks: rolling.FindFallbackMetrics(c),
+		}
+		if stats.run == nil {
+			stats.run = &rolling.RunStats{}
+		}
+		if stats.fallbacks == nil {
+			stats.fallbacks = &rolling.FallbackStats{}
+		}
+		all = append(all, stats)
+	})
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].name < all[j].name
+	})
+
+	isOpen := h.metricName("is_open")
+	writeHeader(w, isOpen, "gauge", "1 if the circuit is open, 0 if it is closed")
+	for _, s := range all {
+		open := int64(0)
+		if s.circuit.IsOpen() {
+			open = 1
+		}
+		writeSample(w, isOpen, s.name, "", "", strconv.FormatInt(open, 10))
+	}
+
+	concurrent := h.metricName("concurrent_commands")
+	writeHeader(w, concurrent, "gauge", "Number of run functions executing right now")
+	for _, s := range all {
+		writeSample(w, concurrent, s.name, "", "", strconv.FormatInt(s.circuit.ConcurrentCommands(), 10))
+	}
+
+	concurrentFallbacks := h.metricName("concurrent_fallbacks")
+	writeHeader(w, concurrentFallbacks, "gauge", "Number of fallback functions executing right now")
+	for _, s := range all {
+		writeSample(w, concurrentFallbacks, s.name, "", "", strconv.FormatInt(s.circuit.ConcurrentFallbacks(), 10))
+	}
+
+	runTotal := h.metricName("run_total")
+	writeHeader(w, runTotal, "counter", "Count of circuit run results")
+	for _, s := range all {
+		r := s.run
+		for _, result := range []struct {
+			name  string
+			count int64
+		}{
+			{"success", r.Successes.TotalSum()},
+			{"err_failure", r.ErrFailures.TotalSum()},
+			{"err_timeout", r.ErrTimeouts.TotalSum()},
+			{"err_bad_request", r.ErrBadRequests.TotalSum()},
+			{"err_interrupt", r.ErrInterrupts.TotalSum()},
+			{"err_short_circuit", r.ErrShortCircuits.TotalSum()},
+			{"err_concurrency_limit_reject", r.ErrConcurrencyLimitRejects.TotalSum()},
+		} {
+			writeSample(w, runTotal, s.name, "result", result.name, strconv.FormatInt(result.count, 10))
+		}
+	}
+
+	fallbackTotal := h.metricName("fallback_total")
+	writeHeader(w, fallbackTotal, "counter", "Count of circuit fallback results")
+	for _, s := range all {
+		f := s.fallbacks
+		for _, result := range []struct {
+			name  string
+			count int64
+		}{
+			{"success", f.Successes.TotalSum()},
+			{"err_failure", f.ErrFailures.TotalSum()},
+			{"err_concurrency_limit_reject", f.ErrConcurrencyLimitRejects.TotalSum()},
+		} {
+			writeSample(w, fallbackTotal, s.name, "result", result.name, strconv.FormatInt(result.count, 10))
+		}
+	}
+
+	// Latencies are only kept for the rolling window.  A summary's _sum and _count would go down as the window moves,
+	// which breaks rate(), so the quantiles are gauges instead.
+	latency := h.metricName("run_duration_quantile_seconds")
+	writeHeader(w, latency, "gauge", "Quantiles of how long circuit run functions took to execute, over the rolling stats window")
+	for _, s := range all {
+		snap := s.run.Latencies.SnapshotAt(s.circuit.Config().General.TimeKeeper.Now())
+		for _, q := range latencyQuantiles {
+			value := "NaN"
+			if len(snap) > 0 {
+				value = formatFloat(snap.Percentile(q * 100).Seconds())
+			}
+			writeSample(w, latency, s.name, "quantile", formatFloat(q), value)
+		}
+	}
+}
+
+func (h *Handler) metricName(name string) string {
+	if h.Namespace == "" {
+		return "circuit_" + name
+	}
+	return h.Namespace + "_circuit_" + name
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeHeader(w *bufio.Writer, name string, metricType string, help string) {
+	_, _ = w.WriteString("# HELP " + name + " " + help + "\n")
+	_, _ = w.WriteString("# TYPE " + name + " " + metricType + "\n")
+}
+
+// writeSample writes one sample labeled with the circuit name and, if labelName is not empty, one more label
+func writeSample(w *bufio.Writer, name string, circuitName string, labelName string, labelValue string, value string) {
+	_, _ = w.WriteString(name + `{circuit="` + escapeLabelValue(circuitName) + `"`)
+	if labelName != "" {
+		_, _ = w.WriteString(`,` + labelName + `="` + escapeLabelValue(labelValue) + `"`)
+	}
+	_, _ = w.WriteString("} " + value + "\n")
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(v string) string {
+	return labelValueEscaper.Replace(v)
+}
@@ -0,0 +1,119 @@
+package openmetrics
+
+import (
+	"context"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/testhelp"
+	"github.com/cep21/circuit/metrics/rolling"
+)
+
+var (
+	helpLine   = regexp.MustCompile(`^# HELP ([a-zA-Z_:][a-zA-Z0-9_:]*) .+$`)
+	typeLine   = regexp.MustCompile(`^# TYPE ([a-zA-Z_:][a-zA-Z0-9_:]*) (counter|gauge|summary|histogram|untyped)$`)
+	sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\{((?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\.)*",?)*)\} (\S+)$`)
+)
+
+// parse checks body is valid text exposition format, returning each sample line mapped to its value
+func parse(t *testing.T, body string) map[string]float64 {
+	samples := make(map[string]float64)
+	typed := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if m := typeLine.FindStringSubmatch(line); m != nil {
+			if _, exists := typed[m[1]]; exists {
+				t.Errorf("metric %s is typed twice", m[1])
+			}
+			typed[m[1]] = m[2]
+			continue
+		}
+		if helpLine.MatchString(line) {
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("line does not parse: %q", line)
+			continue
+		}
+		if typed[m[1]] == "" {
+			t.Errorf("sample comes before its TYPE: %q", line)
+		}
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			t.Errorf("sample value does not parse: %q", line)
+		}
+		samples[m[1]+"{"+m[2]+"}"] = value
+	}
+	return samples
+}
+
+func TestHandler(t *testing.T) {
+	sf := rolling.StatFactory{}
+	h := &circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{sf.CreateConfig},
+	}
+	c := h.MustCreateCircuit("hello-world")
+	h.MustCreateCircuit(`quoted "name"`)
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysFails, func(_ context.Context, _ error) error {
+		return nil
+	}))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+
+	recorder := httptest.NewRecorder()
+	(&Handler{Manager: h, Namespace: "app"}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if recorder.Header().Get("Content-Type") != ContentType {
+		t.Error("unexpected content type", recorder.Header().Get("Content-Type"))
+	}
+	samples := parse(t, recorder.Body.String())
+	for line, value := range map[string]float64{
+		`app_circuit_is_open{circuit="hello-world"}`:                                              1,
+		`app_circuit_is_open{circuit="quoted \"name\""}`:                                          0,
+		`app_circuit_concurrent_commands{circuit="hello-world"}`:                                  0,
+		`app_circuit_run_total{circuit="hello-world",result="success"}`:                           2,
+		`app_circuit_run_total{circuit="hello-world",result="err_failure"}`:                       2,
+		`app_circuit_run_total{circuit="hello-world",result="err_short_circuit"}`:                 1,
+		`app_circuit_run_total{circuit="quoted \"name\"",result="success"}`:                       0,
+		`app_circuit_fallback_total{circuit="hello-world",result="success"}`:                      1,
+		`app_circuit_fallback_total{circuit="hello-world",result="err_concurrency_limit_reject"}`: 0,
+	} {
+		got, exists := samples[line]
+		if !exists {
+			t.Errorf("expected a sample for %s", line)
+			continue
+		}
+		if got != value {
+			t.Errorf("expected %s to be %f, saw %f", line, value, got)
+		}
+	}
+	if _, exists := samples[`app_circuit_run_duration_quantile_seconds{circuit="hello-world",quantile="0.99"}`]; !exists {
+		t.Error("expected a latency quantile")
+	}
+	if _, exists := samples[`app_circuit_run_duration_quantile_seconds_count{circuit="hello-world"}`]; exists {
+		t.Error("expected no windowed count, which would go down as the window moves")
+	}
+	for line := range samples {
+		if strings.HasPrefix(line, "app_circuit_run_duration_seconds") {
+			t.Error("expected no metric named like the prometheusmetrics histogram", line)
+		}
+	}
+}
+
+func TestHandler_NoRollingStats(t *testing.T) {
+	h := &circuit.Manager{}
+	h.MustCreateCircuit("plain")
+	recorder := httptest.NewRecorder()
+	(&Handler{Manager: h}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	samples := parse(t, recorder.Body.String())
+	if v, exists := samples[`circuit_run_total{circuit="plain",result="success"}`]; !exists || v != 0 {
+		t.Error("expected circuits without rolling stats to show zero counts")
+	}
+}
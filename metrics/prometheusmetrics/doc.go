@@ -0,0 +1,5 @@
+/*
+Package prometheusmetrics contains a MetricsCollector that submits circuit metrics to prometheus using the
+github.com/prometheus/client_golang/prometheus library.
+*/
+package prometheusmetrics
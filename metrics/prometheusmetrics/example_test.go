@@ -0,0 +1,24 @@
+package prometheusmetrics_test
+
+import (
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/metrics/prometheusmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// This example shows how to inject a prometheus metric collector into a circuit
+func ExampleCommandFactory_CommandProperties() {
+	// This factory allows us to report prometheus metrics from the circuit
+	f, err := prometheusmetrics.NewCommandFactory(prometheus.NewRegistry(), "myapp")
+	if err != nil {
+		panic(err)
+	}
+
+	// Wire the prometheus factory into the circuit manager
+	h := circuit.Manager{
+		DefaultCircuitProperties: []circuit.CommandPropertiesConstructor{f.CommandProperties},
+	}
+	// This created circuit will now use prometheus
+	h.MustCreateCircuit("using-prometheus")
+	// Output:
+}
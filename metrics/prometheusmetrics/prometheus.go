@@ -0,0 +1,290 @@
+package prometheusmetrics
+
+import (
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CommandFactory allows ingesting prometheus metrics.  Create one with NewCommandFactory or NewLabeledCommandFactory.
+type CommandFactory struct {
+	labelNames       []string
+	runCount         *prometheus.CounterVec
+	runDuration      *prometheus.HistogramVec
+	fallbackCount    *prometheus.CounterVec
+	fallbackDuration *prometheus.HistogramVec
+	isOpen           *prometheus.GaugeVec
+}
+
+// NewCommandFactory creates the prometheus collectors used by circuits and registers them with registerer.  If
+// registerer is nil, prometheus.DefaultRegisterer is used.  Collectors that are already registered are reused.
+func NewCommandFactory(registerer prometheus.Registerer, namespace string) (*CommandFactory, error) {
+	return NewLabeledCommandFactory(registerer, namespace, nil)
+}
+
+// NewLabeledCommandFactory is NewCommandFactory, with an extra prometheus label for each of labelNames.  Their values
+// come from the circuit's General.Labels, with the same keys.  Labels a circuit does not have are empty.
+func NewLabeledCommandFactory(registerer prometheus.Registerer, namespace string, labelNames []string) (*CommandFactory, error) {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	withLabels := func(names ...string) []string {
+		return append(names, labelNames...)
+	}
+	ret := &CommandFactory{
+		labelNames: labelNames,
+		runCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit",
+			Name:      "run_total",
+			Help:      "Count of circuit run results",
+		}, withLabels("circuit", "result")),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "circuit",
+			Name:      "run_duration_seconds",
+			Help:      "How long circuit run functions took to execute",
+			Buckets:   prometheus.DefBuckets,
+		}, withLabels("circuit")),
+		fallbackCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "circuit",
+			Name:      "fallback_total",
+			Help:      "Count of circuit fallback results",
+		}, withLabels("circuit", "result")),
+		fallbackDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "circuit",
+			Name:      "fallback_duration_seconds",
+			Help:      "How long circuit fallback functions took to execute",
+			Buckets:   prometheus.DefBuckets,
+		}, withLabels("circuit")),
+		isOpen: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "circuit",
+			Name:      "is_open",
+			Help:      "1 if the circuit is open, 0 if it is closed",
+		}, withLabels("circuit")),
+	}
+	var err error
+	if ret.runCount, err = registerCounterVec(registerer, ret.runCount); err != nil {
+		return nil, err
+	}
+	if ret.runDuration, err = registerHistogramVec(registerer, ret.runDuration); err != nil {
+		return nil, err
+	}
+	if ret.fallbackCount, err = registerCounterVec(registerer, ret.fallbackCount); err != nil {
+		return nil, err
+	}
+	if ret.fallbackDuration, err = registerHistogramVec(registerer, ret.fallbackDuration); err != nil {
+		return nil, err
+	}
+	if ret.isOpen, err = registerGaugeVec(registerer, ret.isOpen); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func registerCounterVec(registerer prometheus.Registerer, c *prometheus.CounterVec) (*prometheus.CounterVec, error) {
+	if err := registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func registerHistogramVec(registerer prometheus.Registerer, c *prometheus.HistogramVec) (*prometheus.HistogramVec, error) {
+	if err := registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.HistogramVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func registerGaugeVec(registerer prometheus.Registerer, c *prometheus.GaugeVec) (*prometheus.GaugeVec, error) {
+	if err := registerer.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.GaugeVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// labelValues returns the values for a collector with the given leading labels, followed by the circuit's labels
+func (c *CommandFactory) labelValues(labels map[string]string, values ...string) []string {
+	ret := make([]string, 0, len(values)+len(c.labelNames))
+	ret = append(ret, values...)
+	for _, name := range c.labelNames {
+		ret = append(ret, labels[name])
+	}
+	return ret
+}
+
+// CommandProperties creates prometheus metrics for a circuit
+func (c *CommandFactory) CommandProperties(circuitName string) circuit.Config {
+	run := &RunMetricsCollector{factory: c, circuitName: circuitName}
+	run.SetLabels(nil)
+	fallback := &FallbackMetricsCollector{factory: c, circuitName: circuitName}
+	fallback.SetLabels(nil)
+	circ := &CircuitMetricsCollector{factory: c, circuitName: circuitName}
+	circ.SetLabels(nil)
+	return circuit.Config{
+		Metrics: circuit.MetricsCollectors{
+			Run:      []circuit.RunMetrics{run},
+			Fallback: []circuit.FallbackMetrics{fallback},
+			Circuit:  []circuit.Metrics{circ},
+		},
+	}
+}
+
+// CircuitMetricsCollector collects opened/closed metrics
+type CircuitMetricsCollector struct {
+	factory     *CommandFactory
+	circuitName string
+	isOpen      prometheus.Gauge
+}
+
+// SetLabels points the collector at the metrics for the circuit's labels
+func (c *CircuitMetricsCollector) SetLabels(labels map[string]string) {
+	c.isOpen = c.factory.isOpen.WithLabelValues(c.factory.labelValues(labels, c.circuitName)...)
+}
+
+// Closed sets the is_open gauge to 0
+func (c *CircuitMetricsCollector) Closed(now time.Time) {
+	c.isOpen.Set(0)
+}
+
+// Opened sets the is_open gauge to 1
+func (c *CircuitMetricsCollector) Opened(now time.Time) {
+	c.isOpen.Set(1)
+}
+
+var _ circuit.Metrics = &CircuitMetricsCollector{}
+var _ circuit.LabelSetter = &CircuitMetricsCollector{}
+
+// RunMetricsCollector collects command metrics
+type RunMetricsCollector struct {
+	factory                   *CommandFactory
+	circuitName               string
+	success                   prometheus.Counter
+	errFailure                prometheus.Counter
+	errTimeout                prometheus.Counter
+	errBadRequest             prometheus.Counter
+	errInterrupt              prometheus.Counter
+	errShortCircuit           prometheus.Counter
+	errConcurrencyLimitReject prometheus.Counter
+	duration                  prometheus.Observer
+}
+
+// SetLabels points the collector at the metrics for the circuit's labels
+func (c *RunMetricsCollector) SetLabels(labels map[string]string) {
+	f := c.factory
+	result := func(name string) prometheus.Counter {
+		return f.runCount.WithLabelValues(f.labelValues(labels, c.circuitName, name)...)
+	}
+	c.success = result("success")
+	c.errFailure = result("err_failure")
+	c.errTimeout = result("err_timeout")
+	c.errBadRequest = result("err_bad_request")
+	c.errInterrupt = result("err_interrupt")
+	c.errShortCircuit = result("err_short_circuit")
+	c.errConcurrencyLimitReject = result("err_concurrency_limit_reject")
+	c.duration = f.runDuration.WithLabelValues(f.labelValues(labels, c.circuitName)...)
+}
+
+// Success increments the success counter
+func (c *RunMetricsCollector) Success(now time.Time, duration time.Duration) {
+	c.success.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+// ErrFailure increments the failure counter
+func (c *RunMetricsCollector) ErrFailure(now time.Time, duration time.Duration) {
+	c.errFailure.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+// ErrTimeout increments the timeout counter
+func (c *RunMetricsCollector) ErrTimeout(now time.Time, duration time.Duration) {
+	c.errTimeout.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+// ErrBadRequest increments the bad request counter
+func (c *RunMetricsCollector) ErrBadRequest(now time.Time, duration time.Duration) {
+	c.errBadRequest.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+// ErrInterrupt increments the interrupt counter
+func (c *RunMetricsCollector) ErrInterrupt(now time.Time, duration time.Duration) {
+	c.errInterrupt.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+// ErrShortCircuit increments the short circuit counter
+func (c *RunMetricsCollector) ErrShortCircuit(now time.Time) {
+	c.errShortCircuit.Inc()
+}
+
+// ErrConcurrencyLimitReject increments the concurrency limit counter
+func (c *RunMetricsCollector) ErrConcurrencyLimitReject(now time.Time) {
+	c.errConcurrencyLimitReject.Inc()
+}
+
+var _ circuit.RunMetrics = &RunMetricsCollector{}
+var _ circuit.LabelSetter = &RunMetricsCollector{}
+
+// FallbackMetricsCollector collects fallback metrics
+type FallbackMetricsCollector struct {
+	factory                   *CommandFactory
+	circuitName               string
+	success                   prometheus.Counter
+	errFailure                prometheus.Counter
+	errConcurrencyLimitReject prometheus.Counter
+	duration                  prometheus.Observer
+}
+
+// SetLabels points the collector at the metrics for the circuit's labels
+func (c *FallbackMetricsCollector) SetLabels(labels map[string]string) {
+	f := c.factory
+	result := func(name string) prometheus.Counter {
+		return f.fallbackCount.WithLabelValues(f.labelValues(labels, c.circuitName, name)...)
+	}
+	c.success = result("success")
+	c.errFailure = result("err_failure")
+	c.errConcurrencyLimitReject = result("err_concurrency_limit_reject")
+	c.duration = f.fallbackDuration.WithLabelValues(f.labelValues(labels, c.circuitName)...)
+}
+
+// Success increments the success counter
+func (c *FallbackMetricsCollector) Success(now time.Time, duration time.Duration) {
+	c.success.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+// ErrConcurrencyLimitReject increments the concurrency limit counter
+func (c *FallbackMetricsCollector) ErrConcurrencyLimitReject(now time.Time) {
+	c.errConcurrencyLimitReject.Inc()
+}
+
+// ErrFailure increments the failure counter
+func (c *FallbackMetricsCollector) ErrFailure(now time.Time, duration time.Duration) {
+	c.errFailure.Inc()
+	c.duration.Observe(duration.Seconds())
+}
+
+var _ circuit.FallbackMetrics = &FallbackMetricsCollector{}
+var _ circuit.LabelSetter = &FallbackMetricsCollector{}
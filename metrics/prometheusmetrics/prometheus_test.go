@@ -0,0 +1,92 @@
+package prometheusmetrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/testhelp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// histogramCount returns the number of observations of the histogram named name for circuitName
+func histogramCount(t *testing.T, g prometheus.Gatherer, name string, circuitName string) uint64 {
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal("expected to gather metrics", err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "circuit" && label.GetValue() == circuitName {
+					return m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestCommandFactory_CommandProperties(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	f, err := NewCommandFactory(registry, "test")
+	if err != nil {
+		t.Fatal("expected to register with a new registry", err)
+	}
+	config := f.CommandProperties("hello-world")
+	config.Execution.Timeout = time.Millisecond
+	c := circuit.NewCircuitFromConfig("hello-world", config)
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, nil))
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysFails, testhelp.AlwaysPassesFallback))
+	c.OpenCircuit()
+
+	for result, expected := range map[string]float64{
+		"success":     2,
+		"err_failure": 2,
+		"err_timeout": 1,
+	} {
+		if v := testutil.ToFloat64(f.runCount.WithLabelValues("hello-world", result)); v != expected {
+			t.Errorf("expected run_total %s to be %f, saw %f", result, expected, v)
+		}
+	}
+	if v := testutil.ToFloat64(f.fallbackCount.WithLabelValues("hello-world", "success")); v != 1 {
+		t.Errorf("expected one fallback success, saw %f", v)
+	}
+	if v := testutil.ToFloat64(f.isOpen.WithLabelValues("hello-world")); v != 1 {
+		t.Errorf("expected the circuit to be open, saw %f", v)
+	}
+	if n := histogramCount(t, registry, "test_circuit_run_duration_seconds", "hello-world"); n != 5 {
+		t.Errorf("expected a run duration for every run, saw %d", n)
+	}
+	if n := histogramCount(t, registry, "test_circuit_fallback_duration_seconds", "hello-world"); n != 1 {
+		t.Errorf("expected a fallback duration for every fallback, saw %d", n)
+	}
+}
+
+func TestNewCommandFactory_Reregister(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	first, err := NewCommandFactory(registry, "test")
+	if err != nil {
+		t.Fatal("expected to register with a new registry", err)
+	}
+	second, err := NewCommandFactory(registry, "test")
+	if err != nil {
+		t.Fatal("expected registering twice to reuse the collectors", err)
+	}
+	first.runCount.WithLabelValues("shared", "success").Inc()
+	if v := testutil.ToFloat64(second.runCount.WithLabelValues("shared", "success")); v != 1 {
+		t.Errorf("expected both factories to share collectors, saw %f", v)
+	}
+}
@@ -0,0 +1,5 @@
+/*
+Package recorder contains a MetricsRecorder that writes every metric event of a circuit to an io.Writer as JSON
+lines, for offline analysis during a postmortem.
+*/
+package recorder
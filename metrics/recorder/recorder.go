@@ -0,0 +1,259 @@
+package recorder
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/faststats"
+)
+
+// Event kinds, written as Event.Kind
+const (
+	// KindRun events come from RunMetrics
+	KindRun = "run"
+	// KindFallback events come from FallbackMetrics
+	KindFallback = "fallback"
+	// KindCircuit events come from Metrics: the circuit opening or closing
+	KindCircuit = "circuit"
+)
+
+// Event is one line written by a MetricsRecorder
+type Event struct {
+	Time    time.Time `json:"time"`
+	Circuit string    `json:"circuit"`
+	// Kind is KindRun, KindFallback, or KindCircuit
+	Kind string `json:"kind"`
+	// Event is the circuit.Outcome name of a run event, like "success" or "short_circuit".  Fallback events are
+	// "success", "failure", or "concurrency_limit_reject", and circuit events are "opened" or "closed".
+	Event string `json:"event"`
+	// Duration is how long runFunc or the fallback ran, in nanoseconds.  It is left out for events that never ran.
+	Duration time.Duration `json:"duration,omitempty"`
+}
+
+// defaultBufferSize is how many events a MetricsRecorder holds for its writer if NewMetricsRecorder is given 0
+const defaultBufferSize = 1024
+
+// MetricsRecorder writes a circuit's metric events to an io.Writer, one JSON encoded Event per line, while it is
+// started.  Events are only queued on the hot path: a single goroutine writes them, so a slow writer never blocks
+// the circuit.  Events arriving while the queue is full are dropped and counted by Dropped.
+//
+// Attach it to a circuit that is already running with Attach, then Start it during an incident and Stop it once
+// enough has been recorded.  Opened and Closed are only seen if the recorder was also in Metrics.Circuit when the
+// circuit was created: see CommandProperties.
+type MetricsRecorder struct {
+	circuitName string
+	out         io.Writer
+	events      chan Event
+	recording   faststats.AtomicBoolean
+	dropped     faststats.AtomicInt64
+
+	// mu guards the writing goroutine's lifetime and err
+	mu   sync.Mutex
+	stop chan struct{}
+	done chan struct{}
+	err  error
+}
+
+var _ circuit.Metrics = &MetricsRecorder{}
+
+// NewMetricsRecorder creates a stopped recorder for circuitName that writes to out.  bufferSize is how many events
+// can wait for out before new ones are dropped.  Zero, or less, uses a default of 1024.
+func NewMetricsRecorder(circuitName string, out io.Writer, bufferSize int) *MetricsRecorder {
+	if bufferSize <= 0 {
+		bufferSize = defaultBufferSize
+	}
+	return &MetricsRecorder{
+		circuitName: circuitName,
+		out:         out,
+		events:      make(chan Event, bufferSize),
+	}
+}
+
+// CommandProperties returns a config that sends the recorder every run, fallback, and circuit event.  Use it when
+// creating the circuit; the recorder still writes nothing until it is started.
+func (r *MetricsRecorder) CommandProperties() circuit.Config {
+	return circuit.Config{
+		Metrics: circuit.MetricsCollectors{
+			Run:      []circuit.RunMetrics{r.RunMetrics()},
+			Fallback: []circuit.FallbackMetrics{r.FallbackMetrics()},
+			Circuit:  []circuit.Metrics{r},
+		},
+	}
+}
+
+// Attach appends the recorder's run and fallback collectors to a circuit that is already in use
+func (r *MetricsRecorder) Attach(c *circuit.Circuit) {
+	c.AppendRunMetrics(r.RunMetrics())
+	c.AppendFallbackMetrics(r.FallbackMetrics())
+}
+
+// RunMetrics returns a collector that records run events
+func (r *MetricsRecorder) RunMetrics() circuit.RunMetrics {
+	return &runRecorder{r: r}
+}
+
+// FallbackMetrics returns a collector that records fallback events
+func (r *MetricsRecorder) FallbackMetrics() circuit.FallbackMetrics {
+	return &fallbackRecorder{r: r}
+}
+
+// Start begins writing events.  Events queued while the recorder was stopped are discarded, so the output only holds
+// what happened after Start.  Starting a recorder that is already started does nothing.
+func (r *MetricsRecorder) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop != nil {
+		return
+	}
+	r.discardQueued()
+	r.err = nil
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.write(r.stop, r.done)
+	r.recording.Set(true)
+}
+
+// Stop stops recording new events, waits for every queued event to be written, and returns the first error out
+// returned while the recorder was started.  Stopping a recorder that is not started returns nil.
+func (r *MetricsRecorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stop == nil {
+		return nil
+	}
+	r.recording.Set(false)
+	close(r.stop)
+	<-r.done
+	r.stop = nil
+	r.done = nil
+	return r.err
+}
+
+// Recording is true between Start and Stop
+func (r *MetricsRecorder) Recording() bool {
+	return r.recording.Get()
+}
+
+// Dropped returns how many events were not recorded because the queue was full
+func (r *MetricsRecorder) Dropped() int64 {
+	return r.dropped.Get()
+}
+
+// Opened records the circuit opening
+func (r *MetricsRecorder) Opened(now time.Time) {
+	r.record(now, KindCircuit, "opened", 0)
+}
+
+// Closed records the circuit closing
+func (r *MetricsRecorder) Closed(now time.Time) {
+	r.record(now, KindCircuit, "closed", 0)
+}
+
+// record queues an event without blocking, if the recorder is started
+func (r *MetricsRecorder) record(now time.Time, kind string, event string, duration time.Duration) {
+	if !r.recording.Get() {
+		return
+	}
+	select {
+	case r.events <- Event{Time: now, Circuit: r.circuitName, Kind: kind, Event: event, Duration: duration}:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// write encodes queued events until stop is closed, then writes whatever is still queued and closes done
+func (r *MetricsRecorder) write(stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	enc := json.NewEncoder(r.out)
+	for {
+		select {
+		case e := <-r.events:
+			r.encode(enc, e)
+		case <-stop:
+			for {
+				select {
+				case e := <-r.events:
+					r.encode(enc, e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// encode writes one event, remembering the first error.  r.err is only read by Stop once write has returned.
+func (r *MetricsRecorder) encode(enc *json.Encoder, e Event) {
+	if err := enc.Encode(e); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// discardQueued drops events left over from before the last Stop
+func (r *MetricsRecorder) discardQueued() {
+	for {
+		select {
+		case <-r.events:
+		default:
+			return
+		}
+	}
+}
+
+// runRecorder records run events.  It is separate from MetricsRecorder because RunMetrics and FallbackMetrics share
+// method names.
+type runRecorder struct {
+	r *MetricsRecorder
+}
+
+var _ circuit.RunMetrics = &runRecorder{}
+
+func (m *runRecorder) Success(now time.Time, duration time.Duration) {
+	m.r.record(now, KindRun, circuit.OutcomeSuccess.String(), duration)
+}
+
+func (m *runRecorder) ErrFailure(now time.Time, duration time.Duration) {
+	m.r.record(now, KindRun, circuit.OutcomeFailure.String(), duration)
+}
+
+func (m *runRecorder) ErrTimeout(now time.Time, duration time.Duration) {
+	m.r.record(now, KindRun, circuit.OutcomeTimeout.String(), duration)
+}
+
+func (m *runRecorder) ErrBadRequest(now time.Time, duration time.Duration) {
+	m.r.record(now, KindRun, circuit.OutcomeBadRequest.String(), duration)
+}
+
+func (m *runRecorder) ErrInterrupt(now time.Time, duration time.Duration) {
+	m.r.record(now, KindRun, circuit.OutcomeInterrupt.String(), duration)
+}
+
+func (m *runRecorder) ErrConcurrencyLimitReject(now time.Time) {
+	m.r.record(now, KindRun, circuit.OutcomeConcurrencyLimitReject.String(), 0)
+}
+
+func (m *runRecorder) ErrShortCircuit(now time.Time) {
+	m.r.record(now, KindRun, circuit.OutcomeShortCircuit.String(), 0)
+}
+
+// fallbackRecorder records fallback events
+type fallbackRecorder struct {
+	r *MetricsRecorder
+}
+
+var _ circuit.FallbackMetrics = &fallbackRecorder{}
+
+func (m *fallbackRecorder) Success(now time.Time, duration time.Duration) {
+	m.r.record(now, KindFallback, "success", duration)
+}
+
+func (m *fallbackRecorder) ErrFailure(now time.Time, duration time.Duration) {
+	m.r.record(now, KindFallback, "failure", duration)
+}
+
+func (m *fallbackRecorder) ErrConcurrencyLimitReject(now time.Time) {
+	m.r.record(now, KindFallback, "concurrency_limit_reject", 0)
+}
@@ -0,0 +1,109 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/clock"
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+func decodeEvents(t *testing.T, buf *bytes.Buffer) []Event {
+	var ret []Event
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var e Event
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal("expected every line to be an Event", err)
+		}
+		ret = append(ret, e)
+	}
+	return ret
+}
+
+func TestMetricsRecorder(t *testing.T) {
+	clk := &clock.MockClock{}
+	now := time.Unix(1500000000, 0).UTC()
+	clk.Set(now)
+	var buf bytes.Buffer
+	r := NewMetricsRecorder("TestMetricsRecorder", &buf, 0)
+	cfg := r.CommandProperties()
+	cfg.General.TimeKeeper = circuit.TimeKeeper{
+		Now:       clk.Now,
+		AfterFunc: clk.AfterFunc,
+	}
+	c := circuit.NewCircuitFromConfig("TestMetricsRecorder", cfg)
+	ctx := context.Background()
+	// Nothing is written before Start
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+
+	r.Start()
+	if !r.Recording() {
+		t.Fatal("expected the recorder to record once started")
+	}
+	takes := func(d time.Duration, err error) func(context.Context) error {
+		return func(_ context.Context) error {
+			clk.Add(d)
+			return err
+		}
+	}
+	testhelp.MustTesting(t, c.Execute(ctx, takes(time.Millisecond, nil), nil))
+	testhelp.MustTesting(t, c.Execute(ctx, takes(2*time.Millisecond, errors.New("bad")), func(_ context.Context, _ error) error {
+		clk.Add(3 * time.Millisecond)
+		return nil
+	}))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if err := r.Stop(); err != nil {
+		t.Fatal("expected no write errors", err)
+	}
+	// Nothing is written after Stop either
+	c.CloseCircuit()
+
+	name := "TestMetricsRecorder"
+	expected := []Event{
+		{Time: now.Add(time.Millisecond), Circuit: name, Kind: KindRun, Event: "success", Duration: time.Millisecond},
+		{Time: now.Add(3 * time.Millisecond), Circuit: name, Kind: KindRun, Event: "failure", Duration: 2 * time.Millisecond},
+		{Time: now.Add(3 * time.Millisecond), Circuit: name, Kind: KindFallback, Event: "success", Duration: 3 * time.Millisecond},
+		{Time: now.Add(6 * time.Millisecond), Circuit: name, Kind: KindCircuit, Event: "opened"},
+		{Time: now.Add(6 * time.Millisecond), Circuit: name, Kind: KindRun, Event: "short_circuit"},
+	}
+	if events := decodeEvents(t, &buf); !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected the written lines to be the events that occurred\nsaw:  %+v\nwant: %+v", events, expected)
+	}
+	if r.Dropped() != 0 {
+		t.Error("expected no dropped events", r.Dropped())
+	}
+}
+
+func TestMetricsRecorder_Attach(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewMetricsRecorder("TestMetricsRecorder_Attach", &buf, 1)
+	c := circuit.NewCircuitFromConfig("TestMetricsRecorder_Attach", circuit.Config{})
+	r.Attach(c)
+	r.Start()
+	// The second event cannot fit in the queue if the writer has not caught up, so it may be dropped
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	if err := r.Stop(); err != nil {
+		t.Fatal("expected no write errors", err)
+	}
+	events := decodeEvents(t, &buf)
+	if int64(len(events))+r.Dropped() != 2 {
+		t.Fatalf("expected every event to be written or dropped: %d written and %d dropped", len(events), r.Dropped())
+	}
+	for _, e := range events {
+		if e.Kind != KindRun || e.Event != "success" || e.Circuit != "TestMetricsRecorder_Attach" {
+			t.Errorf("expected only successful runs, saw %+v", e)
+		}
+	}
+	if r.Stop() != nil || r.Recording() {
+		t.Error("expected stopping twice to do nothing")
+	}
+}
@@ -0,0 +1,160 @@
+package rolling
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/faststats"
+)
+
+// RejectionAlarm is run metrics that watch a circuit's concurrency limit rejections, which usually mean it is under
+// provisioned.  It calls OnChange with true once the rolling window holds Threshold rejections, and with false once it
+// holds fewer again.  Recovery is noticed on the circuit's next call, so a circuit with no traffic stays alarming.
+type RejectionAlarm struct {
+	rejections faststats.RollingCounter
+	alarming   faststats.AtomicBoolean
+
+	name string
+	// mu protects lastChange, and orders calls to OnChange
+	mu         sync.Mutex
+	lastChange time.Time
+	config     RejectionAlarmConfig
+}
+
+// RejectionAlarmConfig configures a RejectionAlarm
+type RejectionAlarmConfig struct {
+	// Threshold is how many concurrency limit rejections within RollingDuration raise the alarm.  If it is zero, the
+	// alarm is never raised.
+	Threshold int64
+	// RollingDuration is how long rejections are counted
+	RollingDuration time.Duration
+	// NumBuckets is how many buckets RollingDuration is split into
+	NumBuckets int
+	// Debounce is the least time between two calls to OnChange, so a rejection count hovering around Threshold does
+	// not flood alerts.  A change during Debounce is reported at the first call after it, if it still holds.
+	Debounce time.Duration
+	// OnChange is called when the alarm is raised or cleared, with the rejections in the rolling window
+	OnChange func(circuitName string, alarming bool, rejections int64) `json:"-"`
+	// Now should simulate time.Now
+	Now func() time.Time `json:"-"`
+}
+
+// Merge this config with another
+func (r *RejectionAlarmConfig) Merge(other RejectionAlarmConfig) {
+	if r.Threshold == 0 {
+		r.Threshold = other.Threshold
+	}
+	if r.RollingDuration == 0 {
+		r.RollingDuration = other.RollingDuration
+	}
+	if r.NumBuckets == 0 {
+		r.NumBuckets = other.NumBuckets
+	}
+	if r.Debounce == 0 {
+		r.Debounce = other.Debounce
+	}
+	if r.OnChange == nil {
+		r.OnChange = other.OnChange
+	}
+	if r.Now == nil {
+		r.Now = other.Now
+	}
+}
+
+var defaultRejectionAlarmConfig = RejectionAlarmConfig{
+	RollingDuration: 10 * time.Second,
+	NumBuckets:      10,
+	Debounce:        time.Second,
+	Now:             time.Now,
+}
+
+// Metrics creates a RejectionAlarm for a circuit.  Use it as a circuit.Manager's DefaultMetrics to watch every circuit.
+func (r RejectionAlarmConfig) Metrics(circuitName string) circuit.MetricsCollectors {
+	return circuit.MetricsCollectors{
+		Run: []circuit.RunMetrics{NewRejectionAlarm(circuitName, r)},
+	}
+}
+
+// NewRejectionAlarm creates a RejectionAlarm for a circuit
+func NewRejectionAlarm(circuitName string, config RejectionAlarmConfig) *RejectionAlarm {
+	config.Merge(defaultRejectionAlarmConfig)
+	bucketWidth := faststats.BucketWidth(config.RollingDuration, config.NumBuckets)
+	return &RejectionAlarm{
+		rejections: faststats.NewRollingCounter(bucketWidth, config.NumBuckets, config.Now()),
+		name:       circuitName,
+		config:     config,
+	}
+}
+
+var _ circuit.RunMetrics = &RejectionAlarm{}
+
+// Alarming is true if the alarm was last raised, and not yet cleared
+func (r *RejectionAlarm) Alarming() bool {
+	return r.alarming.Get()
+}
+
+// check raises or clears the alarm.  Nothing else changes the alarm, so alarming only changes under mu.
+func (r *RejectionAlarm) check(now time.Time) {
+	if r.config.Threshold <= 0 {
+		return
+	}
+	rejections := r.rejections.RollingSumAt(now)
+	alarming := rejections >= r.config.Threshold
+	if alarming == r.alarming.Get() {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if alarming == r.alarming.Get() || (!r.lastChange.IsZero() && now.Sub(r.lastChange) < r.config.Debounce) {
+		return
+	}
+	r.alarming.Set(alarming)
+	r.lastChange = now
+	if r.config.OnChange != nil {
+		r.config.OnChange(r.name, alarming, rejections)
+	}
+}
+
+// clearCheck is check, only needed while the alarm might clear
+func (r *RejectionAlarm) clearCheck(now time.Time) {
+	if r.alarming.Get() {
+		r.check(now)
+	}
+}
+
+// ErrConcurrencyLimitReject counts a rejection
+func (r *RejectionAlarm) ErrConcurrencyLimitReject(now time.Time) {
+	r.rejections.Inc(now)
+	r.check(now)
+}
+
+// Success may clear the alarm
+func (r *RejectionAlarm) Success(now time.Time, duration time.Duration) {
+	r.clearCheck(now)
+}
+
+// ErrFailure may clear the alarm
+func (r *RejectionAlarm) ErrFailure(now time.Time, duration time.Duration) {
+	r.clearCheck(now)
+}
+
+// ErrTimeout may clear the alarm
+func (r *RejectionAlarm) ErrTimeout(now time.Time, duration time.Duration) {
+	r.clearCheck(now)
+}
+
+// ErrBadRequest may clear the alarm
+func (r *RejectionAlarm) ErrBadRequest(now time.Time, duration time.Duration) {
+	r.clearCheck(now)
+}
+
+// ErrInterrupt may clear the alarm
+func (r *RejectionAlarm) ErrInterrupt(now time.Time, duration time.Duration) {
+	r.clearCheck(now)
+}
+
+// ErrShortCircuit may clear the alarm
+func (r *RejectionAlarm) ErrShortCircuit(now time.Time) {
+	r.clearCheck(now)
+}
@@ -99,6 +99,11 @@ type RunStats struct {
 	// It is analogous to https://github.com/Netflix/Hystrix/wiki/Metrics-and-Monitoring#latency-percentiles-hystrixcommandrun-execution-gauge
 	Latencies faststats.RollingPercentile
 
+	// previousErrors and previousAttempts count errors and legitimate attempts over two rolling windows, so the
+	// older half of their buckets is the window just before the current one
+	previousErrors   faststats.RollingCounter
+	previousAttempts faststats.RollingCounter
+
 	mu     sync.Mutex
 	config RunStatsConfig
 }
@@ -134,6 +139,18 @@ type RunStatsConfig struct {
 	RollingPercentileNumBuckets int
 	// RollingPercentileBucketSize is https://github.com/Netflix/Hystrix/wiki/Configuration#metricsrollingpercentilebucketsize
 	RollingPercentileBucketSize int
+	// RollingPercentileSampleRate records only 1 in every RollingPercentileSampleRate latencies.  See
+	// faststats.RollingPercentile.SetSampleRate.  Zero records every latency.
+	RollingPercentileSampleRate int64
+	// RollingPercentileReservoir keeps a uniform random sample of each percentile bucket's latencies once it is full,
+	// instead of the most recent ones.  See faststats.RollingPercentile.SetReservoirSampling.
+	RollingPercentileReservoir bool
+	// RollingPercentileRand, if set, picks which latencies RollingPercentileReservoir keeps.  Use a seeded source, like
+	// circuit.NewSeededRand, to make sampling repeat between runs.  See faststats.RollingPercentile.SetRand.
+	RollingPercentileRand func(n int64) int64
+	// RollingPercentileCacheDuration, if set, is how long a sorted snapshot of the latencies is reused by readers, so
+	// frequent scrapes do not sort them every time.  See faststats.RollingPercentile.SetCacheDuration.
+	RollingPercentileCacheDuration time.Duration
 }
 
 // Merge this config with another
@@ -156,6 +173,30 @@ func (r *RunStatsConfig) Merge(other RunStatsConfig) {
 	if r.RollingPercentileBucketSize == 0 {
 		r.RollingPercentileBucketSize = other.RollingPercentileBucketSize
 	}
+	if r.RollingPercentileSampleRate == 0 {
+		r.RollingPercentileSampleRate = other.RollingPercentileSampleRate
+	}
+	if !r.RollingPercentileReservoir {
+		r.RollingPercentileReservoir = other.RollingPercentileReservoir
+	}
+	if r.RollingPercentileRand == nil {
+		r.RollingPercentileRand = other.RollingPercentileRand
+	}
+	if r.RollingPercentileCacheDuration == 0 {
+		r.RollingPercentileCacheDuration = other.RollingPercentileCacheDuration
+	}
+}
+
+// RollingStatsBucketWidth is how wide each of the RollingStatsNumBuckets buckets is.  See faststats.BucketWidth for how
+// RollingStatsDuration is rounded when it does not divide evenly.
+func (r *RunStatsConfig) RollingStatsBucketWidth() time.Duration {
+	return faststats.BucketWidth(r.RollingStatsDuration, r.RollingStatsNumBuckets)
+}
+
+// RollingStatsWindow is how much time the buckets really cover: RollingStatsNumBuckets * RollingStatsBucketWidth.  It
+// only differs from RollingStatsDuration if RollingStatsDuration does not divide evenly.
+func (r *RunStatsConfig) RollingStatsWindow() time.Duration {
+	return r.RollingStatsBucketWidth() * time.Duration(r.RollingStatsNumBuckets)
 }
 
 var defaultRunStatsConfig = RunStatsConfig{
@@ -174,15 +215,22 @@ func (r *RunStats) Config() RunStatsConfig {
 	return r.config
 }
 
+// CloneMetrics returns new, empty RunStats with the same configuration
+func (r *RunStats) CloneMetrics(_ string) interface{} {
+	ret := &RunStats{}
+	ret.SetConfigNotThreadSafe(r.Config())
+	return ret
+}
+
 // SetConfigNotThreadSafe updates the RunStats buckets
 func (r *RunStats) SetConfigNotThreadSafe(config RunStatsConfig) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	r.config = config
 	now := config.Now()
-	bucketWidth := time.Duration(config.RollingStatsDuration.Nanoseconds() / int64(config.RollingStatsNumBuckets))
+	bucketWidth := config.RollingStatsBucketWidth()
 	numBuckets := config.RollingStatsNumBuckets
-	rollingPercentileBucketWidth := time.Duration(config.RollingPercentileDuration.Nanoseconds() / int64(config.RollingPercentileNumBuckets))
+	rollingPercentileBucketWidth := faststats.BucketWidth(config.RollingPercentileDuration, config.RollingPercentileNumBuckets)
 	rollingPercentileNumBuckets := config.RollingPercentileNumBuckets
 	rollingPercentileBucketSize := config.RollingPercentileBucketSize
 
@@ -193,12 +241,19 @@ func (r *RunStats) SetConfigNotThreadSafe(config RunStatsConfig) {
 	r.ErrTimeouts = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
 	r.ErrBadRequests = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
 	r.ErrInterrupts = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
+	r.previousErrors = faststats.NewRollingCounter(bucketWidth, numBuckets*2, now)
+	r.previousAttempts = faststats.NewRollingCounter(bucketWidth, numBuckets*2, now)
 	r.Latencies = faststats.NewRollingPercentile(rollingPercentileBucketWidth, rollingPercentileNumBuckets, rollingPercentileBucketSize, now)
+	r.Latencies.SetSampleRate(config.RollingPercentileSampleRate)
+	r.Latencies.SetReservoirSampling(config.RollingPercentileReservoir)
+	r.Latencies.SetRand(config.RollingPercentileRand)
+	r.Latencies.SetCacheDuration(config.RollingPercentileCacheDuration)
 }
 
 // Success increments the Successes bucket
 func (r *RunStats) Success(now time.Time, duration time.Duration) {
 	r.Successes.Inc(now)
+	r.previousAttempts.Inc(now)
 	r.Latencies.AddDuration(duration, now)
 }
 
@@ -216,6 +271,8 @@ func (r *RunStats) ErrConcurrencyLimitReject(now time.Time) {
 // ErrFailure increments the ErrFailure bucket
 func (r *RunStats) ErrFailure(now time.Time, duration time.Duration) {
 	r.ErrFailures.Inc(now)
+	r.previousErrors.Inc(now)
+	r.previousAttempts.Inc(now)
 	r.Latencies.AddDuration(duration, now)
 }
 
@@ -227,6 +284,8 @@ func (r *RunStats) ErrShortCircuit(now time.Time) {
 // ErrTimeout increments the ErrTimeout bucket
 func (r *RunStats) ErrTimeout(now time.Time, duration time.Duration) {
 	r.ErrTimeouts.Inc(now)
+	r.previousErrors.Inc(now)
+	r.previousAttempts.Inc(now)
 	r.Latencies.AddDuration(duration, now)
 }
 
@@ -261,11 +320,50 @@ func (r *RunStats) ErrorPercentageAt(now time.Time) float64 {
 	return float64(errCount) / float64(attemptCount)
 }
 
+// PreviousErrorPercentage is ErrorPercentage for the rolling window just before the current one
+func (r *RunStats) PreviousErrorPercentage() float64 {
+	return r.PreviousErrorPercentageAt(time.Now())
+}
+
+// PreviousErrorPercentageAt is [0.0 - 1.0] errors/legitimate for the rolling window that ended where the current one,
+// at now, begins.  Buckets roll out of the current window and into the previous one together, so comparing it to
+// ErrorPercentageAt(now) gives how the error rate changed from one window to the next.
+func (r *RunStats) PreviousErrorPercentageAt(now time.Time) float64 {
+	attemptCount := r.previousWindowSum(&r.previousAttempts, now)
+	if attemptCount == 0 {
+		return 0
+	}
+	return float64(r.previousWindowSum(&r.previousErrors, now)) / float64(attemptCount)
+}
+
+// previousWindowSum adds up the older half of a counter that covers two rolling windows
+func (r *RunStats) previousWindowSum(counter *faststats.RollingCounter, now time.Time) int64 {
+	buckets := counter.GetBuckets(now)
+	var sum int64
+	for _, b := range buckets[len(buckets)/2:] {
+		sum += b
+	}
+	return sum
+}
+
+var _ circuit.RunHealth = &RunStats{}
+var _ circuit.PreviousRunHealth = &RunStats{}
+var _ circuit.MetricsCloner = &RunStats{}
+
 // FallbackStats tracks fallback metrics in rolling buckets
 type FallbackStats struct {
 	Successes                  faststats.RollingCounter
 	ErrConcurrencyLimitRejects faststats.RollingCounter
 	ErrFailures                faststats.RollingCounter
+	Skips                      faststats.RollingCounter
+	// ShortCircuitSuccesses and ShortCircuitFailures are the part of Successes and ErrFailures for requests the circuit
+	// rejected without calling runFunc
+	ShortCircuitSuccesses faststats.RollingCounter
+	ShortCircuitFailures  faststats.RollingCounter
+	// NestedShortCircuits is the part of ErrFailures where the fallback failed because a circuit it called was open
+	NestedShortCircuits faststats.RollingCounter
+
+	config FallbackStatsConfig
 }
 
 // Var allows FallbackStats on expvar
@@ -275,6 +373,10 @@ func (r *FallbackStats) Var() expvar.Var {
 			"Successes":                  r.Successes.TotalSum(),
 			"ErrConcurrencyLimitRejects": r.ErrConcurrencyLimitRejects.TotalSum(),
 			"ErrFailures":                r.ErrFailures.TotalSum(),
+			"Skips":                      r.Skips.TotalSum(),
+			"ShortCircuitSuccesses":      r.ShortCircuitSuccesses.TotalSum(),
+			"ShortCircuitFailures":       r.ShortCircuitFailures.TotalSum(),
+			"NestedShortCircuits":        r.NestedShortCircuits.TotalSum(),
 		}
 	})
 }
@@ -294,6 +396,26 @@ func (r *FallbackStats) ErrFailure(now time.Time, duration time.Duration) {
 	r.ErrFailures.Inc(now)
 }
 
+// Skipped increments the Skips bucket
+func (r *FallbackStats) Skipped(now time.Time) {
+	r.Skips.Inc(now)
+}
+
+// ShortCircuitSuccess increments the ShortCircuitSuccesses bucket
+func (r *FallbackStats) ShortCircuitSuccess(now time.Time, duration time.Duration) {
+	r.ShortCircuitSuccesses.Inc(now)
+}
+
+// ShortCircuitFailure increments the ShortCircuitFailures bucket
+func (r *FallbackStats) ShortCircuitFailure(now time.Time, duration time.Duration) {
+	r.ShortCircuitFailures.Inc(now)
+}
+
+// ErrNestedShortCircuit increments the NestedShortCircuits bucket
+func (r *FallbackStats) ErrNestedShortCircuit(now time.Time, duration time.Duration) {
+	r.NestedShortCircuits.Inc(now)
+}
+
 // FallbackStatsConfig configures how to track fallback stats
 type FallbackStatsConfig struct {
 	// Rolling Stats size is https://github.com/Netflix/Hystrix/wiki/Configuration#metricsrollingstatstimeinmilliseconds
@@ -317,6 +439,18 @@ func (r *FallbackStatsConfig) Merge(other FallbackStatsConfig) {
 	}
 }
 
+// RollingStatsBucketWidth is how wide each of the RollingStatsNumBuckets buckets is.  See faststats.BucketWidth for how
+// RollingStatsDuration is rounded when it does not divide evenly.
+func (r *FallbackStatsConfig) RollingStatsBucketWidth() time.Duration {
+	return faststats.BucketWidth(r.RollingStatsDuration, r.RollingStatsNumBuckets)
+}
+
+// RollingStatsWindow is how much time the buckets really cover: RollingStatsNumBuckets * RollingStatsBucketWidth.  It
+// only differs from RollingStatsDuration if RollingStatsDuration does not divide evenly.
+func (r *FallbackStatsConfig) RollingStatsWindow() time.Duration {
+	return r.RollingStatsBucketWidth() * time.Duration(r.RollingStatsNumBuckets)
+}
+
 var defaultFallbackStatsConfig = FallbackStatsConfig{
 	Now:                    time.Now,
 	RollingStatsDuration:   10 * time.Second,
@@ -324,14 +458,30 @@ var defaultFallbackStatsConfig = FallbackStatsConfig{
 }
 
 var _ circuit.FallbackMetrics = &FallbackStats{}
+var _ circuit.FallbackSkippedMetrics = &FallbackStats{}
+var _ circuit.ShortCircuitFallbackMetrics = &FallbackStats{}
+var _ circuit.NestedCircuitFallbackMetrics = &FallbackStats{}
+var _ circuit.MetricsCloner = &FallbackStats{}
+
+// CloneMetrics returns new, empty FallbackStats with the same configuration
+func (r *FallbackStats) CloneMetrics(_ string) interface{} {
+	ret := &FallbackStats{}
+	ret.SetConfigNotThreadSafe(r.config)
+	return ret
+}
 
 // SetConfigNotThreadSafe sets the configuration for fallback stats
 func (r *FallbackStats) SetConfigNotThreadSafe(config FallbackStatsConfig) {
+	r.config = config
 	now := config.Now()
-	bucketWidth := time.Duration(config.RollingStatsDuration.Nanoseconds() / int64(config.RollingStatsNumBuckets))
+	bucketWidth := config.RollingStatsBucketWidth()
 	numBuckets := config.RollingStatsNumBuckets
 
 	r.Successes = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
 	r.ErrConcurrencyLimitRejects = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
 	r.ErrFailures = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
+	r.Skips = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
+	r.ShortCircuitSuccesses = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
+	r.ShortCircuitFailures = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
+	r.NestedShortCircuits = faststats.NewRollingCounter(bucketWidth, numBuckets, now)
 }
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/clock"
 	"github.com/cep21/circuit/internal/testhelp"
 )
 
@@ -86,6 +87,83 @@ func TestFallbackCircuit(t *testing.T) {
 	}
 }
 
+func TestFallbackNestedShortCircuit(t *testing.T) {
+	s := StatFactory{}
+	outer := circuit.NewCircuitFromConfig("TestFallbackNestedShortCircuit", s.CreateConfig(""))
+	inner := circuit.NewCircuitFromConfig("TestFallbackNestedShortCircuit-inner", s.CreateConfig(""))
+	fallbackMetrics := FindFallbackMetrics(outer)
+	innerFallback := func(ctx context.Context, _ error) error {
+		return inner.Execute(ctx, testhelp.AlwaysPasses, nil)
+	}
+	ctx := context.Background()
+
+	testhelp.MustNotTesting(t, outer.Execute(ctx, testhelp.AlwaysFails, testhelp.AlwaysFailsFallback))
+	if fallbackMetrics.NestedShortCircuits.TotalSum() != 0 {
+		t.Error("ordinary fallback failures are not nested short circuits")
+	}
+	// The outer circuit being open is not nested, even if the fallback returns its error
+	outer.OpenCircuit()
+	testhelp.MustNotTesting(t, outer.Execute(ctx, testhelp.AlwaysPasses, func(_ context.Context, err error) error {
+		return err
+	}))
+	if fallbackMetrics.NestedShortCircuits.TotalSum() != 0 {
+		t.Error("the circuit's own rejection is not a nested short circuit")
+	}
+	outer.CloseCircuit()
+
+	inner.OpenCircuit()
+	testhelp.MustNotTesting(t, outer.Execute(ctx, testhelp.AlwaysFails, innerFallback))
+	if fallbackMetrics.NestedShortCircuits.TotalSum() != 1 || fallbackMetrics.ErrFailures.TotalSum() != 3 {
+		t.Error("expected the open inner circuit to count as a nested short circuit, and still as a failure")
+	}
+}
+
+func TestFallbackCircuitShortCircuited(t *testing.T) {
+	s := StatFactory{}
+	c := circuit.NewCircuitFromConfig("TestFallbackCircuitShortCircuited", s.CreateConfig(""))
+	fallbackMetrics := FindFallbackMetrics(c)
+
+	// Fallbacks for a failing runFunc are not short circuited
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback))
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysFailsFallback))
+	if fallbackMetrics.ShortCircuitSuccesses.TotalSum() != 0 || fallbackMetrics.ShortCircuitFailures.TotalSum() != 0 {
+		t.Error("fallbacks for runFunc errors should not count as short circuited")
+	}
+
+	c.OpenCircuit()
+	testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, testhelp.AlwaysPassesFallback))
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, testhelp.AlwaysFailsFallback))
+	if fallbackMetrics.ShortCircuitSuccesses.TotalSum() != 1 || fallbackMetrics.ShortCircuitFailures.TotalSum() != 1 {
+		t.Error("fallbacks for an open circuit should count as short circuited")
+	}
+	if fallbackMetrics.Successes.TotalSum() != 2 || fallbackMetrics.ErrFailures.TotalSum() != 2 {
+		t.Error("aggregate fallback counts should include every path")
+	}
+
+	c.CloseCircuit()
+	err := c.Execute(circuit.WithMaxConcurrentRequests(context.Background(), 0), testhelp.AlwaysPasses, testhelp.AlwaysPassesFallback)
+	testhelp.MustTesting(t, err)
+	if fallbackMetrics.ShortCircuitSuccesses.TotalSum() != 2 {
+		t.Error("fallbacks for a concurrency limited request should count as short circuited")
+	}
+}
+
+func TestFallbackCircuitWithoutFallback(t *testing.T) {
+	s := StatFactory{}
+	c := circuit.NewCircuitFromConfig("TestFallbackCircuitWithoutFallback", s.CreateConfig(""))
+	err := c.Execute(circuit.WithoutFallback(context.Background()), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback)
+	if err == nil {
+		t.Error("expected the original error when skipping the fallback")
+	}
+	fallbackMetrics := FindFallbackMetrics(c)
+	if fallbackMetrics.Skips.TotalSum() != 1 {
+		t.Error("expected a skipped fallback")
+	}
+	if fallbackMetrics.Successes.TotalSum() != 0 || fallbackMetrics.ErrFailures.TotalSum() != 0 {
+		t.Error("skipped fallbacks should not count as fallback success or failure")
+	}
+}
+
 func TestCircuitIgnoreContextFailures(t *testing.T) {
 	s := StatFactory{}
 	h := circuit.Manager{
@@ -113,3 +191,190 @@ func TestCircuitIgnoreContextFailures(t *testing.T) {
 		t.Error("rolling sum should count the interrupt")
 	}
 }
+
+func TestRunStatsConfig_NonDivisibleWindow(t *testing.T) {
+	cfg := RunStatsConfig{
+		RollingStatsDuration:   time.Second * 30,
+		RollingStatsNumBuckets: 7,
+	}
+	if cfg.RollingStatsBucketWidth() != time.Nanosecond*4285714286 {
+		t.Error("expected the width rounded to the nearest nanosecond", cfg.RollingStatsBucketWidth())
+	}
+	if cfg.RollingStatsWindow() != time.Nanosecond*30000000002 {
+		t.Error("expected the rounding to show in the window", cfg.RollingStatsWindow())
+	}
+	cfg.Merge(defaultRunStatsConfig)
+	now := time.Now()
+	cfg.Now = func() time.Time { return now }
+	var rs RunStats
+	rs.SetConfigNotThreadSafe(cfg)
+	rs.Success(now.Add(cfg.RollingStatsWindow()-time.Nanosecond), time.Millisecond)
+	if rs.Successes.RollingSumAt(now.Add(cfg.RollingStatsWindow()-time.Nanosecond)) != 1 {
+		t.Error("expected the last nanosecond of the window to be counted")
+	}
+	if len(rs.Successes.GetBuckets(now)) != 7 {
+		t.Error("expected one bucket per RollingStatsNumBuckets")
+	}
+
+	fallbackCfg := FallbackStatsConfig{
+		RollingStatsDuration:   time.Second * 10,
+		RollingStatsNumBuckets: 3,
+	}
+	if fallbackCfg.RollingStatsWindow() != time.Nanosecond*9999999999 {
+		t.Error("expected the fallback window rounded down", fallbackCfg.RollingStatsWindow())
+	}
+}
+
+func TestRunStatsConfig_RollingPercentileSampleRate(t *testing.T) {
+	s := StatFactory{
+		RunConfig: RunStatsConfig{
+			RollingPercentileSampleRate: 4,
+		},
+	}
+	c := circuit.NewCircuitFromConfig("TestRunStatsConfig_RollingPercentileSampleRate", s.CreateConfig("TestRunStatsConfig_RollingPercentileSampleRate"))
+	for i := 0; i < 8; i++ {
+		testhelp.MustTesting(t, c.Execute(context.Background(), testhelp.AlwaysPasses, nil))
+	}
+	rs := FindCommandMetrics(c)
+	if rs.Successes.TotalSum() != 8 || len(rs.Latencies.Snapshot()) != 2 {
+		t.Error("expected every success counted, but only 1 in 4 latencies", rs.Successes.TotalSum(), len(rs.Latencies.Snapshot()))
+	}
+}
+
+func TestCloneMetrics(t *testing.T) {
+	s := StatFactory{}
+	c := circuit.NewCircuitFromConfig("TestCloneMetrics", s.CreateConfig(""))
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), testhelp.AlwaysFails, func(ctx context.Context, err error) error {
+		return err
+	}))
+	clone := c.Clone("TestCloneMetrics_2")
+	if FindCommandMetrics(clone) == FindCommandMetrics(c) || FindFallbackMetrics(clone) == FindFallbackMetrics(c) {
+		t.Fatal("expected the clone to have its own rolling stats")
+	}
+	if FindCommandMetrics(clone).Config().RollingStatsDuration != FindCommandMetrics(c).Config().RollingStatsDuration {
+		t.Error("expected the cloned stats to keep their config")
+	}
+	if n := FindCommandMetrics(clone).ErrFailures.TotalSum(); n != 0 {
+		t.Error("expected the cloned stats to start empty", n)
+	}
+	if n := FindFallbackMetrics(clone).ErrFailures.TotalSum(); n != 0 {
+		t.Error("expected the cloned fallback stats to start empty", n)
+	}
+}
+
+func TestRejectionAlarm(t *testing.T) {
+	clk := &clock.MockClock{}
+	clk.Set(time.Now())
+	var changes []bool
+	h := circuit.Manager{
+		DefaultMetrics: []circuit.MetricsConstructor{RejectionAlarmConfig{
+			Threshold: 5,
+			OnChange: func(circuitName string, alarming bool, rejections int64) {
+				if circuitName != "TestRejectionAlarm" {
+					t.Errorf("unexpected circuit %s", circuitName)
+				}
+				changes = append(changes, alarming)
+			},
+			Now: clk.Now,
+		}.Metrics},
+	}
+	c := h.MustCreateCircuit("TestRejectionAlarm", circuit.Config{
+		General: circuit.GeneralConfig{
+			TimeKeeper: circuit.TimeKeeper{Now: clk.Now, AfterFunc: clk.AfterFunc},
+		},
+	})
+	ctx := context.Background()
+	rejected := circuit.WithMaxConcurrentRequests(ctx, 0)
+	for i := 0; i < 4; i++ {
+		testhelp.MustNotTesting(t, c.Execute(rejected, testhelp.AlwaysPasses, nil))
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	if len(changes) != 0 {
+		t.Fatalf("should not alarm under the threshold: %v", changes)
+	}
+	for i := 0; i < 10; i++ {
+		testhelp.MustNotTesting(t, c.Execute(rejected, testhelp.AlwaysPasses, nil))
+	}
+	if len(changes) != 1 || !changes[0] {
+		t.Fatalf("expected one alarm once the threshold is crossed: %v", changes)
+	}
+	// The rejections roll out of the window
+	clk.Add(11 * time.Second)
+	for i := 0; i < 3; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	if len(changes) != 2 || changes[1] {
+		t.Fatalf("expected one recovery once rejections drop: %v", changes)
+	}
+	// Crossing again right after recovering is debounced, until Debounce passes
+	for i := 0; i < 5; i++ {
+		testhelp.MustNotTesting(t, c.Execute(rejected, testhelp.AlwaysPasses, nil))
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected changes within Debounce to wait: %v", changes)
+	}
+	clk.Add(time.Second)
+	testhelp.MustNotTesting(t, c.Execute(rejected, testhelp.AlwaysPasses, nil))
+	if len(changes) != 3 || !changes[2] {
+		t.Fatalf("expected the alarm once Debounce passed: %v", changes)
+	}
+}
+
+func TestRunStats_PreviousErrorPercentage(t *testing.T) {
+	clk := &clock.MockClock{}
+	start := time.Now()
+	clk.Set(start)
+	s := StatFactory{
+		RunConfig: RunStatsConfig{
+			Now: clk.Now,
+		},
+	}
+	cfg := s.CreateConfig("")
+	cfg.General.TimeKeeper = circuit.TimeKeeper{
+		Now:       clk.Now,
+		AfterFunc: clk.AfterFunc,
+	}
+	c := circuit.NewCircuitFromConfig("TestRunStats_PreviousErrorPercentage", cfg)
+	ctx := context.Background()
+	// A 25% error rate in the first window
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	for i := 0; i < 3; i++ {
+		testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	}
+	cmdMetrics := FindCommandMetrics(c)
+	runCfg := cmdMetrics.Config()
+	window := runCfg.RollingStatsWindow()
+	if p := cmdMetrics.PreviousErrorPercentageAt(clk.Now()); p != 0 {
+		t.Errorf("expected no previous window before the window rolls, saw %f", p)
+	}
+	// Cross into the next window and fail 3 of 4 attempts
+	clk.Set(start.Add(window))
+	for i := 0; i < 3; i++ {
+		testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	}
+	testhelp.MustTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	if p := cmdMetrics.ErrorPercentageAt(clk.Now()); p != .75 {
+		t.Errorf("expected the current window to only see its own attempts, saw %f", p)
+	}
+	if p := cmdMetrics.PreviousErrorPercentageAt(clk.Now()); p != .25 {
+		t.Errorf("expected the first window to roll into the previous one, saw %f", p)
+	}
+	if p := c.PreviousErrorPercentage(); p != .25 {
+		t.Errorf("expected the circuit to read the previous window from RunStats, saw %f", p)
+	}
+	if p := c.HealthSnapshot().PreviousErrorPercentage; p != .25 {
+		t.Errorf("expected the snapshot to include the previous window, saw %f", p)
+	}
+	// Another window later, the second window is the previous one and the first is forgotten
+	clk.Set(start.Add(window * 2))
+	if p := cmdMetrics.PreviousErrorPercentageAt(clk.Now()); p != .75 {
+		t.Errorf("expected the second window to become the previous one, saw %f", p)
+	}
+	if p := cmdMetrics.ErrorPercentageAt(clk.Now()); p != 0 {
+		t.Errorf("expected an empty current window, saw %f", p)
+	}
+	clk.Set(start.Add(window * 3))
+	if p := cmdMetrics.PreviousErrorPercentageAt(clk.Now()); p != 0 {
+		t.Errorf("expected both windows to roll out, saw %f", p)
+	}
+}
@@ -26,6 +26,10 @@ type StatSender interface {
 type CommandFactory struct {
 	StatSender StatSender
 	SampleRate float32
+	// Labels are the circuit label keys (see circuit.GeneralConfig.Labels) to put in stat names.  Their values go, in
+	// this order, between the circuit name and the metric group: <circuit>.<label values...>.run.success.  Labels
+	// a circuit does not have are written as "unknown".
+	Labels []string
 }
 
 func (c *CommandFactory) sampleRate() float32 {
@@ -70,35 +74,37 @@ func (c *CommandFactory) SLOCollector(circuitName string) responsetimeslo.Collec
 	}
 }
 
+func (c *CommandFactory) statSender(circuitName string, group string) prefixedStatSender {
+	ret := prefixedStatSender{
+		sendTo:      c.StatSender,
+		circuitName: circuitName,
+		group:       group,
+		labelKeys:   c.Labels,
+	}
+	ret.SetLabels(nil)
+	return ret
+}
+
 // CommandProperties creates statsd metrics for a circuit
 func (c *CommandFactory) CommandProperties(circuitName string) circuit.Config {
 	return circuit.Config{
 		Metrics: circuit.MetricsCollectors{
 			Run: []circuit.RunMetrics{
 				&RunMetricsCollector{
-					prefixedStatSender: prefixedStatSender{
-						prefix: appendStatsdParts(circuitName, "run"),
-						sendTo: c.StatSender,
-					},
-					SampleRate: c.sampleRate(),
+					prefixedStatSender: c.statSender(circuitName, "run"),
+					SampleRate:         c.sampleRate(),
 				},
 			},
 			Fallback: []circuit.FallbackMetrics{
 				&FallbackMetricsCollector{
-					prefixedStatSender: prefixedStatSender{
-						prefix: appendStatsdParts(circuitName, "fallback"),
-						sendTo: c.StatSender,
-					},
-					SampleRate: c.sampleRate(),
+					prefixedStatSender: c.statSender(circuitName, "fallback"),
+					SampleRate:         c.sampleRate(),
 				},
 			},
 			Circuit: []circuit.Metrics{
 				&CircuitMetricsCollector{
-					prefixedStatSender: prefixedStatSender{
-						prefix: appendStatsdParts(circuitName, "circuit"),
-						sendTo: c.StatSender,
-					},
-					SampleRate: c.sampleRate(),
+					prefixedStatSender: c.statSender(circuitName, "circuit"),
+					SampleRate:         c.sampleRate(),
 				},
 			},
 		},
@@ -119,20 +125,50 @@ func (e *errorChecker) check(err error) {
 type prefixedStatSender struct {
 	sendTo StatSender
 	prefix string
+
+	// If circuitName is set, SetLabels rebuilds prefix from these
+	circuitName string
+	group       string
+	labelKeys   []string
+}
+
+// SetLabels puts the values of the configured label keys into the stat prefix
+func (p *prefixedStatSender) SetLabels(labels map[string]string) {
+	if p.circuitName == "" {
+		return
+	}
+	parts := make([]string, 0, len(p.labelKeys)+2)
+	parts = append(parts, p.circuitName)
+	for _, k := range p.labelKeys {
+		v, exists := labels[k]
+		if !exists || v == "" {
+			v = "unknown"
+		}
+		parts = append(parts, v)
+	}
+	parts = append(parts, p.group)
+	p.prefix = appendStatsdParts(parts...)
 }
 
 var _ StatSender = &prefixedStatSender{}
 
+func (p *prefixedStatSender) stat(stat string) string {
+	if p.prefix == "" {
+		return stat
+	}
+	return p.prefix + "." + stat
+}
+
 func (p *prefixedStatSender) Inc(stat string, val int64, sampleRate float32) error {
-	return p.sendTo.Inc(p.prefix+stat, val, sampleRate)
+	return p.sendTo.Inc(p.stat(stat), val, sampleRate)
 }
 
 func (p *prefixedStatSender) Gauge(stat string, val int64, sampleRate float32) error {
-	return p.sendTo.Gauge(p.prefix+stat, val, sampleRate)
+	return p.sendTo.Gauge(p.stat(stat), val, sampleRate)
 }
 
 func (p *prefixedStatSender) TimingDuration(stat string, val time.Duration, sampleRate float32) error {
-	return p.sendTo.TimingDuration(p.prefix+stat, val, sampleRate)
+	return p.sendTo.TimingDuration(p.stat(stat), val, sampleRate)
 }
 
 // CircuitMetricsCollector collects opened/closed metrics
@@ -153,6 +189,7 @@ func (c *CircuitMetricsCollector) Opened(now time.Time) {
 }
 
 var _ circuit.Metrics = &CircuitMetricsCollector{}
+var _ circuit.LabelSetter = &CircuitMetricsCollector{}
 
 // SLOCollector collects SLO level metrics
 type SLOCollector struct {
@@ -221,6 +258,7 @@ func (c *RunMetricsCollector) ErrConcurrencyLimitReject(now time.Time) {
 }
 
 var _ circuit.RunMetrics = &RunMetricsCollector{}
+var _ circuit.LabelSetter = &RunMetricsCollector{}
 
 // FallbackMetricsCollector collects fallback metrics
 type FallbackMetricsCollector struct {
@@ -232,6 +270,7 @@ type FallbackMetricsCollector struct {
 // Success sends a success to statsd
 func (c *FallbackMetricsCollector) Success(now time.Time, duration time.Duration) {
 	c.check(c.Inc("success", 1, c.SampleRate))
+	c.check(c.TimingDuration("calls", duration, c.SampleRate))
 }
 
 // ErrConcurrencyLimitReject sends a concurrency-limit to statsd
@@ -242,6 +281,14 @@ func (c *FallbackMetricsCollector) ErrConcurrencyLimitReject(now time.Time) {
 // ErrFailure sends a failure to statsd
 func (c *FallbackMetricsCollector) ErrFailure(now time.Time, duration time.Duration) {
 	c.check(c.Inc("err_failure", 1, c.SampleRate))
+	c.check(c.TimingDuration("calls", duration, c.SampleRate))
+}
+
+// Skipped sends a skipped fallback to statsd
+func (c *FallbackMetricsCollector) Skipped(now time.Time) {
+	c.check(c.Inc("skipped", 1, c.SampleRate))
 }
 
 var _ circuit.FallbackMetrics = &FallbackMetricsCollector{}
+var _ circuit.FallbackSkippedMetrics = &FallbackMetricsCollector{}
+var _ circuit.LabelSetter = &FallbackMetricsCollector{}
@@ -0,0 +1,111 @@
+package statsdmetrics
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit"
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+type recordingStatSender struct {
+	mu      sync.Mutex
+	incs    map[string]int64
+	gauges  map[string]int64
+	timings map[string]int
+}
+
+func newRecordingStatSender() *recordingStatSender {
+	return &recordingStatSender{
+		incs:    make(map[string]int64),
+		gauges:  make(map[string]int64),
+		timings: make(map[string]int),
+	}
+}
+
+func (r *recordingStatSender) Inc(stat string, val int64, sampleRate float32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.incs[stat] += val
+	return nil
+}
+
+func (r *recordingStatSender) Gauge(stat string, val int64, sampleRate float32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gauges[stat] = val
+	return nil
+}
+
+func (r *recordingStatSender) TimingDuration(stat string, val time.Duration, sampleRate float32) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timings[stat]++
+	return nil
+}
+
+func TestCommandFactory_CommandProperties(t *testing.T) {
+	sender := newRecordingStatSender()
+	f := CommandFactory{
+		StatSender: sender,
+	}
+	c := circuit.NewCircuitFromConfig("hello-world", f.CommandProperties("hello-world"))
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+		t.Fatal("expected no error", err)
+	}
+	if err := c.Execute(context.Background(), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback); err != nil {
+		t.Fatal("expected fallback to pass", err)
+	}
+	if err := c.Execute(circuit.WithoutFallback(context.Background()), testhelp.AlwaysFails, testhelp.AlwaysPassesFallback); err == nil {
+		t.Fatal("expected failure without a fallback")
+	}
+	c.OpenCircuit()
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err == nil {
+		t.Fatal("expected open circuit to short circuit")
+	}
+
+	expectedIncs := map[string]int64{
+		"hello-world.run.success":           1,
+		"hello-world.run.err_failure":       2,
+		"hello-world.run.err_short_circuit": 1,
+		"hello-world.fallback.success":      1,
+		"hello-world.fallback.skipped":      1,
+	}
+	for stat, val := range expectedIncs {
+		if sender.incs[stat] != val {
+			t.Errorf("expected %s=%d, saw %d", stat, val, sender.incs[stat])
+		}
+	}
+	if sender.timings["hello-world.run.calls"] != 3 {
+		t.Error("expected a timing for every run", sender.timings)
+	}
+	if sender.timings["hello-world.fallback.calls"] != 1 {
+		t.Error("expected a timing for every fallback", sender.timings)
+	}
+	if sender.gauges["hello-world.circuit.is_open"] != 1 {
+		t.Error("expected an is_open gauge", sender.gauges)
+	}
+}
+
+func TestCommandFactory_Labels(t *testing.T) {
+	sender := newRecordingStatSender()
+	f := CommandFactory{
+		StatSender: sender,
+		Labels:     []string{"region", "tier"},
+	}
+	config := f.CommandProperties("hello-world")
+	config.General.Labels = map[string]string{"region": "us-west-2"}
+	c := circuit.NewCircuitFromConfig("hello-world", config)
+	if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+		t.Fatal("expected no error", err)
+	}
+	c.OpenCircuit()
+	if sender.incs["hello-world.us-west-2.unknown.run.success"] != 1 {
+		t.Error("expected label values in the stat name", sender.incs)
+	}
+	if sender.gauges["hello-world.us-west-2.unknown.circuit.is_open"] != 1 {
+		t.Error("expected label values in the gauge name", sender.gauges)
+	}
+}
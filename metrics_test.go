@@ -0,0 +1,438 @@
+package circuit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cep21/circuit/internal/testhelp"
+)
+
+func TestRunMetricsCollection(t *testing.T) {
+	first := &countingRunMetrics{}
+	second := &countingRunMetrics{}
+	collection := RunMetricsCollection{first, second}
+	c := NewCircuitFromConfig("TestRunMetricsCollection", Config{
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collection},
+		},
+	})
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := c.Execute(context.Background(), testhelp.AlwaysPasses, nil); err != nil {
+				t.Error("expected no error", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if first.calls.Get() != 10 || second.calls.Get() != 10 {
+		t.Error("expected both collectors to see every success", first.calls.Get(), second.calls.Get())
+	}
+}
+
+func TestFallbackMetricsCollection(t *testing.T) {
+	first := &countingRunMetrics{}
+	second := &countingRunMetrics{}
+	collection := FallbackMetricsCollection{first, second}
+	collection.Success(time.Now(), time.Second)
+	if first.calls.Get() != 1 || second.calls.Get() != 1 {
+		t.Error("expected both collectors to see the success", first.calls.Get(), second.calls.Get())
+	}
+}
+
+type labeledRunMetrics struct {
+	countingRunMetrics
+	labels map[string]string
+}
+
+func (l *labeledRunMetrics) SetLabels(labels map[string]string) {
+	l.labels = labels
+}
+
+func TestLabelsReachCollectors(t *testing.T) {
+	direct := &labeledRunMetrics{}
+	inCollection := &labeledRunMetrics{}
+	h := Manager{
+		DefaultCircuitProperties: []CommandPropertiesConstructor{
+			func(circuitName string) Config {
+				return Config{
+					General: GeneralConfig{
+						Labels: map[string]string{"region": "us-west-2", "tier": "default"},
+					},
+				}
+			},
+		},
+	}
+	c := h.MustCreateCircuit("TestLabelsReachCollectors", Config{
+		General: GeneralConfig{
+			Labels: map[string]string{"tier": "critical"},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{direct, RunMetricsCollection{inCollection}},
+		},
+	})
+	appended := &labeledRunMetrics{}
+	c.AppendRunMetrics(appended)
+	for _, m := range []*labeledRunMetrics{direct, inCollection, appended} {
+		if m.labels["region"] != "us-west-2" || m.labels["tier"] != "critical" || len(m.labels) != 2 {
+			t.Error("unexpected labels", m.labels)
+		}
+	}
+	if c.Labels()["tier"] != "critical" {
+		t.Error("expected the circuit to report its labels", c.Labels())
+	}
+}
+
+type durationsByOutcome struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+}
+
+func (d *durationsByOutcome) observe(outcome string, duration time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.durations == nil {
+		d.durations = make(map[string]time.Duration)
+	}
+	d.durations[outcome] += duration
+}
+
+func (d *durationsByOutcome) Success(now time.Time, duration time.Duration) {
+	d.observe("success", duration)
+}
+func (d *durationsByOutcome) ErrFailure(now time.Time, duration time.Duration) {
+	d.observe("failure", duration)
+}
+func (d *durationsByOutcome) ErrTimeout(now time.Time, duration time.Duration) {
+	d.observe("timeout", duration)
+}
+func (d *durationsByOutcome) ErrBadRequest(now time.Time, duration time.Duration) {
+	d.observe("bad_request", duration)
+}
+func (d *durationsByOutcome) ErrInterrupt(now time.Time, duration time.Duration) {
+	d.observe("interrupt", duration)
+}
+func (d *durationsByOutcome) ErrConcurrencyLimitReject(now time.Time) {}
+func (d *durationsByOutcome) ErrShortCircuit(now time.Time)           {}
+
+func TestRunMetricsDurationsByOutcome(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+	takes := func(d time.Duration, err error) func(context.Context) error {
+		return func(_ context.Context) error {
+			mu.Lock()
+			now = now.Add(d)
+			mu.Unlock()
+			return err
+		}
+	}
+	collector := &durationsByOutcome{}
+	c := NewCircuitFromConfig("TestRunMetricsDurationsByOutcome", Config{
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now: func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
+				},
+			},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collector},
+		},
+	})
+	testhelp.MustTesting(t, c.Execute(context.Background(), takes(time.Second, nil), nil))
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), takes(time.Millisecond, errors.New("refused")), nil))
+	testhelp.MustNotTesting(t, c.Execute(context.Background(), takes(time.Millisecond*2, SimpleBadRequest{Err: errors.New("bad")}), nil))
+	expected := map[string]time.Duration{
+		"success":     time.Second,
+		"failure":     time.Millisecond,
+		"bad_request": time.Millisecond * 2,
+	}
+	if len(collector.durations) != len(expected) {
+		t.Error("unexpected outcomes", collector.durations)
+	}
+	for outcome, d := range expected {
+		if collector.durations[outcome] != d {
+			t.Errorf("expected %s to take %s, saw %s", outcome, d, collector.durations[outcome])
+		}
+	}
+}
+
+type executeDurations struct {
+	countingRunMetrics
+	runDurations   []time.Duration
+	totalDurations []time.Duration
+}
+
+func (e *executeDurations) ExecuteDuration(now time.Time, runDuration time.Duration, totalDuration time.Duration) {
+	e.runDurations = append(e.runDurations, runDuration)
+	e.totalDurations = append(e.totalDurations, totalDuration)
+}
+
+func TestExecuteDurationMetrics(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+	advance := func(d time.Duration) {
+		mu.Lock()
+		now = now.Add(d)
+		mu.Unlock()
+	}
+	collector := &executeDurations{}
+	c := NewCircuitFromConfig("TestExecuteDurationMetrics", Config{
+		General: GeneralConfig{
+			TimeKeeper: TimeKeeper{
+				Now: func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
+				},
+			},
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collector},
+		},
+	})
+	err := c.Execute(context.Background(), func(_ context.Context) error {
+		advance(time.Second)
+		return errors.New("primary failed")
+	}, func(_ context.Context, _ error) error {
+		advance(time.Second * 2)
+		return nil
+	})
+	testhelp.MustTesting(t, err)
+	if len(collector.runDurations) != 1 || collector.calls.Get() != 1 {
+		t.Fatal("expected one execute duration next to the usual run metric", collector.runDurations, collector.calls.Get())
+	}
+	if collector.runDurations[0] != time.Second {
+		t.Error("expected the primary to take a second", collector.runDurations[0])
+	}
+	if collector.totalDurations[0] != time.Second*3 {
+		t.Error("expected the total to include the fallback", collector.totalDurations[0])
+	}
+}
+
+type orderedRunMetrics struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (o *orderedRunMetrics) add(event string) {
+	o.mu.Lock()
+	o.events = append(o.events, event)
+	o.mu.Unlock()
+}
+
+func (o *orderedRunMetrics) Attempt(now time.Time)                               { o.add("attempt") }
+func (o *orderedRunMetrics) Success(now time.Time, duration time.Duration)       { o.add("success") }
+func (o *orderedRunMetrics) ErrFailure(now time.Time, duration time.Duration)    { o.add("failure") }
+func (o *orderedRunMetrics) ErrTimeout(now time.Time, duration time.Duration)    { o.add("timeout") }
+func (o *orderedRunMetrics) ErrBadRequest(now time.Time, duration time.Duration) { o.add("bad_request") }
+func (o *orderedRunMetrics) ErrInterrupt(now time.Time, duration time.Duration)  { o.add("interrupt") }
+func (o *orderedRunMetrics) ErrConcurrencyLimitReject(now time.Time)             { o.add("concurrency_limit_reject") }
+func (o *orderedRunMetrics) ErrShortCircuit(now time.Time)                       { o.add("short_circuit") }
+
+func TestAttemptMetrics(t *testing.T) {
+	collector := &orderedRunMetrics{}
+	c := NewCircuitFromConfig("TestAttemptMetrics", Config{
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{collector},
+		},
+	})
+	ctx := context.Background()
+	testhelp.MustTesting(t, c.Execute(ctx, func(_ context.Context) error {
+		collector.add("run")
+		return nil
+	}, nil))
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysFails, nil))
+	testhelp.MustNotTesting(t, c.Execute(WithMaxConcurrentRequests(ctx, 0), testhelp.AlwaysPasses, nil))
+	c.OpenCircuit()
+	testhelp.MustNotTesting(t, c.Execute(ctx, testhelp.AlwaysPasses, nil))
+	expected := []string{"attempt", "run", "success", "attempt", "failure", "concurrency_limit_reject", "short_circuit"}
+	if strings.Join(collector.events, ",") != strings.Join(expected, ",") {
+		t.Error("unexpected order of events", collector.events)
+	}
+}
+
+// sharedOrderRunMetrics writes every run callback, prefixed by name, to a log shared with other collectors
+type sharedOrderRunMetrics struct {
+	name string
+	log  *orderedRunMetrics
+}
+
+func (s *sharedOrderRunMetrics) add(event string) { s.log.add(s.name + ":" + event) }
+
+func (s *sharedOrderRunMetrics) Attempt(now time.Time)                               { s.add("attempt") }
+func (s *sharedOrderRunMetrics) Success(now time.Time, duration time.Duration)       { s.add("success") }
+func (s *sharedOrderRunMetrics) ErrFailure(now time.Time, duration time.Duration)    { s.add("failure") }
+func (s *sharedOrderRunMetrics) ErrTimeout(now time.Time, duration time.Duration)    { s.add("timeout") }
+func (s *sharedOrderRunMetrics) ErrBadRequest(now time.Time, duration time.Duration) { s.add("bad_request") }
+func (s *sharedOrderRunMetrics) ErrInterrupt(now time.Time, duration time.Duration)  { s.add("interrupt") }
+func (s *sharedOrderRunMetrics) ErrConcurrencyLimitReject(now time.Time)             { s.add("concurrency_limit_reject") }
+func (s *sharedOrderRunMetrics) ErrShortCircuit(now time.Time)                       { s.add("short_circuit") }
+func (s *sharedOrderRunMetrics) ExecuteDuration(now time.Time, runDuration time.Duration, totalDuration time.Duration) {
+	s.add("execute_duration")
+}
+
+// sharedOrderFallbackMetrics is sharedOrderRunMetrics for fallbacks
+type sharedOrderFallbackMetrics struct {
+	sharedOrderRunMetrics
+}
+
+func (s *sharedOrderFallbackMetrics) Success(now time.Time, duration time.Duration) {
+	s.add("fallback_success")
+}
+func (s *sharedOrderFallbackMetrics) ErrFailure(now time.Time, duration time.Duration) {
+	s.add("fallback_failure")
+}
+
+func TestRunMetricsOrder(t *testing.T) {
+	waitsForTimeout := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	fallback := func(_ context.Context, _ error) error { return nil }
+	testCases := []struct {
+		name     string
+		open     bool
+		runFunc  func(context.Context) error
+		fallback func(context.Context, error) error
+		expected []string
+	}{
+		{
+			name:     "success",
+			runFunc:  testhelp.AlwaysPasses,
+			expected: []string{"a:attempt", "b:attempt", "c:attempt", "a:success", "b:success", "c:success", "a:execute_duration", "b:execute_duration", "c:execute_duration"},
+		},
+		{
+			name:     "failure",
+			runFunc:  testhelp.AlwaysFails,
+			fallback: fallback,
+			expected: []string{"a:attempt", "b:attempt", "c:attempt", "a:failure", "b:failure", "c:failure", "f:fallback_success", "a:execute_duration", "b:execute_duration", "c:execute_duration"},
+		},
+		{
+			name:     "timeout",
+			runFunc:  waitsForTimeout,
+			expected: []string{"a:attempt", "b:attempt", "c:attempt", "a:timeout", "b:timeout", "c:timeout", "a:execute_duration", "b:execute_duration", "c:execute_duration"},
+		},
+		{
+			name:     "short circuit",
+			open:     true,
+			runFunc:  testhelp.AlwaysPasses,
+			fallback: fallback,
+			expected: []string{"a:short_circuit", "b:short_circuit", "c:short_circuit", "f:fallback_success", "a:execute_duration", "b:execute_duration", "c:execute_duration"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			log := &orderedRunMetrics{}
+			c := NewCircuitFromConfig("TestRunMetricsOrder", Config{
+				Execution: ExecutionConfig{
+					Timeout: time.Millisecond,
+				},
+				Metrics: MetricsCollectors{
+					Run:      []RunMetrics{&sharedOrderRunMetrics{name: "a", log: log}, &sharedOrderRunMetrics{name: "b", log: log}},
+					Fallback: []FallbackMetrics{&sharedOrderFallbackMetrics{sharedOrderRunMetrics{name: "f", log: log}}},
+				},
+			})
+			c.AppendRunMetrics(&sharedOrderRunMetrics{name: "c", log: log})
+			if tc.open {
+				c.OpenCircuit()
+			}
+			_ = c.Execute(context.Background(), tc.runFunc, tc.fallback)
+			if strings.Join(log.events, ",") != strings.Join(tc.expected, ",") {
+				t.Error("unexpected order of events", log.events)
+			}
+		})
+	}
+}
+
+func TestRunMetricsOrder_Hedge(t *testing.T) {
+	log := &orderedRunMetrics{}
+	c := NewCircuitFromConfig("TestRunMetricsOrder_Hedge", Config{
+		Fallback: FallbackConfig{
+			Hedge: true,
+		},
+		Metrics: MetricsCollectors{
+			Run:      []RunMetrics{&sharedOrderRunMetrics{name: "a", log: log}},
+			Fallback: []FallbackMetrics{&sharedOrderFallbackMetrics{sharedOrderRunMetrics{name: "f", log: log}}},
+		},
+	})
+	release := make(chan struct{})
+	testhelp.MustTesting(t, c.Execute(context.Background(), func(_ context.Context) error {
+		<-release
+		return nil
+	}, testhelp.AlwaysPassesFallback))
+	close(release)
+	// The losing runFunc reports its outcome after Execute, and ExecuteDuration, are done.  Its attempt may be reported
+	// at any point before that.
+	var events []string
+	for i := 0; i < 100 && len(events) != 3; i++ {
+		time.Sleep(time.Millisecond * 10)
+		events = events[:0]
+		log.mu.Lock()
+		for _, event := range log.events {
+			if event != "a:attempt" {
+				events = append(events, event)
+			}
+		}
+		log.mu.Unlock()
+	}
+	// runFunc returned nil, but its context was canceled first, so the outcome may be either
+	if len(events) != 3 || events[0] != "f:fallback_success" || events[1] != "a:execute_duration" || (events[2] != "a:success" && events[2] != "a:interrupt") {
+		t.Error("unexpected order of events", events)
+	}
+}
+
+func TestMaxReportedDuration(t *testing.T) {
+	now := time.Now()
+	var mu sync.Mutex
+	takes := func(d time.Duration) func(context.Context) error {
+		return func(_ context.Context) error {
+			mu.Lock()
+			now = now.Add(d)
+			mu.Unlock()
+			return nil
+		}
+	}
+	byOutcome := &durationsByOutcome{}
+	execute := &executeDurations{}
+	c := NewCircuitFromConfig("TestMaxReportedDuration", Config{
+		General: GeneralConfig{
+			MaxReportedDuration: time.Second * 5,
+			TimeKeeper: TimeKeeper{
+				Now: func() time.Time {
+					mu.Lock()
+					defer mu.Unlock()
+					return now
+				},
+			},
+		},
+		Execution: ExecutionConfig{
+			Timeout: time.Second * 10,
+		},
+		Metrics: MetricsCollectors{
+			Run: []RunMetrics{byOutcome, execute},
+		},
+	})
+	testhelp.MustTesting(t, c.Execute(context.Background(), takes(time.Second*2), nil))
+	info, _ := c.ExecuteWithInfo(context.Background(), takes(time.Second*30), nil)
+	if info.Outcome != OutcomeTimeout {
+		t.Fatal("capping reported durations should not change timeouts", info.Outcome)
+	}
+	if byOutcome.durations["success"] != time.Second*2 {
+		t.Error("expected durations under the cap to be reported as they are", byOutcome.durations["success"])
+	}
+	if byOutcome.durations["timeout"] != time.Second*5 {
+		t.Error("expected the slow request to be reported at the cap", byOutcome.durations["timeout"])
+	}
+	if len(execute.totalDurations) != 2 || execute.runDurations[1] != time.Second*5 || execute.totalDurations[1] != time.Second*5 {
+		t.Error("expected execute durations to be capped too", execute.runDurations, execute.totalDurations)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build go1.21
+// +build go1.21
+
+/*
+Package oteltracing contains a RunTracer that creates an OpenTelemetry span for each circuit Execute call using the
+go.opentelemetry.io/otel/trace interface.  It needs Go 1.21 or newer, like OpenTelemetry itself.
+*/
+package oteltracing
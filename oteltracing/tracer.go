@@ -0,0 +1,68 @@
+//go:build go1.21
+// +build go1.21
+
+package oteltracing
+
+import (
+	"context"
+
+	"github.com/cep21/circuit"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Attribute keys set on each span
+const (
+	AttributeCircuitName    = "circuit.name"
+	AttributeOutcome        = "circuit.outcome"
+	AttributeFallbackCalled = "circuit.fallback_called"
+)
+
+// RunTracer creates a span for each Execute call of a circuit
+type RunTracer struct {
+	// Tracer creates the spans
+	Tracer trace.Tracer
+	// SpanName is the name of each span.  It defaults to the circuit name.
+	SpanName string
+}
+
+var _ circuit.RunTracer = &RunTracer{}
+
+// CommandProperties traces circuits with this RunTracer.  Use it with a circuit.Manager's DefaultCircuitProperties.
+func (r *RunTracer) CommandProperties(circuitName string) circuit.Config {
+	return circuit.Config{
+		General: circuit.GeneralConfig{
+			RunTracer: r,
+		},
+	}
+}
+
+// StartRun starts a span for a circuit's Execute call
+func (r *RunTracer) StartRun(ctx context.Context, circuitName string) (context.Context, circuit.RunSpan) {
+	spanName := r.SpanName
+	if spanName == "" {
+		spanName = circuitName
+	}
+	ctx, span := r.Tracer.Start(ctx, spanName, trace.WithAttributes(attribute.String(AttributeCircuitName, circuitName)))
+	return ctx, runSpan{span: span}
+}
+
+type runSpan struct {
+	span trace.Span
+}
+
+// End records the outcome of the Execute call and ends the span
+func (r runSpan) End(info circuit.ExecutionInfo, err error) {
+	r.span.SetAttributes(
+		attribute.String(AttributeOutcome, info.Outcome.String()),
+		attribute.Bool(AttributeFallbackCalled, info.FallbackCalled),
+	)
+	if err != nil {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, err.Error())
+	} else {
+		r.span.SetStatus(codes.Ok, "")
+	}
+	r.span.End()
+}
@@ -0,0 +1,64 @@
+package circuit
+
+import (
+	"context"
+
+	"github.com/cep21/circuit/faststats"
+)
+
+// ConcurrencyLimiter decides which calls may run, replacing the circuit's counting of Execution.MaxConcurrentRequests.
+// Use it for admission the circuit does not know about, like weighing calls by cost or preempting low priority ones.
+type ConcurrencyLimiter interface {
+	// Acquire is called before runFunc with the weight set by WithWeight, or 1.  It may block, but should return once
+	// ctx is done.  If ok, the circuit calls release exactly once, after runFunc returns.  If not, the call is a
+	// concurrency limit rejection, or an interrupt if the caller canceled ctx, and release is not called.
+	Acquire(ctx context.Context, weight int64) (release func(), ok bool)
+}
+
+// ConcurrencyPool is a concurrency limit shared by every circuit whose Execution.ConcurrencyPool points to it.  Use it
+// when several circuits protect the same backend, so their combined load stays under what the backend can take.  Each
+// circuit still opens and closes on its own, and still enforces its own Execution.MaxConcurrentRequests first.
+//
+// A call rejected by the pool is a concurrency limit rejection of the circuit that made it.  Calls do not wait for the
+// pool, even with Execution.MaxConcurrencyWait.
+type ConcurrencyPool struct {
+	maxConcurrentRequests faststats.AtomicInt64
+	running               faststats.AtomicInt64
+}
+
+// NewConcurrencyPool creates a pool that allows maxConcurrentRequests calls at once across all of its circuits.  A
+// negative limit allows any number of calls, but still counts them.
+func NewConcurrencyPool(maxConcurrentRequests int64) *ConcurrencyPool {
+	p := &ConcurrencyPool{}
+	p.maxConcurrentRequests.Set(maxConcurrentRequests)
+	return p
+}
+
+// SetConcurrencyLimit changes the pool's limit.  Calls already running are not stopped.
+func (p *ConcurrencyPool) SetConcurrencyLimit(maxConcurrentRequests int64) {
+	p.maxConcurrentRequests.Set(maxConcurrentRequests)
+}
+
+// ConcurrencyLimit returns the pool's limit
+func (p *ConcurrencyPool) ConcurrencyLimit() int64 {
+	return p.maxConcurrentRequests.Get()
+}
+
+// Running returns how many calls, across every circuit in the pool, hold a slot right now
+func (p *ConcurrencyPool) Running() int64 {
+	return p.running.Get()
+}
+
+// tryAcquire takes a slot if one is free.  Callers that get true must call release.
+func (p *ConcurrencyPool) tryAcquire() bool {
+	current := p.running.Add(1)
+	if max := p.maxConcurrentRequests.Get(); max >= 0 && current > max {
+		p.running.Add(-1)
+		return false
+	}
+	return true
+}
+
+func (p *ConcurrencyPool) release() {
+	p.running.Add(-1)
+}
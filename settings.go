@@ -0,0 +1,70 @@
+package circuit
+
+import (
+	"sort"
+	"time"
+)
+
+// Settings are the limits a circuit enforces right now, for audits and documentation.  They are read from the live
+// circuit, so they include changes made while it runs, like SetConcurrencyLimit, SetConfigThreadSafe, or
+// SetErrorThresholdPercentage.  Thresholds of the open/close logic are only filled in if that logic is a
+// SettingsReporter: otherwise they are zero.
+type Settings struct {
+	Name string
+	// Timeout is Execution.Timeout.  Zero or less means no timeout.
+	Timeout time.Duration
+	// MaxConcurrentRequests is Execution.MaxConcurrentRequests.  Negative means no limit.
+	MaxConcurrentRequests int64
+	// FallbackMaxConcurrentRequests is Fallback.MaxConcurrentRequests.  Negative means no limit.
+	FallbackMaxConcurrentRequests int64
+	ForceOpen                     bool
+	ForcedClosed                  bool
+	Disabled                      bool
+	DryRun                        bool
+	// ErrorThresholdPercentage is the error percentage [0 - 100] that opens the circuit
+	ErrorThresholdPercentage int64
+	// RequestVolumeThreshold is how many requests are needed before the circuit can open
+	RequestVolumeThreshold int64
+	// SleepWindow is how long the circuit stays open before it allows a half open attempt
+	SleepWindow time.Duration
+}
+
+// SettingsReporter is implemented by ClosedToOpen and OpenToClosed logic that can fill in the thresholds of Settings
+// it enforces, like the hystrix Opener and Closer
+type SettingsReporter interface {
+	// ReportSettings sets the fields of s that this logic enforces, using its current configuration
+	ReportSettings(s *Settings)
+}
+
+// Settings returns the limits the circuit enforces right now
+func (c *Circuit) Settings() Settings {
+	s := Settings{
+		Name:                          c.Name(),
+		Timeout:                       c.threadSafeConfig.Execution.ExecutionTimeout.Duration(),
+		MaxConcurrentRequests:         c.threadSafeConfig.Execution.MaxConcurrentRequests.Get(),
+		FallbackMaxConcurrentRequests: c.threadSafeConfig.Fallback.MaxConcurrentRequests.Get(),
+		ForceOpen:                     c.threadSafeConfig.CircuitBreaker.ForceOpen.Get(),
+		ForcedClosed:                  c.threadSafeConfig.CircuitBreaker.ForcedClosed.Get(),
+		Disabled:                      c.threadSafeConfig.CircuitBreaker.Disabled.Get(),
+		DryRun:                        c.threadSafeConfig.CircuitBreaker.DryRun.Get(),
+	}
+	if r, ok := c.ClosedToOpen.(SettingsReporter); ok {
+		r.ReportSettings(&s)
+	}
+	if r, ok := c.OpenToClose.(SettingsReporter); ok {
+		r.ReportSettings(&s)
+	}
+	return s
+}
+
+// Settings returns the Settings of every tracked circuit, sorted by name
+func (h *Manager) Settings() []Settings {
+	var ret []Settings
+	h.Each(func(_ string, c *Circuit) {
+		ret = append(ret, c.Settings())
+	})
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
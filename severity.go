@@ -0,0 +1,95 @@
+package circuit
+
+import "time"
+
+// FailureSeverity is implemented by an error returned by runFunc that should count more toward opening the circuit
+// than an ordinary failure, such as an HTTP 503 with Retry-After.  The circuit still counts it as one ErrFailure, and
+// also reports the severity to collectors that implement FailureSeverityMetrics.  Open logic decides what each
+// severity weighs: see the SeverityWeights of the hystrix opener.
+type FailureSeverity interface {
+	FailureSeverity() string
+}
+
+// SeverityChecker decides the severity of an error returned by runFunc, or "" for an ordinary failure.  Use it to
+// classify errors you do not control, such as HTTP or gRPC status codes, without wrapping them.  Errors that implement
+// FailureSeverity always use their own severity.
+type SeverityChecker interface {
+	CheckSeverity(err error) string
+}
+
+// SeverityCheckerFunc adapts a function into a SeverityChecker
+type SeverityCheckerFunc func(err error) string
+
+// CheckSeverity calls f(err)
+func (f SeverityCheckerFunc) CheckSeverity(err error) string {
+	return f(err)
+}
+
+var _ SeverityChecker = SeverityCheckerFunc(nil)
+
+// SeverityOf returns the severity of err if it is a FailureSeverity, or "" if it is not.  On Go 1.13 and later, it
+// also looks through wrapped errors with errors.As.
+func SeverityOf(err error) string {
+	if err == nil {
+		return ""
+	}
+	if s, ok := err.(FailureSeverity); ok {
+		return s.FailureSeverity()
+	}
+	return wrappedSeverity(err)
+}
+
+// SimpleSevereError is a simple wrapper for an error to give it a severity.  Execute returns it as is, so callers can
+// still unwrap the original error.
+type SimpleSevereError struct {
+	Err      error
+	Severity string
+}
+
+var _ FailureSeverity = SimpleSevereError{}
+
+// NewSevereError wraps err so the circuit reports it with severity.  It returns nil if err is nil.
+func NewSevereError(err error, severity string) error {
+	if err == nil {
+		return nil
+	}
+	return SimpleSevereError{Err: err, Severity: severity}
+}
+
+// Error returns the wrapped error's message
+func (s SimpleSevereError) Error() string {
+	return s.Err.Error()
+}
+
+// Cause returns the wrapped error
+func (s SimpleSevereError) Cause() error {
+	return s.Err
+}
+
+// Unwrap returns the wrapped error
+func (s SimpleSevereError) Unwrap() error {
+	return s.Err
+}
+
+// FailureSeverity returns Severity
+func (s SimpleSevereError) FailureSeverity() string {
+	return s.Severity
+}
+
+// FailureSeverityMetrics can be implemented by RunMetrics that want to weigh some failures more than others.
+// ErrFailureSeverity is called right after ErrFailure, and before the circuit asks ClosedToOpen if it should open, for
+// each failure whose error has a severity.  Failures without one only call ErrFailure.
+type FailureSeverityMetrics interface {
+	ErrFailureSeverity(now time.Time, severity string, duration time.Duration)
+}
+
+var _ FailureSeverityMetrics = RunMetricsCollection(nil)
+
+// ErrFailureSeverity sends ErrFailureSeverity to all collectors that implement FailureSeverityMetrics
+func (r RunMetricsCollection) ErrFailureSeverity(now time.Time, severity string, duration time.Duration) {
+	for _, c := range r {
+		if s, ok := c.(FailureSeverityMetrics); ok {
+			s.ErrFailureSeverity(now, severity, duration)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build go1.13
+// +build go1.13
+
+package circuit
+
+import "errors"
+
+// wrappedSeverity is the severity of the first error wrapped by err that is a FailureSeverity
+func wrappedSeverity(err error) string {
+	var s FailureSeverity
+	if errors.As(err, &s) {
+		return s.FailureSeverity()
+	}
+	return ""
+}
@@ -0,0 +1,9 @@
+//go:build !go1.13
+// +build !go1.13
+
+package circuit
+
+// wrappedSeverity needs errors.As, so only top level FailureSeverity errors are found before Go 1.13
+func wrappedSeverity(err error) string {
+	return ""
+}
@@ -0,0 +1,115 @@
+package circuit
+
+import (
+	"context"
+	"time"
+)
+
+// Outcome is how the circuit handled a call to runFunc.  Each Outcome matches the RunMetrics function the circuit
+// called.
+type Outcome int
+
+const (
+	// OutcomeSuccess matches RunMetrics.Success
+	OutcomeSuccess Outcome = iota
+	// OutcomeFailure matches RunMetrics.ErrFailure
+	OutcomeFailure
+	// OutcomeTimeout matches RunMetrics.ErrTimeout
+	OutcomeTimeout
+	// OutcomeBadRequest matches RunMetrics.ErrBadRequest
+	OutcomeBadRequest
+	// OutcomeInterrupt matches RunMetrics.ErrInterrupt
+	OutcomeInterrupt
+	// OutcomeConcurrencyLimitReject matches RunMetrics.ErrConcurrencyLimitReject
+	OutcomeConcurrencyLimitReject
+	// OutcomeShortCircuit matches RunMetrics.ErrShortCircuit
+	OutcomeShortCircuit
+	// OutcomeDraining is a call rejected because the circuit is draining.  No RunMetrics are called.
+	OutcomeDraining
+	// OutcomeBudgetExhausted is a call rejected because GeneralConfig.BudgetFunc said it has no budget left.  No
+	// RunMetrics are called, so it never counts as a failure.
+	OutcomeBudgetExhausted
+)
+
+var outcomeNames = [...]string{
+	OutcomeSuccess:                "success",
+	OutcomeFailure:                "failure",
+	OutcomeTimeout:                "timeout",
+	OutcomeBadRequest:             "bad_request",
+	OutcomeInterrupt:              "interrupt",
+	OutcomeConcurrencyLimitReject: "concurrency_limit_reject",
+	OutcomeShortCircuit:           "short_circuit",
+	OutcomeDraining:               "draining",
+	OutcomeBudgetExhausted:        "budget_exhausted",
+}
+
+// String returns a snake_case name for the outcome
+func (o Outcome) String() string {
+	if o < 0 || int(o) >= len(outcomeNames) {
+		return "unknown"
+	}
+	return outcomeNames[o]
+}
+
+// Admission is a circuit's decision about whether a call may run.  See GeneralConfig.OnAdmission.
+type Admission int
+
+const (
+	// AdmissionAllowed is a call the circuit let run.  How runFunc did is not part of the decision.
+	AdmissionAllowed Admission = iota
+	// AdmissionShortCircuit is a call rejected because the circuit is open, or ClosedToOpen prevented it
+	AdmissionShortCircuit
+	// AdmissionConcurrencyLimitReject is a call rejected because Execution.MaxConcurrentRequests were already running
+	AdmissionConcurrencyLimitReject
+	// AdmissionBudgetExhausted is a call rejected because GeneralConfig.BudgetFunc said it has no budget left
+	AdmissionBudgetExhausted
+)
+
+var admissionNames = [...]string{
+	AdmissionAllowed:                "allowed",
+	AdmissionShortCircuit:           "short_circuit",
+	AdmissionConcurrencyLimitReject: "concurrency_limit_reject",
+	AdmissionBudgetExhausted:        "budget_exhausted",
+}
+
+// String returns a snake_case name for the decision
+func (a Admission) String() string {
+	if a < 0 || int(a) >= len(admissionNames) {
+		return "unknown"
+	}
+	return admissionNames[a]
+}
+
+// ExecutionInfo describes how the circuit handled a single call to Execute
+type ExecutionInfo struct {
+	// Outcome is how runFunc was handled
+	Outcome Outcome
+	// FallbackCalled is true if the fallback function was attempted
+	FallbackCalled bool
+	// FallbackSkipped is true if a fallback function was given, but skipped because of WithoutFallback
+	FallbackSkipped bool
+	// FallbackWon is true if Fallback.Hedge returned the fallback's result because it succeeded before runFunc did.
+	// Outcome is then OutcomeInterrupt if runFunc had not returned yet, since its context is canceled.
+	FallbackWon bool
+	// Duration is how long ExecuteWithInfo took, including any fallback, using the circuit's TimeKeeper.  Only
+	// ExecuteWithInfo measures it, so it is zero in RunSpan.End.
+	Duration time.Duration
+}
+
+// ShortCircuited is true if runFunc was not called because the circuit was open, or its ClosedToOpen prevented it
+func (e ExecutionInfo) ShortCircuited() bool {
+	return e.Outcome == OutcomeShortCircuit
+}
+
+// RunTracer traces calls to Execute.  StartRun is called before the circuit decides if runFunc is allowed to run, so
+// short circuited calls are traced as well.
+type RunTracer interface {
+	// StartRun starts tracing a call to Execute.  The returned context is passed to runFunc and fallbackFunc.
+	StartRun(ctx context.Context, circuitName string) (context.Context, RunSpan)
+}
+
+// RunSpan is a single traced call to Execute
+type RunSpan interface {
+	// End is called once, with how Execute handled the call and the error Execute returns
+	End(info ExecutionInfo, err error)
+}